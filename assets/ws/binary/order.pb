@@ -0,0 +1,4 @@
+
+100001u-1m-1"	SUBMITTED*
+红烧牛肉面*
+可乐0/8ҕȗ/
\ No newline at end of file
@@ -0,0 +1,3 @@
+
+order_accepted100001
+	rider_888	张师傅 ҕȗ/
\ No newline at end of file
@@ -2,4 +2,7 @@ module intercept-wave-upstream
 
 go 1.26
 
-require github.com/gorilla/websocket v1.5.3
+require (
+	github.com/gorilla/websocket v1.5.3
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,194 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"intercept-wave-upstream/internal/common"
+)
+
+// Handler returns the admin control plane API:
+//   - GET /services, GET /services/{name}
+//   - POST /services/{name}/disable?mode=503|refuse, POST /services/{name}/enable
+//   - GET|POST|DELETE /services/{name}/overrides, DELETE /services/{name}/overrides/{id}
+//   - POST /services/{name}/reset?target=, POST /reset
+//   - GET /ws/clients?service=&path=&query=&remote=
+//   - POST /ws/clients/{id}/send, POST /ws/send?service=&path=... (or {"filter":{...}})
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		common.JSON(w, 200, map[string]interface{}{"service": "admin", "endpoints": []string{"/services", "/reset", "/ws/clients"}})
+	})
+	mux.HandleFunc("GET /services", func(w http.ResponseWriter, r *http.Request) {
+		list := []map[string]interface{}{}
+		for _, s := range Services() {
+			list = append(list, s.Snapshot())
+		}
+		common.JSON(w, 200, map[string]interface{}{"services": list})
+	})
+	mux.HandleFunc("GET /services/{name}", withService(func(w http.ResponseWriter, r *http.Request, s *Service) {
+		common.JSON(w, 200, s.Snapshot())
+	}))
+	mux.HandleFunc("POST /services/{name}/disable", withService(func(w http.ResponseWriter, r *http.Request, s *Service) {
+		var in struct {
+			Mode string `json:"mode"`
+		}
+		if !decodeOptional(w, r, &in) {
+			return
+		}
+		if m := r.URL.Query().Get("mode"); m != "" {
+			in.Mode = m
+		}
+		if err := s.Disable(in.Mode); err != nil {
+			common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		common.JSON(w, 200, s.Snapshot())
+	}))
+	mux.HandleFunc("POST /services/{name}/enable", withService(func(w http.ResponseWriter, r *http.Request, s *Service) {
+		s.Enable()
+		common.JSON(w, 200, s.Snapshot())
+	}))
+	mux.HandleFunc("GET /services/{name}/overrides", withService(func(w http.ResponseWriter, r *http.Request, s *Service) {
+		common.JSON(w, 200, map[string]interface{}{"overrides": s.Snapshot()["overrides"]})
+	}))
+	mux.HandleFunc("POST /services/{name}/overrides", withService(func(w http.ResponseWriter, r *http.Request, s *Service) {
+		var in Override
+		if !decodeOptional(w, r, &in) {
+			return
+		}
+		if in.Path == "" || (in.Status == 0 && in.DelayMs <= 0) {
+			common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": "path and one of status/delayMs are required"})
+			return
+		}
+		if in.Status != 0 && (in.Status < 100 || in.Status > 599) {
+			common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid status"})
+			return
+		}
+		common.JSON(w, http.StatusCreated, s.AddOverride(in))
+	}))
+	mux.HandleFunc("DELETE /services/{name}/overrides", withService(func(w http.ResponseWriter, r *http.Request, s *Service) {
+		common.JSON(w, 200, map[string]interface{}{"removed": s.RemoveOverride(0)})
+	}))
+	mux.HandleFunc("DELETE /services/{name}/overrides/{id}", withService(func(w http.ResponseWriter, r *http.Request, s *Service) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil || id <= 0 || s.RemoveOverride(id) == 0 {
+			common.JSON(w, http.StatusNotFound, map[string]interface{}{"error": "not found"})
+			return
+		}
+		common.JSON(w, 200, map[string]interface{}{"removed": 1})
+	}))
+	mux.HandleFunc("POST /services/{name}/reset", withService(func(w http.ResponseWriter, r *http.Request, s *Service) {
+		done, err := s.Reset(r.URL.Query().Get("target"))
+		if err != nil {
+			common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		common.JSON(w, 200, map[string]interface{}{"service": s.Name, "reset": done})
+	}))
+	mux.HandleFunc("POST /reset", func(w http.ResponseWriter, r *http.Request) {
+		out := map[string]interface{}{}
+		for _, s := range Services() {
+			done, _ := s.Reset("")
+			out[s.Name] = done
+		}
+		common.JSON(w, 200, map[string]interface{}{"reset": out})
+	})
+	mux.HandleFunc("GET /ws/clients", func(w http.ResponseWriter, r *http.Request) {
+		list := Clients(filterFromQuery(r))
+		common.JSON(w, 200, map[string]interface{}{"total": len(list), "clients": list})
+	})
+	mux.HandleFunc("POST /ws/clients/{id}/send", func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil || id <= 0 || len(Clients(ClientFilter{ID: id})) == 0 {
+			common.JSON(w, http.StatusNotFound, map[string]interface{}{"error": "unknown client"})
+			return
+		}
+		var fr Frame
+		if !decodeOptional(w, r, &fr) {
+			return
+		}
+		push(w, ClientFilter{ID: id}, fr)
+	})
+	mux.HandleFunc("POST /ws/send", func(w http.ResponseWriter, r *http.Request) {
+		var in struct {
+			Frame
+			Filter *ClientFilter `json:"filter"`
+		}
+		if !decodeOptional(w, r, &in) {
+			return
+		}
+		f := filterFromQuery(r)
+		if in.Filter != nil {
+			f = *in.Filter
+		}
+		push(w, f, in.Frame)
+	})
+	return mux
+}
+
+// Start serves the admin API on port.
+func Start(port int) *http.Server {
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: common.RequestLogger(Handler())}
+	go func() {
+		common.Logf("Admin API listening on :%d", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			common.Logf("Admin server error: %v", err)
+		}
+	}()
+	return srv
+}
+
+func push(w http.ResponseWriter, f ClientFilter, fr Frame) {
+	res, err := Push(f, fr)
+	if err != nil {
+		common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+		return
+	}
+	common.JSON(w, 200, res)
+}
+
+func filterFromQuery(r *http.Request) ClientFilter {
+	q := r.URL.Query()
+	id, _ := strconv.ParseInt(q.Get("id"), 10, 64)
+	return ClientFilter{ID: id, Service: q.Get("service"), Path: q.Get("path"), Query: q.Get("query"), Remote: q.Get("remote")}
+}
+
+func withService(h func(http.ResponseWriter, *http.Request, *Service)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := Lookup(r.PathValue("name"))
+		if !ok {
+			common.JSON(w, http.StatusNotFound, map[string]interface{}{"error": "unknown service"})
+			return
+		}
+		h(w, r, s)
+	}
+}
+
+// decodeOptional decodes a JSON body into v; an empty body is allowed.
+func decodeOptional(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	b, _ := io.ReadAll(r.Body)
+	_ = r.Body.Close()
+	if len(b) == 0 {
+		return true
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid json"})
+		return false
+	}
+	return true
+}
+
+// PortFromEnv returns env ADMIN_PORT when set, otherwise def.
+func PortFromEnv(def int) int {
+	if v := os.Getenv("ADMIN_PORT"); v != "" {
+		if p, err := strconv.Atoi(v); err == nil && p > 0 {
+			return p
+		}
+	}
+	return def
+}
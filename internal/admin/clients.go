@@ -0,0 +1,160 @@
+package admin
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+)
+
+// Client is one connected WebSocket client.
+type Client struct {
+	ID          int64     `json:"id"`
+	Service     string    `json:"service"`
+	Path        string    `json:"path"`
+	Query       string    `json:"query,omitempty"`
+	Remote      string    `json:"remote"`
+	Principal   string    `json:"principal,omitempty"`
+	ConnectedAt time.Time `json:"connectedAt"`
+
+	// Send writes a frame to the connection; nil for read-only entries.
+	Send func(Frame) error `json:"-"`
+}
+
+// Frame is a frame pushed to a client through the admin API. Type is text
+// (default), binary, ping or close. The payload comes from Data, JSON
+// (serialized) or Base64; close frames use Code (default 1000) and Reason.
+type Frame struct {
+	Type   string      `json:"type"`
+	Data   string      `json:"data,omitempty"`
+	JSON   interface{} `json:"json,omitempty"`
+	Base64 string      `json:"base64,omitempty"`
+	Code   int         `json:"code,omitempty"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// Payload returns the frame payload bytes.
+func (f Frame) Payload() ([]byte, error) {
+	switch {
+	case f.Base64 != "":
+		return base64.StdEncoding.DecodeString(f.Base64)
+	case f.JSON != nil:
+		return common.JsonMarshalCompat(f.JSON)
+	default:
+		return []byte(f.Data), nil
+	}
+}
+
+func (f Frame) validate() error {
+	switch f.Type {
+	case "", "text", "binary", "ping", "close":
+	default:
+		return fmt.Errorf("unknown frame type %q", f.Type)
+	}
+	if f.Code != 0 && (f.Code < 1000 || f.Code > 4999) {
+		return fmt.Errorf("invalid close code %d", f.Code)
+	}
+	if _, err := f.Payload(); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	return nil
+}
+
+// ClientFilter selects clients; empty fields match all. Path is a prefix,
+// Query a substring of the raw query.
+type ClientFilter struct {
+	ID      int64  `json:"id,omitempty"`
+	Service string `json:"service,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Query   string `json:"query,omitempty"`
+	Remote  string `json:"remote,omitempty"`
+}
+
+func (f ClientFilter) match(c *Client) bool {
+	switch {
+	case f.ID != 0 && c.ID != f.ID:
+		return false
+	case f.Service != "" && c.Service != f.Service:
+		return false
+	case f.Path != "" && !strings.HasPrefix(c.Path, f.Path):
+		return false
+	case f.Query != "" && !strings.Contains(c.Query, f.Query):
+		return false
+	case f.Remote != "" && !strings.HasPrefix(c.Remote, f.Remote):
+		return false
+	}
+	return true
+}
+
+var (
+	clientMu sync.RWMutex
+	clients  = map[int64]*Client{}
+	clientID atomic.Int64
+
+	errNoSender = errors.New("connection does not accept pushed frames")
+)
+
+// TrackClient registers c, assigning its id, and returns a func that removes it.
+func TrackClient(c *Client) func() {
+	c.ID = clientID.Add(1)
+	if c.ConnectedAt.IsZero() {
+		c.ConnectedAt = time.Now()
+	}
+	clientMu.Lock()
+	clients[c.ID] = c
+	clientMu.Unlock()
+	return func() {
+		clientMu.Lock()
+		delete(clients, c.ID)
+		clientMu.Unlock()
+	}
+}
+
+// Clients returns the connected clients matching f, ordered by id.
+func Clients(f ClientFilter) []*Client {
+	clientMu.RLock()
+	out := make([]*Client, 0, len(clients))
+	for _, c := range clients {
+		if f.match(c) {
+			out = append(out, c)
+		}
+	}
+	clientMu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// PushResult reports the outcome of a push per client.
+type PushResult struct {
+	Sent   []int64          `json:"sent"`
+	Failed map[int64]string `json:"failed,omitempty"`
+}
+
+// Push sends fr to every client matching f.
+func Push(f ClientFilter, fr Frame) (PushResult, error) {
+	if err := fr.validate(); err != nil {
+		return PushResult{}, err
+	}
+	res := PushResult{Sent: []int64{}}
+	for _, c := range Clients(f) {
+		err := errNoSender
+		if c.Send != nil {
+			err = c.Send(fr)
+		}
+		if err != nil {
+			if res.Failed == nil {
+				res.Failed = map[int64]string{}
+			}
+			res.Failed[c.ID] = err.Error()
+			continue
+		}
+		res.Sent = append(res.Sent, c.ID)
+	}
+	return res, nil
+}
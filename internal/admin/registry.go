@@ -0,0 +1,301 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+)
+
+// Disable modes: ModeUnavailable answers every request with 503, ModeRefuse
+// closes the listening sockets so new connections are refused.
+const (
+	ModeUnavailable = "503"
+	ModeRefuse      = "refuse"
+)
+
+// Service is one named upstream with its listeners and runtime controls.
+type Service struct {
+	Name string
+	Kind string
+
+	mu        sync.Mutex
+	listeners []*listener
+	enabled   bool
+	mode      string
+	overrides []*Override
+	resetters map[string]func()
+}
+
+type listener struct {
+	port int
+	tls  bool
+	srv  *http.Server
+	ln   net.Listener
+}
+
+// Override changes the behavior of matching requests: an optional delay and,
+// when Status is set, a canned response instead of the real handler.
+// Path matches exactly, or as a prefix when it ends with "*".
+type Override struct {
+	ID      int64       `json:"id"`
+	Method  string      `json:"method,omitempty"`
+	Path    string      `json:"path"`
+	DelayMs int         `json:"delayMs,omitempty"`
+	Status  int         `json:"status,omitempty"`
+	Body    interface{} `json:"body,omitempty"`
+}
+
+func (o *Override) matches(r *http.Request) bool {
+	if o.Method != "" && !strings.EqualFold(o.Method, r.Method) {
+		return false
+	}
+	if p, ok := strings.CutSuffix(o.Path, "*"); ok {
+		return strings.HasPrefix(r.URL.Path, p)
+	}
+	return r.URL.Path == o.Path
+}
+
+var (
+	regMu      sync.RWMutex
+	services   = map[string]*Service{}
+	overrideID atomic.Int64
+)
+
+// Register adds (or replaces) a service in the registry; replacing resets its
+// runtime state, which keeps repeated test starts independent.
+func Register(name, kind string) *Service {
+	s := &Service{Name: name, Kind: kind, enabled: true, resetters: map[string]func(){}}
+	regMu.Lock()
+	services[name] = s
+	regMu.Unlock()
+	return s
+}
+
+// Lookup returns a registered service.
+func Lookup(name string) (*Service, bool) {
+	regMu.RLock()
+	defer regMu.RUnlock()
+	s, ok := services[name]
+	return s, ok
+}
+
+// Services returns all registered services sorted by name.
+func Services() []*Service {
+	regMu.RLock()
+	out := make([]*Service, 0, len(services))
+	for _, s := range services {
+		out = append(out, s)
+	}
+	regMu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// RegisterReset attaches a named reset hook (e.g. "rest-items") to a service.
+func RegisterReset(service, target string, fn func()) {
+	if s, ok := Lookup(service); ok {
+		s.mu.Lock()
+		s.resetters[target] = fn
+		s.mu.Unlock()
+	}
+}
+
+// Serve binds port and serves srv on it (TLS when useTLS; srv.TLSConfig must
+// be set). The listener is tracked so the service can be disabled in refuse mode.
+func (s *Service) Serve(srv *http.Server, port int, useTLS bool) error {
+	l := &listener{port: port, tls: useTLS, srv: srv}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, l)
+	return s.bindLocked(l)
+}
+
+func (s *Service) bindLocked(l *listener) error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", l.port))
+	if err != nil {
+		return err
+	}
+	l.ln = ln
+	go func() {
+		var err error
+		if l.tls {
+			err = l.srv.ServeTLS(ln, "", "")
+		} else {
+			err = l.srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+			common.Logf("%s server %s error: %v", strings.ToUpper(s.Kind), s.Name, err)
+		}
+	}()
+	return nil
+}
+
+// Disable switches the service off in the given mode (ModeUnavailable by default).
+func (s *Service) Disable(mode string) error {
+	if mode == "" {
+		mode = ModeUnavailable
+	}
+	if mode != ModeUnavailable && mode != ModeRefuse {
+		return fmt.Errorf("unknown mode %q", mode)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.enabled && s.mode == ModeRefuse && mode != ModeRefuse {
+		s.reopenLocked()
+	}
+	s.enabled, s.mode = false, mode
+	if mode == ModeRefuse {
+		for _, l := range s.listeners {
+			if l.ln != nil {
+				_ = l.ln.Close()
+				l.ln = nil
+			}
+		}
+	}
+	common.Logf("admin: service %s disabled (mode=%s)", s.Name, mode)
+	return nil
+}
+
+// Enable switches the service back on, reopening listeners closed by ModeRefuse.
+func (s *Service) Enable() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.enabled && s.mode == ModeRefuse {
+		s.reopenLocked()
+	}
+	s.enabled, s.mode = true, ""
+	common.Logf("admin: service %s enabled", s.Name)
+}
+
+func (s *Service) reopenLocked() {
+	for _, l := range s.listeners {
+		if l.ln == nil {
+			if err := s.bindLocked(l); err != nil {
+				common.Logf("admin: reopen %s :%d: %v", s.Name, l.port, err)
+			}
+		}
+	}
+}
+
+// Reset runs the named reset hook, or all hooks when target is empty, and
+// returns the targets that ran.
+func (s *Service) Reset(target string) ([]string, error) {
+	s.mu.Lock()
+	hooks := map[string]func(){}
+	for k, fn := range s.resetters {
+		if target == "" || k == target {
+			hooks[k] = fn
+		}
+	}
+	s.mu.Unlock()
+	if target != "" && len(hooks) == 0 {
+		return nil, fmt.Errorf("unknown reset target %q", target)
+	}
+	done := make([]string, 0, len(hooks))
+	for k, fn := range hooks {
+		fn()
+		done = append(done, k)
+	}
+	sort.Strings(done)
+	return done, nil
+}
+
+// AddOverride installs a route override and returns it with its id.
+func (s *Service) AddOverride(o Override) *Override {
+	o.ID = overrideID.Add(1)
+	s.mu.Lock()
+	s.overrides = append(s.overrides, &o)
+	s.mu.Unlock()
+	return &o
+}
+
+// RemoveOverride deletes one override (id > 0) or all of them (id == 0).
+func (s *Service) RemoveOverride(id int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id == 0 {
+		n := len(s.overrides)
+		s.overrides = nil
+		return n
+	}
+	for i, o := range s.overrides {
+		if o.ID == id {
+			s.overrides = append(s.overrides[:i], s.overrides[i+1:]...)
+			return 1
+		}
+	}
+	return 0
+}
+
+// Snapshot describes the service for the admin API.
+func (s *Service) Snapshot() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ports := []map[string]interface{}{}
+	for _, l := range s.listeners {
+		ports = append(ports, map[string]interface{}{"port": l.port, "tls": l.tls, "listening": l.ln != nil})
+	}
+	targets := make([]string, 0, len(s.resetters))
+	for k := range s.resetters {
+		targets = append(targets, k)
+	}
+	sort.Strings(targets)
+	overrides := make([]*Override, len(s.overrides))
+	copy(overrides, s.overrides)
+	return map[string]interface{}{
+		"name":         s.Name,
+		"kind":         s.Kind,
+		"enabled":      s.enabled,
+		"mode":         s.mode,
+		"listeners":    ports,
+		"overrides":    overrides,
+		"resetTargets": targets,
+	}
+}
+
+// Gate enforces the service state and route overrides in front of next.
+func Gate(s *Service, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		enabled := s.enabled
+		var ov *Override
+		for _, o := range s.overrides {
+			if o.matches(r) {
+				ov = o
+				break
+			}
+		}
+		s.mu.Unlock()
+		if !enabled {
+			w.Header().Set("Retry-After", "1")
+			common.JSON(w, http.StatusServiceUnavailable, map[string]interface{}{"error": "service disabled", "service": s.Name})
+			return
+		}
+		if ov != nil {
+			if ov.DelayMs > 0 {
+				select {
+				case <-time.After(time.Duration(ov.DelayMs) * time.Millisecond):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			if ov.Status > 0 {
+				body := ov.Body
+				if body == nil {
+					body = map[string]interface{}{"status": ov.Status, "override": ov.ID}
+				}
+				common.JSON(w, ov.Status, body)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
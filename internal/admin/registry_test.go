@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startService registers a service behind Gate on a free port and returns it
+// with its base URL.
+func startService(t *testing.T, name string) (*Service, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	_ = ln.Close()
+	s := Register(name, "http")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	srv := &http.Server{Handler: Gate(s, ok)}
+	if err := s.Serve(srv, port, false); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	})
+	return s, fmt.Sprintf("http://127.0.0.1:%d", port)
+}
+
+// client never reuses connections, so each request observes the current
+// listener state.
+var client = &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 2 * time.Second}
+
+func status(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := client.Get(url + "/")
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func refused(url string) bool {
+	resp, err := client.Get(url + "/")
+	if err == nil {
+		_ = resp.Body.Close()
+		return false
+	}
+	return true
+}
+
+func listening(s *Service) bool {
+	ports, _ := s.Snapshot()["listeners"].([]map[string]interface{})
+	return len(ports) == 1 && ports[0]["listening"] == true
+}
+
+func TestDisableUnavailable(t *testing.T) {
+	s, url := startService(t, "admin-test-503")
+	if got := status(t, url); got != http.StatusNoContent {
+		t.Fatalf("enabled status=%d", got)
+	}
+
+	if err := s.Disable(""); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	resp, err := client.Get(url + "/")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "1" {
+		t.Fatalf("disabled status=%d retry-after=%q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if snap := s.Snapshot(); snap["enabled"] != false || snap["mode"] != ModeUnavailable || !listening(s) {
+		t.Fatalf("snapshot=%v", snap)
+	}
+
+	s.Enable()
+	if got := status(t, url); got != http.StatusNoContent {
+		t.Fatalf("re-enabled status=%d", got)
+	}
+}
+
+func TestDisableRefuse(t *testing.T) {
+	s, url := startService(t, "admin-test-refuse")
+	if err := s.Disable(ModeRefuse); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	if !refused(url) {
+		t.Fatalf("expected connection refused")
+	}
+	if listening(s) {
+		t.Fatalf("listener still open: %v", s.Snapshot())
+	}
+
+	s.Enable()
+	if !listening(s) {
+		t.Fatalf("listener not reopened: %v", s.Snapshot())
+	}
+	if got := status(t, url); got != http.StatusNoContent {
+		t.Fatalf("re-enabled status=%d", got)
+	}
+}
+
+func TestDisableRefuseThenUnavailable(t *testing.T) {
+	s, url := startService(t, "admin-test-reopen")
+	if err := s.Disable(ModeRefuse); err != nil {
+		t.Fatalf("Disable refuse: %v", err)
+	}
+	if !refused(url) {
+		t.Fatalf("expected connection refused")
+	}
+
+	// switching modes reopens the port, which then answers 503
+	if err := s.Disable(ModeUnavailable); err != nil {
+		t.Fatalf("Disable 503: %v", err)
+	}
+	if got := status(t, url); got != http.StatusServiceUnavailable {
+		t.Fatalf("status=%d", got)
+	}
+
+	// refusing a second time closes the reopened listener again
+	if err := s.Disable(ModeRefuse); err != nil {
+		t.Fatalf("Disable refuse again: %v", err)
+	}
+	if !refused(url) {
+		t.Fatalf("expected connection refused after reopen")
+	}
+	s.Enable()
+	if got := status(t, url); got != http.StatusNoContent {
+		t.Fatalf("re-enabled status=%d", got)
+	}
+}
+
+func TestDisableUnknownMode(t *testing.T) {
+	s := Register("admin-test-mode", "http")
+	if err := s.Disable("drop"); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+	if s.Snapshot()["enabled"] != true {
+		t.Fatalf("service disabled by a rejected mode")
+	}
+}
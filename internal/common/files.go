@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 // AssetsDir returns the directory for static JSON assets.
@@ -43,3 +44,33 @@ func LoadJSONDynamic(path string) (interface{}, error) {
 	}
 	return v, nil
 }
+
+// AssetList reads the "data" array of an asset file; a missing or malformed
+// file yields an empty list.
+func AssetList(parts ...string) []map[string]interface{} {
+	v, err := LoadJSONDynamic(JoinAssets(parts...))
+	if err != nil {
+		return nil
+	}
+	m, _ := v.(map[string]interface{})
+	arr, _ := m["data"].([]interface{})
+	out := make([]map[string]interface{}, 0, len(arr))
+	for _, item := range arr {
+		if obj, ok := item.(map[string]interface{}); ok {
+			out = append(out, obj)
+		}
+	}
+	return out
+}
+
+// AssetString renders an asset value (a JSON string or number) as a string,
+// so fixture ids such as 2001 compare equal to "2001".
+func AssetString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+	return ""
+}
@@ -50,6 +50,9 @@ func (rw *respWriter) Flush() {
 	}
 }
 
+// Unwrap exposes the underlying ResponseWriter to http.ResponseController.
+func (rw *respWriter) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
+
 func JSON(w http.ResponseWriter, status int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(status)
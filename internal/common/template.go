@@ -0,0 +1,235 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TemplateContext carries the request data that asset templates can reference.
+//
+// Supported expressions inside "{{...}}":
+//   - path.NAME, query.NAME, header.NAME
+//   - body (raw request body) and body.a.b (field of a JSON body)
+//   - now / now.rfc3339, now.unix, now.unixMilli
+//   - uuid, random.int, random.hex
+//   - seq (global counter) and seq.NAME (named counter)
+//
+// "expr|fallback" yields fallback when expr resolves to an empty value.
+type TemplateContext struct {
+	Path    map[string]string
+	Query   url.Values
+	Header  http.Header
+	RawBody string
+	body    interface{}
+	decoded bool
+}
+
+// NewTemplateContext captures r (reading and restoring its body) for template rendering.
+func NewTemplateContext(r *http.Request, pathParams map[string]string) *TemplateContext {
+	ctx := &TemplateContext{Path: pathParams, Query: r.URL.Query(), Header: r.Header}
+	if r.Body != nil {
+		b, _ := io.ReadAll(r.Body)
+		_ = r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(b))
+		ctx.RawBody = string(b)
+	}
+	if ctx.Path == nil {
+		ctx.Path = map[string]string{}
+	}
+	return ctx
+}
+
+var templateExprRe = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+
+// RenderTemplate returns a deep copy of v with every "{{expr}}" string resolved.
+// A string consisting of a single expression keeps the resolved value's type
+// (e.g. a numeric body field stays a number); otherwise values are stringified.
+func RenderTemplate(v interface{}, ctx *TemplateContext) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, vv := range t {
+			out[k] = RenderTemplate(vv, ctx)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, vv := range t {
+			out[i] = RenderTemplate(vv, ctx)
+		}
+		return out
+	case string:
+		return renderString(t, ctx)
+	default:
+		return v
+	}
+}
+
+// RenderTemplateString resolves expressions in s and always returns a string.
+func RenderTemplateString(s string, ctx *TemplateContext) string {
+	return stringify(renderString(s, ctx))
+}
+
+// LoadJSONTemplate loads a JSON asset and renders it against ctx.
+func LoadJSONTemplate(path string, ctx *TemplateContext) (interface{}, error) {
+	v, err := LoadJSONDynamic(path)
+	if err != nil {
+		return nil, err
+	}
+	return RenderTemplate(v, ctx), nil
+}
+
+func renderString(s string, ctx *TemplateContext) interface{} {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	if m := templateExprRe.FindStringSubmatchIndex(s); m != nil && m[0] == 0 && m[1] == len(s) {
+		return ctx.eval(s[m[2]:m[3]])
+	}
+	return templateExprRe.ReplaceAllStringFunc(s, func(expr string) string {
+		sub := templateExprRe.FindStringSubmatch(expr)
+		return stringify(ctx.eval(sub[1]))
+	})
+}
+
+func stringify(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(t)
+		return string(b)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func (ctx *TemplateContext) eval(expr string) interface{} {
+	fallback, hasFallback := "", false
+	if i := strings.Index(expr, "|"); i >= 0 {
+		fallback, hasFallback = strings.TrimSpace(expr[i+1:]), true
+		expr = strings.TrimSpace(expr[:i])
+	}
+	v := ctx.resolve(expr)
+	if hasFallback && (v == nil || v == "") {
+		return fallback
+	}
+	return v
+}
+
+func (ctx *TemplateContext) resolve(expr string) interface{} {
+	head, rest, _ := strings.Cut(expr, ".")
+	switch head {
+	case "path":
+		if ctx == nil {
+			return nil
+		}
+		return ctx.Path[rest]
+	case "query":
+		if ctx == nil || ctx.Query == nil {
+			return nil
+		}
+		return ctx.Query.Get(rest)
+	case "header":
+		if ctx == nil || ctx.Header == nil {
+			return nil
+		}
+		return ctx.Header.Get(rest)
+	case "body":
+		if ctx == nil {
+			return nil
+		}
+		if rest == "" {
+			return ctx.RawBody
+		}
+		return LookupDotted(ctx.jsonBody(), rest)
+	case "now":
+		now := time.Now()
+		switch rest {
+		case "", "rfc3339":
+			return now.Format(time.RFC3339)
+		case "unix":
+			return now.Unix()
+		case "unixMilli":
+			return now.UnixMilli()
+		}
+	case "uuid":
+		return newUUID()
+	case "random":
+		switch rest {
+		case "int":
+			n, _ := rand.Int(rand.Reader, big.NewInt(100000))
+			return n.Int64()
+		case "hex":
+			b := make([]byte, 8)
+			_, _ = rand.Read(b)
+			return hex.EncodeToString(b)
+		}
+	case "seq":
+		return nextSeq(rest)
+	}
+	return nil
+}
+
+func (ctx *TemplateContext) jsonBody() interface{} {
+	if !ctx.decoded {
+		ctx.decoded = true
+		_ = json.Unmarshal([]byte(ctx.RawBody), &ctx.body)
+	}
+	return ctx.body
+}
+
+// LookupDotted walks a decoded JSON value by dotted keys; numeric keys index arrays.
+func LookupDotted(v interface{}, path string) interface{} {
+	for _, k := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			v = t[k]
+		case []interface{}:
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil
+			}
+			v = t[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+var (
+	seqMu       sync.Mutex
+	seqCounters = map[string]int64{}
+)
+
+func nextSeq(name string) int64 {
+	seqMu.Lock()
+	defer seqMu.Unlock()
+	seqCounters[name]++
+	return seqCounters[name]
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRenderTemplateResolvesRequestData(t *testing.T) {
+	r := httptest.NewRequest("POST", "/orders/42?page=3", strings.NewReader(`{"amount":19.9,"buyer":{"name":"tom"}}`))
+	r.Header.Set("X-Request-Id", "req-1")
+	ctx := NewTemplateContext(r, map[string]string{"id": "42"})
+
+	tpl := map[string]interface{}{
+		"id":     "{{path.id}}",
+		"sku":    "SKU-{{path.id}}",
+		"page":   "{{query.page}}",
+		"size":   "{{query.size|20}}",
+		"req":    "{{header.X-Request-Id}}",
+		"amount": "{{body.amount}}",
+		"buyer":  "{{ body.buyer.name }}",
+		"list":   []interface{}{"{{seq.test}}", "{{seq.test}}"},
+		"uuid":   "{{uuid}}",
+	}
+	out := RenderTemplate(tpl, ctx).(map[string]interface{})
+
+	checks := map[string]interface{}{
+		"id": "42", "sku": "SKU-42", "page": "3", "size": "20", "req": "req-1", "amount": 19.9, "buyer": "tom",
+	}
+	for k, want := range checks {
+		if out[k] != want {
+			t.Fatalf("%s: got %v (%T), want %v", k, out[k], out[k], want)
+		}
+	}
+	list := out["list"].([]interface{})
+	if list[0].(int64)+1 != list[1].(int64) {
+		t.Fatalf("seq not incrementing: %v", list)
+	}
+	if len(out["uuid"].(string)) != 36 {
+		t.Fatalf("unexpected uuid: %v", out["uuid"])
+	}
+	if tpl["id"] != "{{path.id}}" {
+		t.Fatalf("template source mutated")
+	}
+	// the body stays readable for handlers
+	if b, _ := io.ReadAll(r.Body); len(b) == 0 {
+		t.Fatalf("body not restored")
+	}
+}
@@ -0,0 +1,52 @@
+package food
+
+import "intercept-wave-upstream/internal/common"
+
+// OrderRef is an order as the protocol endpoints look it up: either a live
+// store order or a read-only fixture from assets/order/orders.json, kept in
+// its JSON shape. Exactly one of Live and Fixture is set.
+type OrderRef struct {
+	Live    *Order
+	Fixture map[string]interface{}
+}
+
+// FixtureOrders reads the fixture orders. The asset is read on every call so
+// edits show up without a restart.
+func FixtureOrders() []map[string]interface{} {
+	return common.AssetList("order", "orders.json")
+}
+
+// Lookup finds id among the live orders, then the fixture orders.
+func (s *Store) Lookup(id string) (OrderRef, bool) {
+	if o, ok := s.Get(id); ok {
+		return OrderRef{Live: &o}, true
+	}
+	for _, f := range FixtureOrders() {
+		if common.AssetString(f["id"]) == id {
+			return OrderRef{Fixture: f}, true
+		}
+	}
+	return OrderRef{}, false
+}
+
+// All lists the fixture orders followed by the live ones.
+func (s *Store) All() []OrderRef {
+	fixtures := FixtureOrders()
+	live := s.List()
+	out := make([]OrderRef, 0, len(fixtures)+len(live))
+	for _, f := range fixtures {
+		out = append(out, OrderRef{Fixture: f})
+	}
+	for i := range live {
+		out = append(out, OrderRef{Live: &live[i]})
+	}
+	return out
+}
+
+// Status returns the order status.
+func (r OrderRef) Status() string {
+	if r.Live != nil {
+		return r.Live.Status
+	}
+	return common.AssetString(r.Fixture["status"])
+}
@@ -0,0 +1,23 @@
+package food
+
+import "testing"
+
+func TestLookupLiveThenFixture(t *testing.T) {
+	s := NewStore()
+	o := s.Create(map[string]interface{}{"userId": "u-3"}, "test")
+	if ref, ok := s.Lookup(o.ID); !ok || ref.Live == nil || ref.Live.UserID != "u-3" || ref.Status() != StatusCreated {
+		t.Fatalf("live lookup=%+v %v", ref, ok)
+	}
+	// fixture ids are JSON numbers in assets/order/orders.json
+	if ref, ok := s.Lookup("2002"); !ok || ref.Fixture == nil || ref.Status() != "PAID" {
+		t.Fatalf("fixture lookup=%+v %v", ref, ok)
+	}
+	if _, ok := s.Lookup("404"); ok {
+		t.Fatalf("unknown id found")
+	}
+	all := s.All()
+	fixtures := len(FixtureOrders())
+	if len(all) != fixtures+1 || all[0].Fixture == nil || all[fixtures].Live == nil || all[fixtures].Live.ID != o.ID {
+		t.Fatalf("all=%+v", all)
+	}
+}
@@ -0,0 +1,55 @@
+package food
+
+import (
+	"fmt"
+	"sync"
+
+	"intercept-wave-upstream/internal/common"
+)
+
+// RefundStore keeps refunds created at runtime on top of the fixture
+// refunds in assets/payment/refunds.json. The assets are read on every call
+// so edits show up without a restart. Refunds are kept in their JSON shape
+// (refundId, orderId, status, amount, reason, plus any extra fields).
+type RefundStore struct {
+	mu      sync.Mutex
+	created []map[string]interface{}
+}
+
+// Refunds is the process-wide refund store shared by the REST, GraphQL and
+// gRPC payment endpoints.
+var Refunds = &RefundStore{}
+
+// Create records a refund from fields, assigning the next RF-nnnnn id and
+// PENDING unless fields carry a status.
+func (s *RefundStore) Create(fields map[string]interface{}) map[string]interface{} {
+	r := make(map[string]interface{}, len(fields)+2)
+	for k, v := range fields {
+		r[k] = v
+	}
+	if _, ok := r["status"]; !ok {
+		r["status"] = "PENDING"
+	}
+	fixtures := len(common.AssetList("payment", "refunds.json"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r["refundId"] = fmt.Sprintf("RF-%05d", fixtures+len(s.created)+1)
+	s.created = append(s.created, r)
+	return r
+}
+
+// List returns the fixture refunds followed by the created ones.
+func (s *RefundStore) List() []map[string]interface{} {
+	out := common.AssetList("payment", "refunds.json")
+	s.mu.Lock()
+	out = append(out, s.created...)
+	s.mu.Unlock()
+	return out
+}
+
+// Reset drops the created refunds.
+func (s *RefundStore) Reset() {
+	s.mu.Lock()
+	s.created = nil
+	s.mu.Unlock()
+}
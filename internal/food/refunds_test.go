@@ -0,0 +1,23 @@
+package food
+
+import "testing"
+
+func TestRefundStore(t *testing.T) {
+	s := &RefundStore{}
+	fixtures := len(s.List())
+	if fixtures == 0 {
+		t.Fatalf("expected fixture refunds from assets/payment/refunds.json")
+	}
+	r := s.Create(map[string]interface{}{"orderId": "2001", "amount": 5.0, "refundId": "ignored"})
+	if r["status"] != "PENDING" || r["refundId"] == "ignored" || r["orderId"] != "2001" {
+		t.Fatalf("created=%v", r)
+	}
+	list := s.List()
+	if len(list) != fixtures+1 || list[fixtures]["refundId"] != r["refundId"] {
+		t.Fatalf("list=%v", list)
+	}
+	s.Reset()
+	if len(s.List()) != fixtures {
+		t.Fatalf("reset kept created refunds")
+	}
+}
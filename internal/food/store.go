@@ -0,0 +1,286 @@
+// Package food holds the shared food-delivery order state machine. HTTP
+// order endpoints and the live WS food sockets drive the same Store, so
+// a merchant action on one service is visible to users on another.
+package food
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Order states.
+const (
+	StatusCreated   = "CREATED"
+	StatusSubmitted = "SUBMITTED"
+	StatusAccepted  = "ACCEPTED"
+	StatusRejected  = "REJECTED"
+	StatusReady     = "READY"
+	StatusCancelled = "CANCELLED"
+)
+
+// Actions that move an order between states.
+const (
+	ActionSubmit = "submit"
+	ActionAccept = "accept"
+	ActionReject = "reject"
+	ActionReady  = "ready"
+	ActionCancel = "cancel"
+)
+
+// transitions maps action -> from-state -> to-state.
+var transitions = map[string]map[string]string{
+	ActionSubmit: {StatusCreated: StatusSubmitted},
+	ActionAccept: {StatusSubmitted: StatusAccepted},
+	ActionReject: {StatusSubmitted: StatusRejected},
+	ActionReady:  {StatusAccepted: StatusReady},
+	ActionCancel: {StatusCreated: StatusCancelled, StatusSubmitted: StatusCancelled, StatusAccepted: StatusCancelled},
+}
+
+var (
+	ErrNotFound      = errors.New("order not found")
+	ErrUnknownAction = errors.New("unknown action")
+)
+
+// TransitionError reports an action that is not allowed in the current state.
+type TransitionError struct {
+	Action string
+	Status string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot %s an order in status %s", e.Action, e.Status)
+}
+
+// Order is one food order.
+type Order struct {
+	ID         string                 `json:"id"`
+	UserID     string                 `json:"userId"`
+	MerchantID string                 `json:"merchantId"`
+	Status     string                 `json:"status"`
+	Items      interface{}            `json:"items,omitempty"`
+	Amount     float64                `json:"amount,omitempty"`
+	Reason     string                 `json:"reason,omitempty"`
+	Extra      map[string]interface{} `json:"extra,omitempty"`
+	CreatedAt  time.Time              `json:"createdAt"`
+	UpdatedAt  time.Time              `json:"updatedAt"`
+}
+
+// Event is a state change pushed to subscribers.
+type Event struct {
+	Type     string    `json:"type"`
+	OrderID  string    `json:"orderId"`
+	Status   string    `json:"status"`
+	Previous string    `json:"previous,omitempty"`
+	Action   string    `json:"action"`
+	Actor    string    `json:"actor,omitempty"`
+	Order    Order     `json:"order"`
+	Time     time.Time `json:"time"`
+}
+
+// Filter selects the events a subscriber receives; empty fields match all.
+type Filter struct {
+	OrderID    string
+	UserID     string
+	MerchantID string
+	// SkipCreated hides order_created events (merchants only see submitted orders).
+	SkipCreated bool
+}
+
+func (f Filter) match(ev Event) bool {
+	if f.SkipCreated && ev.Action == "create" {
+		return false
+	}
+	return f.matchOrder(ev.Order)
+}
+
+// MatchOrder reports whether o is visible under the filter.
+func (f Filter) MatchOrder(o Order) bool {
+	if f.SkipCreated && o.Status == StatusCreated {
+		return false
+	}
+	return f.matchOrder(o)
+}
+
+func (f Filter) matchOrder(o Order) bool {
+	switch {
+	case f.OrderID != "" && o.ID != f.OrderID:
+		return false
+	case f.UserID != "" && o.UserID != f.UserID:
+		return false
+	case f.MerchantID != "" && o.MerchantID != f.MerchantID:
+		return false
+	}
+	return true
+}
+
+type subscriber struct {
+	filter Filter
+	ch     chan Event
+}
+
+// Store is a concurrency-safe order store with subscriptions.
+type Store struct {
+	mu     sync.Mutex
+	orders map[string]*Order
+	nextID int
+	subs   map[*subscriber]struct{}
+}
+
+// firstOrderID keeps generated ids clear of the fixture ids used by the
+// replay flows and detail templates.
+const firstOrderID = 100001
+
+// Default is the process-wide store shared by all services.
+var Default = NewStore()
+
+// NewStore returns an empty store.
+func NewStore() *Store {
+	return &Store{orders: map[string]*Order{}, nextID: firstOrderID, subs: map[*subscriber]struct{}{}}
+}
+
+// Create adds an order in CREATED state. Known fields (userId, merchantId,
+// items, amount) are lifted from fields; the rest is kept in Extra.
+func (s *Store) Create(fields map[string]interface{}, actor string) Order {
+	now := time.Now()
+	o := &Order{UserID: "u-1", MerchantID: "m-1", Status: StatusCreated, CreatedAt: now, UpdatedAt: now}
+	for k, v := range fields {
+		switch k {
+		case "id", "status":
+		case "userId":
+			o.UserID = fmt.Sprint(v)
+		case "merchantId":
+			o.MerchantID = fmt.Sprint(v)
+		case "items":
+			o.Items = v
+		case "amount":
+			if f, ok := v.(float64); ok {
+				o.Amount = f
+			}
+		default:
+			if o.Extra == nil {
+				o.Extra = map[string]interface{}{}
+			}
+			o.Extra[k] = v
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o.ID = strconv.Itoa(s.nextID)
+	s.nextID++
+	s.orders[o.ID] = o
+	s.publishLocked(Event{Type: "order_created", OrderID: o.ID, Status: o.Status, Action: "create", Actor: actor, Order: *o, Time: now})
+	return *o
+}
+
+// Get returns a copy of an order.
+func (s *Store) Get(id string) (Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[id]
+	if !ok {
+		return Order{}, false
+	}
+	return *o, true
+}
+
+// List returns all orders sorted by id.
+func (s *Store) List() []Order {
+	s.mu.Lock()
+	out := make([]Order, 0, len(s.orders))
+	for _, o := range s.orders {
+		out = append(out, *o)
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		a, _ := strconv.Atoi(out[i].ID)
+		b, _ := strconv.Atoi(out[j].ID)
+		return a < b
+	})
+	return out
+}
+
+// Apply runs action on order id, notifies subscribers and returns the event
+// it published. reason is kept for reject and cancel.
+func (s *Store) Apply(id, action, actor, reason string) (Event, error) {
+	next, ok := transitions[action]
+	if !ok {
+		return Event{}, fmt.Errorf("%w %q", ErrUnknownAction, action)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[id]
+	if !ok {
+		return Event{}, ErrNotFound
+	}
+	to, ok := next[o.Status]
+	if !ok {
+		return Event{}, &TransitionError{Action: action, Status: o.Status}
+	}
+	prev := o.Status
+	o.Status = to
+	o.UpdatedAt = time.Now()
+	if reason != "" {
+		o.Reason = reason
+	}
+	ev := Event{Type: "order_" + stateEvent(to), OrderID: o.ID, Status: to, Previous: prev, Action: action, Actor: actor, Order: *o, Time: o.UpdatedAt}
+	s.publishLocked(ev)
+	return ev, nil
+}
+
+func stateEvent(status string) string {
+	switch status {
+	case StatusSubmitted:
+		return "submitted"
+	case StatusAccepted:
+		return "accepted"
+	case StatusRejected:
+		return "rejected"
+	case StatusReady:
+		return "ready"
+	case StatusCancelled:
+		return "cancelled"
+	}
+	return "updated"
+}
+
+// Subscribe returns a channel of matching events and a func that ends the
+// subscription. Events are dropped for subscribers whose buffer is full.
+func (s *Store) Subscribe(f Filter) (<-chan Event, func()) {
+	sub := &subscriber{filter: f, ch: make(chan Event, 64)}
+	s.mu.Lock()
+	s.subs[sub] = struct{}{}
+	s.mu.Unlock()
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.subs, sub)
+			close(sub.ch)
+			s.mu.Unlock()
+		})
+	}
+}
+
+func (s *Store) publishLocked(ev Event) {
+	for sub := range s.subs {
+		if !sub.filter.match(ev) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+		}
+	}
+}
+
+// Reset removes every order; subscriptions stay open.
+func (s *Store) Reset() {
+	s.mu.Lock()
+	s.orders = map[string]*Order{}
+	s.nextID = firstOrderID
+	s.mu.Unlock()
+}
@@ -0,0 +1,58 @@
+package food
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStoreStateMachine(t *testing.T) {
+	s := NewStore()
+	userEvents, cancelUser := s.Subscribe(Filter{UserID: "u-7"})
+	defer cancelUser()
+	merchantEvents, cancelMerchant := s.Subscribe(Filter{MerchantID: "m-1", SkipCreated: true})
+	defer cancelMerchant()
+
+	o := s.Create(map[string]interface{}{"userId": "u-7", "items": []interface{}{"noodles"}, "note": "no cilantro"}, "test")
+	if o.Status != StatusCreated || o.Extra["note"] != "no cilantro" {
+		t.Fatalf("created=%+v", o)
+	}
+	var applied []Event
+	for _, action := range []string{ActionSubmit, ActionAccept, ActionReady} {
+		ev, err := s.Apply(o.ID, action, "test", "")
+		if err != nil {
+			t.Fatalf("%s: %v", action, err)
+		}
+		applied = append(applied, ev)
+	}
+	var te *TransitionError
+	if _, err := s.Apply(o.ID, ActionCancel, "test", ""); !errors.As(err, &te) {
+		t.Fatalf("cancel after ready: %v", err)
+	}
+	if _, err := s.Apply("nope", ActionAccept, "test", ""); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing order: %v", err)
+	}
+
+	want := func(ch <-chan Event, types ...string) []Event {
+		t.Helper()
+		var got []Event
+		for _, typ := range types {
+			select {
+			case ev := <-ch:
+				if ev.Type != typ {
+					t.Fatalf("event=%s want %s", ev.Type, typ)
+				}
+				got = append(got, ev)
+			case <-time.After(time.Second):
+				t.Fatalf("timeout waiting for %s", typ)
+			}
+		}
+		return got
+	}
+	want(userEvents, "order_created", "order_submitted", "order_accepted", "order_ready")
+	// Apply returns exactly the events subscribers receive
+	if got := want(merchantEvents, "order_submitted", "order_accepted", "order_ready"); !reflect.DeepEqual(got, applied) {
+		t.Fatalf("published=%+v applied=%+v", got, applied)
+	}
+}
@@ -0,0 +1,381 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/food"
+)
+
+// Default is the schema served by the HTTP and WS services: users and posts
+// from the user assets, orders from the order assets plus the shared live
+// food store, and refunds from the shared food.Refunds store.
+var Default = newDefaultSchema()
+
+// idOf renders an id from the assets (a JSON number or string) the way the
+// ID scalar does.
+func idOf(v interface{}) string {
+	if s, ok := idType.serialize(v); ok {
+		return s.(string)
+	}
+	return ""
+}
+
+func findByID(list []map[string]interface{}, key, id string) map[string]interface{} {
+	for _, item := range list {
+		if idOf(item[key]) == id {
+			return item
+		}
+	}
+	return nil
+}
+
+func liveOrder(o food.Order) map[string]interface{} {
+	m := map[string]interface{}{
+		"id":         o.ID,
+		"status":     o.Status,
+		"userId":     o.UserID,
+		"merchantId": o.MerchantID,
+		"items":      o.Items,
+		"createdAt":  o.CreatedAt.Format(time.RFC3339),
+		"updatedAt":  o.UpdatedAt.Format(time.RFC3339),
+		"source":     "live",
+	}
+	if o.Amount != 0 {
+		m["amount"] = o.Amount
+	}
+	if o.Reason != "" {
+		m["reason"] = o.Reason
+	}
+	if c, ok := o.Extra["currency"]; ok {
+		m["currency"] = c
+	}
+	return m
+}
+
+func assetOrder(o map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(o)+1)
+	for k, v := range o {
+		m[k] = v
+	}
+	m["source"] = "asset"
+	return m
+}
+
+// lookupOrder finds a live or fixture order.
+func lookupOrder(id string) map[string]interface{} {
+	if ref, ok := food.Default.Lookup(id); ok {
+		return refOrder(ref)
+	}
+	return nil
+}
+
+func refOrder(ref food.OrderRef) map[string]interface{} {
+	if ref.Live != nil {
+		return liveOrder(*ref.Live)
+	}
+	return assetOrder(ref.Fixture)
+}
+
+func liveEvent(ev food.Event) map[string]interface{} {
+	m := map[string]interface{}{
+		"type":    ev.Type,
+		"orderId": ev.OrderID,
+		"status":  ev.Status,
+		"action":  ev.Action,
+		"time":    ev.Time.Format(time.RFC3339Nano),
+		"order":   liveOrder(ev.Order),
+	}
+	if ev.Previous != "" {
+		m["previous"] = ev.Previous
+	}
+	if ev.Actor != "" {
+		m["actor"] = ev.Actor
+	}
+	return m
+}
+
+// userError is a resolver error with an extensions.code.
+func userError(code, format string, args ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, args...), Extensions: map[string]interface{}{"code": code}}
+}
+
+func stringArg(args map[string]interface{}, name string) string {
+	s, _ := args[name].(string)
+	return s
+}
+
+func newDefaultSchema() *Schema {
+	user := &objectType{name: "User", desc: "A user from assets/user/users.json."}
+	post := &objectType{name: "Post", desc: "A post from assets/user/posts.json."}
+	order := &objectType{name: "Order", desc: "An order: fixture orders from assets/order/orders.json and live orders from the shared food store."}
+	refund := &objectType{name: "Refund", desc: "A refund from assets/payment/refunds.json or created through createRefund."}
+
+	postStats := &objectType{name: "PostStats", fields: []*field{
+		{name: "views", typ: nonNull(intType)},
+		{name: "likes", typ: nonNull(intType)},
+		{name: "comments", typ: nonNull(intType)},
+	}}
+	orderItem := &objectType{name: "OrderItem", fields: []*field{
+		{name: "sku", typ: stringType},
+		{name: "qty", typ: intType},
+		{name: "name", typ: stringType},
+		{name: "price", typ: floatType},
+	}}
+	orderEvent := &objectType{name: "OrderEvent", desc: "A state change of a live order.", fields: []*field{
+		{name: "type", typ: nonNull(stringType), desc: "order_created, order_submitted, order_accepted, ..."},
+		{name: "orderId", typ: nonNull(idType)},
+		{name: "status", typ: nonNull(stringType)},
+		{name: "previous", typ: stringType},
+		{name: "action", typ: nonNull(stringType)},
+		{name: "actor", typ: stringType},
+		{name: "time", typ: nonNull(stringType)},
+		{name: "order", typ: nonNull(order)},
+	}}
+	orderAction := &enumType{name: "OrderAction", desc: "Actions of the food order state machine.", values: []enumValue{
+		{name: "SUBMIT", value: food.ActionSubmit},
+		{name: "ACCEPT", value: food.ActionAccept},
+		{name: "REJECT", value: food.ActionReject},
+		{name: "READY", value: food.ActionReady},
+		{name: "CANCEL", value: food.ActionCancel},
+	}}
+	orderItemInput := &inputObjectType{name: "OrderItemInput", fields: []*argument{
+		{name: "sku", typ: nonNull(stringType)},
+		{name: "qty", typ: nonNull(intType), def: int64(1), hasDef: true},
+		{name: "price", typ: floatType},
+	}}
+	createOrderInput := &inputObjectType{name: "CreateOrderInput", fields: []*argument{
+		{name: "userId", typ: idType},
+		{name: "merchantId", typ: idType},
+		{name: "items", typ: listOf(nonNull(orderItemInput))},
+		{name: "amount", typ: floatType},
+		{name: "currency", typ: stringType},
+	}}
+	createRefundInput := &inputObjectType{name: "CreateRefundInput", fields: []*argument{
+		{name: "orderId", typ: nonNull(idType)},
+		{name: "amount", typ: nonNull(floatType)},
+		{name: "reason", typ: stringType},
+	}}
+
+	usersByStatus := func(status string) []map[string]interface{} {
+		out := []map[string]interface{}{}
+		for _, u := range common.AssetList("user", "users.json") {
+			if status == "" || u["status"] == status {
+				out = append(out, u)
+			}
+		}
+		return out
+	}
+	postsBy := func(authorID string, published interface{}) []map[string]interface{} {
+		out := []map[string]interface{}{}
+		for _, p := range common.AssetList("user", "posts.json") {
+			author, _ := p["author"].(map[string]interface{})
+			if authorID != "" && idOf(author["id"]) != authorID {
+				continue
+			}
+			if published != nil && p["published"] != published {
+				continue
+			}
+			out = append(out, p)
+		}
+		return out
+	}
+	refundsBy := func(orderID, status string) []map[string]interface{} {
+		out := []map[string]interface{}{}
+		for _, r := range food.Refunds.List() {
+			if orderID != "" && idOf(r["orderId"]) != orderID {
+				continue
+			}
+			if status != "" && r["status"] != status {
+				continue
+			}
+			out = append(out, r)
+		}
+		return out
+	}
+
+	user.fields = []*field{
+		{name: "id", typ: nonNull(idType)},
+		{name: "name", typ: nonNull(stringType)},
+		{name: "status", typ: stringType},
+		{name: "department", typ: stringType},
+		{name: "roles", typ: listOf(nonNull(stringType))},
+		{name: "posts", typ: nonNull(listOf(nonNull(post))), resolve: func(p resolveParams) (interface{}, error) {
+			return postsBy(idOf(p.source.(map[string]interface{})["id"]), nil), nil
+		}},
+	}
+	post.fields = []*field{
+		{name: "id", typ: nonNull(idType)},
+		{name: "title", typ: nonNull(stringType)},
+		{name: "category", typ: stringType},
+		{name: "tags", typ: listOf(nonNull(stringType))},
+		{name: "published", typ: nonNull(booleanType)},
+		{name: "createdAt", typ: stringType},
+		{name: "stats", typ: postStats},
+		{name: "author", typ: user, desc: "The author from the user list, or the embedded author when the id is unknown.", resolve: func(p resolveParams) (interface{}, error) {
+			author, _ := p.source.(map[string]interface{})["author"].(map[string]interface{})
+			if author == nil {
+				return nil, nil
+			}
+			if u := findByID(common.AssetList("user", "users.json"), "id", idOf(author["id"])); u != nil {
+				return u, nil
+			}
+			return author, nil
+		}},
+	}
+	order.fields = []*field{
+		{name: "id", typ: nonNull(idType)},
+		{name: "status", typ: nonNull(stringType)},
+		{name: "userId", typ: idType},
+		{name: "merchantId", typ: idType},
+		{name: "items", typ: listOf(nonNull(orderItem))},
+		{name: "amount", typ: floatType},
+		{name: "currency", typ: stringType},
+		{name: "reason", typ: stringType},
+		{name: "createdAt", typ: stringType},
+		{name: "updatedAt", typ: stringType},
+		{name: "source", typ: nonNull(stringType), desc: "\"asset\" for fixture orders, \"live\" for orders in the food store."},
+		{name: "refunds", typ: nonNull(listOf(nonNull(refund))), resolve: func(p resolveParams) (interface{}, error) {
+			return refundsBy(idOf(p.source.(map[string]interface{})["id"]), ""), nil
+		}},
+	}
+	refund.fields = []*field{
+		{name: "refundId", typ: nonNull(idType)},
+		{name: "orderId", typ: nonNull(idType)},
+		{name: "status", typ: nonNull(stringType)},
+		{name: "amount", typ: floatType},
+		{name: "reason", typ: stringType},
+		{name: "order", typ: order, resolve: func(p resolveParams) (interface{}, error) {
+			if o := lookupOrder(idOf(p.source.(map[string]interface{})["orderId"])); o != nil {
+				return o, nil
+			}
+			return nil, nil
+		}},
+	}
+
+	query := &objectType{name: "Query", fields: []*field{
+		{name: "user", typ: user, args: []*argument{{name: "id", typ: nonNull(idType)}}, resolve: func(p resolveParams) (interface{}, error) {
+			if u := findByID(common.AssetList("user", "users.json"), "id", stringArg(p.args, "id")); u != nil {
+				return u, nil
+			}
+			return nil, nil
+		}},
+		{name: "users", typ: nonNull(listOf(nonNull(user))), args: []*argument{{name: "status", typ: stringType}}, resolve: func(p resolveParams) (interface{}, error) {
+			return usersByStatus(stringArg(p.args, "status")), nil
+		}},
+		{name: "post", typ: post, args: []*argument{{name: "id", typ: nonNull(idType)}}, resolve: func(p resolveParams) (interface{}, error) {
+			if found := findByID(common.AssetList("user", "posts.json"), "id", stringArg(p.args, "id")); found != nil {
+				return found, nil
+			}
+			return nil, nil
+		}},
+		{name: "posts", typ: nonNull(listOf(nonNull(post))), args: []*argument{{name: "authorId", typ: idType}, {name: "published", typ: booleanType}}, resolve: func(p resolveParams) (interface{}, error) {
+			return postsBy(stringArg(p.args, "authorId"), p.args["published"]), nil
+		}},
+		{name: "order", typ: order, args: []*argument{{name: "id", typ: nonNull(idType)}}, resolve: func(p resolveParams) (interface{}, error) {
+			if o := lookupOrder(stringArg(p.args, "id")); o != nil {
+				return o, nil
+			}
+			return nil, nil
+		}},
+		{name: "orders", typ: nonNull(listOf(nonNull(order))), desc: "Fixture orders followed by live orders.", args: []*argument{{name: "status", typ: stringType}}, resolve: func(p resolveParams) (interface{}, error) {
+			status := stringArg(p.args, "status")
+			out := []map[string]interface{}{}
+			for _, ref := range food.Default.All() {
+				if status == "" || ref.Status() == status {
+					out = append(out, refOrder(ref))
+				}
+			}
+			return out, nil
+		}},
+		{name: "refund", typ: refund, args: []*argument{{name: "refundId", typ: nonNull(idType)}}, resolve: func(p resolveParams) (interface{}, error) {
+			if r := findByID(food.Refunds.List(), "refundId", stringArg(p.args, "refundId")); r != nil {
+				return r, nil
+			}
+			return nil, nil
+		}},
+		{name: "refunds", typ: nonNull(listOf(nonNull(refund))), args: []*argument{{name: "orderId", typ: idType}, {name: "status", typ: stringType}}, resolve: func(p resolveParams) (interface{}, error) {
+			return refundsBy(stringArg(p.args, "orderId"), stringArg(p.args, "status")), nil
+		}},
+	}}
+
+	mutation := &objectType{name: "Mutation", fields: []*field{
+		{name: "createOrder", typ: nonNull(order), desc: "Creates a live order in CREATED state.", args: []*argument{{name: "input", typ: nonNull(createOrderInput)}}, resolve: func(p resolveParams) (interface{}, error) {
+			in := p.args["input"].(map[string]interface{})
+			fields := map[string]interface{}{}
+			for k, v := range in {
+				if v != nil {
+					fields[k] = v
+				}
+			}
+			return liveOrder(food.Default.Create(fields, "graphql")), nil
+		}},
+		{name: "updateOrderStatus", typ: nonNull(order), desc: "Runs an action of the order state machine on a live order.", args: []*argument{
+			{name: "id", typ: nonNull(idType)},
+			{name: "action", typ: nonNull(orderAction)},
+			{name: "reason", typ: stringType},
+		}, resolve: func(p resolveParams) (interface{}, error) {
+			id := stringArg(p.args, "id")
+			ev, err := food.Default.Apply(id, stringArg(p.args, "action"), "graphql", stringArg(p.args, "reason"))
+			var terr *food.TransitionError
+			switch {
+			case errors.Is(err, food.ErrNotFound):
+				return nil, userError("NOT_FOUND", "order %s not found in the live order store", id)
+			case errors.As(err, &terr):
+				return nil, userError("CONFLICT", "%s", terr.Error())
+			case err != nil:
+				return nil, err
+			}
+			return liveOrder(ev.Order), nil
+		}},
+		{name: "createRefund", typ: nonNull(refund), desc: "Records a PENDING refund for an existing order.", args: []*argument{{name: "input", typ: nonNull(createRefundInput)}}, resolve: func(p resolveParams) (interface{}, error) {
+			in := p.args["input"].(map[string]interface{})
+			orderID := in["orderId"].(string)
+			amount := in["amount"].(float64)
+			if lookupOrder(orderID) == nil {
+				return nil, userError("NOT_FOUND", "order %s not found", orderID)
+			}
+			if amount <= 0 {
+				return nil, userError("BAD_USER_INPUT", "refund amount must be positive")
+			}
+			fields := map[string]interface{}{"orderId": orderID, "amount": amount}
+			if in["reason"] != nil {
+				fields["reason"] = in["reason"]
+			}
+			return food.Refunds.Create(fields), nil
+		}},
+	}}
+
+	subscription := &objectType{name: "Subscription", fields: []*field{
+		{
+			name: "orderStatusChanged",
+			desc: "Streams state changes of live orders, optionally filtered by order, user or merchant.",
+			typ:  nonNull(orderEvent),
+			args: []*argument{{name: "orderId", typ: idType}, {name: "userId", typ: idType}, {name: "merchantId", typ: idType}},
+			subscribe: func(p resolveParams) (<-chan interface{}, func(), error) {
+				ch, cancel := food.Default.Subscribe(food.Filter{
+					OrderID:    stringArg(p.args, "orderId"),
+					UserID:     stringArg(p.args, "userId"),
+					MerchantID: stringArg(p.args, "merchantId"),
+				})
+				out := make(chan interface{})
+				go func() {
+					defer close(out)
+					for ev := range ch {
+						select {
+						case out <- liveEvent(ev):
+						case <-p.ctx.Done():
+							return
+						}
+					}
+				}()
+				return out, cancel, nil
+			},
+			resolve: func(p resolveParams) (interface{}, error) { return p.source, nil },
+		},
+	}}
+
+	return newSchema(query, mutation, subscription)
+}
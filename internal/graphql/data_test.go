@@ -0,0 +1,113 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/food"
+)
+
+func TestDefaultSchemaQueries(t *testing.T) {
+	got := run(t, Default, `{
+		user(id: 2) { id name roles posts { id title stats { views } } }
+		posts(published: true) { id author { name department } }
+		order(id: "2001") { id status amount source items { sku qty } refunds { refundId status } }
+		refund(refundId: "RF-00002") { amount order { id currency } }
+	}`, nil)
+	want := `{"data":{` +
+		`"user":{"id":"2","name":"李四","roles":["viewer"],"posts":[{"id":"102","title":"Gopher Tips","stats":{"views":560}}]},` +
+		`"posts":[{"id":"101","author":{"name":"张三","department":"platform"}},{"id":"103","author":{"name":"王五","department":"sales"}}],` +
+		`"order":{"id":"2001","status":"CREATED","amount":49.9,"source":"asset","items":[{"sku":"A-1","qty":2}],"refunds":[{"refundId":"RF-00001","status":"SUCCESS"}]},` +
+		`"refund":{"amount":19.9,"order":{"id":"2002","currency":"CNY"}}}}`
+	if got != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestDefaultSchemaMutations(t *testing.T) {
+	t.Cleanup(func() {
+		food.Default.Reset()
+		food.Refunds.Reset()
+	})
+	got := run(t, Default, `mutation($in: CreateOrderInput!) {
+		createOrder(input: $in) { id status userId amount items { sku qty } source }
+	}`, map[string]interface{}{"in": map[string]interface{}{
+		"userId": "u-7", "amount": 12.5, "items": []interface{}{map[string]interface{}{"sku": "TEA"}},
+	}})
+	var created struct {
+		Data struct {
+			CreateOrder map[string]interface{} `json:"createOrder"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(got), &created); err != nil || created.Data.CreateOrder == nil {
+		t.Fatalf("createOrder: %s", got)
+	}
+	o := created.Data.CreateOrder
+	id, _ := o["id"].(string)
+	if o["status"] != "CREATED" || o["userId"] != "u-7" || o["source"] != "live" || !strings.Contains(got, `"items":[{"sku":"TEA","qty":1}]`) {
+		t.Fatalf("createOrder: %s", got)
+	}
+
+	got = run(t, Default, `mutation { a: updateOrderStatus(id: "`+id+`", action: SUBMIT) { status } b: updateOrderStatus(id: "`+id+`", action: READY) { status } }`, nil)
+	if !strings.HasPrefix(got, `{"errors":[{"message":"cannot ready an order in status SUBMITTED",`) || !strings.HasSuffix(got, `"path":["b"],"extensions":{"code":"CONFLICT"}}],"data":null}`) {
+		t.Fatalf("serial mutations: %s", got)
+	}
+	if o, _ := food.Default.Get(id); o.Status != food.StatusSubmitted {
+		t.Fatalf("status after mutations=%s", o.Status)
+	}
+
+	got = run(t, Default, `mutation { createRefund(input: {orderId: "`+id+`", amount: 5, reason: "cold"}) { refundId status order { id } } }`, nil)
+	if got != `{"data":{"createRefund":{"refundId":"RF-00003","status":"PENDING","order":{"id":"`+id+`"}}}}` {
+		t.Fatalf("createRefund: %s", got)
+	}
+	got = run(t, Default, `mutation { createRefund(input: {orderId: "404", amount: 5}) { refundId } }`, nil)
+	if !strings.Contains(got, `"extensions":{"code":"NOT_FOUND"}`) {
+		t.Fatalf("createRefund unknown order: %s", got)
+	}
+}
+
+func TestDefaultSchemaSubscription(t *testing.T) {
+	t.Cleanup(food.Default.Reset)
+	o := food.Default.Create(map[string]interface{}{"userId": "u-9"}, "test")
+	op, errs := Default.Prepare(Request{
+		Query:     `subscription($id: ID) { orderStatusChanged(orderId: $id) { type status previous order { id status } } }`,
+		Variables: map[string]interface{}{"id": o.ID},
+	})
+	if len(errs) > 0 {
+		t.Fatalf("prepare: %+v", errs)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	results, err := op.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if _, err := food.Default.Apply(o.ID, food.ActionSubmit, "test", ""); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	select {
+	case res := <-results:
+		b, _ := json.Marshal(res)
+		if string(b) != `{"data":{"orderStatusChanged":{"type":"order_submitted","status":"SUBMITTED","previous":"CREATED","order":{"id":"`+o.ID+`","status":"SUBMITTED"}}}}` {
+			t.Fatalf("event: %s", b)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no event")
+	}
+	cancel()
+	select {
+	case _, ok := <-results:
+		if ok {
+			t.Fatalf("result after cancel")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("stream not closed")
+	}
+
+	if _, errs := Default.Prepare(Request{Query: `subscription { a: orderStatusChanged { type } b: orderStatusChanged { type } }`}); len(errs) == 0 ||
+		errs[0].Message != "Anonymous Subscription must select only one top level field." {
+		t.Fatalf("two root fields: %+v", errs)
+	}
+}
@@ -0,0 +1,374 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// MaxRequestSize bounds a request body or subscribe message, so a huge
+// document cannot exhaust memory before it is parsed.
+const MaxRequestSize = 4 << 20
+
+// Request is a GraphQL request as sent over HTTP or in a graphql-transport-ws
+// subscribe payload.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Operation is a parsed, validated operation with coerced variables.
+type Operation struct {
+	schema *Schema
+	doc    *document
+	op     *operationDef
+	vars   map[string]interface{}
+}
+
+// Prepare parses and validates req and picks the operation to run.
+func (s *Schema) Prepare(req Request) (*Operation, []*Error) {
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, []*Error{{Message: "Must provide query string."}}
+	}
+	doc, err := parse(req.Query)
+	if err != nil {
+		var gerr *Error
+		if errors.As(err, &gerr) {
+			return nil, []*Error{gerr}
+		}
+		return nil, []*Error{{Message: err.Error()}}
+	}
+	if errs := s.validate(doc); len(errs) > 0 {
+		return nil, errs
+	}
+	var op *operationDef
+	switch {
+	case req.OperationName != "":
+		for _, candidate := range doc.operations {
+			if candidate.name == req.OperationName {
+				op = candidate
+			}
+		}
+		if op == nil {
+			return nil, []*Error{{Message: fmt.Sprintf("Unknown operation named \"%s\".", req.OperationName)}}
+		}
+	case len(doc.operations) > 1:
+		return nil, []*Error{{Message: "Must provide operation name if query contains multiple operations."}}
+	default:
+		op = doc.operations[0]
+	}
+	vars, errs := s.coerceVariables(op, req.Variables)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &Operation{schema: s, doc: doc, op: op, vars: vars}, nil
+}
+
+// Kind is "query", "mutation" or "subscription".
+func (o *Operation) Kind() string { return o.op.kind }
+
+// Execute runs a query or mutation. Mutation root fields run one after
+// another, in document order.
+func (o *Operation) Execute(ctx context.Context) *Result {
+	if o.op.kind == "subscription" {
+		return errorResult(&Error{Message: "Subscriptions must be started with Subscribe.", Locations: []Location{o.op.loc}})
+	}
+	return o.run(ctx, o.schema.root(o.op.kind), nil)
+}
+
+// Execute prepares and runs a query or mutation in one step.
+func (s *Schema) Execute(ctx context.Context, req Request) *Result {
+	op, errs := s.Prepare(req)
+	if len(errs) > 0 {
+		return errorResult(errs...)
+	}
+	return op.Execute(ctx)
+}
+
+// Subscribe starts a subscription. Each event from the root field's stream
+// is executed against the selection set and delivered as a result; the
+// channel closes when the stream ends or ctx is cancelled.
+func (o *Operation) Subscribe(ctx context.Context) (<-chan *Result, error) {
+	if o.op.kind != "subscription" {
+		return nil, &Error{Message: fmt.Sprintf("Cannot subscribe to a %s operation.", o.op.kind), Locations: []Location{o.op.loc}}
+	}
+	root := o.schema.subscription
+	e := &executor{ctx: ctx, s: o.schema, doc: o.doc, vars: o.vars}
+	fields := e.collectFields(root, o.op.selections, map[string]bool{})
+	if len(fields) == 0 {
+		return nil, &Error{Message: "Subscription selected no fields.", Locations: []Location{o.op.loc}}
+	}
+	node := fields[0].nodes[0]
+	def := root.field(node.name)
+	if def == nil || def.subscribe == nil {
+		return nil, errorAt(node.loc, "Subscription field \"%s\" is not supported.", node.name)
+	}
+	args, aerr := o.schema.coerceArgs(def.args, node.args, o.vars, node.loc)
+	if aerr != nil {
+		aerr.Path = []interface{}{fields[0].key}
+		return nil, aerr
+	}
+	events, cancel, err := def.subscribe(resolveParams{ctx: ctx, args: args})
+	if err != nil {
+		return nil, &Error{Message: err.Error(), Locations: []Location{node.loc}, Path: []interface{}{fields[0].key}}
+	}
+	out := make(chan *Result)
+	go func() {
+		defer close(out)
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				select {
+				case out <- o.run(ctx, root, ev):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// run executes the operation's selection set against root with the given
+// root value.
+func (o *Operation) run(ctx context.Context, root *objectType, rootValue interface{}) *Result {
+	e := &executor{ctx: ctx, s: o.schema, doc: o.doc, vars: o.vars}
+	data, failed := e.object(root, rootValue, o.op.selections, nil)
+	res := &Result{Errors: e.errs, hasData: true}
+	if !failed {
+		res.Data = data
+	}
+	return res
+}
+
+type executor struct {
+	ctx  context.Context
+	s    *Schema
+	doc  *document
+	vars map[string]interface{}
+	errs []*Error
+}
+
+// collectedField is one response key and the field nodes merged under it.
+type collectedField struct {
+	key   string
+	nodes []*fieldNode
+}
+
+func (e *executor) collectFields(t *objectType, sels []selection, visited map[string]bool) []*collectedField {
+	var out []*collectedField
+	index := map[string]*collectedField{}
+	var walk func(sels []selection)
+	walk = func(sels []selection) {
+		for _, sel := range sels {
+			switch n := sel.(type) {
+			case *fieldNode:
+				if !e.included(n.directives) {
+					continue
+				}
+				if cf, ok := index[n.responseKey()]; ok {
+					cf.nodes = append(cf.nodes, n)
+					continue
+				}
+				cf := &collectedField{key: n.responseKey(), nodes: []*fieldNode{n}}
+				index[cf.key] = cf
+				out = append(out, cf)
+			case *inlineFragment:
+				if e.included(n.directives) && (n.typeCond == "" || n.typeCond == t.name) {
+					walk(n.selections)
+				}
+			case *fragmentSpread:
+				if visited[n.name] || !e.included(n.directives) {
+					continue
+				}
+				visited[n.name] = true
+				if f, ok := e.doc.fragments[n.name]; ok && f.typeCond == t.name {
+					walk(f.selections)
+				}
+			}
+		}
+	}
+	walk(sels)
+	return out
+}
+
+// included applies @skip and @include.
+func (e *executor) included(ds []*directive) bool {
+	for _, d := range ds {
+		if d.name != "skip" && d.name != "include" {
+			continue
+		}
+		for _, def := range e.s.directives {
+			if def.name != d.name {
+				continue
+			}
+			args, err := e.s.coerceArgs(def.args, d.args, e.vars, d.loc)
+			if err != nil {
+				continue
+			}
+			if (d.name == "skip") == (args["if"] == true) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// object executes a selection set. failed reports that a non-null field
+// came back null, so the object itself must become null.
+func (e *executor) object(t *objectType, source interface{}, sels []selection, path []interface{}) (*orderedMap, bool) {
+	out := newOrderedMap()
+	for _, cf := range e.collectFields(t, sels, map[string]bool{}) {
+		fieldPath := appendPath(path, cf.key)
+		node := cf.nodes[0]
+		if node.name == "__typename" {
+			out.set(cf.key, t.name)
+			continue
+		}
+		def := e.s.fieldOn(t, node.name)
+		v, failed := e.field(t, def, source, cf.nodes, fieldPath)
+		if failed {
+			return nil, true
+		}
+		out.set(cf.key, v)
+	}
+	return out, false
+}
+
+// field resolves and completes one field. failed propagates a null into
+// the parent when the field is non-null.
+func (e *executor) field(parent *objectType, def *field, source interface{}, nodes []*fieldNode, path []interface{}) (interface{}, bool) {
+	node := nodes[0]
+	args, aerr := e.s.coerceArgs(def.args, node.args, e.vars, node.loc)
+	if aerr != nil {
+		aerr.Path = path
+		e.errs = append(e.errs, aerr)
+		return e.nullFor(def.typ)
+	}
+	var v interface{}
+	if def.resolve != nil {
+		var err error
+		v, err = def.resolve(resolveParams{ctx: e.ctx, source: source, args: args})
+		if err != nil {
+			e.fail(err, node.loc, path)
+			return e.nullFor(def.typ)
+		}
+	} else if m, ok := source.(map[string]interface{}); ok {
+		v = m[node.name]
+	}
+	var sub []selection
+	for _, n := range nodes {
+		sub = append(sub, n.selections...)
+	}
+	return e.complete(def.typ, parent.name+"."+def.name, sub, v, node.loc, path)
+}
+
+func (e *executor) fail(err error, loc Location, path []interface{}) {
+	var gerr *Error
+	if errors.As(err, &gerr) {
+		cp := *gerr
+		cp.Locations = []Location{loc}
+		cp.Path = path
+		e.errs = append(e.errs, &cp)
+		return
+	}
+	e.errs = append(e.errs, &Error{Message: err.Error(), Locations: []Location{loc}, Path: path})
+}
+
+// nullFor is the outcome of a failed field: null, and failed if the field
+// is non-null.
+func (e *executor) nullFor(t gqlType) (interface{}, bool) {
+	_, required := t.(*nonNullType)
+	return nil, required
+}
+
+// complete shapes a resolved value to its type. A failure in a nullable
+// position is absorbed here as null; in a non-null position it propagates.
+func (e *executor) complete(t gqlType, fieldName string, sels []selection, v interface{}, loc Location, path []interface{}) (interface{}, bool) {
+	if nn, ok := t.(*nonNullType); ok {
+		out, failed := e.completeValue(nn.of, fieldName, sels, v, loc, path)
+		if failed {
+			return nil, true
+		}
+		if out == nil {
+			e.errs = append(e.errs, &Error{Message: fmt.Sprintf("Cannot return null for non-nullable field %s.", fieldName), Locations: []Location{loc}, Path: path})
+			return nil, true
+		}
+		return out, false
+	}
+	out, failed := e.completeValue(t, fieldName, sels, v, loc, path)
+	if failed {
+		return nil, false
+	}
+	return out, false
+}
+
+func (e *executor) completeValue(t gqlType, fieldName string, sels []selection, v interface{}, loc Location, path []interface{}) (interface{}, bool) {
+	if isNil(v) {
+		return nil, false
+	}
+	switch n := t.(type) {
+	case *listType:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.errs = append(e.errs, &Error{Message: fmt.Sprintf("Expected Iterable, but did not find one for field \"%s\".", fieldName), Locations: []Location{loc}, Path: path})
+			return nil, true
+		}
+		out := make([]interface{}, rv.Len())
+		for i := range out {
+			item, failed := e.complete(n.of, fieldName, sels, rv.Index(i).Interface(), loc, appendPath(path, i))
+			if failed {
+				return nil, true
+			}
+			out[i] = item
+		}
+		return out, false
+	case *objectType:
+		obj, failed := e.object(n, v, sels, path)
+		if failed {
+			return nil, true
+		}
+		return obj, false
+	case *enumType:
+		if ev := n.byValue(v); ev != nil {
+			return ev.name, false
+		}
+		e.errs = append(e.errs, &Error{Message: fmt.Sprintf("Enum \"%s\" cannot represent value: %s", n.name, inspect(v)), Locations: []Location{loc}, Path: path})
+		return nil, true
+	case *scalarType:
+		if out, ok := n.serialize(v); ok {
+			return out, false
+		}
+		e.errs = append(e.errs, &Error{Message: fmt.Sprintf("%s cannot represent value: %s", n.name, inspect(v)), Locations: []Location{loc}, Path: path})
+		return nil, true
+	}
+	e.errs = append(e.errs, &Error{Message: fmt.Sprintf("Cannot complete value of type \"%s\".", t), Locations: []Location{loc}, Path: path})
+	return nil, true
+}
+
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+func appendPath(path []interface{}, elem interface{}) []interface{} {
+	out := make([]interface{}, len(path), len(path)+1)
+	copy(out, path)
+	return append(out, elem)
+}
@@ -0,0 +1,172 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testSchema is a small schema exercising nullability and arguments.
+func testSchema() *Schema {
+	pet := &objectType{name: "Pet", fields: []*field{
+		{name: "name", typ: nonNull(stringType)},
+		{name: "age", typ: intType},
+		{name: "owner", typ: nonNull(stringType)},
+	}}
+	color := &enumType{name: "Color", values: []enumValue{{name: "RED", value: "red"}, {name: "BLUE", value: "blue"}}}
+	query := &objectType{name: "Query", fields: []*field{
+		{name: "hello", typ: nonNull(stringType), args: []*argument{{name: "name", typ: stringType, def: "world", hasDef: true}},
+			resolve: func(p resolveParams) (interface{}, error) { return "hello " + p.args["name"].(string), nil }},
+		{name: "pets", typ: listOf(nonNull(pet)), resolve: constant([]interface{}{
+			map[string]interface{}{"name": "rex", "age": 3, "owner": "ann"},
+			map[string]interface{}{"name": "tom", "age": 5},
+		})},
+		{name: "pet", typ: pet, resolve: constant(map[string]interface{}{"name": "rex", "owner": "ann"})},
+		{name: "boom", typ: stringType, resolve: func(resolveParams) (interface{}, error) { return nil, errors.New("boom") }},
+		{name: "paint", typ: nonNull(color), args: []*argument{{name: "color", typ: nonNull(color)}},
+			resolve: func(p resolveParams) (interface{}, error) { return p.args["color"], nil }},
+		{name: "sum", typ: nonNull(intType), args: []*argument{{name: "values", typ: nonNull(listOf(nonNull(intType)))}},
+			resolve: func(p resolveParams) (interface{}, error) {
+				var total int64
+				for _, v := range p.args["values"].([]interface{}) {
+					total += v.(int64)
+				}
+				return total, nil
+			}},
+	}}
+	return newSchema(query, nil, nil)
+}
+
+func run(t *testing.T, s *Schema, query string, vars map[string]interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(s.Execute(context.Background(), Request{Query: query, Variables: vars}))
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return string(b)
+}
+
+func TestExecuteBasics(t *testing.T) {
+	s := testSchema()
+	cases := []struct {
+		query string
+		vars  map[string]interface{}
+		want  string
+	}{
+		{`{ hello }`, nil, `{"data":{"hello":"hello world"}}`},
+		{`query Q($n: String) { greeting: hello(name: $n) __typename }`, map[string]interface{}{"n": "gql"}, `{"data":{"greeting":"hello gql","__typename":"Query"}}`},
+		{`{ pet { ...P } } fragment P on Pet { name age }`, nil, `{"data":{"pet":{"name":"rex","age":null}}}`},
+		{`query($skip: Boolean!) { pet { name age @skip(if: $skip) } }`, map[string]interface{}{"skip": true}, `{"data":{"pet":{"name":"rex"}}}`},
+		{`{ paint(color: BLUE) }`, nil, `{"data":{"paint":"BLUE"}}`},
+		{`query($c: Color!) { paint(color: $c) }`, map[string]interface{}{"c": "RED"}, `{"data":{"paint":"RED"}}`},
+		{`{ sum(values: [1, 2, 3]) }`, nil, `{"data":{"sum":6}}`},
+		{`query($v: [Int!]!) { sum(values: $v) }`, map[string]interface{}{"v": float64(4)}, `{"data":{"sum":4}}`},
+	}
+	for _, c := range cases {
+		if got := run(t, s, c.query, c.vars); got != c.want {
+			t.Errorf("%s\n got  %s\n want %s", c.query, got, c.want)
+		}
+	}
+}
+
+func TestExecuteNullPropagation(t *testing.T) {
+	s := testSchema()
+	got := run(t, s, `{ boom hello }`, nil)
+	if got != `{"errors":[{"message":"boom","locations":[{"line":1,"column":3}],"path":["boom"]}],"data":{"boom":null,"hello":"hello world"}}` {
+		t.Fatalf("resolver error: %s", got)
+	}
+	// the second pet has no owner: the non-null item fails, so the nullable
+	// list becomes null
+	got = run(t, s, `{ pets { name owner } }`, nil)
+	if got != `{"errors":[{"message":"Cannot return null for non-nullable field Pet.owner.","locations":[{"line":1,"column":15}],"path":["pets",1,"owner"]}],"data":{"pets":null}}` {
+		t.Fatalf("propagation: %s", got)
+	}
+}
+
+func TestValidationAndRequestErrors(t *testing.T) {
+	s := testSchema()
+	cases := []struct {
+		query string
+		vars  map[string]interface{}
+		want  string
+	}{
+		{`{ hello(`, nil, `Syntax Error: Expected Name, found <EOF>.`},
+		{`{ nope }`, nil, `Cannot query field "nope" on type "Query".`},
+		{`{ pet }`, nil, `Field "pet" of type "Pet" must have a selection of subfields. Did you mean "pet { ... }"?`},
+		{`{ hello { x } }`, nil, `Field "hello" must not have a selection since type "String!" has no subfields.`},
+		{`{ paint }`, nil, `Field "paint" argument "color" of type "Color!" is required, but it was not provided.`},
+		{`{ paint(color: "RED") }`, nil, `Expected value of type "Color!", found "RED".`},
+		{`{ hello(nick: "x") }`, nil, `Unknown argument "nick" on field "Query.hello".`},
+		{`query($x: Int) { hello }`, nil, `Variable "$x" is never used in anonymous operation.`},
+		{`{ hello(name: $x) }`, nil, `Variable "$x" is not defined by anonymous operation.`},
+		{`query($n: Int) { hello(name: $n) }`, nil, `Variable "$n" of type "Int" used in position expecting type "String".`},
+		{`{ pet { ...A } } fragment A on Pet { ...A }`, nil, `Cannot spread fragment "A" within itself.`},
+		{`{ hello @cache }`, nil, `Unknown directive "@cache".`},
+		{`query($c: Color!) { paint(color: $c) }`, map[string]interface{}{"c": "GREEN"}, `Variable "$c" got invalid value "GREEN"; Value "GREEN" does not exist in "Color" enum.`},
+		{`query($v: [Int!]!) { sum(values: $v) }`, map[string]interface{}{"v": []interface{}{1.5}}, `Variable "$v" got invalid value [1.5]; at index 0: Int cannot represent value: 1.5`},
+		{`mutation { x }`, nil, `Schema is not configured to execute mutation operation.`},
+	}
+	for _, c := range cases {
+		res := s.Execute(context.Background(), Request{Query: c.query, Variables: c.vars})
+		if res.hasData || len(res.Errors) == 0 || res.Errors[0].Message != c.want {
+			b, _ := json.Marshal(res)
+			t.Errorf("%s\n got  %s\n want %s", c.query, b, c.want)
+		}
+	}
+	// nesting deeper than maxDepth is refused instead of overflowing the stack
+	for _, deep := range []string{
+		strings.Repeat("{ pet ", 100000),
+		"{ sum(values: " + strings.Repeat("[", 100000) + ") }",
+		"query($v: " + strings.Repeat("[", 100000) + "Int) { hello }",
+	} {
+		if _, errs := s.Prepare(Request{Query: deep}); len(errs) != 1 || errs[0].Message != "Document exceeds the maximum nesting depth of 64." {
+			t.Fatalf("deep document: %+v", errs)
+		}
+	}
+	if _, errs := s.Prepare(Request{Query: strings.Repeat("{ pet ", 63) + "{ name }" + strings.Repeat("}", 63)}); len(errs) == 0 || strings.Contains(errs[0].Message, "nesting depth") {
+		t.Fatalf("document at the depth limit: %+v", errs)
+	}
+	_, errs := s.Prepare(Request{Query: `query A { hello } query B { hello }`})
+	if len(errs) != 1 || !strings.Contains(errs[0].Message, "Must provide operation name") {
+		t.Fatalf("operation name: %+v", errs)
+	}
+	op, errs := s.Prepare(Request{Query: `query A { hello } query B { hello(name: "b") }`, OperationName: "B"})
+	if len(errs) > 0 {
+		t.Fatalf("prepare B: %+v", errs)
+	}
+	if b, _ := json.Marshal(op.Execute(context.Background())); string(b) != `{"data":{"hello":"hello b"}}` {
+		t.Fatalf("operation B: %s", b)
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	s := testSchema()
+	got := run(t, s, `{ __type(name: "Color") { kind name enumValues { name } } }`, nil)
+	if got != `{"data":{"__type":{"kind":"ENUM","name":"Color","enumValues":[{"name":"RED"},{"name":"BLUE"}]}}}` {
+		t.Fatalf("__type: %s", got)
+	}
+	got = run(t, s, `{ __schema { queryType { name } mutationType { name } } }`, nil)
+	if got != `{"data":{"__schema":{"queryType":{"name":"Query"},"mutationType":null}}}` {
+		t.Fatalf("__schema: %s", got)
+	}
+	got = run(t, s, `{ __type(name: "Query") { fields { name args { name defaultValue type { kind ofType { name } } } } } }`, nil)
+	if !strings.Contains(got, `{"name":"hello","args":[{"name":"name","defaultValue":"\"world\"","type":{"kind":"SCALAR","ofType":null}}]}`) {
+		t.Fatalf("fields: %s", got)
+	}
+}
+
+func TestLexerStrings(t *testing.T) {
+	doc, err := parse("{ hello(name: \"a\\u0041\\n\") other: hello(name: \"\"\"\n    block\n      indented\n  \"\"\") }")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	f := doc.operations[0].selections
+	if got := f[0].(*fieldNode).args[0].val.raw; got != "aA\n" {
+		t.Errorf("string=%q", got)
+	}
+	if got := f[1].(*fieldNode).args[0].val.raw; got != "block\n  indented" {
+		t.Errorf("block string=%q", got)
+	}
+}
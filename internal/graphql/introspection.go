@@ -0,0 +1,313 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// typenameField is __typename, available on every object type; the executor
+// answers it from the parent type.
+var typenameField = &field{name: "__typename", desc: "The name of the current Object type at runtime.", typ: nonNull(stringType)}
+
+// introspection holds the __Schema / __Type machinery of one schema. The
+// introspection types refer to each other, so they are built together and
+// wired up afterwards.
+type introspection struct {
+	schemaType  *objectType
+	schemaField *field
+	typeField   *field
+}
+
+var typeKindEnum = &enumType{
+	name: "__TypeKind",
+	desc: "An enum describing what kind of type a given `__Type` is.",
+	values: []enumValue{
+		{name: "SCALAR", value: "SCALAR"},
+		{name: "OBJECT", value: "OBJECT"},
+		{name: "INTERFACE", value: "INTERFACE"},
+		{name: "UNION", value: "UNION"},
+		{name: "ENUM", value: "ENUM"},
+		{name: "INPUT_OBJECT", value: "INPUT_OBJECT"},
+		{name: "LIST", value: "LIST"},
+		{name: "NON_NULL", value: "NON_NULL"},
+	},
+}
+
+var directiveLocationEnum = func() *enumType {
+	t := &enumType{name: "__DirectiveLocation", desc: "A Directive can be adjacent to many parts of the GraphQL language."}
+	for _, l := range []string{
+		"QUERY", "MUTATION", "SUBSCRIPTION", "FIELD", "FRAGMENT_DEFINITION", "FRAGMENT_SPREAD", "INLINE_FRAGMENT", "VARIABLE_DEFINITION",
+		"SCHEMA", "SCALAR", "OBJECT", "FIELD_DEFINITION", "ARGUMENT_DEFINITION", "INTERFACE", "UNION", "ENUM", "ENUM_VALUE",
+		"INPUT_OBJECT", "INPUT_FIELD_DEFINITION",
+	} {
+		t.values = append(t.values, enumValue{name: l, value: l})
+	}
+	return t
+}()
+
+func newIntrospection(s *Schema) *introspection {
+	typeT := &objectType{name: "__Type", desc: "The fundamental unit of any GraphQL Schema is the type."}
+	fieldT := &objectType{name: "__Field", desc: "Object and Interface types are described by a list of Fields, each of which has a name, potentially a list of arguments, and a return type."}
+	inputT := &objectType{name: "__InputValue", desc: "Arguments provided to Fields or Directives and the input fields of an InputObject are represented as Input Values which describe their type and optionally a default value."}
+	enumT := &objectType{name: "__EnumValue", desc: "One possible value for a given Enum."}
+	directiveT := &objectType{name: "__Directive", desc: "A Directive provides a way to describe alternate runtime execution and type validation behavior in a GraphQL document."}
+	schemaT := &objectType{name: "__Schema", desc: "A GraphQL Schema defines the capabilities of a GraphQL server."}
+
+	includeDeprecated := []*argument{{name: "includeDeprecated", typ: booleanType, def: false, hasDef: true}}
+
+	schemaT.fields = []*field{
+		{name: "description", typ: stringType, resolve: constant(nil)},
+		{name: "types", typ: nonNull(listOf(nonNull(typeT))), resolve: func(resolveParams) (interface{}, error) {
+			out := []interface{}{}
+			for _, name := range s.typeNames() {
+				out = append(out, s.types[name])
+			}
+			return out, nil
+		}},
+		{name: "queryType", typ: nonNull(typeT), resolve: constant(s.query)},
+		{name: "mutationType", typ: typeT, resolve: rootOrNil(s.mutation)},
+		{name: "subscriptionType", typ: typeT, resolve: rootOrNil(s.subscription)},
+		{name: "directives", typ: nonNull(listOf(nonNull(directiveT))), resolve: func(resolveParams) (interface{}, error) {
+			out := []interface{}{}
+			for _, d := range s.directives {
+				out = append(out, d)
+			}
+			return out, nil
+		}},
+	}
+
+	typeT.fields = []*field{
+		{name: "kind", typ: nonNull(typeKindEnum), resolve: func(p resolveParams) (interface{}, error) {
+			return p.source.(gqlType).kind(), nil
+		}},
+		{name: "name", typ: stringType, resolve: func(p resolveParams) (interface{}, error) {
+			switch p.source.(type) {
+			case *listType, *nonNullType:
+				return nil, nil
+			}
+			return p.source.(gqlType).String(), nil
+		}},
+		{name: "description", typ: stringType, resolve: func(p resolveParams) (interface{}, error) {
+			return describe(p.source), nil
+		}},
+		{name: "specifiedByURL", typ: stringType, resolve: constant(nil)},
+		{name: "fields", typ: listOf(nonNull(fieldT)), args: includeDeprecated, resolve: func(p resolveParams) (interface{}, error) {
+			obj, ok := p.source.(*objectType)
+			if !ok {
+				return nil, nil
+			}
+			out := []interface{}{}
+			for _, f := range obj.fields {
+				if f.deprecated == "" || p.args["includeDeprecated"] == true {
+					out = append(out, f)
+				}
+			}
+			return out, nil
+		}},
+		{name: "interfaces", typ: listOf(nonNull(typeT)), resolve: func(p resolveParams) (interface{}, error) {
+			if _, ok := p.source.(*objectType); ok {
+				return []interface{}{}, nil
+			}
+			return nil, nil
+		}},
+		{name: "possibleTypes", typ: listOf(nonNull(typeT)), resolve: constant(nil)},
+		{name: "enumValues", typ: listOf(nonNull(enumT)), args: includeDeprecated, resolve: func(p resolveParams) (interface{}, error) {
+			e, ok := p.source.(*enumType)
+			if !ok {
+				return nil, nil
+			}
+			out := []interface{}{}
+			for i := range e.values {
+				out = append(out, &e.values[i])
+			}
+			return out, nil
+		}},
+		{name: "inputFields", typ: listOf(nonNull(inputT)), resolve: func(p resolveParams) (interface{}, error) {
+			in, ok := p.source.(*inputObjectType)
+			if !ok {
+				return nil, nil
+			}
+			return arguments(in.fields), nil
+		}},
+		{name: "ofType", typ: typeT, resolve: func(p resolveParams) (interface{}, error) {
+			switch w := p.source.(type) {
+			case *listType:
+				return w.of, nil
+			case *nonNullType:
+				return w.of, nil
+			}
+			return nil, nil
+		}},
+	}
+
+	fieldT.fields = []*field{
+		{name: "name", typ: nonNull(stringType), resolve: func(p resolveParams) (interface{}, error) {
+			return p.source.(*field).name, nil
+		}},
+		{name: "description", typ: stringType, resolve: func(p resolveParams) (interface{}, error) {
+			return describe(p.source), nil
+		}},
+		{name: "args", typ: nonNull(listOf(nonNull(inputT))), resolve: func(p resolveParams) (interface{}, error) {
+			return arguments(p.source.(*field).args), nil
+		}},
+		{name: "type", typ: nonNull(typeT), resolve: func(p resolveParams) (interface{}, error) {
+			return p.source.(*field).typ, nil
+		}},
+		{name: "isDeprecated", typ: nonNull(booleanType), resolve: func(p resolveParams) (interface{}, error) {
+			return p.source.(*field).deprecated != "", nil
+		}},
+		{name: "deprecationReason", typ: stringType, resolve: func(p resolveParams) (interface{}, error) {
+			if r := p.source.(*field).deprecated; r != "" {
+				return r, nil
+			}
+			return nil, nil
+		}},
+	}
+
+	inputT.fields = []*field{
+		{name: "name", typ: nonNull(stringType), resolve: func(p resolveParams) (interface{}, error) {
+			return p.source.(*argument).name, nil
+		}},
+		{name: "description", typ: stringType, resolve: func(p resolveParams) (interface{}, error) {
+			return describe(p.source), nil
+		}},
+		{name: "type", typ: nonNull(typeT), resolve: func(p resolveParams) (interface{}, error) {
+			return p.source.(*argument).typ, nil
+		}},
+		{name: "defaultValue", typ: stringType, resolve: func(p resolveParams) (interface{}, error) {
+			a := p.source.(*argument)
+			if !a.hasDef {
+				return nil, nil
+			}
+			return printDefault(a.typ, a.def), nil
+		}},
+		{name: "isDeprecated", typ: nonNull(booleanType), resolve: constant(false)},
+		{name: "deprecationReason", typ: stringType, resolve: constant(nil)},
+	}
+
+	enumT.fields = []*field{
+		{name: "name", typ: nonNull(stringType), resolve: func(p resolveParams) (interface{}, error) {
+			return p.source.(*enumValue).name, nil
+		}},
+		{name: "description", typ: stringType, resolve: func(p resolveParams) (interface{}, error) {
+			return describe(p.source), nil
+		}},
+		{name: "isDeprecated", typ: nonNull(booleanType), resolve: constant(false)},
+		{name: "deprecationReason", typ: stringType, resolve: constant(nil)},
+	}
+
+	directiveT.fields = []*field{
+		{name: "name", typ: nonNull(stringType), resolve: func(p resolveParams) (interface{}, error) {
+			return p.source.(*directiveDef).name, nil
+		}},
+		{name: "description", typ: stringType, resolve: func(p resolveParams) (interface{}, error) {
+			return describe(p.source), nil
+		}},
+		{name: "isRepeatable", typ: nonNull(booleanType), resolve: constant(false)},
+		{name: "locations", typ: nonNull(listOf(nonNull(directiveLocationEnum))), resolve: func(p resolveParams) (interface{}, error) {
+			out := []interface{}{}
+			for _, l := range p.source.(*directiveDef).locations {
+				out = append(out, l)
+			}
+			return out, nil
+		}},
+		{name: "args", typ: nonNull(listOf(nonNull(inputT))), resolve: func(p resolveParams) (interface{}, error) {
+			return arguments(p.source.(*directiveDef).args), nil
+		}},
+	}
+
+	return &introspection{
+		schemaType: schemaT,
+		schemaField: &field{name: "__schema", desc: "Access the current type schema of this server.", typ: nonNull(schemaT),
+			resolve: constant(s)},
+		typeField: &field{name: "__type", desc: "Request the type information of a single type.", typ: typeT,
+			args: []*argument{{name: "name", typ: nonNull(stringType)}},
+			resolve: func(p resolveParams) (interface{}, error) {
+				if t, ok := s.types[p.args["name"].(string)]; ok {
+					return t, nil
+				}
+				return nil, nil
+			}},
+	}
+}
+
+func constant(v interface{}) resolveFunc {
+	return func(resolveParams) (interface{}, error) { return v, nil }
+}
+
+// rootOrNil avoids handing the executor a typed nil pointer.
+func rootOrNil(t *objectType) resolveFunc {
+	if t == nil {
+		return constant(nil)
+	}
+	return constant(t)
+}
+
+func arguments(args []*argument) []interface{} {
+	out := []interface{}{}
+	for _, a := range args {
+		out = append(out, a)
+	}
+	return out
+}
+
+func describe(v interface{}) interface{} {
+	var d string
+	switch t := v.(type) {
+	case *scalarType:
+		d = t.desc
+	case *enumType:
+		d = t.desc
+	case *objectType:
+		d = t.desc
+	case *inputObjectType:
+		d = t.desc
+	case *field:
+		d = t.desc
+	case *argument:
+		d = t.desc
+	case *enumValue:
+		d = t.desc
+	case *directiveDef:
+		d = t.desc
+	}
+	if d == "" {
+		return nil
+	}
+	return d
+}
+
+// printDefault renders a default value as GraphQL source.
+func printDefault(t gqlType, v interface{}) string {
+	if v == nil {
+		return "null"
+	}
+	switch n := namedType(t).(type) {
+	case *enumType:
+		if ev := n.byValue(v); ev != nil {
+			return ev.name
+		}
+	case *inputObjectType:
+		if m, ok := v.(map[string]interface{}); ok {
+			var parts []string
+			for _, f := range n.fields {
+				if fv, ok := m[f.name]; ok {
+					parts = append(parts, f.name+": "+printDefault(f.typ, fv))
+				}
+			}
+			return "{" + strings.Join(parts, ", ") + "}"
+		}
+	}
+	switch x := v.(type) {
+	case string:
+		return strconv.Quote(x)
+	case []interface{}:
+		parts := make([]string, len(x))
+		for i, item := range x {
+			parts[i] = printDefault(t, item)
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
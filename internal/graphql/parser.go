@@ -0,0 +1,538 @@
+package graphql
+
+import (
+	"fmt"
+)
+
+// document is a parsed executable document.
+type document struct {
+	operations []*operationDef
+	fragments  map[string]*fragmentDef
+}
+
+type operationDef struct {
+	kind       string // query, mutation or subscription
+	name       string
+	vars       []*varDef
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+type varDef struct {
+	name       string
+	typ        *typeRef
+	def        *value
+	directives []*directive
+	loc        Location
+}
+
+// typeRef is a type as written in a variable definition: a named type, or a
+// list of elem, optionally non-null.
+type typeRef struct {
+	name    string
+	elem    *typeRef
+	nonNull bool
+}
+
+func (t *typeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+// selection is a *fieldNode, *fragmentSpread or *inlineFragment.
+type selection interface{ location() Location }
+
+type fieldNode struct {
+	alias      string
+	name       string
+	args       []*argNode
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+func (f *fieldNode) location() Location { return f.loc }
+
+// responseKey is the alias if set, otherwise the field name.
+func (f *fieldNode) responseKey() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+	loc        Location
+}
+
+func (f *fragmentSpread) location() Location { return f.loc }
+
+type inlineFragment struct {
+	typeCond   string
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+func (f *inlineFragment) location() Location { return f.loc }
+
+type fragmentDef struct {
+	name       string
+	typeCond   string
+	directives []*directive
+	selections []selection
+	loc        Location
+}
+
+type argNode struct {
+	name string
+	val  *value
+	loc  Location
+}
+
+type directive struct {
+	name string
+	args []*argNode
+	loc  Location
+}
+
+type valueKind int
+
+const (
+	valVariable valueKind = iota
+	valInt
+	valFloat
+	valString
+	valBoolean
+	valNull
+	valEnum
+	valList
+	valObject
+)
+
+// value is a literal or variable in the document.
+type value struct {
+	kind   valueKind
+	raw    string // variable name, number, string, enum or boolean text
+	list   []*value
+	fields []*objectField
+	loc    Location
+}
+
+type objectField struct {
+	name string
+	val  *value
+	loc  Location
+}
+
+// maxDepth bounds the nesting of selection sets, values and list types. The
+// parser recurses once per level, so without it a deeply nested document
+// overflows the goroutine stack, which Go cannot recover from.
+const maxDepth = 64
+
+type parser struct {
+	lex   *lexer
+	tok   token
+	depth int
+}
+
+// parse reads an executable document (operations and fragments only).
+func parse(src string) (*document, error) {
+	p := &parser{lex: newLexer(src)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	doc := &document{fragments: map[string]*fragmentDef{}}
+	for p.tok.kind != tokEOF {
+		switch {
+		case p.peek(tokPunct, "{"):
+			loc := p.tok.loc
+			sels, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operationDef{kind: "query", selections: sels, loc: loc})
+		case p.peek(tokName, "query"), p.peek(tokName, "mutation"), p.peek(tokName, "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.peek(tokName, "fragment"):
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := doc.fragments[f.name]; dup {
+				return nil, &Error{Message: fmt.Sprintf("There can be only one fragment named %q.", f.name), Locations: []Location{f.loc}}
+			}
+			doc.fragments[f.name] = f
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, &Error{Message: "Document does not contain any operation."}
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	t, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = t
+	return nil
+}
+
+// nest enters one nesting level; call the returned func when leaving it.
+func (p *parser) nest() (func(), error) {
+	if p.depth >= maxDepth {
+		return nil, errorAt(p.tok.loc, "Document exceeds the maximum nesting depth of %d.", maxDepth)
+	}
+	p.depth++
+	return func() { p.depth-- }, nil
+}
+
+func (p *parser) peek(kind tokenKind, v string) bool {
+	return p.tok.kind == kind && p.tok.value == v
+}
+
+func (p *parser) unexpected() error {
+	return syntaxError(p.tok.loc, "Unexpected %s.", p.tok)
+}
+
+// skip consumes the punctuator v if it is next.
+func (p *parser) skip(v string) (bool, error) {
+	if !p.peek(tokPunct, v) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *parser) expect(v string) error {
+	if !p.peek(tokPunct, v) {
+		return syntaxError(p.tok.loc, "Expected %q, found %s.", v, p.tok)
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", syntaxError(p.tok.loc, "Expected Name, found %s.", p.tok)
+	}
+	v := p.tok.value
+	return v, p.advance()
+}
+
+func (p *parser) operation() (*operationDef, error) {
+	op := &operationDef{kind: p.tok.value, loc: p.tok.loc}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokName {
+		op.name = p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if ok, err := p.skip("("); err != nil {
+		return nil, err
+	} else if ok {
+		for !p.peek(tokPunct, ")") {
+			v, err := p.varDef()
+			if err != nil {
+				return nil, err
+			}
+			op.vars = append(op.vars, v)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if op.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	op.selections, err = p.selectionSet()
+	return op, err
+}
+
+func (p *parser) varDef() (*varDef, error) {
+	v := &varDef{loc: p.tok.loc}
+	if err := p.expect("$"); err != nil {
+		return nil, err
+	}
+	var err error
+	if v.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	if v.typ, err = p.typeRef(); err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip("="); err != nil {
+		return nil, err
+	} else if ok {
+		if v.def, err = p.value(true); err != nil {
+			return nil, err
+		}
+	}
+	v.directives, err = p.directives()
+	return v, err
+}
+
+func (p *parser) typeRef() (*typeRef, error) {
+	leave, err := p.nest()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	t := &typeRef{}
+	if ok, err := p.skip("["); err != nil {
+		return nil, err
+	} else if ok {
+		if t.elem, err = p.typeRef(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	} else if t.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	t.nonNull, err = p.skip("!")
+	return t, err
+}
+
+func (p *parser) fragment() (*fragmentDef, error) {
+	f := &fragmentDef{loc: p.tok.loc}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var err error
+	if f.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.name == "on" {
+		return nil, syntaxError(f.loc, "Unexpected Name \"on\".")
+	}
+	if !p.peek(tokName, "on") {
+		return nil, syntaxError(p.tok.loc, "Expected \"on\", found %s.", p.tok)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if f.typeCond, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	f.selections, err = p.selectionSet()
+	return f, err
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	leave, err := p.nest()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var out []selection
+	for !p.peek(tokPunct, "}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	if len(out) == 0 {
+		return nil, syntaxError(p.tok.loc, "Expected Name, found \"}\".")
+	}
+	return out, p.advance()
+}
+
+func (p *parser) selection() (selection, error) {
+	loc := p.tok.loc
+	if ok, err := p.skip("..."); err != nil {
+		return nil, err
+	} else if ok {
+		if p.tok.kind == tokName && p.tok.value != "on" {
+			s := &fragmentSpread{name: p.tok.value, loc: loc}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			s.directives, err = p.directives()
+			return s, err
+		}
+		f := &inlineFragment{loc: loc}
+		if p.peek(tokName, "on") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if f.typeCond, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if f.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		f.selections, err = p.selectionSet()
+		return f, err
+	}
+	f := &fieldNode{loc: loc}
+	var err error
+	if f.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		f.alias = f.name
+		if f.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if f.args, err = p.arguments(false); err != nil {
+		return nil, err
+	}
+	if f.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek(tokPunct, "{") {
+		f.selections, err = p.selectionSet()
+	}
+	return f, err
+}
+
+func (p *parser) arguments(constant bool) ([]*argNode, error) {
+	if ok, err := p.skip("("); err != nil || !ok {
+		return nil, err
+	}
+	var out []*argNode
+	for !p.peek(tokPunct, ")") {
+		a := &argNode{loc: p.tok.loc}
+		var err error
+		if a.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if a.val, err = p.value(constant); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	if len(out) == 0 {
+		return nil, syntaxError(p.tok.loc, "Expected Name, found \")\".")
+	}
+	return out, p.advance()
+}
+
+func (p *parser) directives() ([]*directive, error) {
+	var out []*directive
+	for p.peek(tokPunct, "@") {
+		d := &directive{loc: p.tok.loc}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		var err error
+		if d.name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if d.args, err = p.arguments(false); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// value parses a value; constant rejects variables (default values).
+func (p *parser) value(constant bool) (*value, error) {
+	leave, err := p.nest()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	t := p.tok
+	v := &value{raw: t.value, loc: t.loc}
+	switch {
+	case t.kind == tokPunct && t.value == "$":
+		if constant {
+			return nil, p.unexpected()
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		var err error
+		v.kind = valVariable
+		v.raw, err = p.name()
+		return v, err
+	case t.kind == tokPunct && t.value == "[":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		v.kind = valList
+		for !p.peek(tokPunct, "]") {
+			item, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			v.list = append(v.list, item)
+		}
+		return v, p.advance()
+	case t.kind == tokPunct && t.value == "{":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		v.kind = valObject
+		for !p.peek(tokPunct, "}") {
+			f := &objectField{loc: p.tok.loc}
+			var err error
+			if f.name, err = p.name(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if f.val, err = p.value(constant); err != nil {
+				return nil, err
+			}
+			v.fields = append(v.fields, f)
+		}
+		return v, p.advance()
+	case t.kind == tokInt:
+		v.kind = valInt
+	case t.kind == tokFloat:
+		v.kind = valFloat
+	case t.kind == tokString:
+		v.kind = valString
+	case t.kind == tokName && (t.value == "true" || t.value == "false"):
+		v.kind = valBoolean
+	case t.kind == tokName && t.value == "null":
+		v.kind = valNull
+	case t.kind == tokName:
+		v.kind = valEnum
+	default:
+		return nil, p.unexpected()
+	}
+	return v, p.advance()
+}
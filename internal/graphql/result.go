@@ -0,0 +1,99 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Error is a GraphQL error as it appears in a response.
+type Error struct {
+	Message    string                 `json:"message"`
+	Locations  []Location             `json:"locations,omitempty"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func syntaxError(loc Location, format string, args ...interface{}) *Error {
+	return &Error{Message: "Syntax Error: " + fmt.Sprintf(format, args...), Locations: []Location{loc}}
+}
+
+func errorAt(loc Location, format string, args ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, args...), Locations: []Location{loc}}
+}
+
+// Result is an execution result. Data is omitted when the request failed
+// before execution (syntax, validation or variable errors) and null when a
+// non-null root field failed.
+type Result struct {
+	Errors  []*Error
+	Data    interface{}
+	hasData bool
+}
+
+// errorResult is a result for a request that never executed.
+func errorResult(errs ...*Error) *Result { return &Result{Errors: errs} }
+
+// MarshalJSON writes errors first, then data, as graphql-js does.
+func (r *Result) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	if len(r.Errors) > 0 {
+		errs, err := json.Marshal(r.Errors)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(`"errors":`)
+		b.Write(errs)
+	}
+	if r.hasData {
+		if len(r.Errors) > 0 {
+			b.WriteByte(',')
+		}
+		data, err := json.Marshal(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(`"data":`)
+		b.Write(data)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// orderedMap keeps response keys in selection order.
+type orderedMap struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+func newOrderedMap() *orderedMap { return &orderedMap{vals: map[string]interface{}{}} }
+
+func (m *orderedMap) set(k string, v interface{}) {
+	if _, ok := m.vals[k]; !ok {
+		m.keys = append(m.keys, k)
+	}
+	m.vals[k] = v
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range m.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		b.Write(key)
+		b.WriteByte(':')
+		v, err := json.Marshal(m.vals[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
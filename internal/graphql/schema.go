@@ -0,0 +1,450 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gqlType is any schema type: named (scalar, enum, object, input object) or
+// a list / non-null wrapper.
+type gqlType interface {
+	kind() string
+	String() string
+}
+
+// scalarType parses input values (JSON values from variables, or int64,
+// float64, string and bool from literals) and serializes resolver results.
+type scalarType struct {
+	name      string
+	desc      string
+	parse     func(v interface{}) (interface{}, bool)
+	serialize func(v interface{}) (interface{}, bool)
+}
+
+type enumValue struct {
+	name  string
+	desc  string
+	value interface{}
+}
+
+type enumType struct {
+	name   string
+	desc   string
+	values []enumValue
+}
+
+// enumLiteral is an enum value written in the document, kept apart from
+// strings so that "SUBMIT" and SUBMIT are told apart.
+type enumLiteral string
+
+// resolveParams is what a resolver sees.
+type resolveParams struct {
+	ctx    context.Context
+	source interface{}
+	args   map[string]interface{}
+}
+
+type resolveFunc func(p resolveParams) (interface{}, error)
+
+// subscribeFunc opens an event stream for a subscription root field; each
+// event becomes the source of one execution of the selection set. cancel
+// ends the stream.
+type subscribeFunc func(p resolveParams) (events <-chan interface{}, cancel func(), err error)
+
+type argument struct {
+	name   string
+	desc   string
+	typ    gqlType
+	def    interface{}
+	hasDef bool
+}
+
+type field struct {
+	name       string
+	desc       string
+	typ        gqlType
+	args       []*argument
+	resolve    resolveFunc
+	subscribe  subscribeFunc
+	deprecated string
+}
+
+func (f *field) arg(name string) *argument {
+	for _, a := range f.args {
+		if a.name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+type objectType struct {
+	name   string
+	desc   string
+	fields []*field
+}
+
+func (o *objectType) field(name string) *field {
+	for _, f := range o.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+type inputObjectType struct {
+	name   string
+	desc   string
+	fields []*argument
+}
+
+type listType struct{ of gqlType }
+
+type nonNullType struct{ of gqlType }
+
+func (*scalarType) kind() string      { return "SCALAR" }
+func (*enumType) kind() string        { return "ENUM" }
+func (*objectType) kind() string      { return "OBJECT" }
+func (*inputObjectType) kind() string { return "INPUT_OBJECT" }
+func (*listType) kind() string        { return "LIST" }
+func (*nonNullType) kind() string     { return "NON_NULL" }
+
+func (t *scalarType) String() string      { return t.name }
+func (t *enumType) String() string        { return t.name }
+func (t *objectType) String() string      { return t.name }
+func (t *inputObjectType) String() string { return t.name }
+func (t *listType) String() string        { return "[" + t.of.String() + "]" }
+func (t *nonNullType) String() string     { return t.of.String() + "!" }
+
+func nonNull(t gqlType) gqlType { return &nonNullType{of: t} }
+func listOf(t gqlType) gqlType  { return &listType{of: t} }
+
+// namedType strips list and non-null wrappers.
+func namedType(t gqlType) gqlType {
+	for {
+		switch w := t.(type) {
+		case *listType:
+			t = w.of
+		case *nonNullType:
+			t = w.of
+		default:
+			return t
+		}
+	}
+}
+
+func isInputType(t gqlType) bool {
+	switch namedType(t).(type) {
+	case *scalarType, *enumType, *inputObjectType:
+		return true
+	}
+	return false
+}
+
+func isLeafType(t gqlType) bool {
+	switch namedType(t).(type) {
+	case *scalarType, *enumType:
+		return true
+	}
+	return false
+}
+
+// Built-in scalars.
+var (
+	intType = &scalarType{
+		name: "Int",
+		desc: "The `Int` scalar type represents non-fractional signed whole numeric values between -(2^31) and 2^31 - 1.",
+		parse: func(v interface{}) (interface{}, bool) {
+			n, ok := toInt(v, false)
+			return n, ok
+		},
+		serialize: func(v interface{}) (interface{}, bool) {
+			n, ok := toInt(v, true)
+			return n, ok
+		},
+	}
+	floatType = &scalarType{
+		name: "Float",
+		desc: "The `Float` scalar type represents signed double-precision fractional values as specified by IEEE 754.",
+		parse: func(v interface{}) (interface{}, bool) {
+			return toFloat(v)
+		},
+		serialize: func(v interface{}) (interface{}, bool) {
+			return toFloat(v)
+		},
+	}
+	stringType = &scalarType{
+		name: "String",
+		desc: "The `String` scalar type represents textual data, represented as UTF-8 character sequences.",
+		parse: func(v interface{}) (interface{}, bool) {
+			s, ok := v.(string)
+			return s, ok
+		},
+		serialize: func(v interface{}) (interface{}, bool) {
+			switch t := v.(type) {
+			case string:
+				return t, true
+			case bool, int, int64, float64:
+				return fmt.Sprint(t), true
+			}
+			return nil, false
+		},
+	}
+	booleanType = &scalarType{
+		name: "Boolean",
+		desc: "The `Boolean` scalar type represents `true` or `false`.",
+		parse: func(v interface{}) (interface{}, bool) {
+			b, ok := v.(bool)
+			return b, ok
+		},
+		serialize: func(v interface{}) (interface{}, bool) {
+			b, ok := v.(bool)
+			return b, ok
+		},
+	}
+	idType = &scalarType{
+		name: "ID",
+		desc: "The `ID` scalar type represents a unique identifier, serialized as a string.",
+		parse: func(v interface{}) (interface{}, bool) {
+			if s, ok := v.(string); ok {
+				return s, true
+			}
+			if n, ok := toInt(v, false); ok {
+				return strconv.FormatInt(n, 10), true
+			}
+			return nil, false
+		},
+		serialize: func(v interface{}) (interface{}, bool) {
+			if s, ok := v.(string); ok {
+				return s, true
+			}
+			if n, ok := toInt(v, true); ok {
+				return strconv.FormatInt(n, 10), true
+			}
+			return nil, false
+		},
+	}
+)
+
+// toInt accepts integral numbers within the 32-bit range (output allows
+// any integral value that fits int64).
+func toInt(v interface{}, output bool) (int64, bool) {
+	var n int64
+	switch t := v.(type) {
+	case int:
+		n = int64(t)
+	case int64:
+		n = t
+	case float64:
+		if t != math.Trunc(t) || math.IsInf(t, 0) {
+			return 0, false
+		}
+		n = int64(t)
+	default:
+		return 0, false
+	}
+	if !output && (n > math.MaxInt32 || n < math.MinInt32) {
+		return 0, false
+	}
+	return n, true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case int:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	case float64:
+		return t, !math.IsInf(t, 0) && !math.IsNaN(t)
+	}
+	return 0, false
+}
+
+// directiveDef describes a directive for validation and introspection.
+type directiveDef struct {
+	name      string
+	desc      string
+	locations []string
+	args      []*argument
+}
+
+var builtinDirectives = []*directiveDef{
+	{
+		name:      "include",
+		desc:      "Directs the executor to include this field or fragment only when the `if` argument is true.",
+		locations: []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		args:      []*argument{{name: "if", desc: "Included when true.", typ: nonNull(booleanType)}},
+	},
+	{
+		name:      "skip",
+		desc:      "Directs the executor to skip this field or fragment when the `if` argument is true.",
+		locations: []string{"FIELD", "FRAGMENT_SPREAD", "INLINE_FRAGMENT"},
+		args:      []*argument{{name: "if", desc: "Skipped when true.", typ: nonNull(booleanType)}},
+	},
+	{
+		name:      "deprecated",
+		desc:      "Marks an element of a GraphQL schema as no longer supported.",
+		locations: []string{"FIELD_DEFINITION", "ENUM_VALUE"},
+		args:      []*argument{{name: "reason", typ: stringType, def: "No longer supported", hasDef: true}},
+	},
+}
+
+// Schema is an executable GraphQL schema.
+type Schema struct {
+	query        *objectType
+	mutation     *objectType
+	subscription *objectType
+	types        map[string]gqlType
+	directives   []*directiveDef
+	meta         *introspection
+}
+
+// newSchema collects every type reachable from the root types, the
+// built-in scalars and the introspection types.
+func newSchema(query, mutation, subscription *objectType) *Schema {
+	s := &Schema{query: query, mutation: mutation, subscription: subscription, types: map[string]gqlType{}, directives: builtinDirectives}
+	s.meta = newIntrospection(s)
+	for _, t := range []gqlType{intType, floatType, stringType, booleanType, idType, s.meta.schemaType} {
+		s.collect(t)
+	}
+	for _, root := range []*objectType{query, mutation, subscription} {
+		if root != nil {
+			s.collect(root)
+		}
+	}
+	return s
+}
+
+func (s *Schema) collect(t gqlType) {
+	t = namedType(t)
+	name := t.String()
+	if _, seen := s.types[name]; seen {
+		return
+	}
+	s.types[name] = t
+	switch n := t.(type) {
+	case *objectType:
+		for _, f := range n.fields {
+			s.collect(f.typ)
+			for _, a := range f.args {
+				s.collect(a.typ)
+			}
+		}
+	case *inputObjectType:
+		for _, f := range n.fields {
+			s.collect(f.typ)
+		}
+	}
+}
+
+// typeNames lists the schema types in name order.
+func (s *Schema) typeNames() []string {
+	names := make([]string, 0, len(s.types))
+	for n := range s.types {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveTypeRef turns a variable type from the document into a schema type.
+func (s *Schema) resolveTypeRef(t *typeRef) gqlType {
+	var out gqlType
+	if t.elem != nil {
+		inner := s.resolveTypeRef(t.elem)
+		if inner == nil {
+			return nil
+		}
+		out = listOf(inner)
+	} else if named, ok := s.types[t.name]; ok {
+		out = named
+	} else {
+		return nil
+	}
+	if t.nonNull {
+		out = nonNull(out)
+	}
+	return out
+}
+
+// root returns the root object type for an operation kind.
+func (s *Schema) root(kind string) *objectType {
+	switch kind {
+	case "mutation":
+		return s.mutation
+	case "subscription":
+		return s.subscription
+	}
+	return s.query
+}
+
+// fieldOn looks up a field including the meta fields: __typename everywhere
+// and __schema / __type on the query root.
+func (s *Schema) fieldOn(t *objectType, name string) *field {
+	switch {
+	case name == "__typename":
+		return typenameField
+	case t == s.query && name == "__schema":
+		return s.meta.schemaField
+	case t == s.query && name == "__type":
+		return s.meta.typeField
+	}
+	return t.field(name)
+}
+
+// SDL prints the schema in the GraphQL schema definition language, without
+// the built-in scalars and introspection types.
+func (s *Schema) SDL() string {
+	var b strings.Builder
+	for _, name := range s.typeNames() {
+		if strings.HasPrefix(name, "__") {
+			continue
+		}
+		switch t := s.types[name].(type) {
+		case *objectType:
+			fmt.Fprintf(&b, "type %s {\n", t.name)
+			for _, f := range t.fields {
+				fmt.Fprintf(&b, "  %s%s: %s\n", f.name, printArgs(f.args), f.typ)
+			}
+			b.WriteString("}\n\n")
+		case *inputObjectType:
+			fmt.Fprintf(&b, "input %s {\n", t.name)
+			for _, f := range t.fields {
+				fmt.Fprintf(&b, "  %s\n", printInputValue(f))
+			}
+			b.WriteString("}\n\n")
+		case *enumType:
+			names := make([]string, len(t.values))
+			for i, v := range t.values {
+				names[i] = v.name
+			}
+			fmt.Fprintf(&b, "enum %s {\n  %s\n}\n\n", t.name, strings.Join(names, "\n  "))
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func printArgs(args []*argument) string {
+	if len(args) == 0 {
+		return ""
+	}
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = printInputValue(a)
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+func printInputValue(a *argument) string {
+	s := a.name + ": " + a.typ.String()
+	if a.hasDef {
+		s += " = " + printDefault(a.typ, a.def)
+	}
+	return s
+}
@@ -0,0 +1,484 @@
+package graphql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// validator checks a document against the schema. It covers the rules
+// clients trip over in practice; the remaining spec rules are enforced at
+// execution time or do not apply to a schema without interfaces and unions.
+type validator struct {
+	s      *Schema
+	doc    *document
+	errs   []*Error
+	scopes map[string]*scope // per fragment name
+}
+
+// scope records what one operation or fragment body uses directly.
+type scope struct {
+	usages  []varUsage
+	spreads []string
+}
+
+type varUsage struct {
+	name string
+	typ  gqlType // expected type at the usage; nil when unknown
+	loc  Location
+}
+
+func (s *Schema) validate(doc *document) []*Error {
+	v := &validator{s: s, doc: doc, scopes: map[string]*scope{}}
+	v.operationNames()
+	for _, name := range sortedFragmentNames(doc) {
+		f := doc.fragments[name]
+		sc := &scope{}
+		v.scopes[name] = sc
+		t, ok := v.conditionType(f.typeCond, f.loc)
+		v.directives(f.directives, "FRAGMENT_DEFINITION", sc)
+		if ok {
+			v.selections(t, f.selections, sc)
+		}
+	}
+	v.fragmentCycles()
+	used := map[string]bool{}
+	for _, op := range doc.operations {
+		root := s.root(op.kind)
+		if root == nil {
+			v.errs = append(v.errs, errorAt(op.loc, "Schema is not configured to execute %s operation.", op.kind))
+			continue
+		}
+		sc := &scope{}
+		v.directives(op.directives, strings.ToUpper(op.kind), sc)
+		v.selections(root, op.selections, sc)
+		if op.kind == "subscription" {
+			v.singleRootField(op)
+		}
+		reached := v.reachable(sc)
+		for name := range reached {
+			used[name] = true
+		}
+		v.variables(op, sc, reached)
+	}
+	for _, name := range sortedFragmentNames(doc) {
+		if !used[name] {
+			v.errs = append(v.errs, errorAt(doc.fragments[name].loc, "Fragment \"%s\" is never used.", name))
+		}
+	}
+	return v.errs
+}
+
+func sortedFragmentNames(doc *document) []string {
+	names := make([]string, 0, len(doc.fragments))
+	for n := range doc.fragments {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (v *validator) operationNames() {
+	seen := map[string]bool{}
+	for _, op := range v.doc.operations {
+		if op.name == "" {
+			if len(v.doc.operations) > 1 {
+				v.errs = append(v.errs, errorAt(op.loc, "This anonymous operation must be the only defined operation."))
+			}
+			continue
+		}
+		if seen[op.name] {
+			v.errs = append(v.errs, errorAt(op.loc, "There can be only one operation named \"%s\".", op.name))
+		}
+		seen[op.name] = true
+	}
+}
+
+// conditionType resolves a fragment type condition.
+func (v *validator) conditionType(name string, loc Location) (*objectType, bool) {
+	t, ok := v.s.types[name]
+	if !ok {
+		v.errs = append(v.errs, errorAt(loc, "Unknown type \"%s\".", name))
+		return nil, false
+	}
+	obj, ok := t.(*objectType)
+	if !ok {
+		v.errs = append(v.errs, errorAt(loc, "Fragment cannot condition on non composite type \"%s\".", name))
+		return nil, false
+	}
+	return obj, true
+}
+
+func (v *validator) selections(parent *objectType, sels []selection, sc *scope) {
+	seen := map[string]*fieldNode{}
+	for _, sel := range sels {
+		switch n := sel.(type) {
+		case *fieldNode:
+			v.field(parent, n, sc)
+			if prev, ok := seen[n.responseKey()]; ok && (prev.name != n.name || !sameArgs(prev.args, n.args)) {
+				v.errs = append(v.errs, &Error{
+					Message:   fmt.Sprintf("Fields \"%s\" conflict because they differ in name or arguments. Use different aliases on the fields to fetch both if this was intentional.", n.responseKey()),
+					Locations: []Location{prev.loc, n.loc},
+				})
+			}
+			seen[n.responseKey()] = n
+		case *fragmentSpread:
+			v.directives(n.directives, "FRAGMENT_SPREAD", sc)
+			f, ok := v.doc.fragments[n.name]
+			if !ok {
+				v.errs = append(v.errs, errorAt(n.loc, "Unknown fragment \"%s\".", n.name))
+				continue
+			}
+			sc.spreads = append(sc.spreads, n.name)
+			if _, known := v.s.types[f.typeCond].(*objectType); known && f.typeCond != parent.name {
+				v.errs = append(v.errs, errorAt(n.loc, "Fragment \"%s\" cannot be spread here as objects of type \"%s\" can never be of type \"%s\".", n.name, parent.name, f.typeCond))
+			}
+		case *inlineFragment:
+			v.directives(n.directives, "INLINE_FRAGMENT", sc)
+			t := parent
+			if n.typeCond != "" {
+				var ok bool
+				if t, ok = v.conditionType(n.typeCond, n.loc); !ok {
+					continue
+				}
+				if t != parent {
+					v.errs = append(v.errs, errorAt(n.loc, "Fragment cannot be spread here as objects of type \"%s\" can never be of type \"%s\".", parent.name, t.name))
+					continue
+				}
+			}
+			v.selections(t, n.selections, sc)
+		}
+	}
+}
+
+func (v *validator) field(parent *objectType, n *fieldNode, sc *scope) {
+	v.directives(n.directives, "FIELD", sc)
+	f := v.s.fieldOn(parent, n.name)
+	if f == nil {
+		v.errs = append(v.errs, errorAt(n.loc, "Cannot query field \"%s\" on type \"%s\".", n.name, parent.name))
+		return
+	}
+	v.arguments(f.args, n.args, n.loc, func(name string) string {
+		return fmt.Sprintf("Unknown argument \"%s\" on field \"%s.%s\".", name, parent.name, n.name)
+	}, func(a *argument) string {
+		return fmt.Sprintf("Field \"%s\" argument \"%s\" of type \"%s\" is required, but it was not provided.", n.name, a.name, a.typ)
+	}, sc)
+	obj, composite := namedType(f.typ).(*objectType)
+	switch {
+	case !composite && len(n.selections) > 0:
+		v.errs = append(v.errs, errorAt(n.loc, "Field \"%s\" must not have a selection since type \"%s\" has no subfields.", n.name, f.typ))
+	case composite && len(n.selections) == 0:
+		v.errs = append(v.errs, errorAt(n.loc, "Field \"%s\" of type \"%s\" must have a selection of subfields. Did you mean \"%s { ... }\"?", n.name, f.typ, n.name))
+	case composite:
+		v.selections(obj, n.selections, sc)
+	}
+}
+
+func (v *validator) arguments(defs []*argument, nodes []*argNode, loc Location, unknown func(string) string, missing func(*argument) string, sc *scope) {
+	given := map[string]bool{}
+	for _, a := range nodes {
+		if given[a.name] {
+			v.errs = append(v.errs, errorAt(a.loc, "There can be only one argument named \"%s\".", a.name))
+			continue
+		}
+		given[a.name] = true
+		var def *argument
+		for _, d := range defs {
+			if d.name == a.name {
+				def = d
+			}
+		}
+		if def == nil {
+			v.errs = append(v.errs, errorAt(a.loc, "%s", unknown(a.name)))
+			continue
+		}
+		v.literal(def.typ, a.val, sc)
+	}
+	for _, d := range defs {
+		if _, required := d.typ.(*nonNullType); required && !d.hasDef && !given[d.name] {
+			v.errs = append(v.errs, errorAt(loc, "%s", missing(d)))
+		}
+	}
+}
+
+// literal checks a value against its expected type, recording variable
+// usages with the type expected at that position.
+func (v *validator) literal(t gqlType, val *value, sc *scope) {
+	if val.kind == valVariable {
+		sc.usages = append(sc.usages, varUsage{name: val.raw, typ: t, loc: val.loc})
+		return
+	}
+	if !hasVariable(val) {
+		if _, ok := v.s.literalValue(t, val, map[string]interface{}{}); !ok {
+			v.errs = append(v.errs, errorAt(val.loc, "Expected value of type \"%s\", found %s.", t, printValue(val)))
+		}
+		return
+	}
+	// Mixed literal: check the structure and descend to the variables.
+	inner := t
+	if nn, ok := t.(*nonNullType); ok {
+		inner = nn.of
+	}
+	switch n := inner.(type) {
+	case *listType:
+		items := val.list
+		if val.kind != valList {
+			items = []*value{val}
+		}
+		for _, item := range items {
+			v.literal(n.of, item, sc)
+		}
+	case *inputObjectType:
+		if val.kind != valObject {
+			v.errs = append(v.errs, errorAt(val.loc, "Expected value of type \"%s\", found %s.", t, printValue(val)))
+			return
+		}
+		for _, f := range val.fields {
+			def := n.field(f.name)
+			if def == nil {
+				v.errs = append(v.errs, errorAt(f.loc, "Field \"%s\" is not defined by type \"%s\".", f.name, n.name))
+				continue
+			}
+			v.literal(def.typ, f.val, sc)
+		}
+	default:
+		v.errs = append(v.errs, errorAt(val.loc, "Expected value of type \"%s\", found %s.", t, printValue(val)))
+	}
+}
+
+func hasVariable(val *value) bool {
+	switch val.kind {
+	case valVariable:
+		return true
+	case valList:
+		for _, item := range val.list {
+			if hasVariable(item) {
+				return true
+			}
+		}
+	case valObject:
+		for _, f := range val.fields {
+			if hasVariable(f.val) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sameArgs(a, b []*argNode) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x.name == y.name && printValue(x.val) == printValue(y.val) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *validator) directives(ds []*directive, location string, sc *scope) {
+	for _, d := range ds {
+		var def *directiveDef
+		for _, candidate := range v.s.directives {
+			if candidate.name == d.name {
+				def = candidate
+			}
+		}
+		if def == nil {
+			v.errs = append(v.errs, errorAt(d.loc, "Unknown directive \"@%s\".", d.name))
+			continue
+		}
+		allowed := false
+		for _, l := range def.locations {
+			allowed = allowed || l == location
+		}
+		if !allowed {
+			v.errs = append(v.errs, errorAt(d.loc, "Directive \"@%s\" may not be used on %s.", d.name, location))
+			continue
+		}
+		v.arguments(def.args, d.args, d.loc, func(name string) string {
+			return fmt.Sprintf("Unknown argument \"%s\" on directive \"@%s\".", name, d.name)
+		}, func(a *argument) string {
+			return fmt.Sprintf("Directive \"@%s\" argument \"%s\" of type \"%s\" is required, but it was not provided.", d.name, a.name, a.typ)
+		}, sc)
+	}
+}
+
+// fragmentCycles reports fragments that spread themselves, directly or
+// through other fragments.
+func (v *validator) fragmentCycles() {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = visiting
+		if sc, ok := v.scopes[name]; ok {
+			for _, next := range sc.spreads {
+				switch state[next] {
+				case visiting:
+					v.errs = append(v.errs, errorAt(v.doc.fragments[next].loc, "Cannot spread fragment \"%s\" within itself.", next))
+				case 0:
+					visit(next)
+				}
+			}
+		}
+		state[name] = done
+	}
+	for _, name := range sortedFragmentNames(v.doc) {
+		if state[name] == 0 {
+			visit(name)
+		}
+	}
+}
+
+// reachable returns the fragments an operation uses, transitively.
+func (v *validator) reachable(sc *scope) map[string]bool {
+	out := map[string]bool{}
+	queue := append([]string(nil), sc.spreads...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if out[name] {
+			continue
+		}
+		out[name] = true
+		if fs, ok := v.scopes[name]; ok {
+			queue = append(queue, fs.spreads...)
+		}
+	}
+	return out
+}
+
+func (v *validator) variables(op *operationDef, sc *scope, fragments map[string]bool) {
+	opName := "anonymous operation"
+	if op.name != "" {
+		opName = fmt.Sprintf("operation \"%s\"", op.name)
+	}
+	defs := map[string]*varDef{}
+	types := map[string]gqlType{}
+	for _, d := range op.vars {
+		if _, dup := defs[d.name]; dup {
+			v.errs = append(v.errs, errorAt(d.loc, "There can be only one variable named \"$%s\".", d.name))
+			continue
+		}
+		defs[d.name] = d
+		v.directives(d.directives, "VARIABLE_DEFINITION", sc)
+		t := v.s.resolveTypeRef(d.typ)
+		switch {
+		case t == nil:
+			v.errs = append(v.errs, errorAt(d.loc, "Unknown type \"%s\".", namedRef(d.typ)))
+		case !isInputType(t):
+			v.errs = append(v.errs, errorAt(d.loc, "Variable \"$%s\" cannot be non-input type \"%s\".", d.name, d.typ))
+		default:
+			types[d.name] = t
+			if d.def != nil {
+				v.literal(t, d.def, &scope{})
+			}
+		}
+	}
+	usages := append([]varUsage(nil), sc.usages...)
+	for _, name := range sortedKeys(fragments) {
+		if fs, ok := v.scopes[name]; ok {
+			usages = append(usages, fs.usages...)
+		}
+	}
+	used := map[string]bool{}
+	for _, u := range usages {
+		used[u.name] = true
+		d, ok := defs[u.name]
+		if !ok {
+			v.errs = append(v.errs, &Error{Message: fmt.Sprintf("Variable \"$%s\" is not defined by %s.", u.name, opName), Locations: []Location{u.loc, op.loc}})
+			continue
+		}
+		if t := types[u.name]; t != nil && u.typ != nil && !variableFits(t, d.def != nil, u.typ) {
+			v.errs = append(v.errs, &Error{Message: fmt.Sprintf("Variable \"$%s\" of type \"%s\" used in position expecting type \"%s\".", u.name, t, u.typ), Locations: []Location{d.loc, u.loc}})
+		}
+	}
+	for _, d := range op.vars {
+		if !used[d.name] {
+			v.errs = append(v.errs, errorAt(d.loc, "Variable \"$%s\" is never used in %s.", d.name, opName))
+		}
+	}
+}
+
+func namedRef(t *typeRef) string {
+	for t.elem != nil {
+		t = t.elem
+	}
+	return t.name
+}
+
+func sortedKeys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// variableFits reports whether a variable of type have may be used where
+// want is expected. A nullable variable with a default may fill a non-null
+// position.
+func variableFits(have gqlType, hasDefault bool, want gqlType) bool {
+	if w, ok := want.(*nonNullType); ok {
+		if h, ok := have.(*nonNullType); ok {
+			return variableFits(h.of, false, w.of)
+		}
+		return hasDefault && variableFits(have, false, w.of)
+	}
+	if h, ok := have.(*nonNullType); ok {
+		return variableFits(h.of, false, want)
+	}
+	if w, ok := want.(*listType); ok {
+		h, ok := have.(*listType)
+		return ok && variableFits(h.of, false, w.of)
+	}
+	if _, ok := have.(*listType); ok {
+		return false
+	}
+	return have == want
+}
+
+// singleRootField enforces one top-level field per subscription.
+func (v *validator) singleRootField(op *operationDef) {
+	count := 0
+	var walk func(sels []selection, seen map[string]bool)
+	walk = func(sels []selection, seen map[string]bool) {
+		for _, sel := range sels {
+			switch n := sel.(type) {
+			case *fieldNode:
+				if !seen[n.responseKey()] {
+					seen[n.responseKey()] = true
+					count++
+				}
+			case *inlineFragment:
+				walk(n.selections, seen)
+			case *fragmentSpread:
+				if f, ok := v.doc.fragments[n.name]; ok && !seen["..."+n.name] {
+					seen["..."+n.name] = true
+					walk(f.selections, seen)
+				}
+			}
+		}
+	}
+	walk(op.selections, map[string]bool{})
+	if count > 1 {
+		if op.name == "" {
+			v.errs = append(v.errs, errorAt(op.loc, "Anonymous Subscription must select only one top level field."))
+		} else {
+			v.errs = append(v.errs, errorAt(op.loc, "Subscription \"%s\" must select only one top level field.", op.name))
+		}
+	}
+}
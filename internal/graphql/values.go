@@ -0,0 +1,324 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// coerceVariables checks the request variables against the operation's
+// variable definitions, applying defaults.
+func (s *Schema) coerceVariables(op *operationDef, inputs map[string]interface{}) (map[string]interface{}, []*Error) {
+	out := map[string]interface{}{}
+	var errs []*Error
+	for _, def := range op.vars {
+		t := s.resolveTypeRef(def.typ)
+		raw, provided := inputs[def.name]
+		switch {
+		case !provided && def.def != nil:
+			v, ok := s.literalValue(t, def.def, nil)
+			if !ok {
+				errs = append(errs, errorAt(def.loc, "Variable \"$%s\" has invalid default value.", def.name))
+				continue
+			}
+			out[def.name] = v
+		case !provided:
+			if _, required := t.(*nonNullType); required {
+				errs = append(errs, errorAt(def.loc, "Variable \"$%s\" of required type \"%s\" was not provided.", def.name, t))
+			}
+		default:
+			v, msg := coerceInput(t, raw)
+			if msg != "" {
+				errs = append(errs, errorAt(def.loc, "Variable \"$%s\" got invalid value %s; %s", def.name, inspect(raw), msg))
+				continue
+			}
+			out[def.name] = v
+		}
+	}
+	return out, errs
+}
+
+// coerceInput converts a JSON value into the Go value for t. A non-empty
+// message explains why it does not fit.
+func coerceInput(t gqlType, v interface{}) (interface{}, string) {
+	if nn, ok := t.(*nonNullType); ok {
+		if v == nil {
+			return nil, fmt.Sprintf("Expected non-nullable type \"%s\" not to be null.", t)
+		}
+		return coerceInput(nn.of, v)
+	}
+	if v == nil {
+		return nil, ""
+	}
+	switch n := t.(type) {
+	case *listType:
+		items, ok := v.([]interface{})
+		if !ok {
+			item, msg := coerceInput(n.of, v)
+			if msg != "" {
+				return nil, msg
+			}
+			return []interface{}{item}, ""
+		}
+		out := make([]interface{}, len(items))
+		for i, item := range items {
+			c, msg := coerceInput(n.of, item)
+			if msg != "" {
+				return nil, fmt.Sprintf("at index %d: %s", i, msg)
+			}
+			out[i] = c
+		}
+		return out, ""
+	case *inputObjectType:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Sprintf("Expected type \"%s\" to be an object.", n.name)
+		}
+		for k := range obj {
+			if n.field(k) == nil {
+				return nil, fmt.Sprintf("Field \"%s\" is not defined by type \"%s\".", k, n.name)
+			}
+		}
+		out := map[string]interface{}{}
+		for _, f := range n.fields {
+			fv, present := obj[f.name]
+			if !present {
+				if f.hasDef {
+					out[f.name] = f.def
+				} else if _, required := f.typ.(*nonNullType); required {
+					return nil, fmt.Sprintf("Field \"%s\" of required type \"%s\" was not provided.", f.name, f.typ)
+				}
+				continue
+			}
+			c, msg := coerceInput(f.typ, fv)
+			if msg != "" {
+				return nil, fmt.Sprintf("at \"%s\": %s", f.name, msg)
+			}
+			out[f.name] = c
+		}
+		return out, ""
+	case *enumType:
+		if name, ok := v.(string); ok {
+			if ev := n.byName(name); ev != nil {
+				return ev.value, ""
+			}
+		}
+		return nil, fmt.Sprintf("Value %s does not exist in \"%s\" enum.", inspect(v), n.name)
+	case *scalarType:
+		if c, ok := n.parse(v); ok {
+			return c, ""
+		}
+		return nil, fmt.Sprintf("%s cannot represent value: %s", n.name, inspect(v))
+	}
+	return nil, fmt.Sprintf("Unsupported input type \"%s\".", t)
+}
+
+// literalValue converts a literal from the document into the Go value for
+// t. vars nil means variables are not allowed (default values); a variable
+// missing from vars yields nil.
+func (s *Schema) literalValue(t gqlType, v *value, vars map[string]interface{}) (interface{}, bool) {
+	if v.kind == valVariable {
+		if vars == nil {
+			return nil, false
+		}
+		val, ok := vars[v.raw]
+		if !ok || val == nil {
+			_, required := t.(*nonNullType)
+			return nil, !required
+		}
+		return val, true
+	}
+	if nn, ok := t.(*nonNullType); ok {
+		if v.kind == valNull {
+			return nil, false
+		}
+		return s.literalValue(nn.of, v, vars)
+	}
+	if v.kind == valNull {
+		return nil, true
+	}
+	switch n := t.(type) {
+	case *listType:
+		if v.kind != valList {
+			item, ok := s.literalValue(n.of, v, vars)
+			if !ok {
+				return nil, false
+			}
+			return []interface{}{item}, true
+		}
+		out := make([]interface{}, len(v.list))
+		for i, item := range v.list {
+			c, ok := s.literalValue(n.of, item, vars)
+			if !ok {
+				return nil, false
+			}
+			out[i] = c
+		}
+		return out, true
+	case *inputObjectType:
+		if v.kind != valObject {
+			return nil, false
+		}
+		given := map[string]*value{}
+		for _, f := range v.fields {
+			if n.field(f.name) == nil {
+				return nil, false
+			}
+			given[f.name] = f.val
+		}
+		out := map[string]interface{}{}
+		for _, f := range n.fields {
+			fv, present := given[f.name]
+			if present && fv.kind == valVariable {
+				if _, set := vars[fv.raw]; !set {
+					present = false
+				}
+			}
+			if !present {
+				if f.hasDef {
+					out[f.name] = f.def
+				} else if _, required := f.typ.(*nonNullType); required {
+					return nil, false
+				}
+				continue
+			}
+			c, ok := s.literalValue(f.typ, fv, vars)
+			if !ok {
+				return nil, false
+			}
+			out[f.name] = c
+		}
+		return out, true
+	case *enumType:
+		if v.kind != valEnum {
+			return nil, false
+		}
+		if ev := n.byName(v.raw); ev != nil {
+			return ev.value, true
+		}
+		return nil, false
+	case *scalarType:
+		var raw interface{}
+		switch v.kind {
+		case valInt:
+			i, err := strconv.ParseInt(v.raw, 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			raw = i
+		case valFloat:
+			if n == intType || n == idType {
+				return nil, false
+			}
+			f, err := strconv.ParseFloat(v.raw, 64)
+			if err != nil {
+				return nil, false
+			}
+			raw = f
+		case valString:
+			raw = v.raw
+		case valBoolean:
+			raw = v.raw == "true"
+		default:
+			return nil, false
+		}
+		return n.parse(raw)
+	}
+	return nil, false
+}
+
+// coerceArgs builds the argument map for a field or directive.
+func (s *Schema) coerceArgs(defs []*argument, nodes []*argNode, vars map[string]interface{}, loc Location) (map[string]interface{}, *Error) {
+	given := map[string]*argNode{}
+	for _, a := range nodes {
+		given[a.name] = a
+	}
+	out := map[string]interface{}{}
+	for _, def := range defs {
+		a, present := given[def.name]
+		if present && a.val.kind == valVariable {
+			if _, set := vars[a.val.raw]; !set {
+				present = false
+			}
+		}
+		if !present {
+			if def.hasDef {
+				out[def.name] = def.def
+			} else if _, required := def.typ.(*nonNullType); required {
+				return nil, errorAt(loc, "Argument \"%s\" of required type \"%s\" was not provided.", def.name, def.typ)
+			}
+			continue
+		}
+		v, ok := s.literalValue(def.typ, a.val, vars)
+		if !ok {
+			return nil, errorAt(a.loc, "Argument \"%s\" has invalid value %s.", def.name, printValue(a.val))
+		}
+		out[def.name] = v
+	}
+	return out, nil
+}
+
+func (t *inputObjectType) field(name string) *argument {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func (t *enumType) byName(name string) *enumValue {
+	for i := range t.values {
+		if t.values[i].name == name {
+			return &t.values[i]
+		}
+	}
+	return nil
+}
+
+func (t *enumType) byValue(v interface{}) *enumValue {
+	for i := range t.values {
+		if t.values[i].value == v {
+			return &t.values[i]
+		}
+	}
+	return nil
+}
+
+// inspect renders a JSON input value for error messages.
+func inspect(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// printValue renders a literal back as GraphQL source.
+func printValue(v *value) string {
+	switch v.kind {
+	case valVariable:
+		return "$" + v.raw
+	case valString:
+		return strconv.Quote(v.raw)
+	case valList:
+		s := "["
+		for i, item := range v.list {
+			if i > 0 {
+				s += ", "
+			}
+			s += printValue(item)
+		}
+		return s + "]"
+	case valObject:
+		s := "{"
+		for i, f := range v.fields {
+			if i > 0 {
+				s += ", "
+			}
+			s += f.name + ": " + printValue(f.val)
+		}
+		return s + "}"
+	}
+	return v.raw
+}
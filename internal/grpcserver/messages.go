@@ -0,0 +1,417 @@
+package grpcserver
+
+// Messages of assets/grpc/upstream.proto. Field numbers follow the schema;
+// JSON names are the proto3 lowerCamelCase names.
+
+type pbOrderItem struct {
+	SKU   string  `json:"sku,omitempty"`
+	Qty   int32   `json:"qty,omitempty"`
+	Price float64 `json:"price,omitempty"`
+}
+
+func (m *pbOrderItem) marshal(e *encoder) {
+	e.string(1, m.SKU)
+	e.int32(2, m.Qty)
+	e.double(3, m.Price)
+}
+
+func (m *pbOrderItem) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, field, wire int) error {
+		switch field {
+		case 1:
+			return d.string(field, wire, &m.SKU)
+		case 2:
+			return d.int32(field, wire, &m.Qty)
+		case 3:
+			return d.double(field, wire, &m.Price)
+		}
+		return d.skip(wire)
+	})
+}
+
+// decodeItems reads a repeated OrderItem field.
+func decodeItems(d *decoder, field, wire int, dst *[]*pbOrderItem) error {
+	it := &pbOrderItem{}
+	if err := d.message(field, wire, it); err != nil {
+		return err
+	}
+	*dst = append(*dst, it)
+	return nil
+}
+
+type pbOrder struct {
+	ID         string         `json:"id,omitempty"`
+	Status     string         `json:"status,omitempty"`
+	UserID     string         `json:"userId,omitempty"`
+	MerchantID string         `json:"merchantId,omitempty"`
+	Items      []*pbOrderItem `json:"items,omitempty"`
+	Amount     float64        `json:"amount,omitempty"`
+	Currency   string         `json:"currency,omitempty"`
+	Reason     string         `json:"reason,omitempty"`
+	Source     string         `json:"source,omitempty"`
+}
+
+func (m *pbOrder) marshal(e *encoder) {
+	e.string(1, m.ID)
+	e.string(2, m.Status)
+	e.string(3, m.UserID)
+	e.string(4, m.MerchantID)
+	for _, it := range m.Items {
+		e.message(5, it)
+	}
+	e.double(6, m.Amount)
+	e.string(7, m.Currency)
+	e.string(8, m.Reason)
+	e.string(9, m.Source)
+}
+
+func (m *pbOrder) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, field, wire int) error {
+		switch field {
+		case 1:
+			return d.string(field, wire, &m.ID)
+		case 2:
+			return d.string(field, wire, &m.Status)
+		case 3:
+			return d.string(field, wire, &m.UserID)
+		case 4:
+			return d.string(field, wire, &m.MerchantID)
+		case 5:
+			return decodeItems(d, field, wire, &m.Items)
+		case 6:
+			return d.double(field, wire, &m.Amount)
+		case 7:
+			return d.string(field, wire, &m.Currency)
+		case 8:
+			return d.string(field, wire, &m.Reason)
+		case 9:
+			return d.string(field, wire, &m.Source)
+		}
+		return d.skip(wire)
+	})
+}
+
+type pbGetOrderRequest struct {
+	ID string `json:"id,omitempty"`
+}
+
+func (m *pbGetOrderRequest) marshal(e *encoder) { e.string(1, m.ID) }
+
+func (m *pbGetOrderRequest) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, field, wire int) error {
+		if field == 1 {
+			return d.string(field, wire, &m.ID)
+		}
+		return d.skip(wire)
+	})
+}
+
+type pbListOrdersRequest struct {
+	Status string `json:"status,omitempty"`
+}
+
+func (m *pbListOrdersRequest) marshal(e *encoder) { e.string(1, m.Status) }
+
+func (m *pbListOrdersRequest) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, field, wire int) error {
+		if field == 1 {
+			return d.string(field, wire, &m.Status)
+		}
+		return d.skip(wire)
+	})
+}
+
+type pbListOrdersResponse struct {
+	Orders []*pbOrder `json:"orders,omitempty"`
+}
+
+func (m *pbListOrdersResponse) marshal(e *encoder) {
+	for _, o := range m.Orders {
+		e.message(1, o)
+	}
+}
+
+func (m *pbListOrdersResponse) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, field, wire int) error {
+		if field == 1 {
+			o := &pbOrder{}
+			if err := d.message(field, wire, o); err != nil {
+				return err
+			}
+			m.Orders = append(m.Orders, o)
+			return nil
+		}
+		return d.skip(wire)
+	})
+}
+
+type pbCreateOrderRequest struct {
+	UserID     string         `json:"userId,omitempty"`
+	MerchantID string         `json:"merchantId,omitempty"`
+	Items      []*pbOrderItem `json:"items,omitempty"`
+	Amount     float64        `json:"amount,omitempty"`
+	Currency   string         `json:"currency,omitempty"`
+}
+
+func (m *pbCreateOrderRequest) marshal(e *encoder) {
+	e.string(1, m.UserID)
+	e.string(2, m.MerchantID)
+	for _, it := range m.Items {
+		e.message(3, it)
+	}
+	e.double(4, m.Amount)
+	e.string(5, m.Currency)
+}
+
+func (m *pbCreateOrderRequest) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, field, wire int) error {
+		switch field {
+		case 1:
+			return d.string(field, wire, &m.UserID)
+		case 2:
+			return d.string(field, wire, &m.MerchantID)
+		case 3:
+			return decodeItems(d, field, wire, &m.Items)
+		case 4:
+			return d.double(field, wire, &m.Amount)
+		case 5:
+			return d.string(field, wire, &m.Currency)
+		}
+		return d.skip(wire)
+	})
+}
+
+type pbWatchOrdersRequest struct {
+	OrderID    string `json:"orderId,omitempty"`
+	UserID     string `json:"userId,omitempty"`
+	MerchantID string `json:"merchantId,omitempty"`
+	Limit      int32  `json:"limit,omitempty"`
+}
+
+func (m *pbWatchOrdersRequest) marshal(e *encoder) {
+	e.string(1, m.OrderID)
+	e.string(2, m.UserID)
+	e.string(3, m.MerchantID)
+	e.int32(4, m.Limit)
+}
+
+func (m *pbWatchOrdersRequest) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, field, wire int) error {
+		switch field {
+		case 1:
+			return d.string(field, wire, &m.OrderID)
+		case 2:
+			return d.string(field, wire, &m.UserID)
+		case 3:
+			return d.string(field, wire, &m.MerchantID)
+		case 4:
+			return d.int32(field, wire, &m.Limit)
+		}
+		return d.skip(wire)
+	})
+}
+
+type pbOrderAction struct {
+	OrderID string `json:"orderId,omitempty"`
+	Action  string `json:"action,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (m *pbOrderAction) marshal(e *encoder) {
+	e.string(1, m.OrderID)
+	e.string(2, m.Action)
+	e.string(3, m.Reason)
+}
+
+func (m *pbOrderAction) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, field, wire int) error {
+		switch field {
+		case 1:
+			return d.string(field, wire, &m.OrderID)
+		case 2:
+			return d.string(field, wire, &m.Action)
+		case 3:
+			return d.string(field, wire, &m.Reason)
+		}
+		return d.skip(wire)
+	})
+}
+
+type pbOrderEvent struct {
+	Type     string   `json:"type,omitempty"`
+	OrderID  string   `json:"orderId,omitempty"`
+	Status   string   `json:"status,omitempty"`
+	Previous string   `json:"previous,omitempty"`
+	Action   string   `json:"action,omitempty"`
+	Actor    string   `json:"actor,omitempty"`
+	Order    *pbOrder `json:"order,omitempty"`
+	Time     string   `json:"time,omitempty"`
+}
+
+func (m *pbOrderEvent) marshal(e *encoder) {
+	e.string(1, m.Type)
+	e.string(2, m.OrderID)
+	e.string(3, m.Status)
+	e.string(4, m.Previous)
+	e.string(5, m.Action)
+	e.string(6, m.Actor)
+	if m.Order != nil {
+		e.message(7, m.Order)
+	}
+	e.string(8, m.Time)
+}
+
+func (m *pbOrderEvent) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, field, wire int) error {
+		switch field {
+		case 1:
+			return d.string(field, wire, &m.Type)
+		case 2:
+			return d.string(field, wire, &m.OrderID)
+		case 3:
+			return d.string(field, wire, &m.Status)
+		case 4:
+			return d.string(field, wire, &m.Previous)
+		case 5:
+			return d.string(field, wire, &m.Action)
+		case 6:
+			return d.string(field, wire, &m.Actor)
+		case 7:
+			if m.Order == nil {
+				m.Order = &pbOrder{}
+			}
+			return d.message(field, wire, m.Order)
+		case 8:
+			return d.string(field, wire, &m.Time)
+		}
+		return d.skip(wire)
+	})
+}
+
+type pbCheckoutRequest struct {
+	OrderID  string  `json:"orderId,omitempty"`
+	Amount   float64 `json:"amount,omitempty"`
+	Currency string  `json:"currency,omitempty"`
+	Method   string  `json:"method,omitempty"`
+}
+
+func (m *pbCheckoutRequest) marshal(e *encoder) {
+	e.string(1, m.OrderID)
+	e.double(2, m.Amount)
+	e.string(3, m.Currency)
+	e.string(4, m.Method)
+}
+
+func (m *pbCheckoutRequest) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, field, wire int) error {
+		switch field {
+		case 1:
+			return d.string(field, wire, &m.OrderID)
+		case 2:
+			return d.double(field, wire, &m.Amount)
+		case 3:
+			return d.string(field, wire, &m.Currency)
+		case 4:
+			return d.string(field, wire, &m.Method)
+		}
+		return d.skip(wire)
+	})
+}
+
+type pbCheckoutResponse struct {
+	Paid     bool    `json:"paid,omitempty"`
+	Amount   float64 `json:"amount,omitempty"`
+	Currency string  `json:"currency,omitempty"`
+	Method   string  `json:"method,omitempty"`
+	TxnID    string  `json:"txnId,omitempty"`
+	OrderID  string  `json:"orderId,omitempty"`
+}
+
+func (m *pbCheckoutResponse) marshal(e *encoder) {
+	e.bool(1, m.Paid)
+	e.double(2, m.Amount)
+	e.string(3, m.Currency)
+	e.string(4, m.Method)
+	e.string(5, m.TxnID)
+	e.string(6, m.OrderID)
+}
+
+func (m *pbCheckoutResponse) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, field, wire int) error {
+		switch field {
+		case 1:
+			return d.bool(field, wire, &m.Paid)
+		case 2:
+			return d.double(field, wire, &m.Amount)
+		case 3:
+			return d.string(field, wire, &m.Currency)
+		case 4:
+			return d.string(field, wire, &m.Method)
+		case 5:
+			return d.string(field, wire, &m.TxnID)
+		case 6:
+			return d.string(field, wire, &m.OrderID)
+		}
+		return d.skip(wire)
+	})
+}
+
+type pbListRefundsRequest struct {
+	OrderID    string `json:"orderId,omitempty"`
+	Status     string `json:"status,omitempty"`
+	IntervalMs int32  `json:"intervalMs,omitempty"`
+}
+
+func (m *pbListRefundsRequest) marshal(e *encoder) {
+	e.string(1, m.OrderID)
+	e.string(2, m.Status)
+	e.int32(3, m.IntervalMs)
+}
+
+func (m *pbListRefundsRequest) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, field, wire int) error {
+		switch field {
+		case 1:
+			return d.string(field, wire, &m.OrderID)
+		case 2:
+			return d.string(field, wire, &m.Status)
+		case 3:
+			return d.int32(field, wire, &m.IntervalMs)
+		}
+		return d.skip(wire)
+	})
+}
+
+type pbRefund struct {
+	RefundID string  `json:"refundId,omitempty"`
+	OrderID  string  `json:"orderId,omitempty"`
+	Status   string  `json:"status,omitempty"`
+	Amount   float64 `json:"amount,omitempty"`
+	Reason   string  `json:"reason,omitempty"`
+}
+
+func (m *pbRefund) marshal(e *encoder) {
+	e.string(1, m.RefundID)
+	e.string(2, m.OrderID)
+	e.string(3, m.Status)
+	e.double(4, m.Amount)
+	e.string(5, m.Reason)
+}
+
+func (m *pbRefund) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, field, wire int) error {
+		switch field {
+		case 1:
+			return d.string(field, wire, &m.RefundID)
+		case 2:
+			return d.string(field, wire, &m.OrderID)
+		case 3:
+			return d.string(field, wire, &m.Status)
+		case 4:
+			return d.double(field, wire, &m.Amount)
+		case 5:
+			return d.string(field, wire, &m.Reason)
+		}
+		return d.skip(wire)
+	})
+}
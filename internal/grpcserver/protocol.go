@@ -0,0 +1,453 @@
+package grpcserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// code is a gRPC status code.
+type code int
+
+const (
+	codeOK code = iota
+	codeCanceled
+	codeUnknown
+	codeInvalidArgument
+	codeDeadlineExceeded
+	codeNotFound
+	codeAlreadyExists
+	codePermissionDenied
+	codeResourceExhausted
+	codeFailedPrecondition
+	codeAborted
+	codeOutOfRange
+	codeUnimplemented
+	codeInternal
+	codeUnavailable
+	codeDataLoss
+	codeUnauthenticated
+)
+
+// codeNames are the Connect names of the status codes.
+var codeNames = [...]string{
+	"ok", "canceled", "unknown", "invalid_argument", "deadline_exceeded", "not_found",
+	"already_exists", "permission_denied", "resource_exhausted", "failed_precondition",
+	"aborted", "out_of_range", "unimplemented", "internal", "unavailable", "data_loss",
+	"unauthenticated",
+}
+
+// connectHTTPStatus maps status codes to the HTTP status of Connect unary
+// error responses.
+var connectHTTPStatus = map[code]int{
+	codeCanceled:           499,
+	codeUnknown:            http.StatusInternalServerError,
+	codeInvalidArgument:    http.StatusBadRequest,
+	codeDeadlineExceeded:   http.StatusGatewayTimeout,
+	codeNotFound:           http.StatusNotFound,
+	codeAlreadyExists:      http.StatusConflict,
+	codePermissionDenied:   http.StatusForbidden,
+	codeResourceExhausted:  http.StatusTooManyRequests,
+	codeFailedPrecondition: http.StatusBadRequest,
+	codeAborted:            http.StatusConflict,
+	codeOutOfRange:         http.StatusBadRequest,
+	codeUnimplemented:      http.StatusNotImplemented,
+	codeInternal:           http.StatusInternalServerError,
+	codeUnavailable:        http.StatusServiceUnavailable,
+	codeDataLoss:           http.StatusInternalServerError,
+	codeUnauthenticated:    http.StatusUnauthorized,
+}
+
+func (c code) String() string {
+	if c >= 0 && int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "unknown"
+}
+
+// statusError ends an RPC with a non-OK status.
+type statusError struct {
+	code code
+	msg  string
+}
+
+func (e *statusError) Error() string { return fmt.Sprintf("%s: %s", e.code, e.msg) }
+
+func statusf(c code, format string, args ...interface{}) *statusError {
+	return &statusError{code: c, msg: fmt.Sprintf(format, args...)}
+}
+
+// toStatus converts a handler error; nil is OK.
+func toStatus(err error) *statusError {
+	var se *statusError
+	switch {
+	case err == nil:
+		return &statusError{code: codeOK}
+	case errors.As(err, &se):
+		return se
+	case errors.Is(err, context.DeadlineExceeded):
+		return statusf(codeDeadlineExceeded, "deadline exceeded")
+	case errors.Is(err, context.Canceled):
+		return statusf(codeCanceled, "request canceled")
+	}
+	return statusf(codeUnknown, "%v", err)
+}
+
+// maxMessageSize bounds a single request message (the gRPC default).
+const maxMessageSize = 4 << 20
+
+// Frame flags of the length-prefixed envelope.
+const (
+	flagCompressed = 0x01
+	flagEndStream  = 0x02 // Connect end-of-stream message
+	flagTrailers   = 0x80 // gRPC-Web trailers frame
+)
+
+type protocol int
+
+const (
+	protoGRPC protocol = iota
+	protoGRPCWeb
+	protoConnect      // Connect streaming: enveloped messages and an end-stream message
+	protoConnectUnary // Connect unary: a bare message per direction
+)
+
+// format is the protocol and codec selected by the request content type.
+type format struct {
+	protocol protocol
+	json     bool
+	text     bool // grpc-web-text: base64 bodies
+}
+
+// parseContentType maps a request content type to its protocol and codec.
+func parseContentType(ct string) (format, bool) {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return format{}, false
+	}
+	switch mt {
+	case "application/grpc", "application/grpc+proto":
+		return format{protocol: protoGRPC}, true
+	case "application/grpc+json":
+		return format{protocol: protoGRPC, json: true}, true
+	case "application/grpc-web", "application/grpc-web+proto":
+		return format{protocol: protoGRPCWeb}, true
+	case "application/grpc-web+json":
+		return format{protocol: protoGRPCWeb, json: true}, true
+	case "application/grpc-web-text", "application/grpc-web-text+proto":
+		return format{protocol: protoGRPCWeb, text: true}, true
+	case "application/connect+proto":
+		return format{protocol: protoConnect}, true
+	case "application/connect+json":
+		return format{protocol: protoConnect, json: true}, true
+	case "application/proto":
+		return format{protocol: protoConnectUnary}, true
+	case "application/json":
+		return format{protocol: protoConnectUnary, json: true}, true
+	}
+	return format{}, false
+}
+
+// contentType is the response content type for f.
+func (f format) contentType() string {
+	codec := "proto"
+	if f.json {
+		codec = "json"
+	}
+	switch f.protocol {
+	case protoGRPC:
+		return "application/grpc+" + codec
+	case protoGRPCWeb:
+		if f.text {
+			return "application/grpc-web-text+proto"
+		}
+		return "application/grpc-web+" + codec
+	case protoConnect:
+		return "application/connect+" + codec
+	}
+	return "application/" + codec
+}
+
+// parseTimeout reads grpc-timeout or connect-timeout-ms.
+func parseTimeout(r *http.Request, f format) (time.Duration, bool) {
+	if f.protocol == protoConnect || f.protocol == protoConnectUnary {
+		ms, err := strconv.ParseInt(r.Header.Get("Connect-Timeout-Ms"), 10, 64)
+		if err != nil || ms <= 0 {
+			return 0, false
+		}
+		return time.Duration(ms) * time.Millisecond, true
+	}
+	v := r.Header.Get("Grpc-Timeout")
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	unit, ok := units[v[len(v)-1]]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// stream is one RPC in any of the supported protocols. Handlers read
+// requests with Recv and write responses with Send; serveRPC ends the call
+// with the handler's status.
+type stream struct {
+	ctx  context.Context
+	w    http.ResponseWriter
+	rc   *http.ResponseController
+	body io.Reader
+	f    format
+
+	wroteHeader bool
+	recvDone    bool
+	sent        int
+}
+
+func (st *stream) decode(b []byte, m message) error {
+	var err error
+	if st.f.json {
+		err = json.Unmarshal(b, m)
+	} else {
+		err = m.unmarshal(b)
+	}
+	if err != nil {
+		return statusf(codeInvalidArgument, "unmarshal request: %v", err)
+	}
+	return nil
+}
+
+func (st *stream) encode(m message) ([]byte, error) {
+	if st.f.json {
+		return json.Marshal(m)
+	}
+	return marshal(m), nil
+}
+
+// Recv reads the next request message; io.EOF ends the request stream.
+func (st *stream) Recv(m message) error {
+	if st.recvDone {
+		return io.EOF
+	}
+	if st.f.protocol == protoConnectUnary {
+		st.recvDone = true
+		b, err := io.ReadAll(io.LimitReader(st.body, maxMessageSize+1))
+		if err != nil {
+			return statusf(codeInvalidArgument, "read request: %v", err)
+		}
+		if len(b) > maxMessageSize {
+			return statusf(codeResourceExhausted, "request message larger than %d bytes", maxMessageSize)
+		}
+		return st.decode(b, m)
+	}
+	var prefix [5]byte
+	if _, err := io.ReadFull(st.body, prefix[:]); err != nil {
+		st.recvDone = true
+		if err == io.EOF {
+			return io.EOF
+		}
+		if st.ctx.Err() != nil {
+			return st.ctx.Err()
+		}
+		return statusf(codeInvalidArgument, "read request frame: %v", err)
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	switch {
+	case prefix[0]&flagCompressed != 0:
+		return statusf(codeUnimplemented, "compressed messages are not supported")
+	case prefix[0] != 0:
+		return statusf(codeInvalidArgument, "unexpected frame flags 0x%02x", prefix[0])
+	case size > maxMessageSize:
+		return statusf(codeResourceExhausted, "request message larger than %d bytes", maxMessageSize)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(st.body, b); err != nil {
+		st.recvDone = true
+		return statusf(codeInvalidArgument, "read request message: %v", err)
+	}
+	return st.decode(b, m)
+}
+
+// recvOne reads the single request message of a unary or server-streaming
+// call.
+func (st *stream) recvOne(m message) error {
+	if err := st.Recv(m); err != nil {
+		if err == io.EOF {
+			return statusf(codeInvalidArgument, "missing request message")
+		}
+		return err
+	}
+	var extra skipMessage
+	if err := st.Recv(&extra); err != io.EOF {
+		if err == nil {
+			return statusf(codeInvalidArgument, "more than one request message")
+		}
+		return err
+	}
+	return nil
+}
+
+// skipMessage accepts any message without keeping its fields.
+type skipMessage struct{}
+
+func (skipMessage) marshal(*encoder) {}
+
+func (skipMessage) unmarshal(b []byte) error {
+	return decode(b, func(d *decoder, _, wire int) error { return d.skip(wire) })
+}
+
+// sendHeader writes the response headers of a streaming protocol and
+// flushes them, so the client sees the stream open before the first message.
+func (st *stream) sendHeader() {
+	if st.wroteHeader {
+		return
+	}
+	st.wroteHeader = true
+	h := st.w.Header()
+	h.Set("Content-Type", st.f.contentType())
+	if st.f.protocol == protoGRPC {
+		h.Set("Grpc-Accept-Encoding", "identity")
+	}
+	st.w.WriteHeader(http.StatusOK)
+	_ = st.rc.Flush()
+}
+
+// Send writes one response message.
+func (st *stream) Send(m message) error {
+	b, err := st.encode(m)
+	if err != nil {
+		return statusf(codeInternal, "marshal response: %v", err)
+	}
+	st.sent++
+	if st.f.protocol == protoConnectUnary {
+		if st.wroteHeader {
+			return statusf(codeInternal, "unary call sent more than one response")
+		}
+		st.wroteHeader = true
+		st.w.Header().Set("Content-Type", st.f.contentType())
+		st.w.Header().Set("Trailer-X-Upstream-Messages", "1")
+		st.w.WriteHeader(http.StatusOK)
+		_, err = st.w.Write(b)
+		return err
+	}
+	st.sendHeader()
+	return st.writeFrame(0, b)
+}
+
+func (st *stream) writeFrame(flags byte, b []byte) error {
+	frame := make([]byte, 5, 5+len(b))
+	frame[0] = flags
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(b)))
+	frame = append(frame, b...)
+	if st.f.text {
+		frame = []byte(base64.StdEncoding.EncodeToString(frame))
+	}
+	if _, err := st.w.Write(frame); err != nil {
+		return err
+	}
+	return st.rc.Flush()
+}
+
+// finish ends the call with the status of err and the trailer metadata.
+func (st *stream) finish(err error) {
+	s := toStatus(err)
+	sent := strconv.Itoa(st.sent)
+	switch st.f.protocol {
+	case protoGRPC:
+		h := st.w.Header()
+		if !st.wroteHeader {
+			// trailers-only response
+			h.Set("Content-Type", st.f.contentType())
+			h.Set("Grpc-Status", strconv.Itoa(int(s.code)))
+			if s.msg != "" {
+				h.Set("Grpc-Message", percentEncode(s.msg))
+			}
+			h.Set("X-Upstream-Messages", sent)
+			st.w.WriteHeader(http.StatusOK)
+			return
+		}
+		h.Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(int(s.code)))
+		if s.msg != "" {
+			h.Set(http.TrailerPrefix+"Grpc-Message", percentEncode(s.msg))
+		}
+		h.Set(http.TrailerPrefix+"X-Upstream-Messages", sent)
+	case protoGRPCWeb:
+		st.sendHeader()
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "grpc-status: %d\r\n", s.code)
+		if s.msg != "" {
+			fmt.Fprintf(&b, "grpc-message: %s\r\n", percentEncode(s.msg))
+		}
+		fmt.Fprintf(&b, "x-upstream-messages: %s\r\n", sent)
+		_ = st.writeFrame(flagTrailers, b.Bytes())
+	case protoConnect:
+		st.sendHeader()
+		end := map[string]interface{}{"metadata": map[string][]string{"x-upstream-messages": {sent}}}
+		if s.code != codeOK {
+			end["error"] = map[string]interface{}{"code": s.code.String(), "message": s.msg}
+		}
+		b, _ := json.Marshal(end)
+		_ = st.writeFrame(flagEndStream, b)
+	case protoConnectUnary:
+		if s.code == codeOK && st.sent == 0 {
+			s = statusf(codeInternal, "unary call sent no response")
+		}
+		if s.code == codeOK || st.wroteHeader {
+			return
+		}
+		b, _ := json.Marshal(map[string]string{"code": s.code.String(), "message": s.msg})
+		st.w.Header().Set("Content-Type", "application/json")
+		st.w.Header().Set("Trailer-X-Upstream-Messages", sent)
+		st.w.WriteHeader(connectHTTPStatus[s.code])
+		_, _ = st.w.Write(b)
+	}
+}
+
+// percentEncode escapes grpc-message values: bytes outside printable ASCII
+// and '%' become %XX.
+func percentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// decodeWebText decodes a grpc-web-text body. Clients may send several
+// padded base64 chunks back to back, so the body is decoded in 4-byte
+// groups.
+func decodeWebText(b []byte) ([]byte, error) {
+	b = bytes.Join(bytes.Fields(b), nil)
+	if len(b)%4 != 0 {
+		return nil, errors.New("grpc-web-text body is not padded base64")
+	}
+	out := make([]byte, 0, len(b)/4*3)
+	var group [3]byte
+	for i := 0; i < len(b); i += 4 {
+		n, err := base64.StdEncoding.Decode(group[:], b[i:i+4])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, group[:n]...)
+	}
+	return out, nil
+}
@@ -0,0 +1,241 @@
+// Package grpcserver runs the optional gRPC upstreams. One listener serves
+// native gRPC over h2c (or HTTP/2 over TLS) alongside gRPC-Web and Connect,
+// which also work over HTTP/1.1, so trailers, binary framing and streaming
+// can be checked through a proxy. Protobuf encoding is hand-written against
+// assets/grpc/upstream.proto.
+package grpcserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"intercept-wave-upstream/internal/admin"
+	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/tlsutil"
+	"intercept-wave-upstream/internal/topology"
+)
+
+type GRPCSpec struct {
+	Name    string
+	Port    int
+	Bundles []string
+	TLS     *topology.ServiceTLS
+	TLSPort int
+}
+
+// method is one RPC. Streaming methods must be called with a streaming
+// protocol; Connect unary requests get 415 for them.
+type method struct {
+	streaming bool
+	handler   func(st *stream) error
+}
+
+// rpcBundles maps topology bundle names to the RPCs they mount, keyed by
+// their /package.Service/Method path.
+var rpcBundles = map[string]func(sp GRPCSpec) map[string]method{
+	"order":   orderMethods,
+	"payment": paymentMethods,
+}
+
+// defaultBundles are mounted when a service declares none.
+var defaultBundles = []string{"order", "payment"}
+
+// SpecsFromTopology resolves topology entries into gRPC specs for base.
+func SpecsFromTopology(services []topology.GRPCService, base int) []GRPCSpec {
+	specs := make([]GRPCSpec, 0, len(services))
+	for _, s := range services {
+		bundles := s.Bundles
+		if len(bundles) == 0 {
+			bundles = defaultBundles
+		}
+		specs = append(specs, GRPCSpec{
+			Name:    s.Name,
+			Port:    s.ResolvePort(base),
+			Bundles: bundles,
+			TLS:     s.TLS,
+		})
+		if s.TLS != nil {
+			specs[len(specs)-1].TLSPort = s.TLS.ResolvePort(base)
+		}
+	}
+	return specs
+}
+
+func StartAll(base int, topo []topology.GRPCService) []*http.Server {
+	specs := SpecsFromTopology(topo, base)
+	servers := make([]*http.Server, 0, len(specs))
+	for _, sp := range specs {
+		svc := admin.Register(sp.Name, "grpc")
+		handler := common.RequestLogger(admin.Gate(svc, newHandler(sp)))
+		// HTTP/1.1 for gRPC-Web and Connect, prior-knowledge h2c for gRPC
+		protocols := &http.Protocols{}
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		srv := &http.Server{Addr: fmt.Sprintf(":%d", sp.Port), Handler: handler, Protocols: protocols}
+		servers = append(servers, srv)
+		if err := svc.Serve(srv, sp.Port, false); err != nil {
+			common.Logf("gRPC server %s error: %v", sp.Name, err)
+		} else {
+			common.Logf("gRPC %s listening on :%d (h2c)", sp.Name, sp.Port)
+		}
+		if sp.TLS == nil || !sp.TLS.Enabled {
+			continue
+		}
+		tlsSrv, err := tlsutil.NewServer(sp.Name, sp.TLSPort, handler, *sp.TLS)
+		if err != nil {
+			common.Logf("gRPC TLS %s disabled: %v", sp.Name, err)
+			continue
+		}
+		tlsProtocols := &http.Protocols{}
+		tlsProtocols.SetHTTP1(true)
+		tlsProtocols.SetHTTP2(true)
+		tlsSrv.Protocols = tlsProtocols
+		servers = append(servers, tlsSrv)
+		if err := svc.Serve(tlsSrv, sp.TLSPort, true); err != nil {
+			common.Logf("gRPC TLS server %s error: %v", sp.Name, err)
+			continue
+		}
+		common.Logf("gRPC TLS %s listening on :%d (clientAuth=%s)", sp.Name, sp.TLSPort, sp.TLS.ClientAuth)
+	}
+	return servers
+}
+
+// newHandler routes RPC content types to the mounted methods and everything
+// else to the info endpoints.
+func newHandler(sp GRPCSpec) http.Handler {
+	methods := map[string]method{}
+	for _, b := range sp.Bundles {
+		mount, ok := rpcBundles[b]
+		if !ok {
+			common.Logf("gRPC %s: unknown bundle %q (skipped)", sp.Name, b)
+			continue
+		}
+		for path, m := range mount(sp) {
+			methods[path] = m
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			common.JSON(w, http.StatusNotFound, map[string]interface{}{"error": "not found"})
+			return
+		}
+		common.JSON(w, 200, map[string]interface{}{"service": sp.Name, "port": sp.Port, "bundles": sp.Bundles})
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		common.JSON(w, 200, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/upstream.proto", func(w http.ResponseWriter, r *http.Request) {
+		b, err := os.ReadFile(common.JoinAssets("grpc", "upstream.proto"))
+		if err != nil {
+			common.JSON(w, http.StatusNotFound, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write(b)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cors(w, r)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Method != http.MethodPost {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		f, ok := parseContentType(r.Header.Get("Content-Type"))
+		if !ok {
+			w.Header().Set("Accept-Post", "application/grpc, application/grpc-web, application/grpc-web-text, application/connect+proto, application/connect+json, application/proto, application/json")
+			common.JSON(w, http.StatusUnsupportedMediaType, map[string]interface{}{"error": "unsupported content type " + r.Header.Get("Content-Type")})
+			return
+		}
+		serveRPC(w, r, sp, f, methods)
+	})
+}
+
+// cors lets browser gRPC-Web and Connect clients call the service and read
+// the status headers.
+func cors(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Add("Vary", "Origin")
+	h.Set("Access-Control-Expose-Headers", "Grpc-Status, Grpc-Message, X-Upstream-Service, X-Upstream-Messages, Trailer-X-Upstream-Messages")
+	if r.Method == http.MethodOptions {
+		h.Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
+		if req := r.Header.Get("Access-Control-Request-Headers"); req != "" {
+			h.Set("Access-Control-Allow-Headers", req)
+		}
+		h.Set("Access-Control-Max-Age", "7200")
+	}
+}
+
+// serveRPC runs one call in the protocol selected by the content type.
+func serveRPC(w http.ResponseWriter, r *http.Request, sp GRPCSpec, f format, methods map[string]method) {
+	rc := http.NewResponseController(w)
+	// HTTP/1.1 streams read the request while responding
+	_ = rc.EnableFullDuplex()
+	w.Header().Set("X-Upstream-Service", sp.Name)
+
+	ctx := r.Context()
+	if d, ok := parseTimeout(r, f); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	st := &stream{ctx: ctx, w: w, rc: rc, body: r.Body, f: f}
+
+	m, ok := methods[r.URL.Path]
+	switch {
+	case !ok:
+		st.finish(statusf(codeUnimplemented, "unknown method %s", r.URL.Path))
+		return
+	case f.protocol == protoConnectUnary && m.streaming, f.protocol == protoConnect && !m.streaming:
+		kind := "unary"
+		if m.streaming {
+			kind = "streaming"
+		}
+		common.JSON(w, http.StatusUnsupportedMediaType, map[string]interface{}{"error": fmt.Sprintf("%s is a %s method; use the matching Connect content type", r.URL.Path, kind)})
+		return
+	case !identityEncoding(r, f):
+		st.finish(statusf(codeUnimplemented, "only identity encoding is supported"))
+		return
+	}
+	if f.text {
+		raw, err := io.ReadAll(io.LimitReader(r.Body, 2*maxMessageSize))
+		var b []byte
+		if err == nil {
+			b, err = decodeWebText(raw)
+		}
+		if err != nil {
+			st.finish(statusf(codeInvalidArgument, "read grpc-web-text body: %v", err))
+			return
+		}
+		st.body = bytes.NewReader(b)
+	}
+	st.finish(m.handler(st))
+}
+
+// identityEncoding reports whether the request body is uncompressed.
+func identityEncoding(r *http.Request, f format) bool {
+	header := "Grpc-Encoding"
+	switch f.protocol {
+	case protoConnect:
+		header = "Connect-Content-Encoding"
+	case protoConnectUnary:
+		header = "Content-Encoding"
+	}
+	enc := r.Header.Get(header)
+	return enc == "" || strings.EqualFold(enc, "identity")
+}
@@ -0,0 +1,257 @@
+package grpcserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/food"
+	"intercept-wave-upstream/internal/testutil"
+	"intercept-wave-upstream/internal/tlsutil"
+	"intercept-wave-upstream/internal/topology"
+)
+
+// findFreePorts reserves a gRPC port and its TLS port.
+func findFreePorts() (int, error) { return testutil.FreeBase(2) }
+
+// startGRPC runs one gRPC service on port with TLS on port+1.
+func startGRPC(t *testing.T) int {
+	t.Helper()
+	port, err := findFreePorts()
+	if err != nil {
+		t.Fatalf("findFreePorts: %v", err)
+	}
+	srvs := StartAll(0, []topology.GRPCService{{
+		Name: "grpc-test",
+		Port: port,
+		TLS:  &topology.ServiceTLS{Enabled: true, Port: port + 1},
+	}})
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+		food.Default.Reset()
+	})
+	return port
+}
+
+func frame(flags byte, m message) []byte {
+	b := marshal(m)
+	out := make([]byte, 5, 5+len(b))
+	out[0] = flags
+	binary.BigEndian.PutUint32(out[1:], uint32(len(b)))
+	return append(out, b...)
+}
+
+// readFrame reads one length-prefixed frame.
+func readFrame(t *testing.T, r io.Reader) (byte, []byte) {
+	t.Helper()
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	b := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		t.Fatalf("read frame body: %v", err)
+	}
+	return prefix[0], b
+}
+
+func h2cClient() *http.Client {
+	p := &http.Protocols{}
+	p.SetUnencryptedHTTP2(true)
+	return &http.Client{Transport: &http.Transport{Protocols: p}}
+}
+
+func post(t *testing.T, c *http.Client, url, contentType string, body io.Reader) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, body)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestGRPC(t *testing.T) {
+	port := startGRPC(t)
+	base := fmt.Sprintf("http://127.0.0.1:%d", port)
+	c := h2cClient()
+
+	// unary over h2c: message, then grpc-status in the trailers
+	resp := post(t, c, base+orderService+"GetOrder", "application/grpc", bytes.NewReader(frame(0, &pbGetOrderRequest{ID: "2001"})))
+	if resp.ProtoMajor != 2 || resp.Header.Get("Content-Type") != "application/grpc+proto" || resp.Header.Get("X-Upstream-Service") != "grpc-test" {
+		t.Fatalf("headers: %s %v", resp.Proto, resp.Header)
+	}
+	_, b := readFrame(t, resp.Body)
+	var o pbOrder
+	if err := o.unmarshal(b); err != nil || o.ID != "2001" || o.Amount != 49.9 || len(o.Items) != 1 || o.Items[0].Qty != 2 || o.Source != "asset" {
+		t.Fatalf("order: %+v %v", o, err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.Trailer.Get("Grpc-Status") != "0" || resp.Trailer.Get("X-Upstream-Messages") != "1" {
+		t.Fatalf("trailers: %v", resp.Trailer)
+	}
+
+	// errors before any message are trailers-only
+	resp = post(t, c, base+orderService+"GetOrder", "application/grpc", bytes.NewReader(frame(0, &pbGetOrderRequest{ID: "404"})))
+	if resp.Header.Get("Grpc-Status") != "5" || resp.Header.Get("Grpc-Message") != "order 404 not found" {
+		t.Fatalf("not found: %v", resp.Header)
+	}
+	resp = post(t, c, base+"/interceptwave.upstream.v1.OrderService/Nope", "application/grpc", nil)
+	if resp.Header.Get("Grpc-Status") != "12" {
+		t.Fatalf("unimplemented: %v", resp.Header)
+	}
+
+	// server streaming, including refunds created through the other protocols
+	food.Refunds.Create(map[string]interface{}{"orderId": "2001", "amount": 1.5})
+	t.Cleanup(food.Refunds.Reset)
+	resp = post(t, c, base+paymentService+"ListRefunds", "application/grpc", bytes.NewReader(frame(0, &pbListRefundsRequest{})))
+	var ids []string
+	for i := 0; i < 3; i++ {
+		_, b := readFrame(t, resp.Body)
+		var r pbRefund
+		if err := r.unmarshal(b); err != nil {
+			t.Fatalf("refund: %v", err)
+		}
+		ids = append(ids, r.RefundID)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if strings.Join(ids, ",") != "RF-00001,RF-00002,RF-00003" || resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Fatalf("refunds: %v %v", ids, resp.Trailer)
+	}
+
+	// bidi: every action is answered before the next one is sent
+	pr, pw := io.Pipe()
+	resp = post(t, c, base+orderService+"UpdateOrders", "application/grpc", pr)
+	update := func(a *pbOrderAction) pbOrderEvent {
+		t.Helper()
+		if _, err := pw.Write(frame(0, a)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_, b := readFrame(t, resp.Body)
+		var ev pbOrderEvent
+		if err := ev.unmarshal(b); err != nil {
+			t.Fatalf("event: %v", err)
+		}
+		return ev
+	}
+	created := update(&pbOrderAction{Action: "create"})
+	if created.Type != "order_created" || created.Order == nil || created.Order.Source != "live" {
+		t.Fatalf("created: %+v", created)
+	}
+	submitted := update(&pbOrderAction{OrderID: created.OrderID, Action: "submit"})
+	if submitted.Type != "order_submitted" || submitted.Previous != "CREATED" || submitted.Actor != "grpc-test" {
+		t.Fatalf("submitted: %+v", submitted)
+	}
+	_, _ = pw.Write(frame(0, &pbOrderAction{OrderID: created.OrderID, Action: "ready"}))
+	_ = pw.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.Trailer.Get("Grpc-Status") != "9" || resp.Trailer.Get("Grpc-Message") != "cannot ready an order in status SUBMITTED" || resp.Trailer.Get("X-Upstream-Messages") != "2" {
+		t.Fatalf("bidi trailers: %v", resp.Trailer)
+	}
+
+	// the same service over TLS with ALPN h2
+	a, err := tlsutil.Default()
+	if err != nil {
+		t.Fatalf("tls: %v", err)
+	}
+	tc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: a.Pool()}, ForceAttemptHTTP2: true}}
+	resp = post(t, tc, fmt.Sprintf("https://127.0.0.1:%d%sCheckout", port+1, paymentService), "application/grpc", bytes.NewReader(frame(0, &pbCheckoutRequest{OrderID: "2001"})))
+	_, b = readFrame(t, resp.Body)
+	var co pbCheckoutResponse
+	if err := co.unmarshal(b); err != nil || resp.ProtoMajor != 2 || !co.Paid || co.OrderID != "2001" || co.TxnID != "TXN-20240512-XYZ" {
+		t.Fatalf("checkout over TLS: %s %+v %v", resp.Proto, co, err)
+	}
+}
+
+func TestGRPCWebAndConnect(t *testing.T) {
+	port := startGRPC(t)
+	base := fmt.Sprintf("http://127.0.0.1:%d", port)
+	c := http.DefaultClient
+
+	// grpc-web-text over HTTP/1.1: base64 frames, trailers in a 0x80 frame
+	body := base64.StdEncoding.EncodeToString(frame(0, &pbCheckoutRequest{Amount: 12.5, Method: "card"}))
+	resp := post(t, c, base+paymentService+"Checkout", "application/grpc-web-text", strings.NewReader(body))
+	raw, _ := io.ReadAll(resp.Body)
+	decoded, err := decodeWebText(raw)
+	if resp.ProtoMajor != 1 || err != nil || resp.Header.Get("Content-Type") != "application/grpc-web-text+proto" {
+		t.Fatalf("grpc-web-text: %s %v %s", resp.Proto, err, raw)
+	}
+	r := bytes.NewReader(decoded)
+	_, b := readFrame(t, r)
+	var co pbCheckoutResponse
+	if err := co.unmarshal(b); err != nil || co.Amount != 12.5 || co.Method != "card" || co.Currency != "CNY" {
+		t.Fatalf("checkout: %+v %v", co, err)
+	}
+	if flags, b := readFrame(t, r); flags != flagTrailers || string(b) != "grpc-status: 0\r\nx-upstream-messages: 1\r\n" {
+		t.Fatalf("trailer frame: %x %q", flags, b)
+	}
+
+	// Connect unary JSON, errors as HTTP status plus a JSON body
+	resp = post(t, c, base+orderService+"GetOrder", "application/json", strings.NewReader(`{"id":"2003"}`))
+	raw, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(raw) != `{"id":"2003","status":"CANCELLED","items":[{"sku":"C-3","qty":5}],"amount":10.5,"currency":"CNY","source":"asset"}` || resp.Header.Get("Trailer-X-Upstream-Messages") != "1" {
+		t.Fatalf("connect unary: %d %s", resp.StatusCode, raw)
+	}
+	resp = post(t, c, base+orderService+"GetOrder", "application/json", strings.NewReader(`{}`))
+	raw, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != 400 || string(raw) != `{"code":"invalid_argument","message":"id is required"}` {
+		t.Fatalf("connect error: %d %s", resp.StatusCode, raw)
+	}
+	if resp = post(t, c, base+paymentService+"ListRefunds", "application/json", strings.NewReader(`{}`)); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("unary content type on streaming method: %d", resp.StatusCode)
+	}
+
+	// Connect streaming JSON: enveloped messages and an end-stream message
+	env := func(s string) []byte {
+		out := make([]byte, 5, 5+len(s))
+		binary.BigEndian.PutUint32(out[1:], uint32(len(s)))
+		return append(out, s...)
+	}
+	resp = post(t, c, base+paymentService+"ListRefunds", "application/connect+json", bytes.NewReader(env(`{"status":"pending"}`)))
+	if _, b := readFrame(t, resp.Body); string(b) != `{"refundId":"RF-00002","orderId":"2002","status":"PENDING","amount":19.9,"reason":"partial return"}` {
+		t.Fatalf("connect stream message: %s", b)
+	}
+	if flags, b := readFrame(t, resp.Body); flags != flagEndStream || string(b) != `{"metadata":{"x-upstream-messages":["1"]}}` {
+		t.Fatalf("end stream: %x %s", flags, b)
+	}
+
+	// live order events reach a Connect stream
+	resp = post(t, c, base+orderService+"WatchOrders", "application/connect+proto", bytes.NewReader(frame(0, &pbWatchOrdersRequest{UserID: "u-42", Limit: 1})))
+	o := food.Default.Create(map[string]interface{}{"userId": "u-42"}, "test")
+	_, b = readFrame(t, resp.Body)
+	var ev pbOrderEvent
+	if err := ev.unmarshal(b); err != nil || ev.Type != "order_created" || ev.OrderID != o.ID || ev.Order.UserID != "u-42" {
+		t.Fatalf("watch: %+v %v", ev, err)
+	}
+	if flags, _ := readFrame(t, resp.Body); flags != flagEndStream {
+		t.Fatalf("watch end: %x", flags)
+	}
+
+	// CORS preflight for browser clients
+	req, _ := http.NewRequest(http.MethodOptions, base+orderService+"GetOrder", nil)
+	req.Header.Set("Origin", "http://localhost:5173")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web")
+	pre, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("preflight: %v", err)
+	}
+	_ = pre.Body.Close()
+	if pre.StatusCode != http.StatusNoContent || pre.Header.Get("Access-Control-Allow-Origin") != "http://localhost:5173" || pre.Header.Get("Access-Control-Allow-Headers") != "content-type,x-grpc-web" {
+		t.Fatalf("preflight: %d %v", pre.StatusCode, pre.Header)
+	}
+}
@@ -0,0 +1,273 @@
+package grpcserver
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/food"
+)
+
+const (
+	orderService   = "/interceptwave.upstream.v1.OrderService/"
+	paymentService = "/interceptwave.upstream.v1.PaymentService/"
+)
+
+// orderMethods serves orders from the order assets plus the shared live
+// food store; CreateOrder and UpdateOrders drive the same state machine as
+// the HTTP order endpoints and the live WS food sockets.
+func orderMethods(sp GRPCSpec) map[string]method {
+	return map[string]method{
+		orderService + "GetOrder": {handler: func(st *stream) error {
+			var req pbGetOrderRequest
+			if err := st.recvOne(&req); err != nil {
+				return err
+			}
+			if req.ID == "" {
+				return statusf(codeInvalidArgument, "id is required")
+			}
+			ref, ok := food.Default.Lookup(req.ID)
+			if !ok {
+				return statusf(codeNotFound, "order %s not found", req.ID)
+			}
+			return st.Send(refOrder(ref))
+		}},
+		orderService + "ListOrders": {handler: func(st *stream) error {
+			var req pbListOrdersRequest
+			if err := st.recvOne(&req); err != nil {
+				return err
+			}
+			resp := &pbListOrdersResponse{}
+			for _, ref := range food.Default.All() {
+				if req.Status == "" || strings.EqualFold(ref.Status(), req.Status) {
+					resp.Orders = append(resp.Orders, refOrder(ref))
+				}
+			}
+			return st.Send(resp)
+		}},
+		orderService + "CreateOrder": {handler: func(st *stream) error {
+			var req pbCreateOrderRequest
+			if err := st.recvOne(&req); err != nil {
+				return err
+			}
+			fields := map[string]interface{}{}
+			if req.UserID != "" {
+				fields["userId"] = req.UserID
+			}
+			if req.MerchantID != "" {
+				fields["merchantId"] = req.MerchantID
+			}
+			if len(req.Items) > 0 {
+				items := make([]interface{}, 0, len(req.Items))
+				for _, it := range req.Items {
+					items = append(items, map[string]interface{}{"sku": it.SKU, "qty": float64(it.Qty), "price": it.Price})
+				}
+				fields["items"] = items
+			}
+			if req.Amount != 0 {
+				fields["amount"] = req.Amount
+			}
+			if req.Currency != "" {
+				fields["currency"] = req.Currency
+			}
+			return st.Send(liveOrder(food.Default.Create(fields, sp.Name)))
+		}},
+		orderService + "WatchOrders": {streaming: true, handler: func(st *stream) error {
+			var req pbWatchOrdersRequest
+			if err := st.recvOne(&req); err != nil {
+				return err
+			}
+			events, stop := food.Default.Subscribe(food.Filter{OrderID: req.OrderID, UserID: req.UserID, MerchantID: req.MerchantID})
+			defer stop()
+			st.sendHeader()
+			for n := int32(0); req.Limit <= 0 || n < req.Limit; n++ {
+				select {
+				case <-st.ctx.Done():
+					return st.ctx.Err()
+				case ev := <-events:
+					if err := st.Send(liveEvent(ev)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}},
+		orderService + "UpdateOrders": {streaming: true, handler: func(st *stream) error {
+			st.sendHeader()
+			for {
+				var req pbOrderAction
+				if err := st.Recv(&req); err != nil {
+					if err == io.EOF {
+						return nil
+					}
+					return err
+				}
+				ev, err := applyAction(req, sp.Name)
+				if err != nil {
+					return err
+				}
+				if err := st.Send(ev); err != nil {
+					return err
+				}
+			}
+		}},
+	}
+}
+
+// applyAction runs one UpdateOrders action and describes the result the way
+// the food store publishes it.
+func applyAction(req pbOrderAction, actor string) (*pbOrderEvent, error) {
+	if req.Action == "create" && req.OrderID == "" {
+		o := food.Default.Create(map[string]interface{}{}, actor)
+		return &pbOrderEvent{Type: "order_created", OrderID: o.ID, Status: o.Status, Action: "create", Actor: actor, Order: liveOrder(o), Time: o.CreatedAt.Format(time.RFC3339Nano)}, nil
+	}
+	ev, err := food.Default.Apply(req.OrderID, req.Action, actor, req.Reason)
+	var terr *food.TransitionError
+	switch {
+	case errors.Is(err, food.ErrNotFound):
+		return nil, statusf(codeNotFound, "order %s not found", req.OrderID)
+	case errors.Is(err, food.ErrUnknownAction):
+		return nil, statusf(codeInvalidArgument, "%v", err)
+	case errors.As(err, &terr):
+		return nil, statusf(codeFailedPrecondition, "%v", err)
+	case err != nil:
+		return nil, err
+	}
+	return liveEvent(ev), nil
+}
+
+// paymentMethods serves checkout from the payment assets and refunds from
+// the shared refund store.
+func paymentMethods(sp GRPCSpec) map[string]method {
+	return map[string]method{
+		paymentService + "Checkout": {handler: func(st *stream) error {
+			var req pbCheckoutRequest
+			if err := st.recvOne(&req); err != nil {
+				return err
+			}
+			resp := &pbCheckoutResponse{Paid: true, Amount: 199, Currency: "CNY"}
+			if v, err := common.LoadJSONDynamic(common.JoinAssets("payment", "checkout.json")); err == nil {
+				m, _ := v.(map[string]interface{})
+				data, _ := m["data"].(map[string]interface{})
+				resp.Paid, _ = data["paid"].(bool)
+				resp.Amount = number(data["amount"])
+				resp.Currency = common.AssetString(data["currency"])
+				resp.Method = common.AssetString(data["method"])
+				resp.TxnID = common.AssetString(data["txnId"])
+			}
+			resp.OrderID = req.OrderID
+			if req.Amount != 0 {
+				resp.Amount = req.Amount
+			}
+			if req.Currency != "" {
+				resp.Currency = req.Currency
+			}
+			if req.Method != "" {
+				resp.Method = req.Method
+			}
+			return st.Send(resp)
+		}},
+		paymentService + "ListRefunds": {streaming: true, handler: func(st *stream) error {
+			var req pbListRefundsRequest
+			if err := st.recvOne(&req); err != nil {
+				return err
+			}
+			st.sendHeader()
+			for _, r := range food.Refunds.List() {
+				refund := &pbRefund{
+					RefundID: common.AssetString(r["refundId"]),
+					OrderID:  common.AssetString(r["orderId"]),
+					Status:   common.AssetString(r["status"]),
+					Amount:   number(r["amount"]),
+					Reason:   common.AssetString(r["reason"]),
+				}
+				if (req.OrderID != "" && refund.OrderID != req.OrderID) || (req.Status != "" && !strings.EqualFold(refund.Status, req.Status)) {
+					continue
+				}
+				if st.sent > 0 && req.IntervalMs > 0 {
+					select {
+					case <-st.ctx.Done():
+						return st.ctx.Err()
+					case <-time.After(time.Duration(req.IntervalMs) * time.Millisecond):
+					}
+				}
+				if err := st.Send(refund); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+	}
+}
+
+func number(v interface{}) float64 {
+	switch t := v.(type) {
+	case float64:
+		return t
+	case int:
+		return float64(t)
+	}
+	return 0
+}
+
+func orderItems(v interface{}) []*pbOrderItem {
+	list, _ := v.([]interface{})
+	out := make([]*pbOrderItem, 0, len(list))
+	for _, it := range list {
+		m, ok := it.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		out = append(out, &pbOrderItem{SKU: common.AssetString(m["sku"]), Qty: int32(number(m["qty"])), Price: number(m["price"])})
+	}
+	return out
+}
+
+func assetOrder(m map[string]interface{}) *pbOrder {
+	return &pbOrder{
+		ID:       common.AssetString(m["id"]),
+		Status:   common.AssetString(m["status"]),
+		UserID:   common.AssetString(m["userId"]),
+		Items:    orderItems(m["items"]),
+		Amount:   number(m["amount"]),
+		Currency: common.AssetString(m["currency"]),
+		Source:   "asset",
+	}
+}
+
+func liveOrder(o food.Order) *pbOrder {
+	return &pbOrder{
+		ID:         o.ID,
+		Status:     o.Status,
+		UserID:     o.UserID,
+		MerchantID: o.MerchantID,
+		Items:      orderItems(o.Items),
+		Amount:     o.Amount,
+		Currency:   common.AssetString(o.Extra["currency"]),
+		Reason:     o.Reason,
+		Source:     "live",
+	}
+}
+
+func liveEvent(ev food.Event) *pbOrderEvent {
+	return &pbOrderEvent{
+		Type:     ev.Type,
+		OrderID:  ev.OrderID,
+		Status:   ev.Status,
+		Previous: ev.Previous,
+		Action:   ev.Action,
+		Actor:    ev.Actor,
+		Order:    liveOrder(ev.Order),
+		Time:     ev.Time.Format(time.RFC3339Nano),
+	}
+}
+
+// refOrder converts a looked-up order.
+func refOrder(ref food.OrderRef) *pbOrder {
+	if ref.Live != nil {
+		return liveOrder(*ref.Live)
+	}
+	return assetOrder(ref.Fixture)
+}
@@ -0,0 +1,212 @@
+package grpcserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("proto: truncated message")
+
+// message is a protobuf message with hand-written wire encoding. The JSON
+// form (Connect and +json codecs) comes from the struct tags.
+type message interface {
+	marshal(e *encoder)
+	unmarshal(b []byte) error
+}
+
+func marshal(m message) []byte {
+	e := &encoder{}
+	m.marshal(e)
+	return e.buf
+}
+
+// encoder appends fields in proto3 style: zero scalars are omitted.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) varint(v uint64) { e.buf = binary.AppendUvarint(e.buf, v) }
+
+func (e *encoder) tag(field, wire int) { e.varint(uint64(field)<<3 | uint64(wire)) }
+
+func (e *encoder) string(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) bool(field int, v bool) {
+	if !v {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.varint(1)
+}
+
+// int32 sign-extends negative values to ten bytes, as the spec requires.
+func (e *encoder) int32(field int, v int32) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.varint(uint64(int64(v)))
+}
+
+func (e *encoder) double(field int, v float64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireFixed64)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v))
+}
+
+// message encodes a set sub-message, even when all its fields are zero.
+func (e *encoder) message(field int, m message) {
+	b := marshal(m)
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// decoder walks the fields of one message.
+type decoder struct {
+	b []byte
+}
+
+// decode calls fn for every field of b; fn reads the value through d or
+// skips it.
+func decode(b []byte, fn func(d *decoder, field, wire int) error) error {
+	d := &decoder{b: b}
+	for len(d.b) > 0 {
+		key, err := d.varint()
+		if err != nil {
+			return err
+		}
+		field, wire := int(key>>3), int(key&7)
+		if field == 0 {
+			return errors.New("proto: invalid field number 0")
+		}
+		if err := fn(d, field, wire); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) varint() (uint64, error) {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.b)) {
+		return nil, errTruncated
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *decoder) fixed(size int) ([]byte, error) {
+	if len(d.b) < size {
+		return nil, errTruncated
+	}
+	v := d.b[:size]
+	d.b = d.b[size:]
+	return v, nil
+}
+
+func (d *decoder) skip(wire int) error {
+	var err error
+	switch wire {
+	case wireVarint:
+		_, err = d.varint()
+	case wireFixed64:
+		_, err = d.fixed(8)
+	case wireBytes:
+		_, err = d.bytes()
+	case wireFixed32:
+		_, err = d.fixed(4)
+	default:
+		err = fmt.Errorf("proto: unsupported wire type %d", wire)
+	}
+	return err
+}
+
+func expect(field, wire, want int) error {
+	if wire != want {
+		return fmt.Errorf("proto: field %d has wire type %d, want %d", field, wire, want)
+	}
+	return nil
+}
+
+func (d *decoder) string(field, wire int, dst *string) error {
+	if err := expect(field, wire, wireBytes); err != nil {
+		return err
+	}
+	b, err := d.bytes()
+	*dst = string(b)
+	return err
+}
+
+func (d *decoder) bool(field, wire int, dst *bool) error {
+	if err := expect(field, wire, wireVarint); err != nil {
+		return err
+	}
+	v, err := d.varint()
+	*dst = v != 0
+	return err
+}
+
+func (d *decoder) int32(field, wire int, dst *int32) error {
+	if err := expect(field, wire, wireVarint); err != nil {
+		return err
+	}
+	v, err := d.varint()
+	*dst = int32(v)
+	return err
+}
+
+func (d *decoder) double(field, wire int, dst *float64) error {
+	if err := expect(field, wire, wireFixed64); err != nil {
+		return err
+	}
+	b, err := d.fixed(8)
+	if err == nil {
+		*dst = math.Float64frombits(binary.LittleEndian.Uint64(b))
+	}
+	return err
+}
+
+// message merges a sub-message field into m.
+func (d *decoder) message(field, wire int, m message) error {
+	if err := expect(field, wire, wireBytes); err != nil {
+		return err
+	}
+	b, err := d.bytes()
+	if err != nil {
+		return err
+	}
+	return m.unmarshal(b)
+}
@@ -0,0 +1,53 @@
+package grpcserver
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestWireRoundTrip(t *testing.T) {
+	o := &pbOrder{
+		ID:     "2001",
+		Status: "CREATED",
+		Items:  []*pbOrderItem{{SKU: "A-1", Qty: 2}, {SKU: "B-9", Qty: -1, Price: 0.5}},
+		Amount: 49.9,
+		Source: "asset",
+	}
+	b := marshal(o)
+	// field 1 "2001", field 2 "CREATED", then the first item {1:"A-1", 2:2}
+	if want, _ := hex.DecodeString("0a04323030311207435245415445442a070a03412d311002"); !bytes.HasPrefix(b, want) {
+		t.Fatalf("encoding: %x", b)
+	}
+	var got pbOrder
+	if err := got.unmarshal(b); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if got.ID != o.ID || got.Status != o.Status || len(got.Items) != 2 || got.Items[1].Qty != -1 || got.Items[1].Price != 0.5 || got.Amount != 49.9 || got.Source != "asset" {
+		t.Fatalf("round trip: %+v", got)
+	}
+
+	// unknown fields are skipped, truncated input fails
+	ev := marshal(&pbOrderEvent{Type: "order_created", Order: &pbOrder{}, Time: "t"})
+	var req pbGetOrderRequest
+	if err := req.unmarshal(append(ev, 0x78, 0x01)); err != nil {
+		t.Fatalf("skip unknown: %v", err)
+	}
+	if err := got.unmarshal(b[:len(b)-3]); err == nil {
+		t.Fatalf("expected truncation error")
+	}
+	if err := req.unmarshal([]byte{0x0d, 0, 0, 0, 0}); err == nil {
+		t.Fatalf("expected wire type error")
+	}
+}
+
+func TestDecodeWebText(t *testing.T) {
+	// two padded chunks back to back
+	got, err := decodeWebText([]byte("AAAAAAE=\nAA==\r\n"))
+	if err != nil || !bytes.Equal(got, []byte{0, 0, 0, 0, 1, 0}) {
+		t.Fatalf("decode: %x %v", got, err)
+	}
+	if _, err := decodeWebText([]byte("AAA")); err == nil {
+		t.Fatalf("expected padding error")
+	}
+}
@@ -0,0 +1,127 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/admin"
+	"intercept-wave-upstream/internal/topology"
+)
+
+func TestAdminControlPlane(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+
+	srvs := StartAll(base, topology.Default().HTTP)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+	ctl := httptest.NewServer(admin.Handler())
+	defer ctl.Close()
+
+	root := fmt.Sprintf("http://127.0.0.1:%d", base)
+	if err := waitHTTP(root+"/health", 2*time.Second); err != nil {
+		t.Fatalf("user health: %v", err)
+	}
+	post := func(path, body string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(ctl.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		return resp
+	}
+
+	t.Run("list services", func(t *testing.T) {
+		resp, err := http.Get(ctl.URL + "/services")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		list, _ := decodeJSONBody(t, resp)["services"].([]interface{})
+		if len(list) < 3 {
+			t.Fatalf("services=%v", list)
+		}
+	})
+
+	t.Run("route override", func(t *testing.T) {
+		resp := post("/services/user-service/overrides", `{"method":"GET","path":"/user/*","status":418,"delayMs":20}`)
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create status=%d", resp.StatusCode)
+		}
+		_ = resp.Body.Close()
+		start := time.Now()
+		resp, err := http.Get(root + "/user/info")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusTeapot || time.Since(start) < 20*time.Millisecond {
+			t.Fatalf("status=%d elapsed=%v", resp.StatusCode, time.Since(start))
+		}
+		req, _ := http.NewRequest(http.MethodDelete, ctl.URL+"/services/user-service/overrides", nil)
+		if resp, err = http.DefaultClient.Do(req); err != nil {
+			t.Fatalf("DELETE: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp, err = http.Get(root + "/user/info"); err != nil || resp.StatusCode != 200 {
+			t.Fatalf("after delete: %v %v", resp, err)
+		}
+		_ = resp.Body.Close()
+	})
+
+	t.Run("disable 503 and refuse", func(t *testing.T) {
+		_ = post("/services/user-service/disable", `{"mode":"503"}`).Body.Close()
+		resp, err := http.Get(root + "/health")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("status=%d", resp.StatusCode)
+		}
+
+		_ = post("/services/user-service/disable?mode=refuse", "").Body.Close()
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		if resp, err := client.Get(root + "/health"); err == nil {
+			_ = resp.Body.Close()
+			t.Fatalf("expected connection refused, got %d", resp.StatusCode)
+		}
+
+		_ = post("/services/user-service/enable", "").Body.Close()
+		if resp, err = client.Get(root + "/health"); err != nil || resp.StatusCode != 200 {
+			t.Fatalf("after enable: %v %v", resp, err)
+		}
+		_ = resp.Body.Close()
+	})
+
+	t.Run("reset rest items", func(t *testing.T) {
+		resp, err := http.Post(root+"/rest/items", "application/json", strings.NewReader(`{"name":"temp"}`))
+		if err != nil {
+			t.Fatalf("POST: %v", err)
+		}
+		location := resp.Header.Get("Location")
+		_ = resp.Body.Close()
+		resp = post("/services/user-service/reset?target=rest-items", "")
+		if got := decodeJSONBody(t, resp)["reset"]; fmt.Sprint(got) != "[rest-items]" {
+			t.Fatalf("reset=%v", got)
+		}
+		if resp, err = http.Get(root + location); err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("item survived reset: status=%d", resp.StatusCode)
+		}
+	})
+}
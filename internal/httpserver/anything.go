@@ -0,0 +1,77 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// reflectRequest describes r with full fidelity: every header value, the
+// declared vs. actual body length, transfer encoding and trailers. It reads
+// the whole body, so trailers are available afterwards.
+func reflectRequest(r *http.Request) map[string]interface{} {
+	declaredTrailers := make([]string, 0, len(r.Trailer))
+	for k := range r.Trailer {
+		declaredTrailers = append(declaredTrailers, k)
+	}
+	b, _ := io.ReadAll(r.Body)
+	_ = r.Body.Close()
+	body, encoding := encodeBody(b)
+
+	cookies := map[string][]string{}
+	for _, c := range r.Cookies() {
+		cookies[c.Name] = append(cookies[c.Name], c.Value)
+	}
+	out := map[string]interface{}{
+		"method":           r.Method,
+		"url":              fullURL(r),
+		"path":             r.URL.Path,
+		"rawPath":          r.URL.EscapedPath(),
+		"rawQuery":         r.URL.RawQuery,
+		"query":            map[string][]string(r.URL.Query()),
+		"proto":            r.Proto,
+		"protoMajor":       r.ProtoMajor,
+		"protoMinor":       r.ProtoMinor,
+		"host":             r.Host,
+		"remoteAddr":       r.RemoteAddr,
+		"requestURI":       r.RequestURI,
+		"headers":          cloneHeader(r.Header),
+		"cookies":          cookies,
+		"contentLength":    r.ContentLength,
+		"bodyLength":       len(b),
+		"transferEncoding": r.TransferEncoding,
+		"declaredTrailers": declaredTrailers,
+		"trailers":         cloneHeader(r.Trailer),
+		"body":             body,
+		"bodyEncoding":     encoding,
+		"tls":              captureTLS(r.TLS),
+	}
+	if r.TransferEncoding == nil {
+		out["transferEncoding"] = []string{}
+	}
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case ct == "application/json" || strings.HasSuffix(ct, "+json"):
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err == nil {
+			out["json"] = v
+		}
+	case ct == "application/x-www-form-urlencoded":
+		if form, err := url.ParseQuery(string(b)); err == nil {
+			out["form"] = map[string][]string(form)
+		}
+	}
+	return out
+}
+
+// flattenHeader joins multi-valued headers with ", " for the compact /headers view.
+func flattenHeader(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		out[k] = strings.Join(v, ", ")
+	}
+	return out
+}
@@ -0,0 +1,90 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/topology"
+)
+
+func TestAnythingReflectsFullRequest(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+
+	srvs := StartAll(base, topology.Default().HTTP)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	root := fmt.Sprintf("http://127.0.0.1:%d", base)
+	if err := waitHTTP(root+"/health", 2*time.Second); err != nil {
+		t.Fatalf("user health: %v", err)
+	}
+
+	// chunked body (unknown length) with a trailer and non-UTF-8 bytes
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = pw.Write([]byte{0xff, 0xfe, 0x00, 'x'})
+		_ = pw.Close()
+	}()
+	req, _ := http.NewRequest(http.MethodPut, root+"/anything/a/b?k=1&k=2", pr)
+	req.Header.Add("X-Custom", "one")
+	req.Header.Add("X-Custom", "two")
+	req.Trailer = http.Header{"X-Checksum": {"abc"}}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT anything: %v", err)
+	}
+	body := decodeJSONBody(t, resp)
+
+	if body["method"] != "PUT" || body["path"] != "/anything/a/b" {
+		t.Fatalf("unexpected method/path: %v %v", body["method"], body["path"])
+	}
+	if body["bodyEncoding"] != "base64" || body["body"] != "//4AeA==" || body["bodyLength"] != float64(4) {
+		t.Fatalf("unexpected body reflection: %v %v %v", body["bodyEncoding"], body["body"], body["bodyLength"])
+	}
+	if body["contentLength"] != float64(-1) {
+		t.Fatalf("expected unknown content length, got %v", body["contentLength"])
+	}
+	if te := body["transferEncoding"].([]interface{}); len(te) != 1 || te[0] != "chunked" {
+		t.Fatalf("unexpected transfer encoding: %v", te)
+	}
+	if tr := body["trailers"].(map[string]interface{}); tr["X-Checksum"].([]interface{})[0] != "abc" {
+		t.Fatalf("unexpected trailers: %v", tr)
+	}
+	hdrs := body["headers"].(map[string]interface{})
+	if got := hdrs["X-Custom"].([]interface{}); len(got) != 2 {
+		t.Fatalf("multi-valued header lost: %v", got)
+	}
+	if q := body["query"].(map[string]interface{}); len(q["k"].([]interface{})) != 2 {
+		t.Fatalf("multi-valued query lost: %v", q)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, root+"/headers", nil)
+	req.Header.Set("X-Forwarded-Host", "proxy.local")
+	req.Header.Add("Accept", "a/b")
+	req.Header.Add("Accept", "c/d")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET headers: %v", err)
+	}
+	body = decodeJSONBody(t, resp)
+	flat := body["headers"].(map[string]interface{})
+	if flat["X-Forwarded-Host"] != "proxy.local" || !strings.Contains(flat["Accept"].(string), "c/d") {
+		t.Fatalf("unexpected headers: %v", flat)
+	}
+	if !strings.HasPrefix(body["host"].(string), "127.0.0.1:") {
+		t.Fatalf("unexpected host: %v", body["host"])
+	}
+}
@@ -0,0 +1,342 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"intercept-wave-upstream/internal/common"
+)
+
+const (
+	// defaultCaptureLimit is the number of requests kept per service.
+	defaultCaptureLimit = 200
+	// captureBodyLimit caps the stored body bytes per request.
+	captureBodyLimit = 64 * 1024
+	// adminPathPrefix is reserved for per-service introspection endpoints.
+	adminPathPrefix = "/__upstream/"
+)
+
+// CapturedRequest is one request as received by a service.
+type CapturedRequest struct {
+	ID            int64               `json:"id"`
+	ReceivedAt    time.Time           `json:"receivedAt"`
+	Method        string              `json:"method"`
+	URL           string              `json:"url"`
+	Path          string              `json:"path"`
+	RawQuery      string              `json:"rawQuery"`
+	Proto         string              `json:"proto"`
+	Host          string              `json:"host"`
+	RemoteAddr    string              `json:"remoteAddr"`
+	Headers       map[string][]string `json:"headers"`
+	Trailers      map[string][]string `json:"trailers,omitempty"`
+	ContentLength int64               `json:"contentLength"`
+	Body          string              `json:"body"`
+	BodyEncoding  string              `json:"bodyEncoding"`
+	BodySize      int64               `json:"bodySize"`
+	BodyTruncated bool                `json:"bodyTruncated,omitempty"`
+	TLS           *CapturedTLS        `json:"tls,omitempty"`
+	Status        int                 `json:"status"`
+	DurationMs    float64             `json:"durationMs"`
+	// Pending is set while the handler is still running (e.g. a stream).
+	Pending bool `json:"pending,omitempty"`
+	// Aborted is set when the handler panicked, e.g. with
+	// http.ErrAbortHandler for a dropped stream or a reset fault.
+	Aborted bool `json:"aborted,omitempty"`
+}
+
+// CapturedTLS summarizes the TLS state of a captured request.
+type CapturedTLS struct {
+	Version            string   `json:"version"`
+	CipherSuite        string   `json:"cipherSuite"`
+	ServerName         string   `json:"serverName,omitempty"`
+	NegotiatedProtocol string   `json:"negotiatedProtocol,omitempty"`
+	PeerCertificates   []string `json:"peerCertificates,omitempty"`
+}
+
+// captureStore is a bounded ring buffer of captured requests.
+type captureStore struct {
+	mu     sync.Mutex
+	limit  int
+	nextID int64
+	items  []*CapturedRequest
+}
+
+func newCaptureStore(limit int) *captureStore {
+	if limit <= 0 {
+		limit = defaultCaptureLimit
+	}
+	return &captureStore{limit: limit, nextID: 1}
+}
+
+func (s *captureStore) add(c *CapturedRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.ID = s.nextID
+	s.nextID++
+	s.items = append(s.items, c)
+	if len(s.items) > s.limit {
+		s.items = s.items[len(s.items)-s.limit:]
+	}
+}
+
+// finish fills in the response side of an added entry.
+func (s *captureStore) finish(c *CapturedRequest, fill func(c *CapturedRequest)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fill(c)
+	c.Pending = false
+}
+
+func (s *captureStore) clear() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.items)
+	s.items = nil
+	return n
+}
+
+func (s *captureStore) snapshot() []*CapturedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// entries are copied: pending ones are still filled in by finish
+	out := make([]*CapturedRequest, len(s.items))
+	for i, c := range s.items {
+		cp := *c
+		out[i] = &cp
+	}
+	return out
+}
+
+// captureRequests records every request (except the /__upstream/ endpoints)
+// into store as it arrives and fills in the body and response once the
+// wrapped handler returns or panics. The body is captured as the handler
+// reads it; what the handler leaves unread is drained afterwards so BodySize
+// counts the whole body.
+func captureRequests(store *captureStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, adminPathPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		c := &CapturedRequest{
+			ReceivedAt:    start,
+			Method:        r.Method,
+			URL:           fullURL(r),
+			Path:          r.URL.Path,
+			RawQuery:      r.URL.RawQuery,
+			Proto:         r.Proto,
+			Host:          r.Host,
+			RemoteAddr:    r.RemoteAddr,
+			Headers:       cloneHeader(r.Header),
+			ContentLength: r.ContentLength,
+			TLS:           captureTLS(r.TLS),
+			Pending:       true,
+		}
+		store.add(c)
+		tee := &teeBody{ReadCloser: r.Body}
+		r.Body = tee
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		defer func() {
+			rec := recover()
+			if rec == nil && !sw.hijacked {
+				_, _ = io.Copy(io.Discard, tee)
+			}
+			store.finish(c, func(c *CapturedRequest) {
+				if len(r.Trailer) > 0 {
+					c.Trailers = cloneHeader(r.Trailer)
+				}
+				c.Body, c.BodyEncoding = encodeBody(tee.head.Bytes())
+				c.BodySize = tee.n
+				c.BodyTruncated = tee.n > int64(tee.head.Len())
+				c.Status = sw.status
+				c.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+				c.Aborted = rec != nil
+			})
+			if rec != nil {
+				panic(rec)
+			}
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// attachCapture mounts the capture inspection endpoints:
+//   - GET /__upstream/requests?method=&path=&contains=&header=&since=&limit=
+//   - GET /__upstream/requests/{id}
+//   - DELETE /__upstream/requests
+func attachCapture(mux *http.ServeMux, store *captureStore) {
+	mux.HandleFunc(adminPathPrefix+"requests", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			list := filterCaptured(store.snapshot(), r.URL.Query())
+			common.JSON(w, 200, map[string]interface{}{"total": len(list), "requests": list})
+		case http.MethodDelete:
+			common.JSON(w, 200, map[string]interface{}{"cleared": store.clear()})
+		default:
+			w.Header().Set("Allow", "GET,DELETE")
+			common.JSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+		}
+	})
+	mux.HandleFunc("GET "+adminPathPrefix+"requests/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		for _, c := range store.snapshot() {
+			if c.ID == id {
+				common.JSON(w, 200, c)
+				return
+			}
+		}
+		common.JSON(w, http.StatusNotFound, map[string]interface{}{"error": "not found"})
+	})
+}
+
+// filterCaptured applies the query filters; limit keeps the newest entries.
+func filterCaptured(all []*CapturedRequest, q map[string][]string) []*CapturedRequest {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	method := strings.ToUpper(get("method"))
+	path := get("path")
+	contains := get("contains")
+	since, _ := strconv.ParseInt(get("since"), 10, 64)
+	hdrName, hdrValue, hdrHasValue := strings.Cut(get("header"), ":")
+	out := make([]*CapturedRequest, 0, len(all))
+	for _, c := range all {
+		if method != "" && c.Method != method {
+			continue
+		}
+		if path != "" && !strings.HasPrefix(c.Path, path) {
+			continue
+		}
+		if contains != "" && !strings.Contains(c.URL, contains) && !strings.Contains(c.Body, contains) {
+			continue
+		}
+		if since > 0 && c.ID <= since {
+			continue
+		}
+		if hdrName != "" {
+			vals := http.Header(c.Headers).Values(strings.TrimSpace(hdrName))
+			if len(vals) == 0 {
+				continue
+			}
+			if hdrHasValue && !containsString(vals, strings.TrimSpace(hdrValue)) {
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	if limit, err := strconv.Atoi(get("limit")); err == nil && limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func fullURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+func cloneHeader(h http.Header) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for k, v := range h {
+		out[k] = append([]string(nil), v...)
+	}
+	return out
+}
+
+// encodeBody returns the body as text when it is valid UTF-8, base64 otherwise.
+func encodeBody(b []byte) (string, string) {
+	if utf8.Valid(b) {
+		return string(b), "utf-8"
+	}
+	return base64.StdEncoding.EncodeToString(b), "base64"
+}
+
+func captureTLS(cs *tls.ConnectionState) *CapturedTLS {
+	if cs == nil {
+		return nil
+	}
+	out := &CapturedTLS{
+		Version:            tls.VersionName(cs.Version),
+		CipherSuite:        tls.CipherSuiteName(cs.CipherSuite),
+		ServerName:         cs.ServerName,
+		NegotiatedProtocol: cs.NegotiatedProtocol,
+	}
+	for _, cert := range cs.PeerCertificates {
+		out.PeerCertificates = append(out.PeerCertificates, cert.Subject.String())
+	}
+	return out
+}
+
+// teeBody keeps the first captureBodyLimit body bytes read through it and
+// counts them all.
+type teeBody struct {
+	io.ReadCloser
+	head bytes.Buffer
+	n    int64
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if keep := min(n, captureBodyLimit-t.head.Len()); keep > 0 {
+		t.head.Write(p[:keep])
+	}
+	t.n += int64(n)
+	return n, err
+}
+
+// statusWriter records the response status while keeping Flush/Hijack
+// available to streaming and WebSocket handlers.
+type statusWriter struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	sw.status = code
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := sw.ResponseWriter.(http.Hijacker); ok {
+		sw.hijacked = true
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("hijacker not supported")
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter { return sw.ResponseWriter }
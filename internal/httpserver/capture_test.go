@@ -0,0 +1,202 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/tlsutil"
+	"intercept-wave-upstream/internal/topology"
+)
+
+func TestCaptureRecordsAndFiltersRequests(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+
+	srvs := StartAll(base, topology.Default().HTTP)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	root := fmt.Sprintf("http://127.0.0.1:%d", base+1)
+	if err := waitHTTP(root+"/health", 2*time.Second); err != nil {
+		t.Fatalf("order health: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, root+"/echo?x=1", bytes.NewBufferString(`{"a":1}`))
+	req.Header.Add("X-Forwarded-For", "10.0.0.1")
+	req.Header.Add("X-Forwarded-For", "10.0.0.2")
+	req.AddCookie(&http.Cookie{Name: "sid", Value: "abc"})
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST echo: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	resp, err = http.Get(root + "/__upstream/requests?method=POST&path=/echo&header=X-Forwarded-For:10.0.0.2")
+	if err != nil {
+		t.Fatalf("GET requests: %v", err)
+	}
+	body := decodeJSONBody(t, resp)
+	if body["total"] != float64(1) {
+		t.Fatalf("unexpected total: %v", body)
+	}
+	c := body["requests"].([]interface{})[0].(map[string]interface{})
+	if c["body"] != `{"a":1}` || c["rawQuery"] != "x=1" || c["status"] != float64(200) {
+		t.Fatalf("unexpected capture: %v", c)
+	}
+	hdrs := c["headers"].(map[string]interface{})
+	if len(hdrs["X-Forwarded-For"].([]interface{})) != 2 || hdrs["Cookie"] == nil {
+		t.Fatalf("headers not fully captured: %v", hdrs)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, root+"/__upstream/requests", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE requests: %v", err)
+	}
+	_ = resp.Body.Close()
+	resp, err = http.Get(root + "/__upstream/requests")
+	if err != nil {
+		t.Fatalf("GET requests: %v", err)
+	}
+	if body := decodeJSONBody(t, resp); body["total"] != float64(0) {
+		t.Fatalf("expected cleared buffer, got %v", body["total"])
+	}
+}
+
+// progressReader counts the bytes read from it.
+type progressReader struct {
+	r io.Reader
+	n int
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += n
+	return n, err
+}
+
+func TestCaptureReadsBodyAlongsideHandler(t *testing.T) {
+	store := newCaptureStore(10)
+	body := &progressReader{r: strings.NewReader(strings.Repeat("b", captureBodyLimit+100))}
+	h := captureRequests(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body.n != 0 {
+			t.Errorf("body consumed before the handler ran: %d bytes", body.n)
+		}
+		buf := make([]byte, 10)
+		if _, err := io.ReadFull(r.Body, buf); err != nil || string(buf) != "bbbbbbbbbb" {
+			t.Errorf("handler read %q, %v", buf, err)
+		}
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/upload", io.NopCloser(body))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	list := store.snapshot()
+	if len(list) != 1 {
+		t.Fatalf("captured %d requests", len(list))
+	}
+	// the unread rest is drained and counted; only the first
+	// captureBodyLimit bytes are kept
+	c := list[0]
+	if len(c.Body) != captureBodyLimit || !c.BodyTruncated || c.BodySize != captureBodyLimit+100 || body.n != captureBodyLimit+100 {
+		t.Fatalf("body=%d truncated=%v size=%d", len(c.Body), c.BodyTruncated, c.BodySize)
+	}
+}
+
+func TestCaptureRecordsPendingAndAbortedRequests(t *testing.T) {
+	store := newCaptureStore(10)
+	release := make(chan struct{})
+	srv := httptest.NewServer(captureRequests(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+		w.(http.Flusher).Flush()
+		<-release
+		panic(http.ErrAbortHandler)
+	})))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/stream", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	// the handler is still running: the request is already listed
+	list := store.snapshot()
+	if len(list) != 1 || !list[0].Pending || list[0].Path != "/stream" {
+		t.Fatalf("pending capture: %+v", list)
+	}
+
+	close(release)
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Fatalf("expected the aborted response to fail")
+	}
+	list = store.snapshot()
+	c := list[0]
+	if c.Pending || !c.Aborted || c.Status != http.StatusAccepted || c.Body != "hello" || c.BodySize != 5 {
+		t.Fatalf("aborted capture: %+v", c)
+	}
+}
+
+func TestCaptureRecordsTLS(t *testing.T) {
+	a, err := tlsutil.Default()
+	if err != nil {
+		t.Fatalf("authority: %v", err)
+	}
+	cfg, err := tlsutil.ServerConfig("capture", topology.ServiceTLS{Enabled: true, ClientAuth: "request"})
+	if err != nil {
+		t.Fatalf("server config: %v", err)
+	}
+	store := newCaptureStore(10)
+	srv := httptest.NewUnstartedServer(captureRequests(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	srv.TLS = cfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	certPEM, keyPEM, err := a.IssueClient("capture-client")
+	if err != nil {
+		t.Fatalf("issue client: %v", err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("client pair: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      a.Pool(),
+		Certificates: []tls.Certificate{pair},
+		ServerName:   "localhost",
+	}}}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get(srv.URL + "/secure")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	list := store.snapshot()
+	if len(list) != 1 || list[0].TLS == nil {
+		t.Fatalf("captured %+v", list)
+	}
+	c, got := list[0], list[0].TLS
+	if !strings.HasPrefix(c.URL, "https://") || got.Version != tls.VersionName(resp.TLS.Version) || got.CipherSuite == "" || got.ServerName != "localhost" {
+		t.Fatalf("tls=%+v url=%s", got, c.URL)
+	}
+	if len(got.PeerCertificates) != 1 || !strings.Contains(got.PeerCertificates[0], "CN=capture-client") {
+		t.Fatalf("peer certificates=%v", got.PeerCertificates)
+	}
+}
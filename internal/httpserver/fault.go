@@ -0,0 +1,486 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+)
+
+// faultHeader and faultQuery select a fault for a single request, e.g.
+// "X-Upstream-Fault: slow;delay=20" or "?__fault=truncate;percent=30".
+const (
+	faultHeader = "X-Upstream-Fault"
+	faultQuery  = "__fault"
+)
+
+// Fault is a parsed fault directive: a name plus key=value parameters.
+//
+// Supported faults:
+//   - close: close the socket before any response bytes
+//   - reset: send headers and `after` body bytes (default 0), then TCP reset
+//   - truncate: advertise the full Content-Length but send `ratio` (default 0.5) of the body
+//   - stall: send headers, then hang for `ms` (default 30000) or until the client leaves
+//   - slow: send the body `chunk` bytes (default 1) every `delay` ms (default 50)
+//   - malformed: reply with a syntactically broken HTTP response
+//   - error (alias random5xx): reply with `status` (default random 500/502/503/504)
+//
+// slow and reset also apply to streaming responses (SSE, /stream, bodies
+// over maxRecordedBody), which pass through as they are written; truncate
+// needs the full body and answers 400 for them.
+//
+// Every fault accepts `percent` (0-100, default 100): the chance it fires.
+type Fault struct {
+	Name   string            `json:"name"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// ParseFault parses "name;key=value;key=value".
+func ParseFault(s string) (Fault, error) {
+	parts := strings.Split(strings.TrimSpace(s), ";")
+	f := Fault{Name: strings.ToLower(strings.TrimSpace(parts[0])), Params: map[string]string{}}
+	switch f.Name {
+	case "close", "reset", "truncate", "stall", "slow", "malformed", "error", "random5xx":
+	case "":
+		return f, fmt.Errorf("empty fault")
+	default:
+		return f, fmt.Errorf("unknown fault %q", f.Name)
+	}
+	for _, p := range parts[1:] {
+		k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+		if k != "" {
+			f.Params[strings.ToLower(k)] = v
+		}
+	}
+	return f, nil
+}
+
+func (f Fault) String() string {
+	var b strings.Builder
+	b.WriteString(f.Name)
+	keys := make([]string, 0, len(f.Params))
+	for k := range f.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, ";%s=%s", k, f.Params[k])
+	}
+	return b.String()
+}
+
+func (f Fault) intParam(key string, def int) int {
+	if n, err := strconv.Atoi(f.Params[key]); err == nil {
+		return n
+	}
+	return def
+}
+
+func (f Fault) fires() bool {
+	pct := f.intParam("percent", 100)
+	return pct >= 100 || rand.Intn(100) < pct
+}
+
+// faultRule is the service-wide fault set through the admin API.
+type faultRule struct {
+	Fault      Fault  `json:"fault"`
+	PathPrefix string `json:"pathPrefix,omitempty"`
+}
+
+type faultState struct {
+	mu   sync.RWMutex
+	rule *faultRule
+}
+
+func (s *faultState) get() *faultRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rule
+}
+
+func (s *faultState) set(r *faultRule) {
+	s.mu.Lock()
+	s.rule = r
+	s.mu.Unlock()
+}
+
+// injectFaults applies the per-request fault (header/query) or, failing
+// that, the global rule. /__upstream/ endpoints are never faulted.
+func injectFaults(state *faultState, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, adminPathPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		directive := r.Header.Get(faultHeader)
+		if directive == "" {
+			directive = faultFromQuery(r.URL.RawQuery)
+		}
+		var fault *Fault
+		if directive != "" {
+			f, err := ParseFault(directive)
+			if err != nil {
+				common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+				return
+			}
+			fault = &f
+		} else if rule := state.get(); rule != nil && strings.HasPrefix(r.URL.Path, rule.PathPrefix) {
+			fault = &rule.Fault
+		}
+		if fault == nil || !fault.fires() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		common.Logf("fault %s injected for %s %s", fault, r.Method, r.URL.Path)
+		applyFault(*fault, w, r, next)
+	})
+}
+
+// faultFromQuery returns the __fault query value. url.ParseQuery drops pairs
+// containing ';', which the directive syntax relies on, so the raw query is
+// scanned directly.
+func faultFromQuery(raw string) string {
+	for _, pair := range strings.Split(raw, "&") {
+		k, v, _ := strings.Cut(pair, "=")
+		if k != faultQuery {
+			continue
+		}
+		if u, err := url.QueryUnescape(v); err == nil {
+			return u
+		}
+		return v
+	}
+	return ""
+}
+
+func applyFault(f Fault, w http.ResponseWriter, r *http.Request, next http.Handler) {
+	switch f.Name {
+	case "close":
+		conn, _, ok := hijack(w)
+		if !ok {
+			panic(http.ErrAbortHandler)
+		}
+		_ = conn.Close()
+	case "malformed":
+		conn, buf, ok := hijack(w)
+		if !ok {
+			panic(http.ErrAbortHandler)
+		}
+		_, _ = buf.WriteString("HTTP/1.1 2OO OK\r\nContent-Type: application/json\r\nContent-Length: not-a-number\r\nBroken Header Line\r\n\r\n{\"malformed\":")
+		_ = buf.Flush()
+		_ = conn.Close()
+	case "error", "random5xx":
+		status := f.intParam("status", 0)
+		if status < 100 || status > 599 {
+			status = []int{500, 502, 503, 504}[rand.Intn(4)]
+		}
+		common.JSON(w, status, map[string]interface{}{"error": "injected fault", "status": status})
+	case "stall":
+		w.WriteHeader(http.StatusOK)
+		if fl, ok := w.(http.Flusher); ok {
+			fl.Flush()
+		}
+		select {
+		case <-time.After(time.Duration(f.intParam("ms", 30000)) * time.Millisecond):
+		case <-r.Context().Done():
+		}
+	case "slow":
+		sw := &slowWriter{
+			w:     w,
+			chunk: max(f.intParam("chunk", 1), 1),
+			delay: time.Duration(f.intParam("delay", 50)) * time.Millisecond,
+			done:  r.Context().Done(),
+		}
+		rec := record(next, r, func(rec *recordedResponse) (io.Writer, error) {
+			rec.commit(w)
+			return sw, nil
+		})
+		if rec.streaming() {
+			return
+		}
+		copyHeader(w.Header(), rec.header)
+		w.Header().Set("Content-Length", strconv.Itoa(rec.body.Len()))
+		w.WriteHeader(rec.status)
+		_, _ = sw.Write(rec.body.Bytes())
+	case "truncate":
+		rec := record(next, r, func(rec *recordedResponse) (io.Writer, error) {
+			common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": "fault truncate needs a complete response; " + r.URL.Path + " streams (use reset;after=N)"})
+			return nil, errFaultStream
+		})
+		if rec.streaming() {
+			return
+		}
+		body := rec.body.Bytes()
+		ratio, err := strconv.ParseFloat(f.Params["ratio"], 64)
+		if err != nil || ratio < 0 || ratio >= 1 {
+			ratio = 0.5
+		}
+		replayPartial(w, rec, body[:int(float64(len(body))*ratio)], false)
+	case "reset":
+		rw := &resetWriter{w: w, left: max(f.intParam("after", 0), 0)}
+		rec := record(next, r, func(rec *recordedResponse) (io.Writer, error) {
+			rec.commit(w)
+			return rw, nil
+		})
+		if rec.streaming() {
+			rw.reset()
+			return
+		}
+		body := rec.body.Bytes()
+		replayPartial(w, rec, body[:min(rw.left, len(body))], true)
+	default:
+		next.ServeHTTP(w, r)
+	}
+}
+
+// attachFaults mounts the service-wide fault control endpoint:
+//   - GET /__upstream/faults — current rule
+//   - PUT|POST /__upstream/faults {"fault":"slow;delay=20","pathPrefix":"/api"}
+//   - DELETE /__upstream/faults — clear
+func attachFaults(mux *http.ServeMux, state *faultState) {
+	mux.HandleFunc(adminPathPrefix+"faults", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			common.JSON(w, 200, map[string]interface{}{"rule": state.get()})
+		case http.MethodPut, http.MethodPost:
+			var in struct {
+				Fault      string `json:"fault"`
+				PathPrefix string `json:"pathPrefix"`
+			}
+			b, _ := io.ReadAll(r.Body)
+			_ = r.Body.Close()
+			if err := json.Unmarshal(b, &in); err != nil {
+				common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid json"})
+				return
+			}
+			f, err := ParseFault(in.Fault)
+			if err != nil {
+				common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+				return
+			}
+			rule := &faultRule{Fault: f, PathPrefix: in.PathPrefix}
+			state.set(rule)
+			common.JSON(w, 200, map[string]interface{}{"rule": rule})
+		case http.MethodDelete:
+			state.set(nil)
+			common.JSON(w, 200, map[string]interface{}{"rule": nil})
+		default:
+			w.Header().Set("Allow", "GET,PUT,POST,DELETE")
+			common.JSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+		}
+	})
+}
+
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, bool) {
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, nil, false
+	}
+	return conn, buf, true
+}
+
+// replayPartial writes the recorded status and headers with the full
+// Content-Length, then only send, and closes the connection; rst makes the
+// close a TCP reset.
+func replayPartial(w http.ResponseWriter, rec *recordedResponse, send []byte, rst bool) {
+	conn, buf, ok := hijack(w)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+	rec.header.Set("Content-Length", strconv.Itoa(rec.body.Len()))
+	_, _ = fmt.Fprintf(buf, "HTTP/1.1 %d %s\r\n", rec.status, http.StatusText(rec.status))
+	_ = rec.header.Write(buf)
+	_, _ = buf.WriteString("\r\n")
+	_, _ = buf.Write(send)
+	_ = buf.Flush()
+	if rst {
+		resetConn(conn)
+		return
+	}
+	_ = conn.Close()
+}
+
+// maxRecordedBody caps how much of a response the slow, truncate and reset
+// faults buffer. A larger response, or one the handler flushes (SSE, the
+// /stream routes), is handled as a stream.
+const maxRecordedBody = 4 << 20
+
+var (
+	errFaultStream = errors.New("fault does not apply to streaming responses")
+	errFaultReset  = errors.New("connection reset by fault")
+)
+
+// recordedResponse buffers a handler response so faults can replay it.
+// When the response turns out to be a stream, onStream decides how the rest
+// is delivered: later writes go to the writer it returns (after the bytes
+// buffered so far), or fail with its error so the handler stops.
+type recordedResponse struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	onStream func(rec *recordedResponse) (io.Writer, error)
+	out      io.Writer
+	err      error
+}
+
+func (rr *recordedResponse) Header() http.Header { return rr.header }
+func (rr *recordedResponse) Write(b []byte) (int, error) {
+	if !rr.streaming() && rr.body.Len()+len(b) > maxRecordedBody {
+		rr.startStream()
+	}
+	switch {
+	case rr.err != nil:
+		return 0, rr.err
+	case rr.out != nil:
+		return rr.out.Write(b)
+	}
+	return rr.body.Write(b)
+}
+func (rr *recordedResponse) WriteHeader(code int) { rr.status = code }
+
+// Flush marks the response as a stream.
+func (rr *recordedResponse) Flush() {
+	if !rr.streaming() {
+		rr.startStream()
+	}
+	if fl, ok := rr.out.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+func (rr *recordedResponse) streaming() bool { return rr.out != nil || rr.err != nil }
+
+func (rr *recordedResponse) startStream() {
+	rr.out, rr.err = rr.onStream(rr)
+	if rr.out != nil && rr.body.Len() > 0 {
+		// bytes buffered before the stream started go out first
+		if _, err := rr.out.Write(rr.body.Bytes()); err != nil {
+			rr.err = err
+		}
+		rr.body.Reset()
+	}
+}
+
+// commit sends the recorded status and headers to w.
+func (rr *recordedResponse) commit(w http.ResponseWriter) {
+	copyHeader(w.Header(), rr.header)
+	w.WriteHeader(rr.status)
+}
+
+func record(next http.Handler, r *http.Request, onStream func(rec *recordedResponse) (io.Writer, error)) *recordedResponse {
+	rec := &recordedResponse{header: http.Header{}, status: http.StatusOK, onStream: onStream}
+	next.ServeHTTP(rec, r)
+	return rec
+}
+
+// slowWriter trickles writes to w chunk bytes every delay.
+type slowWriter struct {
+	w     http.ResponseWriter
+	chunk int
+	delay time.Duration
+	done  <-chan struct{}
+}
+
+func (s *slowWriter) Write(b []byte) (int, error) {
+	fl, _ := s.w.(http.Flusher)
+	written := 0
+	for len(b) > 0 {
+		n := min(s.chunk, len(b))
+		if _, err := s.w.Write(b[:n]); err != nil {
+			return written, err
+		}
+		if fl != nil {
+			fl.Flush()
+		}
+		written += n
+		b = b[n:]
+		select {
+		case <-time.After(s.delay):
+		case <-s.done:
+			return written, http.ErrAbortHandler
+		}
+	}
+	return written, nil
+}
+
+func (s *slowWriter) Flush() {
+	if fl, ok := s.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// resetWriter passes left more bytes through to w, then resets the
+// connection.
+type resetWriter struct {
+	w    http.ResponseWriter
+	left int
+	done bool
+}
+
+func (rw *resetWriter) Write(b []byte) (int, error) {
+	n := min(rw.left, len(b))
+	if n > 0 {
+		if _, err := rw.w.Write(b[:n]); err != nil {
+			return 0, err
+		}
+		rw.left -= n
+	}
+	if n < len(b) {
+		rw.reset()
+		return n, errFaultReset
+	}
+	return n, nil
+}
+
+func (rw *resetWriter) Flush() {
+	if fl, ok := rw.w.(http.Flusher); ok && !rw.done {
+		fl.Flush()
+	}
+}
+
+// reset flushes what was written and aborts the connection with a TCP reset
+// (a stream reset on HTTP/2).
+func (rw *resetWriter) reset() {
+	if rw.done {
+		return
+	}
+	rw.done = true
+	conn, _, ok := hijack(rw.w)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+	resetConn(conn)
+}
+
+// resetConn closes conn with a TCP reset. A TLS connection is unwrapped
+// first and its TCP connection closed directly, so no close_notify alert
+// precedes the reset.
+func resetConn(conn net.Conn) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.SetLinger(0)
+	}
+	_ = conn.Close()
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = append([]string(nil), v...)
+	}
+}
@@ -0,0 +1,195 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/topology"
+)
+
+func TestFaultInjection(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+
+	srvs := StartAll(base, topology.Default().HTTP)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	// faults break connections; never reuse one across subtests
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+	get := func(path string) (*http.Response, error) {
+		return client.Get(fmt.Sprintf("http://127.0.0.1:%d%s", base, path))
+	}
+	root := fmt.Sprintf("http://127.0.0.1:%d", base)
+	if err := waitHTTP(root+"/health", 2*time.Second); err != nil {
+		t.Fatalf("user health: %v", err)
+	}
+
+	t.Run("error via header", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, root+"/user/info", nil)
+		req.Header.Set("X-Upstream-Fault", "error;status=502")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("status=%d", resp.StatusCode)
+		}
+	})
+
+	t.Run("truncate via query", func(t *testing.T) {
+		resp, err := get("/large?size=1000&__fault=truncate")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if _, err := io.ReadAll(resp.Body); err == nil {
+			t.Fatalf("expected unexpected EOF on truncated body")
+		}
+	})
+
+	t.Run("close before headers", func(t *testing.T) {
+		if resp, err := get("/health?__fault=close"); err == nil {
+			_ = resp.Body.Close()
+			t.Fatalf("expected transport error")
+		}
+	})
+
+	t.Run("global rule with path prefix", func(t *testing.T) {
+		resp, err := client.Post(root+"/__upstream/faults", "application/json", bytes.NewBufferString(`{"fault":"error;status=503","pathPrefix":"/users"}`))
+		if err != nil {
+			t.Fatalf("POST faults: %v", err)
+		}
+		_ = resp.Body.Close()
+
+		resp, _ = get("/users")
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("faulted path status=%d", resp.StatusCode)
+		}
+		resp, _ = get("/health")
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unrelated path status=%d", resp.StatusCode)
+		}
+
+		req, _ := http.NewRequest(http.MethodDelete, root+"/__upstream/faults", nil)
+		resp, _ = client.Do(req)
+		_ = resp.Body.Close()
+		resp, _ = get("/users")
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("cleared rule still active: %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("reset after some bytes", func(t *testing.T) {
+		resp, err := get("/large?size=1000&__fault=reset;after=10")
+		if err != nil {
+			return // the reset may beat the headers to the client
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if b, err := io.ReadAll(resp.Body); err == nil {
+			t.Fatalf("expected reset, read %d bytes", len(b))
+		}
+	})
+
+	t.Run("slow trickles the whole body", func(t *testing.T) {
+		start := time.Now()
+		resp, err := get("/large?size=20&__fault=slow;chunk=10;delay=30")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil || len(b) < 20 || int64(len(b)) != resp.ContentLength {
+			t.Fatalf("body=%q contentLength=%d err=%v", b, resp.ContentLength, err)
+		}
+		if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+			t.Fatalf("slow body took only %v", elapsed)
+		}
+	})
+
+	t.Run("stall holds the body", func(t *testing.T) {
+		start := time.Now()
+		resp, err := get("/user/info?__fault=stall;ms=150")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || len(b) != 0 {
+			t.Fatalf("status=%d body=%q", resp.StatusCode, b)
+		}
+		if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+			t.Fatalf("stall ended after %v", elapsed)
+		}
+	})
+
+	t.Run("malformed response", func(t *testing.T) {
+		if resp, err := get("/user/info?__fault=malformed"); err == nil {
+			_ = resp.Body.Close()
+			t.Fatalf("expected a parse error, got status %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("slow passes a stream through", func(t *testing.T) {
+		resp, err := get("/sse?count=3&interval=10&__fault=slow;chunk=64;delay=1")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		if resp.ContentLength != -1 {
+			t.Fatalf("stream got Content-Length %d", resp.ContentLength)
+		}
+		if ids, err := readSSEIDs(t, resp); err != nil || len(ids) != 3 {
+			t.Fatalf("ids=%v err=%v", ids, err)
+		}
+	})
+
+	t.Run("reset cuts an endless stream", func(t *testing.T) {
+		resp, err := get("/stream/ndjson?count=0&interval=10&__fault=reset;after=100")
+		if err != nil {
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if b, err := io.ReadAll(resp.Body); err == nil || len(b) > 100 {
+			t.Fatalf("read %d bytes, err=%v", len(b), err)
+		}
+	})
+
+	t.Run("truncate rejects a stream", func(t *testing.T) {
+		resp, err := get("/stream/ndjson?count=0&__fault=truncate")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("status=%d", resp.StatusCode)
+		}
+	})
+}
+
+func TestFaultStringSortsParams(t *testing.T) {
+	f, err := ParseFault("slow;percent=50;delay=20;chunk=4")
+	if err != nil {
+		t.Fatalf("ParseFault: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if got := f.String(); got != "slow;chunk=4;delay=20;percent=50" {
+			t.Fatalf("String()=%q", got)
+		}
+	}
+}
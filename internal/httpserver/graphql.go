@@ -0,0 +1,115 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"intercept-wave-upstream/internal/admin"
+	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/food"
+	"intercept-wave-upstream/internal/graphql"
+)
+
+// graphqlResponseType is the GraphQL-over-HTTP media type. Clients that
+// accept it get 4xx statuses for requests that fail before execution;
+// plain application/json clients always get 200 with an errors list.
+const graphqlResponseType = "application/graphql-response+json"
+
+// graphqlRoutes mounts the GraphQL endpoint over the user, order and payment
+// data (POST JSON, POST application/graphql, or GET with query parameters)
+// and the schema in SDL form.
+func graphqlRoutes(mux *http.ServeMux, spec ServiceSpec) {
+	p := spec.InterceptPrefix
+	registerPaths(mux, []string{p + "/graphql", "/graphql"}, serveGraphQL)
+	registerPaths(mux, []string{p + "/graphql/schema", "/graphql/schema"}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, graphql.Default.SDL())
+	})
+	admin.RegisterReset(spec.Name, "refunds", food.Refunds.Reset)
+}
+
+func serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	modern := strings.Contains(r.Header.Get("Accept"), graphqlResponseType)
+	reply := func(status int, res *graphql.Result) {
+		b, _ := json.Marshal(res)
+		if modern {
+			w.Header().Set("Content-Type", graphqlResponseType+"; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write(b)
+	}
+
+	var req graphql.Request
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		for _, param := range []struct {
+			name string
+			dst  *map[string]interface{}
+		}{{"variables", &req.Variables}, {"extensions", &req.Extensions}} {
+			if v := q.Get(param.name); v != "" {
+				if err := json.Unmarshal([]byte(v), param.dst); err != nil {
+					common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": fmt.Sprintf("invalid %s: %v", param.name, err)})
+					return
+				}
+			}
+		}
+	case http.MethodPost:
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, graphql.MaxRequestSize))
+		_ = r.Body.Close()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			common.JSON(w, http.StatusRequestEntityTooLarge, map[string]interface{}{"error": fmt.Sprintf("request body larger than %d bytes", tooLarge.Limit)})
+			return
+		}
+		if err != nil {
+			common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+			return
+		}
+		mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mt {
+		case "application/graphql":
+			req.Query = string(body)
+		case "application/json", "":
+			if err := json.Unmarshal(body, &req); err != nil {
+				common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": "invalid JSON body: " + err.Error()})
+				return
+			}
+		default:
+			common.JSON(w, http.StatusUnsupportedMediaType, map[string]interface{}{"error": "unsupported content type " + mt})
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		common.JSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+		return
+	}
+
+	op, errs := graphql.Default.Prepare(req)
+	if len(errs) > 0 {
+		reply(http.StatusBadRequest, &graphql.Result{Errors: errs})
+		return
+	}
+	switch {
+	case op.Kind() == "mutation" && r.Method == http.MethodGet:
+		w.Header().Set("Allow", "POST")
+		common.JSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "mutations require POST"})
+		return
+	case op.Kind() == "subscription":
+		reply(http.StatusBadRequest, &graphql.Result{Errors: []*graphql.Error{{
+			Message: "Subscriptions are served over graphql-transport-ws at /graphql on the WS services.",
+		}}})
+		return
+	}
+	reply(http.StatusOK, op.Execute(r.Context()))
+}
@@ -0,0 +1,140 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/food"
+	"intercept-wave-upstream/internal/graphql"
+	"intercept-wave-upstream/internal/topology"
+)
+
+func TestGraphQLEndpoint(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().HTTP)
+	// a private client: pooled connections to ports of servers shut down by
+	// earlier tests would fail POSTs with EOF
+	client := &http.Client{Transport: &http.Transport{}}
+	t.Cleanup(func() {
+		client.CloseIdleConnections()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+		food.Default.Reset()
+		food.Refunds.Reset()
+	})
+	for port := base; port <= base+2; port++ {
+		if err := waitHTTP(fmt.Sprintf("http://127.0.0.1:%d/health", port), 2*time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	endpoint := fmt.Sprintf("http://127.0.0.1:%d/api/graphql", base)
+
+	do := func(method, target, contentType, accept, body string) (int, string, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, target, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), string(b)
+	}
+
+	status, ctype, body := do("POST", endpoint, "application/json", "", `{"query":"query($id: ID!) { user(id: $id) { name } }","variables":{"id":"1"}}`)
+	if status != 200 || !strings.HasPrefix(ctype, "application/json") || body != `{"data":{"user":{"name":"张三"}}}` {
+		t.Fatalf("POST json: %d %s %s", status, ctype, body)
+	}
+	status, _, body = do("POST", endpoint, "application/graphql", "", `{ refunds(status: "PENDING") { refundId } }`)
+	if status != 200 || body != `{"data":{"refunds":[{"refundId":"RF-00002"}]}}` {
+		t.Fatalf("POST graphql: %d %s", status, body)
+	}
+	status, _, body = do("GET", endpoint+"?query="+url.QueryEscape(`{ order(id: 2003) { status } }`), "", "", "")
+	if status != 200 || body != `{"data":{"order":{"status":"CANCELLED"}}}` {
+		t.Fatalf("GET: %d %s", status, body)
+	}
+
+	// mutations need POST; the order lands in the shared food store
+	mutation := `mutation { createOrder(input: {userId: "u-1", amount: 9.5}) { id status } }`
+	if status, _, body = do("GET", endpoint+"?query="+url.QueryEscape(mutation), "", "", ""); status != http.StatusMethodNotAllowed {
+		t.Fatalf("GET mutation: %d %s", status, body)
+	}
+	status, _, body = do("POST", fmt.Sprintf("http://127.0.0.1:%d/graphql", base+1), "application/json", "", `{"query":`+fmt.Sprintf("%q", mutation)+`}`)
+	var created struct {
+		Data struct {
+			CreateOrder struct {
+				ID     string `json:"id"`
+				Status string `json:"status"`
+			} `json:"createOrder"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal([]byte(body), &created)
+	if o, ok := food.Default.Get(created.Data.CreateOrder.ID); status != 200 || created.Data.CreateOrder.Status != "CREATED" || !ok || o.Amount != 9.5 {
+		t.Fatalf("POST mutation: %d %s", status, body)
+	}
+
+	// refunds created on one service are readable from the others
+	status, _, body = do("POST", fmt.Sprintf("http://127.0.0.1:%d/graphql", base+2), "application/graphql", "", `mutation { createRefund(input: {orderId: "2002", amount: 19.9}) { refundId } }`)
+	var refund struct {
+		Data struct {
+			CreateRefund struct {
+				RefundID string `json:"refundId"`
+			} `json:"createRefund"`
+		} `json:"data"`
+	}
+	_ = json.Unmarshal([]byte(body), &refund)
+	id := refund.Data.CreateRefund.RefundID
+	if status != 200 || id == "" {
+		t.Fatalf("createRefund: %d %s", status, body)
+	}
+	if status, _, body = do("POST", endpoint, "application/graphql", "", `{ refund(refundId: "`+id+`") { orderId status } }`); status != 200 || body != `{"data":{"refund":{"orderId":"2002","status":"PENDING"}}}` {
+		t.Fatalf("refund: %d %s", status, body)
+	}
+
+	// request errors: 200 for application/json, 400 for graphql-response+json
+	invalid := `{"query":"{ nope }"}`
+	if status, _, body = do("POST", endpoint, "application/json", "", invalid); status != 200 || !strings.HasPrefix(body, `{"errors":[{"message":"Cannot query field \"nope\" on type \"Query\".`) {
+		t.Fatalf("legacy validation error: %d %s", status, body)
+	}
+	if status, ctype, _ = do("POST", endpoint, "application/json", "application/graphql-response+json", invalid); status != 400 || !strings.HasPrefix(ctype, "application/graphql-response+json") {
+		t.Fatalf("validation error: %d %s", status, ctype)
+	}
+	if status, _, body = do("POST", endpoint, "application/json", "application/graphql-response+json", `{"query":"subscription { orderStatusChanged { type } }"}`); status != 400 || !strings.Contains(body, "graphql-transport-ws") {
+		t.Fatalf("subscription over HTTP: %d %s", status, body)
+	}
+	if status, _, _ = do("POST", endpoint, "application/json", "", `{"query":`); status != 400 {
+		t.Fatalf("malformed body: %d", status)
+	}
+	if status, _, body = do("POST", endpoint, "application/graphql", "", strings.Repeat("{user(id:1)", 100000)); status != 200 || !strings.Contains(body, "maximum nesting depth") {
+		t.Fatalf("deep query: %d %s", status, body)
+	}
+	if status, _, _ = do("POST", endpoint, "application/graphql", "", strings.Repeat(" ", graphql.MaxRequestSize+1)); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized body: %d", status)
+	}
+	if status, _, _ = do("PUT", endpoint, "application/json", "", `{}`); status != http.StatusMethodNotAllowed {
+		t.Fatalf("PUT: %d", status)
+	}
+
+	if status, _, body = do("GET", fmt.Sprintf("http://127.0.0.1:%d/pay-api/graphql/schema", base+2), "", "", ""); status != 200 || !strings.Contains(body, "type Subscription {\n  orderStatusChanged(orderId: ID, userId: ID, merchantId: ID): OrderEvent!\n}") {
+		t.Fatalf("schema: %d %s", status, body)
+	}
+}
@@ -0,0 +1,152 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+)
+
+// ManifestRoute is one asset-driven endpoint declared in a routes manifest.
+//
+// Path uses ServeMux wildcards, e.g. "/users/{id}/profile". Bodies and header
+// values are rendered as templates (see common.TemplateContext), so path
+// parameters reach the response as {{path.name}}.
+type ManifestRoute struct {
+	Method    string            `json:"method,omitempty"`
+	Path      string            `json:"path"`
+	Status    int               `json:"status,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	BodyAsset string            `json:"bodyAsset,omitempty"`
+	Body      interface{}       `json:"body,omitempty"`
+	DelayMs   int               `json:"delayMs,omitempty"`
+}
+
+var pathParamRe = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(?:\.\.\.)?\}`)
+
+// manifestPath returns the asset-relative manifest for a service: the
+// topology override when set, otherwise routes/{service-name}.json.
+func manifestPath(spec ServiceSpec) string {
+	if spec.RouteManifest != "" {
+		return spec.RouteManifest
+	}
+	return "routes/" + spec.Name + ".json"
+}
+
+// LoadManifest reads a routes manifest (a JSON array of ManifestRoute).
+func LoadManifest(path string) ([]ManifestRoute, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var routes []ManifestRoute
+	if err := json.Unmarshal(b, &routes); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// mountManifest registers every manifest route of spec on mux. A missing
+// manifest is not an error; invalid entries are logged and skipped.
+func mountManifest(mux *http.ServeMux, spec ServiceSpec) {
+	path := common.JoinAssets(manifestPath(spec))
+	routes, err := LoadManifest(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			common.Logf("HTTP %s: routes manifest %s: %v", spec.Name, path, err)
+		}
+		return
+	}
+	for _, rt := range routes {
+		if !strings.HasPrefix(rt.Path, "/") {
+			common.Logf("HTTP %s: manifest route %q must start with /", spec.Name, rt.Path)
+			continue
+		}
+		paths := []string{rt.Path}
+		if spec.InterceptPrefix != "" {
+			paths = append(paths, spec.InterceptPrefix+rt.Path)
+		}
+		h := manifestHandler(rt)
+		for _, p := range paths {
+			pattern := p
+			if rt.Method != "" {
+				pattern = strings.ToUpper(rt.Method) + " " + p
+			}
+			if err := safeHandle(mux, pattern, h); err != nil {
+				common.Logf("HTTP %s: manifest route %q: %v", spec.Name, pattern, err)
+				continue
+			}
+			common.Logf("HTTP %s: manifest route %s", spec.Name, pattern)
+		}
+	}
+}
+
+// safeHandle registers a pattern, converting ServeMux conflict panics into errors.
+func safeHandle(mux *http.ServeMux, pattern string, h http.HandlerFunc) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("%v", rec)
+		}
+	}()
+	mux.HandleFunc(pattern, h)
+	return nil
+}
+
+func manifestHandler(rt ManifestRoute) http.HandlerFunc {
+	params := pathParamRe.FindAllStringSubmatch(rt.Path, -1)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rt.DelayMs > 0 {
+			time.Sleep(time.Duration(rt.DelayMs) * time.Millisecond)
+		}
+		var payload interface{}
+		if rt.BodyAsset != "" {
+			v, err := common.LoadJSONDynamic(common.JoinAssets(rt.BodyAsset))
+			if err != nil {
+				common.JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "asset unavailable", "asset": rt.BodyAsset})
+				return
+			}
+			payload = v
+		} else {
+			payload = rt.Body
+		}
+		values := map[string]string{}
+		for _, m := range params {
+			values[m[1]] = r.PathValue(m[1])
+		}
+		ctx := common.NewTemplateContext(r, values)
+		payload = common.RenderTemplate(payload, ctx)
+		status := rt.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		for k, v := range rt.Headers {
+			w.Header().Set(k, common.RenderTemplateString(v, ctx))
+		}
+		if s, ok := payload.(string); ok {
+			if w.Header().Get("Content-Type") == "" {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			}
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(s))
+			return
+		}
+		if payload == nil {
+			w.WriteHeader(status)
+			return
+		}
+		b, err := common.JsonMarshalCompat(payload)
+		if err != nil {
+			b = []byte("{}")
+		}
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write(b)
+	}
+}
@@ -0,0 +1,77 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/topology"
+)
+
+func TestManifestRoutesServeAssetsWithPathParams(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+
+	srvs := StartAll(base, topology.Default().HTTP)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	if err := waitHTTP(fmt.Sprintf("http://127.0.0.1:%d/health", base), 2*time.Second); err != nil {
+		t.Fatalf("user health: %v", err)
+	}
+
+	for _, path := range []string{"/users/77/profile", "/api/users/77/profile"} {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d%s", base, path))
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		body := decodeJSONBody(t, resp)
+		data := body["data"].(map[string]interface{})
+		if data["userId"] != "77" || data["nickname"] != "Z3" {
+			t.Fatalf("%s: unexpected data: %v", path, data)
+		}
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/order-api/orders/3009/tracking", base+1))
+	if err != nil {
+		t.Fatalf("GET tracking: %v", err)
+	}
+	if resp.Header.Get("X-Upstream-Source") != "manifest" {
+		t.Fatalf("missing manifest header")
+	}
+	tracking := decodeJSONBody(t, resp)["data"].(map[string]interface{})
+	if tracking["orderId"] != "3009" || tracking["requestId"] != "none" {
+		t.Fatalf("unexpected tracking data: %v", tracking)
+	}
+
+	resp, err = http.Post(fmt.Sprintf("http://127.0.0.1:%d/payments/P-9/cancel", base+2), "application/json", nil)
+	if err != nil {
+		t.Fatalf("POST cancel: %v", err)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected status=%d", resp.StatusCode)
+	}
+	body := decodeJSONBody(t, resp)
+	if body["data"].(map[string]interface{})["paymentId"] != "P-9" {
+		t.Fatalf("unexpected body: %v", body)
+	}
+
+	// method-scoped routes leave other methods to the regular mux
+	resp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/payments/P-9/cancel", base+2))
+	if err != nil {
+		t.Fatalf("GET cancel: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusAccepted {
+		t.Fatalf("GET should not match POST-only manifest route")
+	}
+}
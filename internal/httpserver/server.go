@@ -13,93 +13,160 @@ import (
 	"sync"
 	"time"
 
+	"intercept-wave-upstream/internal/admin"
 	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/food"
+	"intercept-wave-upstream/internal/tlsutil"
+	"intercept-wave-upstream/internal/topology"
 )
 
 type ServiceSpec struct {
 	Name            string
 	Port            int
 	InterceptPrefix string
-	Routes          func(mux *http.ServeMux, spec ServiceSpec)
+	Bundles         []string
+	RouteManifest   string
+	CaptureLimit    int
+	TLS             *topology.ServiceTLS
+	TLSPort         int
 }
 
-func StartAll(base int) []*http.Server {
-	services := []ServiceSpec{
-		{Name: "user-service", Port: base + 0, InterceptPrefix: "/api", Routes: userRoutes},
-		{Name: "order-service", Port: base + 1, InterceptPrefix: "/order-api", Routes: orderRoutes},
-		{Name: "payment-service", Port: base + 2, InterceptPrefix: "/pay-api", Routes: paymentRoutes},
+// routeBundles maps topology bundle names to the routes they mount.
+var routeBundles = map[string]func(mux *http.ServeMux, spec ServiceSpec){
+	"user":    userRoutes,
+	"order":   orderRoutes,
+	"payment": paymentRoutes,
+	"graphql": graphqlRoutes,
+}
+
+// SpecsFromTopology resolves topology entries into service specs for base.
+func SpecsFromTopology(services []topology.HTTPService, base int) []ServiceSpec {
+	specs := make([]ServiceSpec, 0, len(services))
+	for _, s := range services {
+		specs = append(specs, ServiceSpec{
+			Name:            s.Name,
+			Port:            s.ResolvePort(base),
+			InterceptPrefix: s.InterceptPrefix,
+			Bundles:         s.Bundles,
+			RouteManifest:   s.RouteManifest,
+			CaptureLimit:    s.CaptureLimit,
+			TLS:             s.TLS,
+		})
+		if s.TLS != nil {
+			specs[len(specs)-1].TLSPort = s.TLS.ResolvePort(base)
+		}
 	}
+	return specs
+}
+
+func StartAll(base int, topo []topology.HTTPService) []*http.Server {
+	services := SpecsFromTopology(topo, base)
 
-	var wg sync.WaitGroup
 	servers := make([]*http.Server, 0, len(services))
 	for _, s := range services {
+		svc := admin.Register(s.Name, "http")
 		mux := http.NewServeMux()
 		attachCommon(mux, s)
-		s.Routes(mux, s)
-		server := &http.Server{Addr: fmt.Sprintf(":%d", s.Port), Handler: common.RequestLogger(mux)}
-		servers = append(servers, server)
-		wg.Add(1)
-		go func(sp ServiceSpec, srv *http.Server) {
-			defer wg.Done()
-			common.Logf("HTTP %s listening on :%d", sp.Name, sp.Port)
-			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-				common.Logf("HTTP server %s error: %v", sp.Name, err)
+		for _, b := range s.Bundles {
+			routes, ok := routeBundles[b]
+			if !ok {
+				common.Logf("HTTP %s: unknown route bundle %q (skipped)", s.Name, b)
+				continue
 			}
-		}(s, server)
+			routes(mux, s)
+		}
+		mountManifest(mux, s)
+		captured := newCaptureStore(s.CaptureLimit)
+		attachCapture(mux, captured)
+		faults := &faultState{}
+		attachFaults(mux, faults)
+		admin.RegisterReset(s.Name, "requests", func() { captured.clear() })
+		admin.RegisterReset(s.Name, "faults", func() { faults.set(nil) })
+		handler := common.RequestLogger(captureRequests(captured, admin.Gate(svc, injectFaults(faults, mux))))
+		server := &http.Server{Addr: fmt.Sprintf(":%d", s.Port), Handler: handler}
+		servers = append(servers, server)
+		if err := svc.Serve(server, s.Port, false); err != nil {
+			common.Logf("HTTP server %s error: %v", s.Name, err)
+		} else {
+			common.Logf("HTTP %s listening on :%d", s.Name, s.Port)
+		}
+		if s.TLS == nil || !s.TLS.Enabled {
+			continue
+		}
+		tlsServer, err := tlsutil.NewServer(s.Name, s.TLSPort, handler, *s.TLS)
+		if err != nil {
+			common.Logf("HTTPS %s disabled: %v", s.Name, err)
+			continue
+		}
+		servers = append(servers, tlsServer)
+		if err := svc.Serve(tlsServer, s.TLSPort, true); err != nil {
+			common.Logf("HTTPS server %s error: %v", s.Name, err)
+			continue
+		}
+		common.Logf("HTTPS %s listening on :%d (clientAuth=%s)", s.Name, s.TLSPort, s.TLS.ClientAuth)
 	}
-	// give servers time to bind
-	time.Sleep(100 * time.Millisecond)
 	return servers
 }
 
 func attachCommon(mux *http.ServeMux, spec ServiceSpec) {
 	// Simple in-memory store for REST demo endpoints (per-service instance)
 	type anyMap = map[string]interface{}
-	items := map[int]anyMap{}
-	nextID := 1
-	var mu sync.Mutex
+	var (
+		items  map[int]anyMap
+		nextID int
+		mu     sync.Mutex
+	)
 
-	// Seed REST items from assets if present
-	if arr, err := common.LoadJSONDynamic(common.JoinAssets("rest", "items.json")); err == nil {
-		if list, ok := arr.([]interface{}); ok {
-			mu.Lock()
-			for _, it := range list {
-				if m, ok := it.(map[string]interface{}); ok {
-					// coerce id
-					id := 0
-					if v, ok := m["id"]; ok {
-						switch t := v.(type) {
-						case float64:
-							id = int(t)
-						case int:
-							id = t
-						case int64:
-							id = int(t)
-						case json.Number:
-							if n, _ := t.Int64(); n > 0 {
-								id = int(n)
+	// Seed REST items from assets if present; the admin API can re-seed
+	// the store through the "rest-items" reset target. Callers hold mu.
+	seedItems := func() {
+		items = map[int]anyMap{}
+		nextID = 1
+		if arr, err := common.LoadJSONDynamic(common.JoinAssets("rest", "items.json")); err == nil {
+			if list, ok := arr.([]interface{}); ok {
+				for _, it := range list {
+					if m, ok := it.(map[string]interface{}); ok {
+						// coerce id
+						id := 0
+						if v, ok := m["id"]; ok {
+							switch t := v.(type) {
+							case float64:
+								id = int(t)
+							case int:
+								id = t
+							case int64:
+								id = int(t)
+							case json.Number:
+								if n, _ := t.Int64(); n > 0 {
+									id = int(n)
+								}
 							}
 						}
+						if id <= 0 {
+							id = nextID
+							nextID++
+							m["id"] = id
+						}
+						if id >= nextID {
+							nextID = id + 1
+						}
+						// shallow copy
+						cp := anyMap{}
+						for k, v := range m {
+							cp[k] = v
+						}
+						items[id] = cp
 					}
-					if id <= 0 {
-						id = nextID
-						nextID++
-						m["id"] = id
-					}
-					if id >= nextID {
-						nextID = id + 1
-					}
-					// shallow copy
-					cp := anyMap{}
-					for k, v := range m {
-						cp[k] = v
-					}
-					items[id] = cp
 				}
 			}
-			mu.Unlock()
 		}
 	}
+	seedItems()
+	admin.RegisterReset(spec.Name, "rest-items", func() {
+		mu.Lock()
+		seedItems()
+		mu.Unlock()
+	})
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		common.JSON(w, 200, map[string]interface{}{
@@ -134,16 +201,24 @@ func attachCommon(mux *http.ServeMux, spec ServiceSpec) {
 	})
 
 	mux.HandleFunc("/headers", func(w http.ResponseWriter, r *http.Request) {
-		keys := []string{"Authorization", "Content-Type", "User-Agent", "X-Request-Id"}
-		m := map[string]string{}
-		for _, k := range keys {
-			if v := r.Header.Get(k); v != "" {
-				m[k] = v
-			}
-		}
-		common.JSON(w, 200, map[string]interface{}{"headers": m})
+		common.JSON(w, 200, map[string]interface{}{
+			"headers":    flattenHeader(r.Header),
+			"rawHeaders": cloneHeader(r.Header),
+			"host":       r.Host,
+			"proto":      r.Proto,
+		})
 	})
 
+	// httpbin-style reflection of the complete request, any method and sub-path
+	anything := func(w http.ResponseWriter, r *http.Request) {
+		common.JSON(w, 200, reflectRequest(r))
+	}
+	mux.HandleFunc("/anything", anything)
+	mux.HandleFunc("/anything/", anything)
+
+	attachSSE(mux)
+	attachStreaming(mux)
+
 	mux.HandleFunc("/cookies", func(w http.ResponseWriter, r *http.Request) {
 		cs := map[string]string{}
 		for _, c := range r.Cookies() {
@@ -171,12 +246,16 @@ func attachCommon(mux *http.ServeMux, spec ServiceSpec) {
 	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
 		b, _ := io.ReadAll(r.Body)
 		_ = r.Body.Close()
+		body, encoding := encodeBody(b)
 		common.JSON(w, 200, map[string]interface{}{
-			"method": r.Method,
-			"path":   r.URL.Path,
-			"query":  r.URL.RawQuery,
-			"length": len(b),
-			"body":   string(b),
+			"method":        r.Method,
+			"path":          r.URL.Path,
+			"query":         r.URL.RawQuery,
+			"length":        len(b),
+			"contentLength": r.ContentLength,
+			"body":          body,
+			"bodyEncoding":  encoding,
+			"headers":       cloneHeader(r.Header),
 		})
 	})
 
@@ -372,35 +451,17 @@ func userRoutes(mux *http.ServeMux, spec ServiceSpec) {
 		payload := assetPayloadOrFallback([]string{"user", "preferences.json"}, map[string]interface{}{
 			"code": 0,
 			"data": map[string]interface{}{
+				"userId":   "{{path.id}}",
 				"theme":    "light",
 				"language": "zh-CN",
-				"notifications": map[string]bool{
+				"notifications": map[string]interface{}{
 					"email": true,
 					"sms":   false,
 				},
 			},
 		})
-		body, ok := payload.(map[string]interface{})
-		if !ok {
-			common.JSON(w, 200, payload)
-			return
-		}
-		data, ok := body["data"].(map[string]interface{})
-		if !ok {
-			common.JSON(w, 200, payload)
-			return
-		}
-		cp := map[string]interface{}{}
-		for k, v := range body {
-			cp[k] = v
-		}
-		dataCopy := map[string]interface{}{}
-		for k, v := range data {
-			dataCopy[k] = v
-		}
-		dataCopy["userId"] = userID
-		cp["data"] = dataCopy
-		common.JSON(w, 200, cp)
+		ctx := common.NewTemplateContext(r, map[string]string{"id": userID})
+		common.JSON(w, 200, common.RenderTemplate(payload, ctx))
 	})
 }
 
@@ -412,7 +473,14 @@ func orderRoutes(mux *http.ServeMux, spec ServiceSpec) {
 			_ = r.Body.Close()
 			var in map[string]interface{}
 			_ = json.Unmarshal(b, &in)
-			in["id"] = rand.Intn(100000)
+			if in == nil {
+				in = map[string]interface{}{}
+			}
+			// orders live in the shared food store so WS food sockets see them
+			o := food.Default.Create(in, spec.Name)
+			id, _ := strconv.Atoi(o.ID)
+			in["id"] = id
+			in["status"] = o.Status
 			common.JSON(w, 201, map[string]interface{}{"code": 0, "data": in})
 			return
 		}
@@ -431,50 +499,24 @@ func orderRoutes(mux *http.ServeMux, spec ServiceSpec) {
 			http.NotFound(w, r)
 			return
 		}
+		if o, ok := food.Default.Get(orderID); ok {
+			common.JSON(w, 200, map[string]interface{}{"code": 0, "data": o})
+			return
+		}
 		payload := assetPayloadOrFallback([]string{"order", "detail.json"}, map[string]interface{}{
 			"code": 0,
 			"data": map[string]interface{}{
-				"id":       orderID,
+				"id":       "{{path.id}}",
 				"status":   "PROCESSING",
 				"currency": "CNY",
 				"amount":   88.5,
-				"items": []map[string]interface{}{
-					{"sku": "SKU-" + orderID, "qty": 1, "price": 88.5},
+				"items": []interface{}{
+					map[string]interface{}{"sku": "SKU-{{path.id}}", "qty": 1, "price": 88.5},
 				},
 			},
 		})
-		body, ok := payload.(map[string]interface{})
-		if !ok {
-			common.JSON(w, 200, payload)
-			return
-		}
-		data, ok := body["data"].(map[string]interface{})
-		if !ok {
-			common.JSON(w, 200, payload)
-			return
-		}
-		cp := map[string]interface{}{}
-		for k, v := range body {
-			cp[k] = v
-		}
-		dataCopy := map[string]interface{}{}
-		for k, v := range data {
-			dataCopy[k] = v
-		}
-		dataCopy["id"] = orderID
-		if items, ok := dataCopy["items"].([]interface{}); ok && len(items) > 0 {
-			if first, ok := items[0].(map[string]interface{}); ok {
-				firstCopy := map[string]interface{}{}
-				for k, v := range first {
-					firstCopy[k] = v
-				}
-				firstCopy["sku"] = "SKU-" + orderID
-				items[0] = firstCopy
-				dataCopy["items"] = items
-			}
-		}
-		cp["data"] = dataCopy
-		common.JSON(w, 200, cp)
+		ctx := common.NewTemplateContext(r, map[string]string{"id": orderID})
+		common.JSON(w, 200, common.RenderTemplate(payload, ctx))
 	})
 	registerPaths(mux, []string{p + "/admin/orders/summary", "/admin/orders/summary"}, func(w http.ResponseWriter, r *http.Request) {
 		common.JSON(w, 200, assetPayloadOrFallback([]string{"order", "admin_summary.json"}, map[string]interface{}{
@@ -488,12 +530,40 @@ func orderRoutes(mux *http.ServeMux, spec ServiceSpec) {
 	})
 	// emulate wildcard: /order/{id}/submit
 	registerPaths(mux, []string{p + "/order/", "/order/"}, func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasSuffix(r.URL.Path, "/submit") {
-			common.JSON(w, 200, assetPayloadOrFallback([]string{"order", "submit.json"}, map[string]interface{}{"message": "submit ok"}))
+		if !strings.HasSuffix(r.URL.Path, "/submit") {
+			http.NotFound(w, r)
 			return
 		}
-		http.NotFound(w, r)
+		// orders created through POST /orders move to SUBMITTED; other ids
+		// answer as if they had been submitted
+		rest := strings.TrimSuffix(r.URL.Path, "/submit")
+		orderID := rest[strings.LastIndex(rest, "/")+1:]
+		status := food.StatusSubmitted
+		if _, ok := food.Default.Get(orderID); ok {
+			ev, err := food.Default.Apply(orderID, food.ActionSubmit, spec.Name, "")
+			if err != nil {
+				frame := map[string]interface{}{"error": err.Error(), "orderId": orderID}
+				var te *food.TransitionError
+				if errors.As(err, &te) {
+					frame["status"] = te.Status
+				}
+				common.JSON(w, http.StatusConflict, frame)
+				return
+			}
+			status = ev.Status
+		}
+		payload := assetPayloadOrFallback([]string{"order", "submit.json"}, map[string]interface{}{
+			"code":    0,
+			"message": "submit ok",
+			"data": map[string]interface{}{
+				"orderId": "{{path.id}}",
+				"status":  "{{path.status}}",
+			},
+		})
+		ctx := common.NewTemplateContext(r, map[string]string{"id": orderID, "status": status})
+		common.JSON(w, 200, common.RenderTemplate(payload, ctx))
 	})
+	admin.RegisterReset(spec.Name, "orders", food.Default.Reset)
 }
 
 func paymentRoutes(mux *http.ServeMux, spec ServiceSpec) {
@@ -542,38 +612,17 @@ func paymentRoutes(mux *http.ServeMux, spec ServiceSpec) {
 		}))
 	})
 	registerPaths(mux, []string{p + "/callbacks/alipay", "/callbacks/alipay"}, func(w http.ResponseWriter, r *http.Request) {
-		b, _ := io.ReadAll(r.Body)
-		_ = r.Body.Close()
 		payload := assetPayloadOrFallback([]string{"payment", "callback_alipay.json"}, map[string]interface{}{
 			"code": 0,
 			"data": map[string]interface{}{
 				"provider":     "alipay",
-				"callbackBody": string(b),
+				"callbackBody": "{{body}}",
 				"verified":     true,
 			},
 			"message": "callback received",
 		})
-		body, ok := payload.(map[string]interface{})
-		if !ok {
-			common.JSON(w, 200, payload)
-			return
-		}
-		data, ok := body["data"].(map[string]interface{})
-		if !ok {
-			common.JSON(w, 200, payload)
-			return
-		}
-		cp := map[string]interface{}{}
-		for k, v := range body {
-			cp[k] = v
-		}
-		dataCopy := map[string]interface{}{}
-		for k, v := range data {
-			dataCopy[k] = v
-		}
-		dataCopy["callbackBody"] = string(b)
-		cp["data"] = dataCopy
-		common.JSON(w, 200, cp)
+		ctx := common.NewTemplateContext(r, nil)
+		common.JSON(w, 200, common.RenderTemplate(payload, ctx))
 	})
 }
 
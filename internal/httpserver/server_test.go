@@ -6,35 +6,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"testing"
 	"time"
+
+	"intercept-wave-upstream/internal/testutil"
+	"intercept-wave-upstream/internal/topology"
 )
 
-// find a contiguous base port for 6 ports (HTTP: +0..+2, WS would be +3..+5)
-func findFreeBase() (int, error) {
-	// scan a wide high-port range for 6 contiguous ports
-	for base := 20000; base < 60000; base += 11 {
-		ok := true
-		var conns []net.Listener
-		for p := base; p <= base+5; p++ {
-			ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", p))
-			if err != nil {
-				ok = false
-				break
-			}
-			conns = append(conns, ln)
-		}
-		for _, c := range conns {
-			_ = c.Close()
-		}
-		if ok {
-			return base, nil
-		}
-	}
-	return 0, fmt.Errorf("no free base port range found")
-}
+// findFreeBase reserves the default topology's 6 ports (HTTP: +0..+2, WS would be +3..+5).
+func findFreeBase() (int, error) { return testutil.FreeBase(6) }
 
 func waitHTTP(url string, timeout time.Duration) error {
 	deadline := time.Now().Add(timeout)
@@ -65,7 +46,7 @@ func TestHTTPServersStartAndRespond(t *testing.T) {
 		t.Fatalf("findFreeBase: %v", err)
 	}
 
-	srvs := StartAll(base)
+	srvs := StartAll(base, topology.Default().HTTP)
 	t.Cleanup(func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
@@ -100,7 +81,7 @@ func TestHTTPServersExposeRouteFriendlyAliases(t *testing.T) {
 		t.Fatalf("findFreeBase: %v", err)
 	}
 
-	srvs := StartAll(base)
+	srvs := StartAll(base, topology.Default().HTTP)
 	t.Cleanup(func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
@@ -171,8 +152,9 @@ func TestHTTPServersExposeRouteFriendlyAliases(t *testing.T) {
 			t.Fatalf("GET /order/3009/submit: %v", err)
 		}
 		body = decodeJSONBody(t, resp)
-		if body["message"] != "submit ok" {
-			t.Fatalf("unexpected message: %v", body["message"])
+		submitted, _ := body["data"].(map[string]interface{})
+		if body["message"] != "submit ok" || submitted["orderId"] != "3009" || submitted["status"] != "SUBMITTED" {
+			t.Fatalf("unexpected submit reply: %v", body)
 		}
 	})
 
@@ -219,7 +201,7 @@ func TestPaymentRefundCreateReturnsCreated(t *testing.T) {
 		t.Fatalf("findFreeBase: %v", err)
 	}
 
-	srvs := StartAll(base)
+	srvs := StartAll(base, topology.Default().HTTP)
 	t.Cleanup(func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
@@ -246,3 +228,52 @@ func TestPaymentRefundCreateReturnsCreated(t *testing.T) {
 		t.Fatalf("unexpected status=%d", resp.StatusCode)
 	}
 }
+
+func TestOrdersDriveFoodStore(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().HTTP)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+	root := fmt.Sprintf("http://127.0.0.1:%d", base+1)
+	if err := waitHTTP(root+"/health", 2*time.Second); err != nil {
+		t.Fatalf("order health: %v", err)
+	}
+
+	resp, err := http.Post(root+"/orders", "application/json", bytes.NewBufferString(`{"userId":"u-9","items":[{"name":"noodles","qty":1}]}`))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	data, _ := decodeJSONBody(t, resp)["data"].(map[string]interface{})
+	if resp.StatusCode != 201 || data["status"] != "CREATED" {
+		t.Fatalf("create status=%d data=%v", resp.StatusCode, data)
+	}
+	id := fmt.Sprint(data["id"])
+
+	if resp, err = http.Get(root + "/order/" + id + "/submit"); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if data, _ = decodeJSONBody(t, resp)["data"].(map[string]interface{}); data["status"] != "SUBMITTED" {
+		t.Fatalf("submit data=%v", data)
+	}
+	if resp, err = http.Get(root + "/order-api/order/" + id + "/submit"); err != nil {
+		t.Fatalf("resubmit: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("resubmit status=%d", resp.StatusCode)
+	}
+	if resp, err = http.Get(root + "/orders/" + id); err != nil {
+		t.Fatalf("detail: %v", err)
+	}
+	if data, _ = decodeJSONBody(t, resp)["data"].(map[string]interface{}); data["status"] != "SUBMITTED" || data["userId"] != "u-9" {
+		t.Fatalf("detail data=%v", data)
+	}
+}
@@ -0,0 +1,204 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+)
+
+// sseEvent is one Server-Sent Event; Data may be any JSON value (non-strings
+// are serialized) and multi-line strings are split into several data: lines.
+type sseEvent struct {
+	ID    string      `json:"id,omitempty"`
+	Event string      `json:"event,omitempty"`
+	Data  interface{} `json:"data"`
+	Retry int         `json:"retry,omitempty"`
+}
+
+// sseOptions are read from the query string:
+// interval (ms), count (0 = unlimited for generated streams), retry (ms),
+// event (generated event name) and dropAfter (abort the connection after N events).
+type sseOptions struct {
+	interval  time.Duration
+	count     int
+	retry     int
+	event     string
+	dropAfter int
+}
+
+func parseSSEOptions(r *http.Request, defCount int) sseOptions {
+	q := r.URL.Query()
+	atoi := func(k string, def int) int {
+		if n, err := strconv.Atoi(q.Get(k)); err == nil && n >= 0 {
+			return n
+		}
+		return def
+	}
+	o := sseOptions{
+		interval:  time.Duration(atoi("interval", 1000)) * time.Millisecond,
+		count:     atoi("count", defCount),
+		retry:     atoi("retry", 0),
+		event:     q.Get("event"),
+		dropAfter: atoi("dropAfter", 0),
+	}
+	if o.event == "" {
+		o.event = "tick"
+	}
+	return o
+}
+
+// lastEventID honours the Last-Event-ID header (set by EventSource on
+// reconnect) and the lastEventId query parameter.
+func lastEventID(r *http.Request) string {
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		return v
+	}
+	return r.URL.Query().Get("lastEventId")
+}
+
+// attachSSE mounts the SSE endpoints:
+//   - GET /sse — generated ticker events with numeric ids
+//   - GET /sse/{name} — events replayed from assets/sse/{name}.json
+func attachSSE(mux *http.ServeMux) {
+	mux.HandleFunc("GET /sse", func(w http.ResponseWriter, r *http.Request) {
+		opts := parseSSEOptions(r, 10)
+		start := 1
+		if n, err := strconv.Atoi(lastEventID(r)); err == nil && n >= 0 {
+			start = n + 1
+		}
+		i := start
+		more := func() bool { return opts.count == 0 || i < start+opts.count }
+		next := func() sseEvent {
+			ev := sseEvent{
+				ID:    strconv.Itoa(i),
+				Event: opts.event,
+				Data:  map[string]interface{}{"seq": i, "time": time.Now().Format(time.RFC3339Nano)},
+			}
+			i++
+			return ev
+		}
+		streamSSE(w, r, opts, more, next)
+	})
+
+	mux.HandleFunc("GET /sse/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		v, err := common.LoadJSONDynamic(common.JoinAssets("sse", name+".json"))
+		if err != nil {
+			common.JSON(w, http.StatusNotFound, map[string]interface{}{"error": "unknown stream", "stream": name})
+			return
+		}
+		list, _ := v.([]interface{})
+		ctx := common.NewTemplateContext(r, map[string]string{"name": name})
+		events := make([]sseEvent, 0, len(list))
+		for idx, it := range list {
+			m, ok := it.(map[string]interface{})
+			if !ok {
+				m = map[string]interface{}{"data": it}
+			}
+			ev := sseEvent{Data: m["data"]}
+			if id, ok := m["id"]; ok {
+				ev.ID = fmt.Sprint(id)
+			} else {
+				ev.ID = strconv.Itoa(idx + 1)
+			}
+			ev.Event, _ = m["event"].(string)
+			if rt, ok := m["retry"].(float64); ok {
+				ev.Retry = int(rt)
+			}
+			events = append(events, ev)
+		}
+		// resume after the event the client saw last
+		if last := lastEventID(r); last != "" {
+			for idx, ev := range events {
+				if ev.ID == last {
+					events = events[idx+1:]
+					break
+				}
+			}
+		}
+		opts := parseSSEOptions(r, len(events))
+		sent := 0
+		more := func() bool { return sent < len(events) && (opts.count == 0 || sent < opts.count) }
+		next := func() sseEvent {
+			ev := events[sent]
+			ev.Data = common.RenderTemplate(ev.Data, ctx)
+			sent++
+			return ev
+		}
+		streamSSE(w, r, opts, more, next)
+	})
+}
+
+// streamSSE writes events from next while more reports there are some left,
+// until the client goes away or dropAfter triggers an abrupt disconnect. The
+// stream ends right after the last event rather than one interval later.
+func streamSSE(w http.ResponseWriter, r *http.Request, opts sseOptions, more func() bool, next func() sseEvent) {
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream; charset=utf-8")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if opts.retry > 0 {
+		_, _ = fmt.Fprintf(w, "retry: %d\n\n", opts.retry)
+	}
+	// an initial comment lets clients and proxies see the stream open immediately
+	_, _ = fmt.Fprint(w, ": stream open\n\n")
+	flush()
+
+	sent := 0
+	for more() {
+		if _, err := fmt.Fprint(w, formatSSE(next())); err != nil {
+			return
+		}
+		flush()
+		sent++
+		if opts.dropAfter > 0 && sent >= opts.dropAfter {
+			common.Logf("SSE %s dropping connection after %d events", r.URL.Path, sent)
+			panic(http.ErrAbortHandler)
+		}
+		if !more() {
+			return
+		}
+		select {
+		case <-time.After(opts.interval):
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func formatSSE(ev sseEvent) string {
+	var b strings.Builder
+	if ev.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", ev.ID)
+	}
+	if ev.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", ev.Event)
+	}
+	if ev.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", ev.Retry)
+	}
+	data, ok := ev.Data.(string)
+	if !ok {
+		enc, err := common.JsonMarshalCompat(ev.Data)
+		if err != nil {
+			enc = []byte("null")
+		}
+		data = string(enc)
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
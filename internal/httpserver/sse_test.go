@@ -0,0 +1,96 @@
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/topology"
+)
+
+// readSSEIDs collects the id: fields of a finished event stream.
+func readSSEIDs(t *testing.T, resp *http.Response) ([]string, error) {
+	t.Helper()
+	defer func() { _ = resp.Body.Close() }()
+	var ids []string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		if id, ok := strings.CutPrefix(sc.Text(), "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, sc.Err()
+}
+
+func TestSSEStreamsAndResumes(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+
+	srvs := StartAll(base, topology.Default().HTTP)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	root := fmt.Sprintf("http://127.0.0.1:%d", base)
+	if err := waitHTTP(root+"/health", 2*time.Second); err != nil {
+		t.Fatalf("user health: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, root+"/sse?interval=5&count=3", nil)
+	req.Header.Set("Last-Event-ID", "5")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /sse: %v", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	ids, err := readSSEIDs(t, resp)
+	if err != nil || strings.Join(ids, ",") != "6,7,8" {
+		t.Fatalf("unexpected ids %v (err=%v)", ids, err)
+	}
+
+	resp, err = http.Get(root + "/sse/orders?interval=5&lastEventId=2")
+	if err != nil {
+		t.Fatalf("GET /sse/orders: %v", err)
+	}
+	ids, err = readSSEIDs(t, resp)
+	if err != nil || strings.Join(ids, ",") != "3,4,5" {
+		t.Fatalf("unexpected asset ids %v (err=%v)", ids, err)
+	}
+
+	resp, err = http.Get(root + "/sse?interval=5&count=10&dropAfter=2")
+	if err != nil {
+		t.Fatalf("GET /sse dropAfter: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Fatalf("expected abrupt end of stream")
+	}
+
+	// the stream closes right after the last event, not one interval later
+	for _, path := range []string{"/sse?interval=2000&count=2", "/sse/orders?interval=2000&lastEventId=4"} {
+		start := time.Now()
+		resp, err = http.Get(root + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		if _, err := readSSEIDs(t, resp); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if d := time.Since(start); d >= 3500*time.Millisecond {
+			t.Fatalf("%s took %v", path, d)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package httpserver
+
+import (
+	"hash/crc32"
+	"net/http"
+	"strconv"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+)
+
+const (
+	maxChunkSize   = 16 * 1024 * 1024
+	maxStreamTotal = 64 * 1024 * 1024 * 1024
+)
+
+// attachStreaming mounts the streaming endpoints:
+//   - GET /stream/chunked — chunked transfer-encoding with controllable shape
+//   - GET /stream/ndjson — newline-delimited JSON records
+func attachStreaming(mux *http.ServeMux) {
+	// /stream/chunked?chunkSize=1024&count=10&delay=0&total=&contentLength=false
+	// total (bytes) wins over count; one chunk buffer is reused so multi-GB
+	// streams do not allocate. Trailers report bytes sent and a CRC32.
+	mux.HandleFunc("GET /stream/chunked", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		chunkSize := int64(queryInt(q.Get("chunkSize"), 1024))
+		chunkSize = min(max(chunkSize, 1), maxChunkSize)
+		count := int64(queryInt(q.Get("count"), 10))
+		total := chunkSize * max(count, 0)
+		if t, err := strconv.ParseInt(q.Get("total"), 10, 64); err == nil && t >= 0 {
+			total = t
+		}
+		total = min(total, maxStreamTotal)
+		delay := time.Duration(queryInt(q.Get("delay"), 0)) * time.Millisecond
+
+		buf := make([]byte, min(chunkSize, max(total, 1)))
+		for i := range buf {
+			buf[i] = byte('a' + (i % 26))
+		}
+		h := w.Header()
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("X-Stream-Total", strconv.FormatInt(total, 10))
+		h.Set("X-Stream-Chunk-Size", strconv.FormatInt(chunkSize, 10))
+		if q.Get("contentLength") == "true" {
+			h.Set("Content-Length", strconv.FormatInt(total, 10))
+		} else {
+			h.Set("Trailer", "X-Stream-Bytes, X-Stream-Crc32")
+		}
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+
+		var sent int64
+		var crc uint32
+		for sent < total {
+			n := min(int64(len(buf)), total-sent)
+			if _, err := w.Write(buf[:n]); err != nil {
+				common.Logf("stream %s aborted after %d bytes: %v", r.URL.Path, sent, err)
+				return
+			}
+			crc = crc32.Update(crc, crc32.IEEETable, buf[:n])
+			sent += n
+			if flusher != nil {
+				flusher.Flush()
+			}
+			if delay > 0 && sent < total {
+				select {
+				case <-time.After(delay):
+				case <-r.Context().Done():
+					return
+				}
+			}
+		}
+		h.Set("X-Stream-Bytes", strconv.FormatInt(sent, 10))
+		h.Set("X-Stream-Crc32", strconv.FormatUint(uint64(crc), 16))
+	})
+
+	// /stream/ndjson?count=10&interval=100&pad=0 — count=0 streams until the
+	// client disconnects; pad adds filler bytes to every record.
+	mux.HandleFunc("GET /stream/ndjson", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		count := queryInt(q.Get("count"), 10)
+		interval := time.Duration(queryInt(q.Get("interval"), 100)) * time.Millisecond
+		pad := min(queryInt(q.Get("pad"), 0), maxChunkSize)
+		filler := ""
+		if pad > 0 {
+			b := make([]byte, pad)
+			for i := range b {
+				b[i] = byte('a' + (i % 26))
+			}
+			filler = string(b)
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+		for i := 1; count <= 0 || i <= count; i++ {
+			rec := map[string]interface{}{"seq": i, "time": time.Now().Format(time.RFC3339Nano)}
+			if filler != "" {
+				rec["pad"] = filler
+			}
+			b, _ := common.JsonMarshalCompat(rec)
+			if _, err := w.Write(append(b, '\n')); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			if i == count {
+				break
+			}
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+// queryInt parses a non-negative integer query value, falling back to def.
+func queryInt(s string, def int) int {
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return n
+	}
+	return def
+}
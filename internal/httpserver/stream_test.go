@@ -0,0 +1,69 @@
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/topology"
+)
+
+func TestStreamingEndpoints(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+
+	srvs := StartAll(base, topology.Default().HTTP)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	root := fmt.Sprintf("http://127.0.0.1:%d", base+1)
+	if err := waitHTTP(root+"/health", 2*time.Second); err != nil {
+		t.Fatalf("order health: %v", err)
+	}
+
+	resp, err := http.Get(root + "/stream/chunked?chunkSize=1000&total=10500")
+	if err != nil {
+		t.Fatalf("GET chunked: %v", err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil || len(b) != 10500 {
+		t.Fatalf("read %d bytes (err=%v)", len(b), err)
+	}
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("expected chunked encoding, got %v", resp.TransferEncoding)
+	}
+	if resp.Trailer.Get("X-Stream-Bytes") != "10500" {
+		t.Fatalf("unexpected bytes trailer: %v", resp.Trailer)
+	}
+	if want := strconv.FormatUint(uint64(crc32.ChecksumIEEE(b)), 16); resp.Trailer.Get("X-Stream-Crc32") != want {
+		t.Fatalf("crc trailer %q != %q", resp.Trailer.Get("X-Stream-Crc32"), want)
+	}
+
+	resp, err = http.Get(root + "/stream/ndjson?count=3&interval=1")
+	if err != nil {
+		t.Fatalf("GET ndjson: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	lines := 0
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		lines++
+	}
+	if lines != 3 {
+		t.Fatalf("expected 3 records, got %d", lines)
+	}
+}
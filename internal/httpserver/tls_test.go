@@ -0,0 +1,104 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/tlsutil"
+	"intercept-wave-upstream/internal/topology"
+)
+
+func TestHTTPSListeners(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	// user-service on +3 without client auth, order-service on +4 requiring
+	// a client certificate; +0..+2 stay plain HTTP.
+	services := topology.Default().HTTP
+	services[0].TLS = &topology.ServiceTLS{Enabled: true, Port: base + 3}
+	services[1].TLS = &topology.ServiceTLS{Enabled: true, Port: base + 4, ClientAuth: "require"}
+	srvs := StartAll(base, services)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	a, err := tlsutil.Default()
+	if err != nil {
+		t.Fatalf("tlsutil: %v", err)
+	}
+	certPEM, keyPEM, err := a.IssueClient("tls-test-client")
+	if err != nil {
+		t.Fatalf("issue client: %v", err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("client pair: %v", err)
+	}
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		tr := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: a.Pool(), Certificates: certs}}
+		t.Cleanup(tr.CloseIdleConnections)
+		return &http.Client{Transport: tr, Timeout: 5 * time.Second}
+	}
+	plain, noCert, withCert := newClient(), newClient(), newClient(pair)
+
+	get := func(c *http.Client, url string) (int, string) {
+		t.Helper()
+		resp, err := c.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	if err := waitHTTP(fmt.Sprintf("http://127.0.0.1:%d/health", base+1), 2*time.Second); err != nil {
+		t.Fatalf("order health: %v", err)
+	}
+
+	t.Run("same routes over https", func(t *testing.T) {
+		for _, path := range []string{"/health", "/user/info", "/users/42/preferences"} {
+			wantStatus, wantBody := get(plain, fmt.Sprintf("http://127.0.0.1:%d%s", base, path))
+			status, body := get(noCert, fmt.Sprintf("https://127.0.0.1:%d%s", base+3, path))
+			if status != wantStatus || body != wantBody {
+				t.Fatalf("%s over https: %d %s, want %d %s", path, status, body, wantStatus, wantBody)
+			}
+		}
+	})
+
+	t.Run("reset fault sends a TCP reset", func(t *testing.T) {
+		conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", base+3), &tls.Config{RootCAs: a.Pool()})
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, _ = io.WriteString(conn, "GET /large?size=1000&__fault=reset;after=10 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		if _, err := io.ReadAll(conn); !errors.Is(err, syscall.ECONNRESET) {
+			t.Fatalf("read err=%v, want connection reset", err)
+		}
+	})
+
+	t.Run("client auth require", func(t *testing.T) {
+		url := fmt.Sprintf("https://127.0.0.1:%d/orders/3009", base+4)
+		if resp, err := noCert.Get(url); err == nil {
+			_ = resp.Body.Close()
+			t.Fatalf("expected handshake failure without client certificate, got %d", resp.StatusCode)
+		}
+		wantStatus, wantBody := get(plain, fmt.Sprintf("http://127.0.0.1:%d/orders/3009", base+1))
+		if status, body := get(withCert, url); status != wantStatus || body != wantBody {
+			t.Fatalf("mTLS GET: %d %s, want %d %s", status, body, wantStatus, wantBody)
+		}
+	})
+}
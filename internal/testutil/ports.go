@@ -0,0 +1,47 @@
+// Package testutil holds helpers shared by the server package tests.
+package testutil
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+)
+
+// Port scan range. It sits below the usual ephemeral range so outgoing
+// client connections do not take ports a test is about to bind.
+const (
+	portRangeStart = 20000
+	portRangeEnd   = 30000
+)
+
+// FreeBase returns a base port such that base..base+n-1 are free on
+// 127.0.0.1. The scan starts at a random n-aligned block, so test binaries
+// of different packages running in parallel do not race for the same ports.
+func FreeBase(n int) (int, error) {
+	blocks := (portRangeEnd - portRangeStart) / n
+	first := rand.Intn(blocks)
+	for i := 0; i < blocks; i++ {
+		base := portRangeStart + (first+i)%blocks*n
+		if free(base, n) {
+			return base, nil
+		}
+	}
+	return 0, fmt.Errorf("no %d free contiguous ports in %d-%d", n, portRangeStart, portRangeEnd)
+}
+
+func free(base, n int) bool {
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}()
+	for p := base; p < base+n; p++ {
+		l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", p))
+		if err != nil {
+			return false
+		}
+		listeners = append(listeners, l)
+	}
+	return true
+}
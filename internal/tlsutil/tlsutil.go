@@ -0,0 +1,268 @@
+package tlsutil
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/topology"
+)
+
+// Authority is a certificate authority that signs service and client certificates.
+type Authority struct {
+	Cert    *x509.Certificate
+	Key     crypto.Signer
+	CertPEM []byte
+}
+
+var (
+	authMu    sync.Mutex
+	authority *Authority
+)
+
+// Setup initializes the process-wide authority from cfg: the provided CA files
+// when set, otherwise a freshly generated in-memory CA. When an export
+// directory is configured (cfg.ExportDir or env TLS_EXPORT_DIR) the CA and a
+// client certificate are written there.
+func Setup(cfg topology.TLSConfig) (*Authority, error) {
+	var (
+		a   *Authority
+		err error
+	)
+	if cfg.CACertFile != "" || cfg.CAKeyFile != "" {
+		a, err = LoadAuthority(cfg.CACertFile, cfg.CAKeyFile)
+	} else {
+		a, err = NewAuthority()
+	}
+	if err != nil {
+		return nil, err
+	}
+	dir := cfg.ExportDir
+	if dir == "" {
+		dir = os.Getenv("TLS_EXPORT_DIR")
+	}
+	if dir != "" {
+		if err := a.Export(dir); err != nil {
+			return nil, err
+		}
+		common.Logf("TLS CA and client certificate exported to %s", dir)
+	}
+	authMu.Lock()
+	authority = a
+	authMu.Unlock()
+	return a, nil
+}
+
+// Default returns the process-wide authority, generating one on first use.
+func Default() (*Authority, error) {
+	authMu.Lock()
+	defer authMu.Unlock()
+	if authority != nil {
+		return authority, nil
+	}
+	a, err := NewAuthority()
+	if err != nil {
+		return nil, err
+	}
+	authority = a
+	return a, nil
+}
+
+// NewAuthority generates a self-signed ECDSA P-256 CA valid for one year.
+func NewAuthority() (*Authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "Intercept Wave Upstream Test CA", Organization: []string{"zhongmiao-org"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{Cert: cert, Key: key, CertPEM: pemBlock("CERTIFICATE", der)}, nil
+}
+
+// LoadAuthority reads a PEM CA certificate and its private key.
+func LoadAuthority(certFile, keyFile string) (*Authority, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("load CA: %s is not a CA certificate", certFile)
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("load CA: unsupported key type")
+	}
+	return &Authority{Cert: cert, Key: signer, CertPEM: pemBlock("CERTIFICATE", cert.Raw)}, nil
+}
+
+// Pool returns a cert pool containing only the authority.
+func (a *Authority) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(a.Cert)
+	return pool
+}
+
+// IssueServer signs a server certificate for hosts (DNS names or IPs).
+func (a *Authority) IssueServer(cn string, hosts []string) (tls.Certificate, error) {
+	tmpl := a.leafTemplate(cn, x509.ExtKeyUsageServerAuth)
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	certPEM, keyPEM, err := a.issue(tmpl)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// IssueClient signs a client certificate and returns it PEM-encoded.
+func (a *Authority) IssueClient(cn string) (certPEM, keyPEM []byte, err error) {
+	return a.issue(a.leafTemplate(cn, x509.ExtKeyUsageClientAuth))
+}
+
+// Export writes ca.pem, client.pem and client-key.pem into dir.
+func (a *Authority) Export(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	certPEM, keyPEM, err := a.IssueClient("intercept-wave-client")
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{"ca.pem": a.CertPEM, "client.pem": certPEM, "client-key.pem": keyPEM}
+	for name, b := range files {
+		mode := os.FileMode(0o644)
+		if name == "client-key.pem" {
+			mode = 0o600
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), b, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Authority) leafTemplate(cn string, usage x509.ExtKeyUsage) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"zhongmiao-org"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(0, 6, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+}
+
+func (a *Authority) issue(tmpl *x509.Certificate) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.Cert, key.Public(), a.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pemBlock("CERTIFICATE", der), pemBlock("PRIVATE KEY", keyDER), nil
+}
+
+// ServerConfig builds the tls.Config for a service listener: provided PEM
+// files when set, otherwise a leaf certificate issued by the authority.
+func ServerConfig(name string, st topology.ServiceTLS) (*tls.Config, error) {
+	a, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	var cert tls.Certificate
+	if st.CertFile != "" {
+		cert, err = tls.LoadX509KeyPair(st.CertFile, st.KeyFile)
+	} else {
+		cert, err = a.IssueServer(name, defaultHosts(st.Hosts))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tls %s: %w", name, err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		ClientCAs:    a.Pool(),
+	}
+	switch st.ClientAuth {
+	case "request":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case "any":
+		cfg.ClientAuth = tls.RequireAnyClientCert
+	default:
+		cfg.ClientAuth = tls.NoClientCert
+	}
+	return cfg, nil
+}
+
+// NewServer returns an http.Server with a TLS config for the service; start
+// it with ListenAndServeTLS("", "").
+func NewServer(name string, port int, handler http.Handler, st topology.ServiceTLS) (*http.Server, error) {
+	cfg, err := ServerConfig(name, st)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: handler, TLSConfig: cfg}, nil
+}
+
+func defaultHosts(extra []string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if h, err := os.Hostname(); err == nil && h != "" {
+		hosts = append(hosts, h)
+	}
+	return append(hosts, extra...)
+}
+
+func randomSerial() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 126))
+	return n
+}
+
+func pemBlock(typ string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
@@ -0,0 +1,57 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"intercept-wave-upstream/internal/topology"
+)
+
+func TestMutualTLSWithExportedClientCert(t *testing.T) {
+	dir := t.TempDir()
+	a, err := Setup(topology.TLSConfig{ExportDir: dir})
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	cfg, err := ServerConfig("svc", topology.ServiceTLS{Enabled: true, ClientAuth: "require"})
+	if err != nil {
+		t.Fatalf("server config: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	srv.TLS = cfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	caPEM, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil || len(caPEM) == 0 {
+		t.Fatalf("ca.pem not exported: %v", err)
+	}
+
+	noCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: a.Pool()}}}
+	if resp, err := noCert.Get(srv.URL); err == nil {
+		_ = resp.Body.Close()
+		t.Fatalf("expected handshake failure without client certificate")
+	}
+
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem"))
+	if err != nil {
+		t.Fatalf("load client pair: %v", err)
+	}
+	withCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: a.Pool(), Certificates: []tls.Certificate{pair}}}}
+	resp, err := withCert.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("mTLS request: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, _ := io.ReadAll(resp.Body)
+	if string(b) != "intercept-wave-client" {
+		t.Fatalf("unexpected peer CN: %q", b)
+	}
+}
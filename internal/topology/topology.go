@@ -0,0 +1,397 @@
+package topology
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultTopology is the built-in six-service layout (3 HTTP + 3 WS).
+//
+//go:embed default.json
+var defaultTopology []byte
+
+// Topology declares every upstream service started by the process.
+type Topology struct {
+	HTTP  []HTTPService `json:"http"`
+	WS    []WSService   `json:"ws"`
+	GRPC  []GRPCService `json:"grpc,omitempty"`
+	TLS   TLSConfig     `json:"tls,omitempty"`
+	Admin AdminConfig   `json:"admin,omitempty"`
+}
+
+// DefaultAdminPortOffset places the admin API at BASE_PORT+99 unless the
+// topology says otherwise.
+const DefaultAdminPortOffset = 99
+
+// AdminConfig places the admin control plane listener.
+type AdminConfig struct {
+	Disabled   bool `json:"disabled,omitempty"`
+	Port       int  `json:"port,omitempty"`
+	PortOffset *int `json:"portOffset,omitempty"`
+}
+
+// ResolvePort returns the admin port: an explicit port wins, then
+// base+portOffset, then base+DefaultAdminPortOffset.
+func (a AdminConfig) ResolvePort(base int) int {
+	if p := resolvePort(a.Port, a.PortOffset, base); p > 0 {
+		return p
+	}
+	return base + DefaultAdminPortOffset
+}
+
+// TLSConfig configures the process-wide certificate authority used to issue
+// service certificates. Without CA files an in-memory CA is generated.
+type TLSConfig struct {
+	CACertFile string `json:"caCertFile,omitempty"`
+	CAKeyFile  string `json:"caKeyFile,omitempty"`
+	// ExportDir receives ca.pem plus a client certificate for mTLS tests.
+	ExportDir string `json:"exportDir,omitempty"`
+}
+
+// ServiceTLS enables an additional TLS listener for a service.
+type ServiceTLS struct {
+	Enabled    bool     `json:"enabled"`
+	Port       int      `json:"port,omitempty"`
+	PortOffset *int     `json:"portOffset,omitempty"`
+	CertFile   string   `json:"certFile,omitempty"`
+	KeyFile    string   `json:"keyFile,omitempty"`
+	Hosts      []string `json:"hosts,omitempty"`
+	// ClientAuth is one of none (default), request, require or any.
+	ClientAuth string `json:"clientAuth,omitempty"`
+}
+
+// ResolvePort returns the TLS listener port: an explicit port wins, otherwise
+// base+portOffset.
+func (s ServiceTLS) ResolvePort(base int) int { return resolvePort(s.Port, s.PortOffset, base) }
+
+// HTTPService describes one HTTP upstream and the route bundles it mounts.
+type HTTPService struct {
+	Name            string   `json:"name"`
+	Port            int      `json:"port,omitempty"`
+	PortOffset      *int     `json:"portOffset,omitempty"`
+	InterceptPrefix string   `json:"interceptPrefix,omitempty"`
+	Bundles         []string `json:"bundles,omitempty"`
+	// RouteManifest is an asset-relative routes manifest; defaults to routes/{name}.json.
+	RouteManifest string `json:"routeManifest,omitempty"`
+	// CaptureLimit bounds the request capture ring buffer (default 200).
+	CaptureLimit int         `json:"captureLimit,omitempty"`
+	TLS          *ServiceTLS `json:"tls,omitempty"`
+}
+
+// GRPCService describes one gRPC upstream. The listener speaks gRPC over h2c
+// (and over TLS when enabled) plus gRPC-Web and Connect over HTTP/1.1.
+type GRPCService struct {
+	Name       string `json:"name"`
+	Port       int    `json:"port,omitempty"`
+	PortOffset *int   `json:"portOffset,omitempty"`
+	// Bundles selects the RPC services: order, payment (default: both).
+	Bundles []string    `json:"bundles,omitempty"`
+	TLS     *ServiceTLS `json:"tls,omitempty"`
+}
+
+// WSService describes one WebSocket upstream and the route bundles it mounts.
+type WSService struct {
+	Name            string      `json:"name"`
+	Port            int         `json:"port,omitempty"`
+	PortOffset      *int        `json:"portOffset,omitempty"`
+	InterceptPrefix string      `json:"interceptPrefix,omitempty"`
+	EventKey        string      `json:"eventKey,omitempty"`
+	Bundles         []string    `json:"bundles,omitempty"`
+	TLS             *ServiceTLS `json:"tls,omitempty"`
+	// Auth selects how upgrade requests authenticate (default: token mode
+	// with the built-in token).
+	Auth *WSAuth `json:"auth,omitempty"`
+	// Options apply to every endpoint; Endpoints replaces them per path
+	// (e.g. "/ws/echo").
+	Options   *WSOptions           `json:"options,omitempty"`
+	Endpoints map[string]WSOptions `json:"endpoints,omitempty"`
+}
+
+// WSOptions tunes the upgrade of WS endpoints.
+type WSOptions struct {
+	// Subprotocols are advertised in server preference order.
+	Subprotocols []string `json:"subprotocols,omitempty"`
+	// RequireSubprotocol rejects upgrades that offer none of Subprotocols.
+	RequireSubprotocol bool           `json:"requireSubprotocol,omitempty"`
+	Compression        *WSCompression `json:"compression,omitempty"`
+	// Hello sends a first frame reporting the negotiated values.
+	Hello     bool         `json:"hello,omitempty"`
+	Heartbeat *WSHeartbeat `json:"heartbeat,omitempty"`
+}
+
+// WSHeartbeat configures keepalive and idle policies. Zero values disable
+// the respective feature.
+type WSHeartbeat struct {
+	// PingIntervalMs sends a ping frame every interval.
+	PingIntervalMs int `json:"pingIntervalMs,omitempty"`
+	// PongTimeoutMs closes the connection when a ping is not answered in time.
+	PongTimeoutMs int `json:"pongTimeoutMs,omitempty"`
+	// MessageIntervalMs sends Message as an application-level heartbeat
+	// (default {"<eventKey>":"heartbeat","time":...}).
+	MessageIntervalMs int         `json:"messageIntervalMs,omitempty"`
+	Message           interface{} `json:"message,omitempty"`
+	// IdleTimeoutMs closes the connection when the client sends nothing
+	// (data, ping or pong) for that long.
+	IdleTimeoutMs int `json:"idleTimeoutMs,omitempty"`
+}
+
+// WSCompression enables permessage-deflate.
+type WSCompression struct {
+	Enabled bool `json:"enabled"`
+	// Level is the flate level for outgoing messages (-2..9, default 1).
+	Level int `json:"level,omitempty"`
+	// DisableWrite negotiates the extension but sends uncompressed frames.
+	DisableWrite bool `json:"disableWrite,omitempty"`
+}
+
+// WS auth modes.
+const (
+	AuthToken       = "token"
+	AuthJWT         = "jwt"
+	AuthBasic       = "basic"
+	AuthCookie      = "cookie"
+	AuthSubprotocol = "subprotocol"
+	AuthNone        = "none"
+)
+
+// WSAuth configures WebSocket upgrade authentication. Mode is one of token,
+// jwt, basic, cookie, subprotocol or none.
+type WSAuth struct {
+	Mode string `json:"mode"`
+	// Tokens are accepted by the token and subprotocol modes.
+	Tokens []string `json:"tokens,omitempty"`
+	// Users maps Basic auth user names to passwords.
+	Users map[string]string `json:"users,omitempty"`
+	// Cookie names the session cookie (default "session"); Sessions lists
+	// the valid session ids (default: Tokens).
+	Cookie   string   `json:"cookie,omitempty"`
+	Sessions []string `json:"sessions,omitempty"`
+	JWT      *JWTAuth `json:"jwt,omitempty"`
+}
+
+// JWTAuth validates Bearer JWTs with a local key.
+type JWTAuth struct {
+	// Alg is HS256 (default) or RS256.
+	Alg    string `json:"alg,omitempty"`
+	Secret string `json:"secret,omitempty"`
+	// PublicKeyFile is a PEM RSA public key or certificate for RS256.
+	PublicKeyFile string `json:"publicKeyFile,omitempty"`
+	Issuer        string `json:"issuer,omitempty"`
+	Audience      string `json:"audience,omitempty"`
+	// Claims must match exactly (e.g. {"role":"merchant"}).
+	Claims map[string]interface{} `json:"claims,omitempty"`
+	// LeewaySeconds tolerates clock skew on exp/nbf.
+	LeewaySeconds int `json:"leewaySeconds,omitempty"`
+}
+
+// ResolvePort returns the absolute port: an explicit port wins, otherwise
+// base+portOffset.
+func (s HTTPService) ResolvePort(base int) int { return resolvePort(s.Port, s.PortOffset, base) }
+
+// ResolvePort returns the absolute port: an explicit port wins, otherwise
+// base+portOffset.
+func (s WSService) ResolvePort(base int) int { return resolvePort(s.Port, s.PortOffset, base) }
+
+// ResolvePort returns the absolute port: an explicit port wins, otherwise
+// base+portOffset.
+func (s GRPCService) ResolvePort(base int) int { return resolvePort(s.Port, s.PortOffset, base) }
+
+func resolvePort(port int, offset *int, base int) int {
+	if port > 0 {
+		return port
+	}
+	if offset != nil {
+		return base + *offset
+	}
+	return 0
+}
+
+// Default returns the built-in topology shipped in default.json.
+func Default() *Topology {
+	t, err := parse(defaultTopology, ".json")
+	if err != nil {
+		panic(fmt.Sprintf("topology: invalid built-in default: %v", err))
+	}
+	return t
+}
+
+// Load reads a topology file (JSON, or YAML when the extension is .yaml/.yml).
+// An empty path returns the built-in default.
+func Load(path string) (*Topology, error) {
+	if path == "" {
+		return Default(), nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := parse(b, filepath.Ext(path))
+	if err != nil {
+		return nil, fmt.Errorf("topology %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// PathFromEnv returns the topology file path from env TOPOLOGY_FILE (may be empty).
+func PathFromEnv() string {
+	return os.Getenv("TOPOLOGY_FILE")
+}
+
+func parse(b []byte, ext string) (*Topology, error) {
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		// decode YAML generically, then reuse the JSON tags for the typed view
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		jb, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		b = jb
+	}
+	var t Topology
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (t *Topology) validate() error {
+	if len(t.HTTP) == 0 && len(t.WS) == 0 && len(t.GRPC) == 0 {
+		return fmt.Errorf("no services declared")
+	}
+	seen := map[string]bool{}
+	check := func(kind, name string, port int, offset *int) error {
+		if name == "" {
+			return fmt.Errorf("%s service without name", kind)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate service name %q", name)
+		}
+		seen[name] = true
+		if port <= 0 && offset == nil {
+			return fmt.Errorf("service %q needs port or portOffset", name)
+		}
+		return nil
+	}
+	checkTLS := func(name string, st *ServiceTLS) error {
+		if st == nil || !st.Enabled {
+			return nil
+		}
+		if st.Port <= 0 && st.PortOffset == nil {
+			return fmt.Errorf("service %q: tls needs port or portOffset", name)
+		}
+		switch st.ClientAuth {
+		case "", "none", "request", "require", "any":
+		default:
+			return fmt.Errorf("service %q: unknown tls clientAuth %q", name, st.ClientAuth)
+		}
+		if (st.CertFile == "") != (st.KeyFile == "") {
+			return fmt.Errorf("service %q: tls certFile and keyFile go together", name)
+		}
+		return nil
+	}
+	for _, s := range t.HTTP {
+		if err := check("http", s.Name, s.Port, s.PortOffset); err != nil {
+			return err
+		}
+		if err := checkTLS(s.Name, s.TLS); err != nil {
+			return err
+		}
+	}
+	for _, s := range t.WS {
+		if err := check("ws", s.Name, s.Port, s.PortOffset); err != nil {
+			return err
+		}
+		if err := checkAuth(s.Name, s.Auth); err != nil {
+			return err
+		}
+		if err := checkOptions(s.Name, s.Options); err != nil {
+			return err
+		}
+		for path, o := range s.Endpoints {
+			if !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("service %q: endpoint %q must start with /", s.Name, path)
+			}
+			if err := checkOptions(s.Name, &o); err != nil {
+				return err
+			}
+		}
+		if err := checkTLS(s.Name, s.TLS); err != nil {
+			return err
+		}
+	}
+	for _, s := range t.GRPC {
+		if err := check("grpc", s.Name, s.Port, s.PortOffset); err != nil {
+			return err
+		}
+		if err := checkTLS(s.Name, s.TLS); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkAuth(name string, a *WSAuth) error {
+	if a == nil {
+		return nil
+	}
+	switch a.Mode {
+	case "", AuthToken, AuthSubprotocol, AuthCookie, AuthNone:
+	case AuthBasic:
+		if len(a.Users) == 0 {
+			return fmt.Errorf("service %q: basic auth needs users", name)
+		}
+	case AuthJWT:
+		if a.JWT == nil {
+			return fmt.Errorf("service %q: jwt auth needs a jwt block", name)
+		}
+		switch a.JWT.Alg {
+		case "", "HS256":
+			if a.JWT.Secret == "" {
+				return fmt.Errorf("service %q: HS256 needs secret", name)
+			}
+		case "RS256":
+			if a.JWT.PublicKeyFile == "" {
+				return fmt.Errorf("service %q: RS256 needs publicKeyFile", name)
+			}
+		default:
+			return fmt.Errorf("service %q: unsupported jwt alg %q", name, a.JWT.Alg)
+		}
+	default:
+		return fmt.Errorf("service %q: unknown auth mode %q", name, a.Mode)
+	}
+	return nil
+}
+
+func checkOptions(name string, o *WSOptions) error {
+	if o == nil {
+		return nil
+	}
+	if o.RequireSubprotocol && len(o.Subprotocols) == 0 {
+		return fmt.Errorf("service %q: requireSubprotocol needs subprotocols", name)
+	}
+	if c := o.Compression; c != nil && c.Level != 0 && (c.Level < -2 || c.Level > 9) {
+		return fmt.Errorf("service %q: compression level %d out of range", name, c.Level)
+	}
+	if h := o.Heartbeat; h != nil {
+		if h.PingIntervalMs < 0 || h.PongTimeoutMs < 0 || h.MessageIntervalMs < 0 || h.IdleTimeoutMs < 0 {
+			return fmt.Errorf("service %q: heartbeat intervals must not be negative", name)
+		}
+		if h.PongTimeoutMs > 0 && h.PingIntervalMs == 0 {
+			return fmt.Errorf("service %q: pongTimeoutMs needs pingIntervalMs", name)
+		}
+	}
+	return nil
+}
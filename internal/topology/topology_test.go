@@ -0,0 +1,96 @@
+package topology
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultMatchesLegacyLayout(t *testing.T) {
+	topo := Default()
+	if len(topo.HTTP) != 3 || len(topo.WS) != 3 {
+		t.Fatalf("unexpected service counts: http=%d ws=%d", len(topo.HTTP), len(topo.WS))
+	}
+	if got := topo.HTTP[1].ResolvePort(9000); got != 9001 {
+		t.Fatalf("order-service port=%d", got)
+	}
+	if got := topo.WS[2].ResolvePort(9000); got != 9005 {
+		t.Fatalf("ws-timeline port=%d", got)
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "topo.yaml")
+	src := `http:
+  - name: inventory-service
+    port: 18080
+    interceptPrefix: /inv-api
+    bundles: [order]
+ws:
+  - name: ws-push
+    portOffset: 10
+    bundles: [echo]
+`
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	topo, err := Load(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if topo.HTTP[0].ResolvePort(9000) != 18080 || topo.HTTP[0].InterceptPrefix != "/inv-api" {
+		t.Fatalf("unexpected http service: %+v", topo.HTTP[0])
+	}
+	if topo.WS[0].ResolvePort(9000) != 9010 {
+		t.Fatalf("unexpected ws port: %d", topo.WS[0].ResolvePort(9000))
+	}
+}
+
+func TestLoadRejectsDuplicateNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topo.json")
+	src := `{"http":[{"name":"a","port":1},{"name":"a","port":2}]}`
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Fatalf("expected duplicate name error")
+	}
+}
+
+func TestLoadRejectsIncompleteAuth(t *testing.T) {
+	for _, auth := range []string{
+		`{"mode":"magic"}`,
+		`{"mode":"basic"}`,
+		`{"mode":"jwt","jwt":{"alg":"RS256","secret":"x"}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "topo.json")
+		src := `{"ws":[{"name":"w","port":1,"auth":` + auth + `}]}`
+		if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Fatalf("expected error for auth %s", auth)
+		}
+	}
+}
+
+func TestLoadGRPCOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topo.yaml")
+	src := `grpc:
+  - name: grpc-orders
+    portOffset: 6
+    bundles: [order]
+    tls: {enabled: true, portOffset: 16}
+`
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	topo, err := Load(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(topo.GRPC) != 1 || topo.GRPC[0].ResolvePort(9000) != 9006 || topo.GRPC[0].TLS.ResolvePort(9000) != 9016 {
+		t.Fatalf("unexpected grpc service: %+v", topo.GRPC)
+	}
+}
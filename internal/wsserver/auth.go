@@ -0,0 +1,334 @@
+package wsserver
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+// staticWsToken is the built-in token accepted when a service declares no
+// auth block (or token mode without a token list). Clients provide it via
+// header "X-Auth-Token" or query param "token".
+const staticWsToken = "zhongmiao-org-token"
+
+// wsAuth is the compiled auth configuration of one WS service.
+type wsAuth struct {
+	mode     string
+	tokens   map[string]bool
+	users    map[string]string
+	cookie   string
+	sessions map[string]bool
+	jwt      *jwtVerifier
+	// broken holds a configuration error; every upgrade is then rejected.
+	broken error
+}
+
+// authResult describes an accepted upgrade: who connected and, for the
+// subprotocol mode, which Sec-WebSocket-Protocol value to echo.
+type authResult struct {
+	Principal   string
+	Subprotocol string
+}
+
+func newWSAuth(cfg *topology.WSAuth) *wsAuth {
+	if cfg == nil {
+		cfg = &topology.WSAuth{}
+	}
+	a := &wsAuth{mode: cfg.Mode, users: cfg.Users, cookie: cfg.Cookie}
+	if a.mode == "" {
+		a.mode = topology.AuthToken
+	}
+	tokens := cfg.Tokens
+	if len(tokens) == 0 {
+		tokens = []string{staticWsToken}
+	}
+	a.tokens = stringSet(tokens)
+	a.sessions = a.tokens
+	if len(cfg.Sessions) > 0 {
+		a.sessions = stringSet(cfg.Sessions)
+	}
+	if a.cookie == "" {
+		a.cookie = "session"
+	}
+	if a.mode == topology.AuthJWT {
+		v, err := newJWTVerifier(cfg.JWT)
+		if err != nil {
+			a.broken = err
+		}
+		a.jwt = v
+	}
+	return a
+}
+
+func stringSet(list []string) map[string]bool {
+	m := make(map[string]bool, len(list))
+	for _, s := range list {
+		m[s] = true
+	}
+	return m
+}
+
+// hint tells a rejected client which credential the service expects.
+func (a *wsAuth) hint() string {
+	switch a.mode {
+	case topology.AuthJWT:
+		return "provide Authorization: Bearer <jwt> or ?access_token="
+	case topology.AuthBasic:
+		return "provide Authorization: Basic credentials"
+	case topology.AuthCookie:
+		return "provide cookie " + a.cookie
+	case topology.AuthSubprotocol:
+		return "offer the token as a Sec-WebSocket-Protocol value"
+	default:
+		return "provide X-Auth-Token header or ?token="
+	}
+}
+
+// check authenticates an upgrade request.
+func (a *wsAuth) check(r *http.Request) (authResult, error) {
+	if a.broken != nil {
+		return authResult{}, a.broken
+	}
+	switch a.mode {
+	case topology.AuthNone:
+		return authResult{}, nil
+	case topology.AuthToken:
+		tok := r.Header.Get("X-Auth-Token")
+		if tok == "" {
+			tok = r.URL.Query().Get("token")
+		}
+		if !a.tokens[tok] {
+			return authResult{}, errors.New("invalid token")
+		}
+		return authResult{Principal: "token"}, nil
+	case topology.AuthBasic:
+		user, pass, ok := r.BasicAuth()
+		if !ok {
+			return authResult{}, errors.New("missing basic credentials")
+		}
+		want, known := a.users[user]
+		if !known || !hmac.Equal([]byte(want), []byte(pass)) {
+			return authResult{}, errors.New("invalid basic credentials")
+		}
+		return authResult{Principal: user}, nil
+	case topology.AuthCookie:
+		c, err := r.Cookie(a.cookie)
+		if err != nil || !a.sessions[c.Value] {
+			return authResult{}, errors.New("invalid session")
+		}
+		return authResult{Principal: "session:" + c.Value}, nil
+	case topology.AuthSubprotocol:
+		offered := websocket.Subprotocols(r)
+		for i, p := range offered {
+			if !a.tokens[p] {
+				continue
+			}
+			// echo a marker protocol offered alongside the token when present
+			echo := p
+			for j, q := range offered {
+				if j != i && !a.tokens[q] {
+					echo = q
+					break
+				}
+			}
+			return authResult{Principal: "subprotocol", Subprotocol: echo}, nil
+		}
+		return authResult{}, errors.New("no token in Sec-WebSocket-Protocol")
+	case topology.AuthJWT:
+		tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			tok = r.URL.Query().Get("access_token")
+		}
+		if tok == "" {
+			return authResult{}, errors.New("missing bearer token")
+		}
+		claims, err := a.jwt.verify(strings.TrimSpace(tok), time.Now())
+		if err != nil {
+			return authResult{}, err
+		}
+		sub, _ := claims["sub"].(string)
+		return authResult{Principal: sub}, nil
+	}
+	return authResult{}, fmt.Errorf("unknown auth mode %q", a.mode)
+}
+
+// authorize runs the service auth and writes a 401 on failure.
+func authorize(w http.ResponseWriter, r *http.Request, sp WsSpec) (authResult, bool) {
+	a := sp.auth
+	if a == nil {
+		a = newWSAuth(nil)
+	}
+	res, err := a.check(r)
+	if err == nil {
+		return res, true
+	}
+	common.Logf("WS %s: auth (%s) rejected %s: %v", sp.Name, a.mode, r.URL.Path, err)
+	switch a.mode {
+	case topology.AuthBasic:
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+sp.Name+`"`)
+	case topology.AuthJWT:
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+	common.JSON(w, http.StatusUnauthorized, map[string]interface{}{
+		"error":  "unauthorized",
+		"hint":   a.hint(),
+		"reason": err.Error(),
+	})
+	return res, false
+}
+
+// jwtVerifier checks compact JWS tokens signed with HS256 or RS256.
+type jwtVerifier struct {
+	cfg    topology.JWTAuth
+	alg    string
+	secret []byte
+	pub    *rsa.PublicKey
+}
+
+func newJWTVerifier(cfg *topology.JWTAuth) (*jwtVerifier, error) {
+	if cfg == nil {
+		return nil, errors.New("jwt auth without jwt config")
+	}
+	v := &jwtVerifier{cfg: *cfg, alg: cfg.Alg, secret: []byte(cfg.Secret)}
+	if v.alg == "" {
+		v.alg = "HS256"
+	}
+	if v.alg == "RS256" {
+		pub, err := loadRSAPublicKey(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("jwt public key: %w", err)
+		}
+		v.pub = pub
+	}
+	return v, nil
+}
+
+// loadRSAPublicKey reads a PEM PUBLIC KEY, RSA PUBLIC KEY or CERTIFICATE.
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+	return pub, nil
+}
+
+// verify checks the signature and the registered plus configured claims.
+func (v *jwtVerifier) verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("jwt header: %w", err)
+	}
+	if header.Alg != v.alg {
+		return nil, fmt.Errorf("unexpected jwt alg %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("jwt signature encoding")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch v.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, errors.New("invalid jwt signature")
+		}
+	case "RS256":
+		sum := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(v.pub, crypto.SHA256, sum[:], sig); err != nil {
+			return nil, errors.New("invalid jwt signature")
+		}
+	}
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("jwt claims: %w", err)
+	}
+	leeway := float64(v.cfg.LeewaySeconds)
+	unix := float64(now.Unix())
+	if exp, ok := claims["exp"].(float64); ok && unix > exp+leeway {
+		return nil, errors.New("jwt expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && unix+leeway < nbf {
+		return nil, errors.New("jwt not yet valid")
+	}
+	if v.cfg.Issuer != "" && claims["iss"] != v.cfg.Issuer {
+		return nil, errors.New("jwt issuer mismatch")
+	}
+	if v.cfg.Audience != "" && !audienceContains(claims["aud"], v.cfg.Audience) {
+		return nil, errors.New("jwt audience mismatch")
+	}
+	for k, want := range v.cfg.Claims {
+		if !reflect.DeepEqual(claims[k], want) {
+			return nil, fmt.Errorf("jwt claim %q mismatch", k)
+		}
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func audienceContains(aud interface{}, want string) bool {
+	switch t := aud.(type) {
+	case string:
+		return t == want
+	case []interface{}:
+		for _, a := range t {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package wsserver
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func signJWT(t *testing.T, alg string, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	enc := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": alg, "typ": "JWT"}) + "." + enc(claims)
+	var sig []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case "RS256":
+		sum := sha256.Sum256([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, sum[:]); err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestWsAuthModes(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa: %v", err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	pubFile := filepath.Join(t.TempDir(), "jwt.pub")
+	if err := os.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	secret := []byte("s3cret")
+
+	off := func(i int) *int { return &i }
+	svc := func(i int, auth *topology.WSAuth) topology.WSService {
+		return topology.WSService{Name: fmt.Sprintf("auth-%d", i), PortOffset: off(i), Bundles: []string{"echo"}, Auth: auth}
+	}
+	topo := []topology.WSService{
+		svc(0, &topology.WSAuth{Mode: "token", Tokens: []string{"alpha", "beta"}}),
+		svc(1, &topology.WSAuth{Mode: "jwt", JWT: &topology.JWTAuth{Secret: string(secret), Issuer: "iw", Claims: map[string]interface{}{"role": "merchant"}}}),
+		svc(2, &topology.WSAuth{Mode: "jwt", JWT: &topology.JWTAuth{Alg: "RS256", PublicKeyFile: pubFile, Audience: "upstream"}}),
+		svc(3, &topology.WSAuth{Mode: "basic", Users: map[string]string{"alice": "pw"}}),
+		svc(4, &topology.WSAuth{Mode: "cookie", Sessions: []string{"sess-1"}}),
+		svc(5, &topology.WSAuth{Mode: "subprotocol", Tokens: []string{"tok-1"}}),
+	}
+	srvs := StartAll(base, topo)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	dial := func(offset int, query string, hdr http.Header, protocols ...string) (*websocket.Conn, int) {
+		t.Helper()
+		d := websocket.Dialer{Subprotocols: protocols, HandshakeTimeout: 2 * time.Second}
+		c, resp, err := d.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws/echo%s", base+offset, query), hdr)
+		if err != nil {
+			if resp == nil {
+				t.Fatalf("dial: %v", err)
+			}
+			return nil, resp.StatusCode
+		}
+		return c, http.StatusSwitchingProtocols
+	}
+	expect := func(name string, offset int, query string, hdr http.Header, want int, protocols ...string) *websocket.Conn {
+		t.Helper()
+		c, status := dial(offset, query, hdr, protocols...)
+		if status != want {
+			t.Fatalf("%s: status=%d want %d", name, status, want)
+		}
+		if c != nil {
+			t.Cleanup(func() { _ = c.Close() })
+		}
+		return c
+	}
+	bearer := func(tok string) http.Header { return http.Header{"Authorization": {"Bearer " + tok}} }
+	exp := time.Now().Add(time.Hour).Unix()
+
+	expect("token query", 0, "?token=beta", nil, 101)
+	expect("token header", 0, "", http.Header{"X-Auth-Token": {"alpha"}}, 101)
+	expect("token wrong", 0, "?token="+staticWsToken, nil, 401)
+
+	hs := signJWT(t, "HS256", secret, map[string]interface{}{"sub": "m-1", "iss": "iw", "role": "merchant", "exp": exp})
+	expect("hs256", 1, "", bearer(hs), 101)
+	expect("hs256 query", 1, "?access_token="+hs, nil, 101)
+	expect("hs256 claim", 1, "", bearer(signJWT(t, "HS256", secret, map[string]interface{}{"iss": "iw", "role": "user", "exp": exp})), 401)
+	expect("hs256 expired", 1, "", bearer(signJWT(t, "HS256", secret, map[string]interface{}{"iss": "iw", "role": "merchant", "exp": 1})), 401)
+	expect("hs256 bad key", 1, "", bearer(signJWT(t, "HS256", []byte("other"), map[string]interface{}{"iss": "iw", "role": "merchant"})), 401)
+
+	expect("rs256", 2, "", bearer(signJWT(t, "RS256", rsaKey, map[string]interface{}{"aud": []string{"upstream"}})), 101)
+	expect("rs256 alg confusion", 2, "", bearer(signJWT(t, "HS256", der, map[string]interface{}{"aud": "upstream"})), 401)
+
+	basic := http.Header{}
+	(&http.Request{Header: basic}).SetBasicAuth("alice", "pw")
+	expect("basic", 3, "", basic, 101)
+	(&http.Request{Header: basic}).SetBasicAuth("alice", "nope")
+	expect("basic wrong", 3, "", basic, 401)
+
+	expect("cookie", 4, "", http.Header{"Cookie": {"session=sess-1"}}, 101)
+	expect("cookie wrong", 4, "", http.Header{"Cookie": {"session=other"}}, 401)
+
+	c := expect("subprotocol", 5, "", nil, 101, "access_token", "tok-1")
+	if c.Subprotocol() != "access_token" {
+		t.Fatalf("negotiated subprotocol=%q", c.Subprotocol())
+	}
+	expect("subprotocol wrong", 5, "", nil, 401, "access_token", "tok-2")
+}
@@ -0,0 +1,286 @@
+package wsserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxBinarySize caps generated messages (64 MiB).
+const maxBinarySize = 64 << 20
+
+// binaryOptions are read from the query string of /ws/binary and /ws/fragmented.
+type binaryOptions struct {
+	kind     string // random, pattern or fixture
+	size     int
+	count    int
+	interval time.Duration
+	seed     uint64
+	pattern  []byte
+	fixture  []byte
+	text     bool
+	meta     bool
+	fragment int
+	delay    time.Duration
+}
+
+func parseBinaryOptions(q url.Values, defKind string) (binaryOptions, error) {
+	atoi := func(k string, def int) (int, error) {
+		v := q.Get(k)
+		if v == "" {
+			return def, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid %s %q", k, v)
+		}
+		return n, nil
+	}
+	o := binaryOptions{kind: q.Get("kind"), text: q.Get("type") == "text", meta: q.Get("meta") == "1" || q.Get("meta") == "true"}
+	if o.kind == "" {
+		o.kind = defKind
+	}
+	var err error
+	if o.size, err = atoi("size", 1024); err != nil {
+		return o, err
+	}
+	if o.size > maxBinarySize {
+		return o, fmt.Errorf("size %d exceeds %d", o.size, maxBinarySize)
+	}
+	if o.count, err = atoi("count", 1); err != nil {
+		return o, err
+	}
+	ms, err := atoi("interval", 0)
+	if err != nil {
+		return o, err
+	}
+	o.interval = time.Duration(ms) * time.Millisecond
+	if o.fragment, err = atoi("fragment", 0); err != nil {
+		return o, err
+	}
+	if ms, err = atoi("delayMs", 0); err != nil {
+		return o, err
+	}
+	o.delay = time.Duration(ms) * time.Millisecond
+	if s := q.Get("seed"); s != "" {
+		if o.seed, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return o, fmt.Errorf("invalid seed %q", s)
+		}
+	} else {
+		o.seed = uint64(time.Now().UnixNano())
+	}
+	switch o.kind {
+	case "random":
+	case "pattern":
+		if o.text {
+			o.pattern = []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+		} else {
+			o.pattern = make([]byte, 256)
+			for i := range o.pattern {
+				o.pattern[i] = byte(i)
+			}
+		}
+		if p := q.Get("pattern"); p != "" {
+			if o.pattern, err = hex.DecodeString(p); err != nil || len(o.pattern) == 0 {
+				return o, fmt.Errorf("invalid pattern %q", p)
+			}
+		}
+	case "fixture":
+		if o.fixture, err = loadBinaryFixture(q.Get("fixture")); err != nil {
+			return o, err
+		}
+	default:
+		return o, fmt.Errorf("unknown kind %q", o.kind)
+	}
+	return o, nil
+}
+
+// loadBinaryFixture reads assets/ws/binary/{name}; a name without extension
+// refers to the .pb file.
+func loadBinaryFixture(name string) ([]byte, error) {
+	if name == "" {
+		name = "order"
+	}
+	if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid fixture %q", name)
+	}
+	if filepath.Ext(name) == "" {
+		name += ".pb"
+	}
+	b, err := os.ReadFile(common.JoinAssets("ws", "binary", name))
+	if err != nil {
+		return nil, fmt.Errorf("fixture %s unavailable", name)
+	}
+	return b, nil
+}
+
+// payload builds message seq. Random payloads are reproducible per seed.
+func (o binaryOptions) payload(seq int) []byte {
+	switch o.kind {
+	case "fixture":
+		return o.fixture
+	case "pattern":
+		b := make([]byte, o.size)
+		for i := range b {
+			b[i] = o.pattern[i%len(o.pattern)]
+		}
+		return b
+	}
+	b := make([]byte, o.size)
+	if o.text {
+		// printable ASCII keeps text frames valid UTF-8
+		const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+		r := rand.New(rand.NewPCG(o.seed, uint64(seq)))
+		for i := range b {
+			b[i] = alphabet[r.IntN(len(alphabet))]
+		}
+		return b
+	}
+	r := rand.New(rand.NewPCG(o.seed, uint64(seq)))
+	for i := 0; i < len(b); i += 8 {
+		v := r.Uint64()
+		for j := 0; j < 8 && i+j < len(b); j++ {
+			b[i+j] = byte(v >> (8 * j))
+		}
+	}
+	return b
+}
+
+// writeStreamed sends payload through NextWriter in chunk-sized writes so it
+// leaves as one fragmented message; the frame size follows the connection
+// write buffer (see upgraderFor). The write lock is held for the whole message.
+func (c *wsConn) writeStreamed(t int, payload []byte, chunk int, delay time.Duration) error {
+	if chunk <= 0 {
+		chunk = 4096
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	logWsFrame(c.sp, "send", t, payload)
+	w, err := c.NextWriter(t)
+	if err != nil {
+		return err
+	}
+	for len(payload) > 0 {
+		n := min(chunk, len(payload))
+		if _, err := w.Write(payload[:n]); err != nil {
+			_ = w.Close()
+			return err
+		}
+		payload = payload[n:]
+		if delay > 0 && len(payload) > 0 {
+			time.Sleep(delay)
+		}
+	}
+	return w.Close()
+}
+
+// binaryRoutes mounts the binary frame endpoints:
+//   - /ws/binary — random, patterned or fixture payloads, optionally large
+//   - /ws/fragmented — messages streamed across continuation frames
+//   - /ws/checksum — reports size and hashes of every received message
+func binaryRoutes(mux *http.ServeMux, sp WsSpec) {
+	emit := func(defKind string, streamed bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			o, err := parseBinaryOptions(r.URL.Query(), defKind)
+			if err != nil {
+				common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": err.Error()})
+				return
+			}
+			c, release, ok := accept(w, r, sp)
+			if !ok {
+				return
+			}
+			defer release()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for {
+					t, msg, err := c.ReadMessage()
+					if err != nil {
+						common.Logf("WS %s recv loop end: %v", sp.Name, err)
+						return
+					}
+					logWsFrame(sp, "recv", t, msg)
+				}
+			}()
+			mt := websocket.BinaryMessage
+			if o.text {
+				mt = websocket.TextMessage
+			}
+			key := eventKeyForService(sp)
+			for i := 1; i <= o.count; i++ {
+				b := o.payload(i)
+				if o.meta {
+					sum := sha256.Sum256(b)
+					if err := writeJSONWithLog(c, sp, map[string]interface{}{
+						key: "binary", "seq": i, "kind": o.kind, "size": len(b),
+						"sha256": hex.EncodeToString(sum[:]), "streamed": streamed,
+					}); err != nil {
+						return
+					}
+				}
+				if streamed {
+					err = c.writeStreamed(mt, b, o.fragment, o.delay)
+				} else {
+					err = writeMessageLogged(c, sp, mt, b)
+				}
+				if err != nil {
+					common.Logf("WS %s binary write: %v", sp.Name, err)
+					return
+				}
+				select {
+				case <-done:
+					return
+				case <-time.After(o.interval):
+				}
+			}
+			_ = c.closeWith(websocket.CloseNormalClosure, "bye")
+		}
+	}
+	handleWS(mux, sp, "/ws/binary", emit("random", false))
+	handleWS(mux, sp, "/ws/fragmented", emit("pattern", true))
+
+	handleWS(mux, sp, "/ws/checksum", func(w http.ResponseWriter, r *http.Request) {
+		c, release, ok := accept(w, r, sp)
+		if !ok {
+			return
+		}
+		defer release()
+		key := eventKeyForService(sp)
+		var total int64
+		for seq := 1; ; seq++ {
+			t, msg, err := c.ReadMessage()
+			if err != nil {
+				common.Logf("WS %s recv loop end: %v", sp.Name, err)
+				return
+			}
+			logWsFrame(sp, "recv", t, msg)
+			total += int64(len(msg))
+			sum := sha256.Sum256(msg)
+			if err := writeJSONWithLog(c, sp, map[string]interface{}{
+				key:          "checksum",
+				"seq":        seq,
+				"frameType":  wsTypeName(t),
+				"size":       len(msg),
+				"sha256":     hex.EncodeToString(sum[:]),
+				"crc32":      fmt.Sprintf("%08x", crc32.ChecksumIEEE(msg)),
+				"totalBytes": total,
+			}); err != nil {
+				return
+			}
+		}
+	})
+}
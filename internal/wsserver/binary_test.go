@@ -0,0 +1,144 @@
+package wsserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWsBinaryEndpoints(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+	hdr := http.Header{"X-Auth-Token": {staticWsToken}}
+	dial := func(path string) *websocket.Conn {
+		t.Helper()
+		c, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d%s", base+3, path), hdr)
+		if err != nil {
+			t.Fatalf("dial %s: %v", path, err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return c
+	}
+	readBinary := func(c *websocket.Conn) []byte {
+		t.Helper()
+		mt, b, err := c.ReadMessage()
+		if err != nil || mt != websocket.BinaryMessage {
+			t.Fatalf("read binary: type=%d err=%v", mt, err)
+		}
+		return b
+	}
+
+	if b := readBinary(dial("/ws/binary?kind=pattern&pattern=abcd&size=6")); !bytes.Equal(b, []byte{0xab, 0xcd, 0xab, 0xcd, 0xab, 0xcd}) {
+		t.Fatalf("pattern % x", b)
+	}
+	r1 := readBinary(dial("/ws/binary?size=64&seed=7"))
+	if r2 := readBinary(dial("/ws/binary?size=64&seed=7")); !bytes.Equal(r1, r2) || len(r1) != 64 {
+		t.Fatalf("seeded random payloads differ")
+	}
+	want, _ := os.ReadFile(common.JoinAssets("ws", "binary", "order.pb"))
+	if b := readBinary(dial("/ws/binary?kind=fixture&fixture=order")); len(want) == 0 || !bytes.Equal(b, want) {
+		t.Fatalf("fixture mismatch")
+	}
+
+	// a large message matches the announced hash
+	c := dial("/ws/binary?size=8388608&meta=1")
+	var meta map[string]interface{}
+	if err := c.ReadJSON(&meta); err != nil {
+		t.Fatalf("meta: %v", err)
+	}
+	big := readBinary(c)
+	sum := sha256.Sum256(big)
+	if len(big) != 8<<20 || meta["sha256"] != hex.EncodeToString(sum[:]) {
+		t.Fatalf("large message size=%d meta=%v", len(big), meta)
+	}
+
+	// the checksum endpoint hashes what it received
+	c = dial("/ws/checksum")
+	_ = c.WriteMessage(websocket.BinaryMessage, big)
+	var ck map[string]interface{}
+	if err := c.ReadJSON(&ck); err != nil {
+		t.Fatalf("checksum: %v", err)
+	}
+	if ck["sha256"] != meta["sha256"] || ck["size"] != float64(8<<20) || ck["frameType"] != "binary" {
+		t.Fatalf("checksum=%v", ck)
+	}
+
+	// fragmented: 10000 bytes in 1000-byte frames on the wire
+	raw := dialRaw(t, base+3, "/ws/fragmented?size=10000&fragment=1000")
+	_ = raw.SetReadDeadline(time.Now().Add(3 * time.Second))
+	br := bufio.NewReader(raw)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("handshake: %v", err)
+		}
+		if line == "\r\n" {
+			break
+		}
+	}
+	var frames []byte
+	var payload []byte
+	for {
+		var h [2]byte
+		if _, err := io.ReadFull(br, h[:]); err != nil {
+			t.Fatalf("frame header: %v", err)
+		}
+		n := int(h[1] & 0x7f)
+		switch n {
+		case 126:
+			var ext [2]byte
+			_, _ = io.ReadFull(br, ext[:])
+			n = int(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			_, _ = io.ReadFull(br, ext[:])
+			n = int(binary.BigEndian.Uint64(ext[:]))
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(br, body); err != nil {
+			t.Fatalf("frame body: %v", err)
+		}
+		frames = append(frames, h[0])
+		payload = append(payload, body...)
+		if h[0]&0x80 != 0 {
+			break
+		}
+	}
+	if len(frames) != 10 || frames[0] != 0x02 || frames[1] != 0x00 || frames[9] != 0x80 || len(payload) != 10000 || payload[257] != 1 {
+		t.Fatalf("frames % x payload=%d", frames, len(payload))
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/ws/binary?kind=fixture&fixture=missing", base+3))
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("missing fixture status=%d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,164 @@
+package wsserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+
+	"github.com/gorilla/websocket"
+)
+
+// Close modes for server-initiated termination.
+const (
+	// closeFrame sends a close frame (the default).
+	closeFrame = "frame"
+	// closeReset aborts the TCP connection with RST, no close frame.
+	closeReset = "reset"
+	// closeDrop closes the TCP connection with FIN, no close frame.
+	closeDrop = "drop"
+	// closeHalf shuts down the write side only and keeps reading.
+	closeHalf = "halfclose"
+)
+
+const defaultCloseWait = 5 * time.Second
+
+// closePlan describes how the server ends a connection. It is read from the
+// upgrade query and overrides the code, reason and mode each handler would
+// otherwise use:
+//   - closeCode, closeReason: any code (1001, 1008, 1011, 4000-4999, ...)
+//   - closeMode: frame, reset, drop or halfclose
+//   - afterClose: data frames still sent after the close frame
+//   - clientClose=ignore: never answer the client's close frame
+//   - closeWait: ms to hold half-closed or ignored connections (default 5000)
+//   - closeAfterMs: close the connection from the server side after ms
+type closePlan struct {
+	Mode              string
+	Code              int
+	Reason            string
+	HasReason         bool
+	AfterClose        int
+	IgnoreClientClose bool
+	Wait              time.Duration
+	After             time.Duration
+}
+
+func closePlanFromQuery(r *http.Request) closePlan {
+	q := r.URL.Query()
+	atoi := func(k string) int {
+		n, _ := strconv.Atoi(q.Get(k))
+		return n
+	}
+	p := closePlan{
+		Mode:              q.Get("closeMode"),
+		Code:              atoi("closeCode"),
+		Reason:            q.Get("closeReason"),
+		HasReason:         q.Has("closeReason"),
+		AfterClose:        atoi("afterClose"),
+		IgnoreClientClose: q.Get("clientClose") == "ignore",
+		Wait:              time.Duration(atoi("closeWait")) * time.Millisecond,
+		After:             time.Duration(atoi("closeAfterMs")) * time.Millisecond,
+	}
+	if p.Wait <= 0 {
+		p.Wait = defaultCloseWait
+	}
+	return p
+}
+
+// closeWith ends the connection the way the handler intends (code, reason),
+// unless the upgrade query asked for something else.
+func (c *wsConn) closeWith(code int, reason string) error {
+	p := c.plan
+	if p.Code == 0 {
+		p.Code = code
+	}
+	if !p.HasReason {
+		p.Reason = reason
+	}
+	return c.terminate(p)
+}
+
+// terminate applies p once; later calls are no-ops.
+func (c *wsConn) terminate(p closePlan) error {
+	var err error
+	c.closeOnce.Do(func() { err = c.doTerminate(p) })
+	return err
+}
+
+func (c *wsConn) doTerminate(p closePlan) error {
+	sp := c.sp
+	switch p.Mode {
+	case closeReset:
+		common.Logf("WS %s close: reset", sp.Name)
+		nc := c.NetConn()
+		if tc, ok := nc.(*tls.Conn); ok {
+			nc = tc.NetConn()
+		}
+		if tcp, ok := nc.(*net.TCPConn); ok {
+			_ = tcp.SetLinger(0)
+		}
+		return c.NetConn().Close()
+	case closeDrop:
+		common.Logf("WS %s close: drop", sp.Name)
+		return c.NetConn().Close()
+	case closeHalf:
+		common.Logf("WS %s close: half-close", sp.Name)
+		cw, ok := c.NetConn().(interface{ CloseWrite() error })
+		if !ok {
+			return fmt.Errorf("half-close unsupported on %T", c.NetConn())
+		}
+		if err := cw.CloseWrite(); err != nil {
+			return err
+		}
+		c.waitRead(p.Wait)
+		return nil
+	}
+	code := p.Code
+	if code == 0 {
+		code = websocket.CloseNormalClosure
+	}
+	if err := writeControlLogged(c, sp, websocket.CloseMessage, websocket.FormatCloseMessage(code, truncateReason(p.Reason)), time.Now().Add(time.Second)); err != nil {
+		return err
+	}
+	for i := 1; i <= p.AfterClose; i++ {
+		// gorilla refuses writes after a close frame, so frame these by hand
+		payload := []byte(fmt.Sprintf("after-close %d", i))
+		logWsFrame(sp, "send", websocket.TextMessage, payload)
+		c.mu.Lock()
+		_, err := c.NetConn().Write(rawTextFrame(payload))
+		c.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawTextFrame encodes an unmasked, final text frame.
+func rawTextFrame(payload []byte) []byte {
+	n := len(payload)
+	var b []byte
+	switch {
+	case n < 126:
+		b = []byte{0x81, byte(n)}
+	case n <= 0xffff:
+		b = []byte{0x81, 126, byte(n >> 8), byte(n)}
+	default:
+		b = []byte{0x81, 127, 0, 0, 0, 0, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	return append(b, payload...)
+}
+
+// waitRead blocks until the read side ends or d elapses.
+func (c *wsConn) waitRead(d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-c.readDone:
+	case <-t.C:
+	}
+}
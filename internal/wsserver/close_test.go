@@ -0,0 +1,123 @@
+package wsserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRawTextFrame(t *testing.T) {
+	if got := rawTextFrame([]byte("hi")); !bytes.Equal(got, []byte{0x81, 2, 'h', 'i'}) {
+		t.Fatalf("short frame % x", got)
+	}
+	if got := rawTextFrame(make([]byte, 300)); !bytes.Equal(got[:4], []byte{0x81, 126, 1, 44}) || len(got) != 304 {
+		t.Fatalf("medium frame header % x", got[:4])
+	}
+}
+
+func TestWsCloseModes(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+	hdr := http.Header{"X-Auth-Token": {staticWsToken}}
+	dial := func(query string) *websocket.Conn {
+		t.Helper()
+		c, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws/echo?%s", base+3, query), hdr)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		_ = c.SetReadDeadline(time.Now().Add(3 * time.Second))
+		return c
+	}
+	readErr := func(c *websocket.Conn) error {
+		t.Helper()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// any code and reason
+	err = readErr(dial("closeAfterMs=50&closeCode=4003&closeReason=maintenance"))
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != 4003 || ce.Text != "maintenance" {
+		t.Fatalf("coded close: %v", err)
+	}
+
+	// drop: FIN without a close frame surfaces as 1006
+	if err := readErr(dial("closeAfterMs=50&closeMode=drop")); !websocket.IsCloseError(err, websocket.CloseAbnormalClosure) {
+		t.Fatalf("drop: %v", err)
+	}
+
+	// reset: RST instead of FIN
+	if err := readErr(dial("closeAfterMs=50&closeMode=reset")); !strings.Contains(err.Error(), "reset") {
+		t.Fatalf("reset: %v", err)
+	}
+
+	// half-close: no close frame, but the server still reads
+	c := dial("closeAfterMs=50&closeMode=halfclose&closeWait=1000")
+	if err := readErr(c); !websocket.IsCloseError(err, websocket.CloseAbnormalClosure) {
+		t.Fatalf("halfclose: %v", err)
+	}
+
+	// ignore: the client's close frame is never answered
+	c = dial("clientClose=ignore&closeWait=400")
+	_ = c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "client"), time.Now().Add(time.Second))
+	_ = c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := c.ReadMessage(); err == nil || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("ignore answered: %v", err)
+	}
+
+	// afterClose: data frames follow the close frame on the wire
+	raw := dialRaw(t, base+3, "/ws/echo?closeAfterMs=50&afterClose=2")
+	_ = raw.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var got []byte
+	buf := make([]byte, 512)
+	for !bytes.Contains(got, []byte("after-close 2")) {
+		n, err := raw.Read(buf)
+		if err != nil {
+			t.Fatalf("raw read: %v (got %q)", err, got)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if i, j := bytes.Index(got, []byte{0x88}), bytes.Index(got, []byte("after-close 1")); i < 0 || j < i {
+		t.Fatalf("close frame not before data: %q", got)
+	}
+}
+
+// dialRaw performs the upgrade by hand so frames after the close frame stay visible.
+func dialRaw(t *testing.T, port int, path string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatalf("dial raw: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	req := "GET " + path + " HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\nX-Auth-Token: " + staticWsToken + "\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatalf("write upgrade: %v", err)
+	}
+	return conn
+}
@@ -0,0 +1,90 @@
+package wsserver
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"intercept-wave-upstream/internal/admin"
+	"intercept-wave-upstream/internal/common"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsConn serializes writes to an upgraded connection so handlers and the
+// admin push API can write concurrently. Reads stay with the handler.
+type wsConn struct {
+	*websocket.Conn
+	sp   WsSpec
+	mu   sync.Mutex
+	plan closePlan
+
+	closeOnce    sync.Once
+	readOnce     sync.Once
+	readDone     chan struct{}
+	clientClosed atomic.Bool
+	lastRecv     atomic.Int64
+	lastPong     atomic.Int64
+}
+
+func newWSConn(raw *websocket.Conn, sp WsSpec, plan closePlan) *wsConn {
+	c := &wsConn{Conn: raw, sp: sp, plan: plan, readDone: make(chan struct{})}
+	c.installControlHandlers()
+	if plan.IgnoreClientClose {
+		raw.SetCloseHandler(func(code int, text string) error {
+			common.Logf("WS %s client close %d %q ignored", sp.Name, code, text)
+			c.clientClosed.Store(true)
+			return nil
+		})
+	}
+	return c
+}
+
+// ReadMessage reads the next data frame and records when the read side ends.
+func (c *wsConn) ReadMessage() (int, []byte, error) {
+	t, b, err := c.Conn.ReadMessage()
+	if err != nil {
+		c.readOnce.Do(func() { close(c.readDone) })
+	} else {
+		c.touch()
+	}
+	return t, b, err
+}
+
+// WriteMessage writes one data frame under the write lock.
+func (c *wsConn) WriteMessage(t int, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.WriteMessage(t, payload)
+}
+
+// writeMessageWithin writes one data frame with a write deadline of wait.
+// The deadline is set and cleared under the write lock so it never applies
+// to a concurrent writer such as an admin push.
+func (c *wsConn) writeMessageWithin(t int, payload []byte, wait time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(wait))
+	defer c.Conn.SetWriteDeadline(time.Time{})
+	return c.Conn.WriteMessage(t, payload)
+}
+
+// push writes an admin frame to the connection.
+func (c *wsConn) push(f admin.Frame) error {
+	payload, _ := f.Payload()
+	deadline := time.Now().Add(time.Second)
+	switch f.Type {
+	case "binary":
+		return writeMessageLogged(c, c.sp, websocket.BinaryMessage, payload)
+	case "ping":
+		return writeControlLogged(c, c.sp, websocket.PingMessage, payload, deadline)
+	case "close":
+		code := f.Code
+		if code == 0 {
+			code = websocket.CloseNormalClosure
+		}
+		return writeControlLogged(c, c.sp, websocket.CloseMessage, websocket.FormatCloseMessage(code, f.Reason), deadline)
+	default:
+		return writeMessageLogged(c, c.sp, websocket.TextMessage, payload)
+	}
+}
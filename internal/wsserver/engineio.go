@@ -0,0 +1,471 @@
+package wsserver
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+
+	"github.com/gorilla/websocket"
+)
+
+// Engine.IO v4 packet types.
+const (
+	eioOpen    = '0'
+	eioClose   = '1'
+	eioPing    = '2'
+	eioPong    = '3'
+	eioMessage = '4'
+	eioUpgrade = '5'
+	eioNoop    = '6'
+)
+
+const (
+	eioRecordSep           = "\x1e"
+	eioMaxPayload          = 1000000
+	eioDefaultPingInterval = 25 * time.Second
+	eioDefaultPingTimeout  = 20 * time.Second
+)
+
+// eioPacket is one Engine.IO packet: encoded text ("4..." etc.) or a binary
+// attachment.
+type eioPacket struct {
+	text   string
+	binary []byte
+}
+
+// eioServer holds the Engine.IO sessions and Socket.IO rooms of one service.
+type eioServer struct {
+	sp WsSpec
+
+	mu       sync.Mutex
+	sessions map[string]*eioSession
+	rooms    map[string]map[*sioSocket]struct{}
+}
+
+// eioSession is one Engine.IO connection. Packets are queued while the
+// transport is polling (or upgrading) and written directly once it is a
+// WebSocket.
+type eioSession struct {
+	id                        string
+	srv                       *eioServer
+	r                         *http.Request
+	pingInterval, pingTimeout time.Duration
+
+	mu        sync.Mutex
+	transport string
+	queue     []eioPacket
+	polling   bool
+	upgrading bool
+	ws        *wsConn
+	closed    bool
+	sockets   map[string]*sioSocket
+	partial   *sioPacket
+	parts     [][]byte
+
+	wake      chan struct{}
+	pong      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newEIOServer(sp WsSpec) *eioServer {
+	return &eioServer{sp: sp, sessions: map[string]*eioSession{}, rooms: map[string]map[*sioSocket]struct{}{}}
+}
+
+func eioID() string {
+	b := make([]byte, 10)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// eioError answers with the Engine.IO error body.
+func eioError(w http.ResponseWriter, code int, msg string) {
+	common.JSON(w, http.StatusBadRequest, map[string]interface{}{"code": code, "message": msg})
+}
+
+// socketioRoutes mounts /socket.io/: Engine.IO v4 (polling, websocket and
+// upgrade) carrying Socket.IO v5 packets (see socketio.go).
+func socketioRoutes(mux *http.ServeMux, sp WsSpec) {
+	srv := newEIOServer(sp)
+	handleWS(mux, sp, "/socket.io/", srv.serveHTTP)
+}
+
+func (s *eioServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("EIO") != "4" {
+		eioError(w, 5, "Unsupported protocol version")
+		return
+	}
+	sid := q.Get("sid")
+	switch q.Get("transport") {
+	case "polling":
+		if sid == "" {
+			if r.Method != http.MethodGet {
+				eioError(w, 2, "Bad handshake method")
+				return
+			}
+			if _, ok := authorize(w, r, s.sp); !ok {
+				return
+			}
+			sess := s.newSession(r, "polling")
+			writePolling(w, []eioPacket{sess.openPacket()})
+			return
+		}
+		sess := s.session(sid)
+		if sess == nil {
+			eioError(w, 1, "Session ID unknown")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			sess.poll(w, r)
+		case http.MethodPost:
+			sess.post(w, r)
+		default:
+			eioError(w, 3, "Bad request")
+		}
+	case "websocket":
+		s.serveWebSocket(w, r, sid)
+	default:
+		eioError(w, 0, "Transport unknown")
+	}
+}
+
+func (s *eioServer) newSession(r *http.Request, transport string) *eioSession {
+	sess := &eioSession{
+		id:           eioID(),
+		srv:          s,
+		r:            r,
+		pingInterval: eioDefaultPingInterval,
+		pingTimeout:  eioDefaultPingTimeout,
+		transport:    transport,
+		sockets:      map[string]*sioSocket{},
+		wake:         make(chan struct{}, 1),
+		pong:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+	q := r.URL.Query()
+	if n, err := strconv.Atoi(q.Get("pingInterval")); err == nil && n > 0 {
+		sess.pingInterval = time.Duration(n) * time.Millisecond
+	}
+	if n, err := strconv.Atoi(q.Get("pingTimeout")); err == nil && n > 0 {
+		sess.pingTimeout = time.Duration(n) * time.Millisecond
+	}
+	s.mu.Lock()
+	s.sessions[sess.id] = sess
+	s.mu.Unlock()
+	common.Logf("SIO %s session %s open (%s)", s.sp.Name, sess.id, transport)
+	go sess.heartbeat()
+	return sess
+}
+
+func (s *eioServer) session(id string) *eioSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id]
+}
+
+func (sess *eioSession) openPacket() eioPacket {
+	upgrades := []string{}
+	if sess.transport == "polling" {
+		upgrades = append(upgrades, "websocket")
+	}
+	b, _ := json.Marshal(map[string]interface{}{
+		"sid":          sess.id,
+		"upgrades":     upgrades,
+		"pingInterval": sess.pingInterval.Milliseconds(),
+		"pingTimeout":  sess.pingTimeout.Milliseconds(),
+		"maxPayload":   eioMaxPayload,
+	})
+	return eioPacket{text: string(eioOpen) + string(b)}
+}
+
+// writePolling encodes packets as one polling payload: records separated by
+// 0x1e, binary packets as "b" + base64.
+func writePolling(w http.ResponseWriter, pkts []eioPacket) {
+	parts := make([]string, len(pkts))
+	for i, p := range pkts {
+		if p.binary != nil {
+			parts[i] = "b" + base64.StdEncoding.EncodeToString(p.binary)
+		} else {
+			parts[i] = p.text
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, strings.Join(parts, eioRecordSep))
+}
+
+// poll is the long-polling GET: it returns once packets are queued.
+func (sess *eioSession) poll(w http.ResponseWriter, r *http.Request) {
+	sess.mu.Lock()
+	if sess.polling || sess.transport != "polling" {
+		sess.mu.Unlock()
+		eioError(w, 3, "Bad request")
+		return
+	}
+	sess.polling = true
+	sess.mu.Unlock()
+	defer func() {
+		sess.mu.Lock()
+		sess.polling = false
+		sess.mu.Unlock()
+	}()
+	for {
+		sess.mu.Lock()
+		if len(sess.queue) > 0 || sess.closed {
+			pkts, closed := sess.queue, sess.closed
+			sess.queue = nil
+			sess.mu.Unlock()
+			if closed && len(pkts) == 0 {
+				pkts = []eioPacket{{text: string(eioClose)}}
+			}
+			writePolling(w, pkts)
+			return
+		}
+		sess.mu.Unlock()
+		select {
+		case <-sess.wake:
+		case <-sess.done:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// post decodes a polling payload sent by the client.
+func (sess *eioSession) post(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, eioMaxPayload+1))
+	if err != nil || len(body) > eioMaxPayload {
+		eioError(w, 3, "Bad request")
+		return
+	}
+	for _, rec := range strings.Split(string(body), eioRecordSep) {
+		if rec == "" {
+			continue
+		}
+		if rec[0] == 'b' {
+			b, err := base64.StdEncoding.DecodeString(rec[1:])
+			if err != nil {
+				eioError(w, 3, "Bad request")
+				return
+			}
+			sess.handleBinary(b)
+			continue
+		}
+		sess.handleText(rec)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	_, _ = io.WriteString(w, "ok")
+}
+
+// serveWebSocket runs the websocket transport, either from scratch or as an
+// upgrade of a polling session (2probe / 3probe / 5).
+func (s *eioServer) serveWebSocket(w http.ResponseWriter, r *http.Request, sid string) {
+	if sid == "" {
+		c, release, ok := accept(w, r, s.sp)
+		if !ok {
+			return
+		}
+		defer release()
+		sess := s.newSession(r, "websocket")
+		sess.mu.Lock()
+		sess.ws = c
+		sess.writeWSLocked(sess.openPacket())
+		sess.mu.Unlock()
+		sess.readWS(c)
+		return
+	}
+	sess := s.session(sid)
+	if sess == nil {
+		eioError(w, 1, "Session ID unknown")
+		return
+	}
+	sess.mu.Lock()
+	if sess.transport != "polling" || sess.upgrading {
+		sess.mu.Unlock()
+		eioError(w, 3, "Bad request")
+		return
+	}
+	sess.upgrading = true
+	sess.mu.Unlock()
+	failed := func() {
+		sess.mu.Lock()
+		sess.upgrading = false
+		sess.mu.Unlock()
+	}
+	c, release, ok := accept(w, r, s.sp)
+	if !ok {
+		failed()
+		return
+	}
+	defer release()
+	_ = c.SetReadDeadline(time.Now().Add(sess.pingTimeout))
+	if t, msg, err := c.ReadMessage(); err != nil || t != websocket.TextMessage || string(msg) != "2probe" {
+		failed()
+		return
+	}
+	logWsFrame(s.sp, "recv", websocket.TextMessage, []byte("2probe"))
+	if err := writeMessageLogged(c, s.sp, websocket.TextMessage, []byte("3probe")); err != nil {
+		failed()
+		return
+	}
+	// flush the pending GET so the client can finish the upgrade
+	sess.send(eioPacket{text: string(eioNoop)})
+	if t, msg, err := c.ReadMessage(); err != nil || t != websocket.TextMessage || string(msg) != string(eioUpgrade) {
+		failed()
+		return
+	}
+	logWsFrame(s.sp, "recv", websocket.TextMessage, []byte{eioUpgrade})
+	_ = c.SetReadDeadline(time.Time{})
+	sess.mu.Lock()
+	sess.transport, sess.ws, sess.upgrading = "websocket", c, false
+	pending := sess.queue
+	sess.queue = nil
+	for _, p := range pending {
+		sess.writeWSLocked(p)
+	}
+	sess.mu.Unlock()
+	sess.wakeUp()
+	common.Logf("SIO %s session %s upgraded to websocket", s.sp.Name, sess.id)
+	sess.readWS(c)
+}
+
+func (sess *eioSession) readWS(c *wsConn) {
+	sp := sess.srv.sp
+	defer sess.close("transport close")
+	for {
+		t, msg, err := c.ReadMessage()
+		if err != nil {
+			common.Logf("WS %s recv loop end: %v", sp.Name, err)
+			return
+		}
+		logWsFrame(sp, "recv", t, msg)
+		if t == websocket.BinaryMessage {
+			sess.handleBinary(msg)
+		} else {
+			sess.handleText(string(msg))
+		}
+	}
+}
+
+func (sess *eioSession) writeWSLocked(p eioPacket) {
+	sp := sess.srv.sp
+	if p.binary != nil {
+		_ = writeMessageLogged(sess.ws, sp, websocket.BinaryMessage, p.binary)
+		return
+	}
+	_ = writeMessageLogged(sess.ws, sp, websocket.TextMessage, []byte(p.text))
+}
+
+func (sess *eioSession) wakeUp() {
+	select {
+	case sess.wake <- struct{}{}:
+	default:
+	}
+}
+
+// send writes packets in order: directly on a WebSocket transport, queued
+// for the next poll otherwise.
+func (sess *eioSession) send(pkts ...eioPacket) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.closed {
+		return
+	}
+	if sess.transport == "websocket" && sess.ws != nil {
+		for _, p := range pkts {
+			sess.writeWSLocked(p)
+		}
+		return
+	}
+	sess.queue = append(sess.queue, pkts...)
+	sess.wakeUp()
+}
+
+func (sess *eioSession) handleText(s string) {
+	if s == "" {
+		return
+	}
+	switch s[0] {
+	case eioPing:
+		sess.send(eioPacket{text: string(eioPong) + s[1:]})
+	case eioPong:
+		select {
+		case sess.pong <- struct{}{}:
+		default:
+		}
+	case eioMessage:
+		sess.onMessage(s[1:])
+	case eioClose:
+		sess.close("client close")
+	}
+}
+
+// heartbeat pings every pingInterval and closes the session when a pong
+// does not arrive within pingTimeout.
+func (sess *eioSession) heartbeat() {
+	for {
+		select {
+		case <-time.After(sess.pingInterval):
+		case <-sess.done:
+			return
+		}
+		select {
+		case <-sess.pong:
+		default:
+		}
+		sess.send(eioPacket{text: string(eioPing)})
+		select {
+		case <-sess.pong:
+		case <-time.After(sess.pingTimeout):
+			sess.close("ping timeout")
+			return
+		case <-sess.done:
+			return
+		}
+	}
+}
+
+// close ends the session, its namespaces and its transport.
+func (sess *eioSession) close(reason string) {
+	sess.closeOnce.Do(func() {
+		s := sess.srv
+		s.mu.Lock()
+		delete(s.sessions, sess.id)
+		s.mu.Unlock()
+		sess.mu.Lock()
+		sess.closed = true
+		sockets := sess.sockets
+		sess.sockets = map[string]*sioSocket{}
+		ws := sess.ws
+		sess.mu.Unlock()
+		for _, k := range sockets {
+			k.disconnect()
+		}
+		close(sess.done)
+		if ws != nil {
+			_ = ws.Close()
+		}
+		common.Logf("SIO %s session %s closed: %s", s.sp.Name, sess.id, reason)
+	})
+}
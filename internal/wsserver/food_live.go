@@ -0,0 +1,167 @@
+package wsserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/food"
+)
+
+// Roles of the live food sockets and the actions each may send.
+const (
+	foodRoleUser     = "user"
+	foodRoleMerchant = "merchant"
+)
+
+var foodRoleActions = map[string]map[string]bool{
+	foodRoleUser:     {"create": true, food.ActionSubmit: true, food.ActionCancel: true},
+	foodRoleMerchant: {food.ActionAccept: true, food.ActionReject: true, food.ActionReady: true},
+}
+
+// serveLiveFood connects a food socket to the shared order store: it sends a
+// "subscribed" snapshot, pushes every matching state change and applies the
+// actions the client sends, e.g. {"type":"accept","orderId":"100001"}.
+//
+// Query: orderId narrows to one order; userId (user role) or merchantId
+// (merchant role) narrows to one party.
+func serveLiveFood(c *wsConn, sp WsSpec, r *http.Request, role string) {
+	key := eventKeyForService(sp)
+	q := r.URL.Query()
+	filter := food.Filter{OrderID: q.Get("orderId")}
+	if role == foodRoleMerchant {
+		filter.MerchantID = q.Get("merchantId")
+		filter.SkipCreated = true
+	} else {
+		filter.UserID = q.Get("userId")
+	}
+	events, cancel := food.Default.Subscribe(filter)
+	defer cancel()
+
+	replies := make(chan map[string]interface{}, 16)
+	stop := make(chan struct{})
+	defer close(stop)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			t, msg, err := c.ReadMessage()
+			if err != nil {
+				common.Logf("WS %s recv loop end: %v", sp.Name, err)
+				return
+			}
+			logWsFrame(sp, "recv", t, msg)
+			select {
+			case replies <- handleFoodAction(sp, role, key, msg):
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	orders := []food.Order{}
+	for _, o := range food.Default.List() {
+		if filter.MatchOrder(o) {
+			orders = append(orders, o)
+		}
+	}
+	if err := writeJSONWithLog(c, sp, map[string]interface{}{key: "subscribed", "role": role, "orders": orders}); err != nil {
+		return
+	}
+	write := func(m map[string]interface{}) bool {
+		if err := writeJSONWithLog(c, sp, m); err != nil {
+			log.Printf("food %s write: %v", role, err)
+			return false
+		}
+		return true
+	}
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok || !write(foodEventFrame(ev, role, key)) {
+				return
+			}
+		case m := <-replies:
+			// the store publishes before the action returns: flush those
+			// events first so the ack follows the change it caused
+			for flushing := true; flushing; {
+				select {
+				case ev, ok := <-events:
+					if !ok || !write(foodEventFrame(ev, role, key)) {
+						return
+					}
+				default:
+					flushing = false
+				}
+			}
+			if !write(m) {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// handleFoodAction applies one client message and returns the ack or error frame.
+func handleFoodAction(sp WsSpec, role, key string, msg []byte) map[string]interface{} {
+	var in map[string]interface{}
+	if err := json.Unmarshal(msg, &in); err != nil {
+		return map[string]interface{}{key: "error", "message": "expected a JSON object"}
+	}
+	action := ""
+	for _, k := range []string{key, "type", "action", "event"} {
+		if v, ok := in[k].(string); ok && v != "" {
+			action = v
+			break
+		}
+	}
+	orderID, _ := in["orderId"].(string)
+	if !foodRoleActions[role][action] {
+		return map[string]interface{}{key: "error", "request": action, "orderId": orderID, "message": "action not allowed for " + role}
+	}
+	actor := sp.Name + "/" + role
+	if action == "create" {
+		fields, _ := in["order"].(map[string]interface{})
+		o := food.Default.Create(fields, actor)
+		return map[string]interface{}{key: "ack", "request": action, "orderId": o.ID, "status": o.Status}
+	}
+	reason, _ := in["reason"].(string)
+	ev, err := food.Default.Apply(orderID, action, actor, reason)
+	if err != nil {
+		frame := map[string]interface{}{key: "error", "request": action, "orderId": orderID, "message": err.Error()}
+		var te *food.TransitionError
+		if errors.As(err, &te) {
+			frame["status"] = te.Status
+		}
+		return frame
+	}
+	return map[string]interface{}{key: "ack", "request": action, "orderId": ev.OrderID, "status": ev.Status}
+}
+
+// foodEventFrame renders a store event for a role; merchants see a newly
+// submitted order as "new_order", like the replay flow.
+func foodEventFrame(ev food.Event, role, key string) map[string]interface{} {
+	typ := ev.Type
+	if role == foodRoleMerchant && ev.Action == food.ActionSubmit {
+		typ = "new_order"
+	}
+	m := map[string]interface{}{
+		key:          typ,
+		"orderId":    ev.OrderID,
+		"status":     ev.Status,
+		"transition": ev.Action,
+		"actor":      ev.Actor,
+		"order":      ev.Order,
+		"timestamp":  ev.Time.UnixMilli(),
+	}
+	if ev.Previous != "" {
+		m["previous"] = ev.Previous
+	}
+	if ev.Order.Reason != "" {
+		m["reason"] = ev.Order.Reason
+	}
+	return m
+}
@@ -0,0 +1,120 @@
+package wsserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/food"
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWsFoodLiveFlow(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	hdr := http.Header{"X-Auth-Token": {staticWsToken}}
+	dial := func(path string) *websocket.Conn {
+		t.Helper()
+		c, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d%s", base+3, path), hdr)
+		if err != nil {
+			t.Fatalf("dial %s: %v", path, err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		return c
+	}
+	next := func(c *websocket.Conn, want string) map[string]interface{} {
+		t.Helper()
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var ev map[string]interface{}
+		if err := c.ReadJSON(&ev); err != nil {
+			t.Fatalf("read %s: %v", want, err)
+		}
+		if ev["type"] != want {
+			t.Fatalf("got %v, want %s", ev, want)
+		}
+		return ev
+	}
+
+	user := dial("/ws/food/user?userId=u-live")
+	next(user, "subscribed")
+	merchant := dial("/ws/food/merchant?merchantId=m-live")
+	next(merchant, "subscribed")
+
+	// the order service creates and submits orders through the same store
+	o := food.Default.Create(map[string]interface{}{"userId": "u-live", "merchantId": "m-live"}, "order-service")
+	next(user, "order_created")
+	if _, err := food.Default.Apply(o.ID, food.ActionSubmit, "order-service", ""); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	next(user, "order_submitted")
+	if ev := next(merchant, "new_order"); ev["orderId"] != o.ID {
+		t.Fatalf("new_order=%v", ev)
+	}
+
+	_ = merchant.WriteJSON(map[string]interface{}{"type": "ready", "orderId": o.ID})
+	if ev := next(merchant, "error"); ev["status"] != food.StatusSubmitted {
+		t.Fatalf("error=%v", ev)
+	}
+	for _, step := range []struct{ action, event string }{{"accept", "order_accepted"}, {"ready", "order_ready"}} {
+		_ = merchant.WriteJSON(map[string]interface{}{"type": step.action, "orderId": o.ID})
+		next(merchant, step.event)
+		next(merchant, "ack")
+		if ev := next(user, step.event); ev["orderId"] != o.ID {
+			t.Fatalf("%s=%v", step.event, ev)
+		}
+	}
+}
+
+func TestWsFoodReplayMode(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	// ws-ticker keys its events with "action"
+	url := fmt.Sprintf("ws://127.0.0.1:%d/ws/food/merchant?mode=replay&interval=1", base+4)
+	c, _, err := websocket.DefaultDialer.Dial(url, http.Header{"X-Auth-Token": {staticWsToken}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = c.Close() }()
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var events []interface{}
+	for {
+		var ev map[string]interface{}
+		if err := c.ReadJSON(&ev); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Fatalf("read: %v", err)
+			}
+			break
+		}
+		events = append(events, ev["action"])
+	}
+	// assets/ws/food_merchant.json, not the live "subscribed" snapshot
+	if len(events) < 2 || events[0] != "new_order" || events[1] != "order_cancelled" {
+		t.Fatalf("replay events=%v", events)
+	}
+}
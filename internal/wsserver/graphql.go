@@ -0,0 +1,262 @@
+package wsserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/graphql"
+
+	"github.com/gorilla/websocket"
+)
+
+const gqlwsProtocol = "graphql-transport-ws"
+
+// graphql-transport-ws close codes.
+const (
+	gqlwsInvalidMessage      = 4400
+	gqlwsUnauthorized        = 4401
+	gqlwsSubprotocolRejected = 4406
+	gqlwsInitTimeout         = 4408
+	gqlwsSubscriberExists    = 4409
+	gqlwsTooManyInitRequests = 4429
+)
+
+const gqlwsDefaultInitTimeoutMs = 3000
+
+// gqlwsMessage is one graphql-transport-ws message. Payload is kept raw on
+// the way in and holds a result or error list on the way out.
+type gqlwsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type gqlwsOut struct {
+	ID      string      `json:"id,omitempty"`
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// gqlwsSession is the state of one graphql-transport-ws connection.
+type gqlwsSession struct {
+	c  *wsConn
+	sp WsSpec
+	wg sync.WaitGroup
+
+	mu     sync.Mutex
+	inited bool
+	acked  bool
+	subs   map[string]*gqlwsOperation
+}
+
+// gqlwsOperation is one running subscribe id. A client may reuse an id once
+// it sent complete, so entries are compared by pointer when they end.
+type gqlwsOperation struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// graphqlRoutes mounts /ws/graphql and /graphql: the graphql-transport-ws
+// protocol over the shared GraphQL schema. Subscriptions stream order
+// status changes; queries and mutations answer with one next and complete.
+// ?initTimeout=ms bounds the wait for connection_init (default 3000).
+func graphqlRoutes(mux *http.ServeMux, sp WsSpec) {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		initTimeout := gqlwsDefaultInitTimeoutMs
+		if v := r.URL.Query().Get("initTimeout"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": "initTimeout must be a positive number of ms"})
+				return
+			}
+			initTimeout = n
+		}
+		c, release, ok := accept(w, r, sp)
+		if !ok {
+			return
+		}
+		defer release()
+		if c.Subprotocol() != gqlwsProtocol {
+			c.expire(gqlwsSubprotocolRejected, "Subprotocol not acceptable")
+			return
+		}
+		// oversized messages close the socket with 1009 (message too big)
+		c.SetReadLimit(graphql.MaxRequestSize)
+		s := &gqlwsSession{c: c, sp: sp, subs: map[string]*gqlwsOperation{}}
+		defer s.close()
+		timer := time.AfterFunc(time.Duration(initTimeout)*time.Millisecond, func() {
+			s.mu.Lock()
+			inited := s.inited
+			s.mu.Unlock()
+			if !inited {
+				c.expire(gqlwsInitTimeout, "Connection initialisation timeout")
+			}
+		})
+		defer timer.Stop()
+		for {
+			t, msg, err := c.ReadMessage()
+			if err != nil {
+				common.Logf("WS %s graphql recv loop end: %v", sp.Name, err)
+				return
+			}
+			logWsFrame(sp, "recv", t, msg)
+			if t != websocket.TextMessage {
+				c.expire(gqlwsInvalidMessage, "Invalid message received")
+				return
+			}
+			if !s.handle(msg) {
+				return
+			}
+		}
+	}
+	handleWS(mux, sp, "/ws/graphql", h)
+	handleWS(mux, sp, "/graphql", h)
+}
+
+// close cancels running operations and waits for their goroutines.
+func (s *gqlwsSession) close() {
+	s.mu.Lock()
+	for id, op := range s.subs {
+		op.cancel()
+		delete(s.subs, id)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *gqlwsSession) send(m gqlwsOut) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		common.Logf("WS %s graphql marshal: %v", s.sp.Name, err)
+		return
+	}
+	_ = writeMessageLogged(s.c, s.sp, websocket.TextMessage, b)
+}
+
+// handle processes one client message and reports whether the connection
+// stays open.
+func (s *gqlwsSession) handle(raw []byte) bool {
+	var m gqlwsMessage
+	if err := json.Unmarshal(raw, &m); err != nil || m.Type == "" {
+		s.c.expire(gqlwsInvalidMessage, "Invalid message received")
+		return false
+	}
+	switch m.Type {
+	case "connection_init":
+		if len(m.Payload) > 0 && m.Payload[0] != '{' && string(m.Payload) != "null" {
+			s.c.expire(gqlwsInvalidMessage, "Invalid message received")
+			return false
+		}
+		s.mu.Lock()
+		again := s.inited
+		s.inited = true
+		s.mu.Unlock()
+		if again {
+			s.c.expire(gqlwsTooManyInitRequests, "Too many initialisation requests")
+			return false
+		}
+		s.send(gqlwsOut{Type: "connection_ack"})
+		s.mu.Lock()
+		s.acked = true
+		s.mu.Unlock()
+	case "ping":
+		out := gqlwsOut{Type: "pong"}
+		if len(m.Payload) > 0 {
+			out.Payload = m.Payload
+		}
+		s.send(out)
+	case "pong":
+	case "subscribe":
+		return s.subscribe(m)
+	case "complete":
+		s.mu.Lock()
+		op, ok := s.subs[m.ID]
+		delete(s.subs, m.ID)
+		s.mu.Unlock()
+		if ok {
+			op.cancel()
+		}
+	default:
+		s.c.expire(gqlwsInvalidMessage, "Invalid message received")
+		return false
+	}
+	return true
+}
+
+// subscribe starts an operation. Request errors answer with an error
+// message; results stream as next messages until complete.
+func (s *gqlwsSession) subscribe(m gqlwsMessage) bool {
+	var req graphql.Request
+	if m.ID == "" || json.Unmarshal(m.Payload, &req) != nil || req.Query == "" {
+		s.c.expire(gqlwsInvalidMessage, "Invalid message received")
+		return false
+	}
+	s.mu.Lock()
+	if !s.acked {
+		s.mu.Unlock()
+		s.c.expire(gqlwsUnauthorized, "Unauthorized")
+		return false
+	}
+	if _, dup := s.subs[m.ID]; dup {
+		s.mu.Unlock()
+		s.c.expire(gqlwsSubscriberExists, "Subscriber for "+m.ID+" already exists")
+		return false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &gqlwsOperation{ctx: ctx, cancel: cancel}
+	s.subs[m.ID] = run
+	s.mu.Unlock()
+
+	// Subscriptions attach to their source before the next message is read,
+	// so events caused by later messages are not missed.
+	op, errs := graphql.Default.Prepare(req)
+	var results <-chan *graphql.Result
+	if len(errs) == 0 && op.Kind() == "subscription" {
+		var err error
+		if results, err = op.Subscribe(ctx); err != nil {
+			var gerr *graphql.Error
+			if !errors.As(err, &gerr) {
+				gerr = &graphql.Error{Message: err.Error()}
+			}
+			errs = []*graphql.Error{gerr}
+		}
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.finish(m.ID, run)
+		switch {
+		case len(errs) > 0:
+			s.send(gqlwsOut{ID: m.ID, Type: "error", Payload: errs})
+			cancel()
+		case results == nil:
+			s.send(gqlwsOut{ID: m.ID, Type: "next", Payload: op.Execute(ctx)})
+		default:
+			for res := range results {
+				s.send(gqlwsOut{ID: m.ID, Type: "next", Payload: res})
+			}
+		}
+	}()
+	return true
+}
+
+// finish sends complete unless the client completed the operation (or an
+// error message already ended it) and forgets the id.
+func (s *gqlwsSession) finish(id string, op *gqlwsOperation) {
+	s.mu.Lock()
+	active := s.subs[id] == op
+	if active {
+		delete(s.subs, id)
+	}
+	s.mu.Unlock()
+	if active && op.ctx.Err() == nil {
+		s.send(gqlwsOut{ID: id, Type: "complete"})
+	}
+	op.cancel()
+}
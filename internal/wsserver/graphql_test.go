@@ -0,0 +1,173 @@
+package wsserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/food"
+	"intercept-wave-upstream/internal/graphql"
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+type gqlwsClient struct {
+	t *testing.T
+	c *websocket.Conn
+}
+
+func dialGraphQLWS(t *testing.T, port int, query string, subprotocols ...string) *gqlwsClient {
+	t.Helper()
+	if subprotocols == nil {
+		subprotocols = []string{gqlwsProtocol}
+	}
+	d := websocket.Dialer{Subprotocols: subprotocols}
+	c, _, err := d.Dial(fmt.Sprintf("ws://127.0.0.1:%d/graphql%s", port, query), http.Header{"X-Auth-Token": {staticWsToken}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	_ = c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	return &gqlwsClient{t: t, c: c}
+}
+
+func (gc *gqlwsClient) send(m string) {
+	gc.t.Helper()
+	if err := gc.c.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+		gc.t.Fatalf("write: %v", err)
+	}
+}
+
+func (gc *gqlwsClient) read() string {
+	gc.t.Helper()
+	_, b, err := gc.c.ReadMessage()
+	if err != nil {
+		gc.t.Fatalf("read: %v", err)
+	}
+	return string(b)
+}
+
+func (gc *gqlwsClient) init() {
+	gc.t.Helper()
+	gc.send(`{"type":"connection_init","payload":{"token":"t"}}`)
+	if got := gc.read(); got != `{"type":"connection_ack"}` {
+		gc.t.Fatalf("ack=%s", got)
+	}
+}
+
+// closed expects the server to close with code and reason.
+func (gc *gqlwsClient) closed(code int, reason string) {
+	gc.t.Helper()
+	_, _, err := gc.c.ReadMessage()
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != code || ce.Text != reason {
+		gc.t.Fatalf("close: %v, want %d %q", err, code, reason)
+	}
+}
+
+func TestGraphQLTransportWS(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+		food.Default.Reset()
+	})
+	port := base + 3
+
+	gc := dialGraphQLWS(t, port, "")
+	if p := gc.c.Subprotocol(); p != gqlwsProtocol {
+		t.Fatalf("subprotocol=%q", p)
+	}
+	gc.init()
+	gc.send(`{"type":"ping","payload":{"n":1}}`)
+	if got := gc.read(); got != `{"type":"pong","payload":{"n":1}}` {
+		t.Fatalf("pong=%s", got)
+	}
+
+	// a subscription streams order status changes for one order
+	o := food.Default.Create(map[string]interface{}{"userId": "u-1"}, "test")
+	sub, _ := json.Marshal(map[string]interface{}{"id": "s1", "type": "subscribe", "payload": map[string]interface{}{
+		"query":     `subscription($id: ID) { orderStatusChanged(orderId: $id) { type status order { id } } }`,
+		"variables": map[string]interface{}{"id": o.ID},
+	}})
+	gc.send(string(sub))
+	// queries over the socket answer with next and complete
+	gc.send(`{"id":"q1","type":"subscribe","payload":{"query":"{ user(id: 3) { name } }"}}`)
+	if got := gc.read(); got != `{"id":"q1","type":"next","payload":{"data":{"user":{"name":"王五"}}}}` {
+		t.Fatalf("query next=%s", got)
+	}
+	if got := gc.read(); got != `{"id":"q1","type":"complete"}` {
+		t.Fatalf("query complete=%s", got)
+	}
+	if _, err := food.Default.Apply(o.ID, food.ActionSubmit, "test", ""); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if got := gc.read(); got != `{"id":"s1","type":"next","payload":{"data":{"orderStatusChanged":{"type":"order_submitted","status":"SUBMITTED","order":{"id":"`+o.ID+`"}}}}}` {
+		t.Fatalf("event=%s", got)
+	}
+
+	// request errors come back as an error message for the id
+	gc.send(`{"id":"bad","type":"subscribe","payload":{"query":"subscription { orderStatusChanged { nope } }"}}`)
+	if got := gc.read(); !strings.HasPrefix(got, `{"id":"bad","type":"error","payload":[{"message":"Cannot query field \"nope\" on type \"OrderEvent\".`) {
+		t.Fatalf("error=%s", got)
+	}
+
+	// documents nested past the parser limit are request errors too
+	deep, _ := json.Marshal(map[string]interface{}{"id": "deep", "type": "subscribe", "payload": map[string]interface{}{"query": strings.Repeat("{user(id:1)", 100000)}})
+	gc.send(string(deep))
+	if got := gc.read(); !strings.HasPrefix(got, `{"id":"deep","type":"error","payload":[{"message":"Document exceeds the maximum nesting depth of 64."`) {
+		t.Fatalf("deep error=%s", got)
+	}
+
+	// client complete stops the stream: the next frame is the pong
+	gc.send(`{"id":"s1","type":"complete"}`)
+	time.Sleep(50 * time.Millisecond)
+	if _, err := food.Default.Apply(o.ID, food.ActionAccept, "test", ""); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	gc.send(`{"type":"ping"}`)
+	if got := gc.read(); got != `{"type":"pong"}` {
+		t.Fatalf("after complete=%s", got)
+	}
+
+	// the id is free again; reusing a running id closes with 4409
+	gc.send(string(sub))
+	gc.send(string(sub))
+	gc.closed(gqlwsSubscriberExists, "Subscriber for s1 already exists")
+
+	early := dialGraphQLWS(t, port, "")
+	early.send(`{"id":"1","type":"subscribe","payload":{"query":"{ users { id } }"}}`)
+	early.closed(gqlwsUnauthorized, "Unauthorized")
+
+	twice := dialGraphQLWS(t, port, "")
+	twice.init()
+	twice.send(`{"type":"connection_init"}`)
+	twice.closed(gqlwsTooManyInitRequests, "Too many initialisation requests")
+
+	invalid := dialGraphQLWS(t, port, "")
+	invalid.init()
+	invalid.send(`{"type":"start","id":"1"}`)
+	invalid.closed(gqlwsInvalidMessage, "Invalid message received")
+
+	// messages over the request size limit close the socket with 1009
+	huge := dialGraphQLWS(t, port, "")
+	huge.init()
+	_ = huge.c.WriteMessage(websocket.TextMessage, []byte(`{"id":"1","type":"subscribe","payload":{"query":"`+strings.Repeat(" ", graphql.MaxRequestSize)+`{ users { id } }"}}`))
+	huge.closed(websocket.CloseMessageTooBig, "")
+
+	dialGraphQLWS(t, port, "?initTimeout=50").closed(gqlwsInitTimeout, "Connection initialisation timeout")
+	dialGraphQLWS(t, port, "", "graphql-ws").closed(gqlwsSubprotocolRejected, "Subprotocol not acceptable")
+}
@@ -0,0 +1,177 @@
+package wsserver
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+
+	"github.com/gorilla/websocket"
+)
+
+// heartbeatCloseGrace is how long an expired connection may take to answer
+// the close frame before its socket is closed.
+const heartbeatCloseGrace = time.Second
+
+// heartbeat is the keepalive policy of one connection: the endpoint options,
+// overridden by ?pingMs=, ?pongTimeoutMs=, ?heartbeatMs= and ?idleTimeoutMs=.
+type heartbeat struct {
+	ping, pongTimeout, message, idle time.Duration
+	payload                          interface{}
+}
+
+func (hb heartbeat) enabled() bool {
+	return hb.ping > 0 || hb.message > 0 || hb.idle > 0
+}
+
+func (ep *endpoint) heartbeatFor(r *http.Request) heartbeat {
+	var hb heartbeat
+	if cfg := ep.opts.Heartbeat; cfg != nil {
+		hb = heartbeat{
+			ping:        time.Duration(cfg.PingIntervalMs) * time.Millisecond,
+			pongTimeout: time.Duration(cfg.PongTimeoutMs) * time.Millisecond,
+			message:     time.Duration(cfg.MessageIntervalMs) * time.Millisecond,
+			idle:        time.Duration(cfg.IdleTimeoutMs) * time.Millisecond,
+			payload:     cfg.Message,
+		}
+	}
+	q := r.URL.Query()
+	override := func(k string, d *time.Duration) {
+		if n, err := strconv.Atoi(q.Get(k)); err == nil && n >= 0 {
+			*d = time.Duration(n) * time.Millisecond
+		}
+	}
+	override("pingMs", &hb.ping)
+	override("pongTimeoutMs", &hb.pongTimeout)
+	override("heartbeatMs", &hb.message)
+	override("idleTimeoutMs", &hb.idle)
+	return hb
+}
+
+// installControlHandlers logs inbound pings and pongs and records them as
+// client activity. Pings are answered like the gorilla default handler.
+func (c *wsConn) installControlHandlers() {
+	sp := c.sp
+	c.SetPingHandler(func(data string) error {
+		c.touch()
+		logWsFrame(sp, "recv", websocket.PingMessage, []byte(data))
+		err := writeControlLogged(c, sp, websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			return nil
+		}
+		return err
+	})
+	c.SetPongHandler(func(data string) error {
+		c.touch()
+		c.lastPong.Store(time.Now().UnixNano())
+		logWsFrame(sp, "recv", websocket.PongMessage, []byte(data))
+		return nil
+	})
+}
+
+func (c *wsConn) touch() { c.lastRecv.Store(time.Now().UnixNano()) }
+
+// expire closes the connection for a heartbeat policy and drops the socket if
+// the client does not answer the close frame.
+func (c *wsConn) expire(code int, reason string) {
+	common.Logf("WS %s %s", c.sp.Name, reason)
+	_ = c.closeWith(code, reason)
+	time.AfterFunc(heartbeatCloseGrace, func() { _ = c.NetConn().Close() })
+}
+
+// startHeartbeat runs the keepalive policy until stop is called or the read
+// side ends.
+func (c *wsConn) startHeartbeat(hb heartbeat, r *http.Request) (stop func()) {
+	if !hb.enabled() {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go c.runHeartbeat(hb, r, done)
+	return func() { close(done) }
+}
+
+func (c *wsConn) runHeartbeat(hb heartbeat, r *http.Request, done <-chan struct{}) {
+	sp := c.sp
+	ticker := func(d time.Duration) (<-chan time.Time, func()) {
+		if d <= 0 {
+			return nil, func() {}
+		}
+		t := time.NewTicker(d)
+		return t.C, t.Stop
+	}
+	pingC, stopPing := ticker(hb.ping)
+	defer stopPing()
+	msgC, stopMsg := ticker(hb.message)
+	defer stopMsg()
+	idleCheck := hb.idle / 4
+	if hb.idle > 0 && idleCheck < 10*time.Millisecond {
+		idleCheck = 10 * time.Millisecond
+	}
+	idleC, stopIdle := ticker(idleCheck)
+	defer stopIdle()
+
+	payload := hb.payload
+	if payload == nil {
+		payload = map[string]interface{}{eventKeyForService(sp): "heartbeat", "time": "{{now.unixMilli}}"}
+	}
+	tctx := &common.TemplateContext{Path: map[string]string{}, Query: r.URL.Query(), Header: r.Header}
+
+	var (
+		pongTimer *time.Timer
+		pongC     <-chan time.Time
+		pingSent  time.Time
+		pings     int
+	)
+	defer func() {
+		if pongTimer != nil {
+			pongTimer.Stop()
+		}
+	}()
+	c.touch()
+	for {
+		select {
+		case <-done:
+			return
+		case <-c.readDone:
+			return
+		case <-pingC:
+			pings++
+			sent := time.Now()
+			if err := writeControlLogged(c, sp, websocket.PingMessage, []byte(strconv.Itoa(pings)), sent.Add(time.Second)); err != nil {
+				return
+			}
+			if hb.pongTimeout > 0 && pongTimer == nil {
+				pingSent = sent
+				pongTimer = time.NewTimer(hb.pongTimeout)
+				pongC = pongTimer.C
+			}
+		case <-pongC:
+			pongTimer, pongC = nil, nil
+			if c.lastPong.Load() < pingSent.UnixNano() {
+				c.expire(websocket.ClosePolicyViolation, "pong timeout")
+				return
+			}
+		case <-msgC:
+			var b []byte
+			switch v := common.RenderTemplate(payload, tctx).(type) {
+			case string:
+				b = []byte(v)
+			default:
+				b, _ = common.JsonMarshalCompat(v)
+			}
+			if err := writeMessageLogged(c, sp, websocket.TextMessage, b); err != nil {
+				return
+			}
+		case <-idleC:
+			if time.Since(time.Unix(0, c.lastRecv.Load())) >= hb.idle {
+				c.expire(websocket.CloseGoingAway, "idle timeout")
+				return
+			}
+		}
+	}
+}
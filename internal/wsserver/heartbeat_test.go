@@ -0,0 +1,98 @@
+package wsserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWsHeartbeat(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	off := 0
+	topo := []topology.WSService{{
+		Name:       "ws-heartbeat",
+		PortOffset: &off,
+		Bundles:    []string{"echo"},
+		Auth:       &topology.WSAuth{Mode: topology.AuthNone},
+		Endpoints: map[string]topology.WSOptions{"/ws/echo": {
+			Heartbeat: &topology.WSHeartbeat{PingIntervalMs: 50, PongTimeoutMs: 200},
+		}},
+	}}
+	srvs := StartAll(base, topo)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+	dial := func(query string) *websocket.Conn {
+		t.Helper()
+		c, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws/echo?%s", base, query), nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		return c
+	}
+	closeCode := func(c *websocket.Conn) (int, string) {
+		t.Helper()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				var ce *websocket.CloseError
+				if !errors.As(err, &ce) {
+					t.Fatalf("read: %v", err)
+				}
+				return ce.Code, ce.Text
+			}
+		}
+	}
+
+	// a client answering pings stays connected and gets app-level heartbeats
+	c := dial("heartbeatMs=40")
+	pings := 0
+	c.SetPingHandler(func(data string) error {
+		pings++
+		return c.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	beats := 0
+	for deadline := time.Now().Add(400 * time.Millisecond); time.Now().Before(deadline); {
+		var m map[string]interface{}
+		if err := c.ReadJSON(&m); err != nil {
+			t.Fatalf("alive client: %v", err)
+		}
+		if m["type"] == "heartbeat" {
+			beats++
+		}
+	}
+	if pings < 3 || beats < 3 {
+		t.Fatalf("pings=%d beats=%d", pings, beats)
+	}
+
+	// a client that never answers pings is closed after pongTimeoutMs
+	c = dial("")
+	c.SetPingHandler(func(string) error { return nil })
+	if code, reason := closeCode(c); code != websocket.ClosePolicyViolation || reason != "pong timeout" {
+		t.Fatalf("pong timeout close=%d %q", code, reason)
+	}
+
+	// idle timeout without pings
+	c = dial("pingMs=0&idleTimeoutMs=150")
+	start := time.Now()
+	if code, reason := closeCode(c); code != websocket.CloseGoingAway || reason != "idle timeout" {
+		t.Fatalf("idle close=%d %q", code, reason)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("idle close after %v", d)
+	}
+}
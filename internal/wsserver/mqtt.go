@@ -0,0 +1,848 @@
+package wsserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"intercept-wave-upstream/internal/admin"
+	"intercept-wave-upstream/internal/common"
+
+	"github.com/gorilla/websocket"
+)
+
+// MQTT control packet types.
+const (
+	mqttConnect     = 1
+	mqttConnack     = 2
+	mqttPublish     = 3
+	mqttPuback      = 4
+	mqttSubscribe   = 8
+	mqttSuback      = 9
+	mqttUnsubscribe = 10
+	mqttUnsuback    = 11
+	mqttPingreq     = 12
+	mqttPingresp    = 13
+	mqttDisconnect  = 14
+)
+
+// MQTT 5 property identifiers used by the broker.
+const (
+	mqttPropSubscriptionID   = 0x0B
+	mqttPropAssignedClientID = 0x12
+	mqttPropTopicAlias       = 0x23
+	mqttPropMaximumQoS       = 0x24
+	mqttPropRetainAvailable  = 0x25
+	mqttPropMaxPacketSize    = 0x27
+	mqttPropSharedAvailable  = 0x2A
+)
+
+// MQTT 5 reason codes sent by the broker (3.1.1 uses its own return codes).
+const (
+	mqttReasonNoSubscription  = 0x11
+	mqttReasonMalformed       = 0x81
+	mqttReasonProtocolError   = 0x82
+	mqttReasonBadFilter       = 0x8F
+	mqttReasonKeepAlive       = 0x8D
+	mqttReasonTakenOver       = 0x8E
+	mqttReasonQoSNotSupported = 0x9B
+	mqttReasonDisconnectWill  = 0x04
+)
+
+const (
+	// mqttMaxPacket bounds one control packet.
+	mqttMaxPacket = 1 << 20
+	// mqttMaxInflight bounds unacknowledged QoS 1 messages per session;
+	// beyond it messages go out at QoS 0.
+	mqttMaxInflight = 1000
+	// mqttTickTopic receives a retained tick every mqttTickInterval while
+	// clients are connected.
+	mqttTickTopic    = "ticker/tick"
+	mqttTickInterval = time.Second
+)
+
+// mqttProp is one MQTT 5 property with its encoded value.
+type mqttProp struct {
+	id    byte
+	value []byte
+}
+
+// mqttPropSizes gives the value size of fixed-width properties; 0 marks a
+// variable byte integer, -1 a length-prefixed string or binary, -2 a string
+// pair.
+var mqttPropSizes = map[byte]int{
+	0x01: 1, 0x17: 1, 0x19: 1, 0x24: 1, 0x25: 1, 0x28: 1, 0x29: 1, 0x2A: 1,
+	0x13: 2, 0x21: 2, 0x22: 2, 0x23: 2,
+	0x02: 4, 0x11: 4, 0x18: 4, 0x27: 4,
+	0x0B: 0,
+	0x03: -1, 0x08: -1, 0x09: -1, 0x12: -1, 0x15: -1, 0x16: -1, 0x1A: -1, 0x1C: -1, 0x1F: -1,
+	0x26: -2,
+}
+
+var errMQTTMalformed = errors.New("malformed packet")
+
+// mqttReader decodes the variable header and payload of a packet. The first
+// error sticks; later reads return zero values.
+type mqttReader struct {
+	b   []byte
+	err error
+}
+
+func (r *mqttReader) take(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.err = errMQTTMalformed
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *mqttReader) byte() byte {
+	if v := r.take(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (r *mqttReader) uint16() uint16 {
+	if v := r.take(2); v != nil {
+		return binary.BigEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (r *mqttReader) varint() int {
+	n, shift := 0, 0
+	for i := 0; i < 4; i++ {
+		c := r.byte()
+		n |= int(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return n
+		}
+		shift += 7
+	}
+	r.err = errMQTTMalformed
+	return 0
+}
+
+func (r *mqttReader) bytes() []byte { return r.take(int(r.uint16())) }
+
+func (r *mqttReader) str() string { return string(r.bytes()) }
+
+func (r *mqttReader) props() []mqttProp {
+	pr := &mqttReader{b: r.take(r.varint())}
+	var out []mqttProp
+	for r.err == nil && pr.err == nil && len(pr.b) > 0 {
+		id := pr.byte()
+		size, ok := mqttPropSizes[id]
+		start := pr.b
+		switch {
+		case !ok:
+			pr.err = errMQTTMalformed
+		case size > 0:
+			pr.take(size)
+		case size == 0:
+			pr.varint()
+		case size == -1:
+			pr.bytes()
+		default:
+			pr.bytes()
+			pr.bytes()
+		}
+		if pr.err == nil {
+			out = append(out, mqttProp{id: id, value: start[:len(start)-len(pr.b)]})
+		}
+	}
+	if pr.err != nil {
+		r.err = pr.err
+	}
+	return out
+}
+
+// mqttWriter builds packet bodies.
+type mqttWriter struct{ bytes.Buffer }
+
+func (w *mqttWriter) uint16(v uint16) { _ = binary.Write(w, binary.BigEndian, v) }
+
+func (w *mqttWriter) str(s string) {
+	w.uint16(uint16(len(s)))
+	w.WriteString(s)
+}
+
+func (w *mqttWriter) varint(n int) { w.Write(mqttVarint(n)) }
+
+func (w *mqttWriter) props(ps []mqttProp) {
+	var b bytes.Buffer
+	for _, p := range ps {
+		b.WriteByte(p.id)
+		b.Write(p.value)
+	}
+	w.varint(b.Len())
+	w.Write(b.Bytes())
+}
+
+func mqttVarint(n int) []byte {
+	var out []byte
+	for {
+		c := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			c |= 0x80
+		}
+		out = append(out, c)
+		if n == 0 {
+			return out
+		}
+	}
+}
+
+func mqttStrProp(id byte, s string) mqttProp {
+	var w mqttWriter
+	w.str(s)
+	return mqttProp{id: id, value: w.Bytes()}
+}
+
+// mqttEncode frames a packet: fixed header byte, remaining length, body.
+func mqttEncode(header byte, body []byte) []byte {
+	out := append([]byte{header}, mqttVarint(len(body))...)
+	return append(out, body...)
+}
+
+// mqttPacket is one decoded control packet.
+type mqttPacket struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// mqttSplit takes the complete packets off the front of buf; MQTT packets may
+// span or share WebSocket messages.
+func mqttSplit(buf []byte) ([]mqttPacket, []byte, error) {
+	var out []mqttPacket
+	for len(buf) >= 2 {
+		n, shift, i := 0, 0, 1
+		for ; ; i++ {
+			if i > 4 {
+				return out, buf, errMQTTMalformed
+			}
+			if i >= len(buf) {
+				return out, buf, nil
+			}
+			n |= int(buf[i]&0x7f) << shift
+			shift += 7
+			if buf[i]&0x80 == 0 {
+				break
+			}
+		}
+		if n > mqttMaxPacket {
+			return out, buf, fmt.Errorf("packet of %d bytes exceeds the %d byte limit", n, mqttMaxPacket)
+		}
+		end := i + 1 + n
+		if end > len(buf) {
+			return out, buf, nil
+		}
+		out = append(out, mqttPacket{typ: buf[0] >> 4, flags: buf[0] & 0x0f, body: buf[i+1 : end]})
+		buf = buf[end:]
+	}
+	return out, buf, nil
+}
+
+// mqttValidFilter checks wildcard placement: # only as the last level and
+// both wildcards alone in their level.
+func mqttValidFilter(f string) bool {
+	if f == "" {
+		return false
+	}
+	levels := strings.Split(f, "/")
+	for i, l := range levels {
+		if strings.Contains(l, "#") && (l != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(l, "+") && l != "+" {
+			return false
+		}
+	}
+	return true
+}
+
+// mqttMatch reports whether topic matches filter. Topics starting with $
+// are not matched by a leading wildcard.
+func mqttMatch(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	fl, tl := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fl {
+		if f == "#" {
+			return true
+		}
+		if i >= len(tl) || (f != "+" && f != tl[i]) {
+			return false
+		}
+	}
+	return len(fl) == len(tl)
+}
+
+// mqttMessage is an application message inside the broker.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+	qos     byte
+	retain  bool
+	props   []mqttProp
+	from    *mqttSession
+}
+
+// mqttSub is one topic filter of a session.
+type mqttSub struct {
+	filter  string
+	qos     byte
+	noLocal bool
+	rap     bool
+	subID   int
+}
+
+// mqttBroker owns the clients and retained messages of one WS service.
+type mqttBroker struct {
+	sp       WsSpec
+	mu       sync.Mutex
+	clients  map[string]*mqttSession
+	retained map[string]mqttMessage
+	nextAuto int
+	tickStop chan struct{}
+}
+
+func newMQTTBroker(sp WsSpec) *mqttBroker {
+	return &mqttBroker{sp: sp, clients: map[string]*mqttSession{}, retained: map[string]mqttMessage{}}
+}
+
+// reset drops retained messages.
+func (b *mqttBroker) reset() {
+	b.mu.Lock()
+	b.retained = map[string]mqttMessage{}
+	b.mu.Unlock()
+}
+
+// register adds s under its client id, taking over an existing session with
+// the same id, and starts the ticker for the first client.
+func (b *mqttBroker) register(s *mqttSession) {
+	b.mu.Lock()
+	if s.clientID == "" {
+		b.nextAuto++
+		s.clientID = fmt.Sprintf("auto-%d", b.nextAuto)
+		s.assigned = true
+	}
+	old := b.clients[s.clientID]
+	b.clients[s.clientID] = s
+	if b.tickStop == nil {
+		b.tickStop = make(chan struct{})
+		go b.tick(b.tickStop)
+	}
+	b.mu.Unlock()
+	if old != nil {
+		common.Logf("WS %s mqtt client %s taken over", b.sp.Name, s.clientID)
+		old.disconnect(mqttReasonTakenOver, "session taken over")
+	}
+}
+
+func (b *mqttBroker) unregister(s *mqttSession) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.clients[s.clientID] == s {
+		delete(b.clients, s.clientID)
+	}
+	if len(b.clients) == 0 && b.tickStop != nil {
+		close(b.tickStop)
+		b.tickStop = nil
+	}
+}
+
+func (b *mqttBroker) tick(stop chan struct{}) {
+	t := time.NewTicker(mqttTickInterval)
+	defer t.Stop()
+	for i := 1; ; i++ {
+		select {
+		case <-t.C:
+			payload, _ := json.Marshal(map[string]interface{}{"tick": i, "time": time.Now().UnixMilli()})
+			b.publish(mqttMessage{topic: mqttTickTopic, payload: payload, retain: true})
+		case <-stop:
+			return
+		}
+	}
+}
+
+// publish stores or clears the retained message and forwards m to every
+// matching session once, at the highest granted QoS.
+func (b *mqttBroker) publish(m mqttMessage) {
+	b.mu.Lock()
+	if m.retain {
+		if len(m.payload) == 0 {
+			delete(b.retained, m.topic)
+		} else {
+			b.retained[m.topic] = m
+		}
+	}
+	targets := make([]*mqttSession, 0, len(b.clients))
+	for _, s := range b.clients {
+		targets = append(targets, s)
+	}
+	b.mu.Unlock()
+	for _, s := range targets {
+		s.route(m)
+	}
+}
+
+func (b *mqttBroker) retainedFor(filter string) []mqttMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []mqttMessage
+	for topic, m := range b.retained {
+		if mqttMatch(filter, topic) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// mqttSession is one MQTT connection.
+type mqttSession struct {
+	b         *mqttBroker
+	c         *wsConn
+	sp        WsSpec
+	version   byte
+	clientID  string
+	assigned  bool
+	keepAlive time.Duration
+
+	mu       sync.Mutex
+	will     *mqttMessage
+	subs     map[string]mqttSub
+	nextPID  uint16
+	inflight map[uint16]bool
+}
+
+// mqttRoutes mounts /ws/mqtt (and /mqtt, the path MQTT clients default to):
+// an MQTT 3.1.1 / 5 broker over binary WebSocket messages with QoS 0 and 1,
+// retained messages, + and # wildcards and will messages. Sessions are
+// always clean. While clients are connected the broker publishes a retained
+// {"tick":N,"time":..} to ticker/tick every second.
+func mqttRoutes(mux *http.ServeMux, sp WsSpec) {
+	b := newMQTTBroker(sp)
+	admin.RegisterReset(sp.Name, "mqtt", b.reset)
+	h := func(w http.ResponseWriter, r *http.Request) {
+		c, release, ok := accept(w, r, sp)
+		if !ok {
+			return
+		}
+		defer release()
+		s := &mqttSession{b: b, c: c, sp: sp, subs: map[string]mqttSub{}, inflight: map[uint16]bool{}}
+		s.serve()
+	}
+	handleWS(mux, sp, "/ws/mqtt", h)
+	handleWS(mux, sp, "/mqtt", h)
+}
+
+// serve reads packets until the connection ends, then publishes the will
+// unless the client disconnected cleanly.
+func (s *mqttSession) serve() {
+	var buf []byte
+	connected := false
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		if !connected {
+			return
+		}
+		s.b.unregister(s)
+		s.mu.Lock()
+		will := s.will
+		s.will = nil
+		s.mu.Unlock()
+		if will != nil {
+			common.Logf("WS %s mqtt client %s will -> %s", s.sp.Name, s.clientID, will.topic)
+			s.b.publish(*will)
+		}
+	}()
+	for {
+		t, msg, err := s.c.ReadMessage()
+		if err != nil {
+			common.Logf("WS %s mqtt recv loop end: %v", s.sp.Name, err)
+			return
+		}
+		logWsFrame(s.sp, "recv", t, msg)
+		if t != websocket.BinaryMessage {
+			_ = s.c.closeWith(websocket.CloseUnsupportedData, "MQTT requires binary frames")
+			return
+		}
+		buf = append(buf, msg...)
+		packets, rest, err := mqttSplit(buf)
+		buf = rest
+		for _, p := range packets {
+			if !connected {
+				if p.typ != mqttConnect {
+					_ = s.c.closeWith(websocket.CloseProtocolError, "expected CONNECT")
+					return
+				}
+				if !s.connect(p) {
+					return
+				}
+				connected = true
+				s.watchKeepAlive(done)
+				continue
+			}
+			if !s.handle(p) {
+				return
+			}
+		}
+		if err != nil {
+			s.disconnect(mqttReasonMalformed, err.Error())
+			return
+		}
+	}
+}
+
+func (s *mqttSession) write(header byte, body []byte) {
+	_ = writeMessageLogged(s.c, s.sp, websocket.BinaryMessage, mqttEncode(header, body))
+}
+
+// disconnect ends the session from the server side; MQTT 5 clients are told
+// why first.
+func (s *mqttSession) disconnect(reason byte, text string) {
+	if s.version == 5 {
+		var w mqttWriter
+		w.WriteByte(reason)
+		w.props(nil)
+		s.write(mqttDisconnect<<4, w.Bytes())
+	}
+	_ = s.c.closeWith(websocket.CloseNormalClosure, text)
+}
+
+// connect handles CONNECT and answers CONNACK; it reports whether the
+// session was accepted.
+func (s *mqttSession) connect(p mqttPacket) bool {
+	r := &mqttReader{b: p.body}
+	name, level := r.str(), r.byte()
+	flags := r.byte()
+	keepAlive := r.uint16()
+	if r.err != nil || (name != "MQTT" && name != "MQIsdp") {
+		_ = s.c.closeWith(websocket.CloseProtocolError, "malformed CONNECT")
+		return false
+	}
+	if level != 4 && level != 5 {
+		// 3.1.1 return code 1: unacceptable protocol version
+		s.write(mqttConnack<<4, []byte{0, 0x01})
+		_ = s.c.closeWith(websocket.CloseNormalClosure, "unsupported MQTT version")
+		return false
+	}
+	s.version = level
+	if level == 5 {
+		r.props()
+	}
+	s.clientID = r.str()
+	if flags&0x04 != 0 {
+		will := &mqttMessage{qos: min((flags>>3)&0x03, 1), retain: flags&0x20 != 0}
+		if level == 5 {
+			will.props = r.props()
+		}
+		will.topic = r.str()
+		will.payload = r.bytes()
+		s.will = will
+	}
+	if flags&0x80 != 0 {
+		r.str()
+	}
+	if flags&0x40 != 0 {
+		r.bytes()
+	}
+	if r.err != nil || flags&0x01 != 0 {
+		s.disconnect(mqttReasonMalformed, "malformed CONNECT")
+		return false
+	}
+	if s.clientID == "" && level == 4 && flags&0x02 == 0 {
+		// 3.1.1 return code 2: identifier rejected
+		s.write(mqttConnack<<4, []byte{0, 0x02})
+		_ = s.c.closeWith(websocket.CloseNormalClosure, "client id required")
+		return false
+	}
+	s.keepAlive = time.Duration(keepAlive) * time.Second
+	s.b.register(s)
+	var w mqttWriter
+	w.WriteByte(0) // never a stored session
+	w.WriteByte(0)
+	if level == 5 {
+		props := []mqttProp{
+			{id: mqttPropMaximumQoS, value: []byte{1}},
+			{id: mqttPropRetainAvailable, value: []byte{1}},
+			{id: mqttPropSharedAvailable, value: []byte{0}},
+			{id: mqttPropMaxPacketSize, value: binary.BigEndian.AppendUint32(nil, mqttMaxPacket)},
+		}
+		if s.assigned {
+			props = append(props, mqttStrProp(mqttPropAssignedClientID, s.clientID))
+		}
+		w.props(props)
+	}
+	s.write(mqttConnack<<4, w.Bytes())
+	common.Logf("WS %s mqtt client %s connected (protocol level %d)", s.sp.Name, s.clientID, level)
+	return true
+}
+
+// watchKeepAlive closes the session after 1.5 keep-alive periods without
+// any packet.
+func (s *mqttSession) watchKeepAlive(done chan struct{}) {
+	if s.keepAlive <= 0 {
+		return
+	}
+	limit := s.keepAlive * 3 / 2
+	go func() {
+		t := time.NewTicker(s.keepAlive / 2)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if time.Since(time.Unix(0, s.c.lastRecv.Load())) > limit {
+					common.Logf("WS %s mqtt client %s keep-alive timeout", s.sp.Name, s.clientID)
+					s.disconnect(mqttReasonKeepAlive, "keep-alive timeout")
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// handle processes one packet after CONNECT and reports whether the session
+// goes on.
+func (s *mqttSession) handle(p mqttPacket) bool {
+	r := &mqttReader{b: p.body}
+	switch p.typ {
+	case mqttPublish:
+		return s.onPublish(p, r)
+	case mqttPuback:
+		pid := r.uint16()
+		s.mu.Lock()
+		delete(s.inflight, pid)
+		s.mu.Unlock()
+	case mqttSubscribe:
+		return s.subscribe(p, r)
+	case mqttUnsubscribe:
+		return s.unsubscribe(p, r)
+	case mqttPingreq:
+		s.write(mqttPingresp<<4, nil)
+	case mqttDisconnect:
+		reason := byte(0)
+		if len(p.body) > 0 {
+			reason = r.byte()
+		}
+		if reason != mqttReasonDisconnectWill {
+			s.mu.Lock()
+			s.will = nil
+			s.mu.Unlock()
+		}
+		_ = s.c.closeWith(websocket.CloseNormalClosure, "disconnect")
+		return false
+	default:
+		s.disconnect(mqttReasonProtocolError, fmt.Sprintf("unexpected packet type %d", p.typ))
+		return false
+	}
+	return true
+}
+
+func (s *mqttSession) onPublish(p mqttPacket, r *mqttReader) bool {
+	qos := (p.flags >> 1) & 0x03
+	if qos > 1 {
+		s.disconnect(mqttReasonQoSNotSupported, "QoS 2 is not supported")
+		return false
+	}
+	m := mqttMessage{topic: r.str(), qos: qos, retain: p.flags&0x01 != 0, from: s}
+	var pid uint16
+	if qos > 0 {
+		pid = r.uint16()
+	}
+	if s.version == 5 {
+		for _, pr := range r.props() {
+			if pr.id == mqttPropTopicAlias {
+				s.disconnect(mqttReasonProtocolError, "topic aliases are not supported")
+				return false
+			}
+			m.props = append(m.props, pr)
+		}
+	}
+	m.payload = append([]byte(nil), r.b...)
+	if r.err != nil || m.topic == "" || strings.ContainsAny(m.topic, "+#") {
+		s.disconnect(mqttReasonMalformed, "invalid PUBLISH")
+		return false
+	}
+	s.b.publish(m)
+	if qos == 1 {
+		var w mqttWriter
+		w.uint16(pid)
+		s.write(mqttPuback<<4, w.Bytes())
+	}
+	return true
+}
+
+func (s *mqttSession) subscribe(p mqttPacket, r *mqttReader) bool {
+	pid := r.uint16()
+	subID := 0
+	if s.version == 5 {
+		for _, pr := range r.props() {
+			if pr.id == mqttPropSubscriptionID {
+				subID = (&mqttReader{b: pr.value}).varint()
+			}
+		}
+	}
+	type request struct {
+		filter string
+		opts   byte
+	}
+	var reqs []request
+	for r.err == nil && len(r.b) > 0 {
+		reqs = append(reqs, request{r.str(), r.byte()})
+	}
+	if r.err != nil || p.flags != 0x02 || len(reqs) == 0 {
+		s.disconnect(mqttReasonMalformed, "invalid SUBSCRIBE")
+		return false
+	}
+	var w mqttWriter
+	w.uint16(pid)
+	if s.version == 5 {
+		w.props(nil)
+	}
+	var replay []mqttSub
+	for _, q := range reqs {
+		if !mqttValidFilter(q.filter) || strings.HasPrefix(q.filter, "$share/") {
+			if s.version == 5 {
+				w.WriteByte(mqttReasonBadFilter)
+			} else {
+				w.WriteByte(0x80)
+			}
+			continue
+		}
+		sub := mqttSub{filter: q.filter, qos: min(q.opts&0x03, 1), subID: subID}
+		if s.version == 5 {
+			sub.noLocal, sub.rap = q.opts&0x04 != 0, q.opts&0x08 != 0
+		}
+		s.mu.Lock()
+		_, existed := s.subs[q.filter]
+		s.subs[q.filter] = sub
+		s.mu.Unlock()
+		// retain handling: 0 always send retained, 1 only for new subscriptions, 2 never
+		if rh := (q.opts >> 4) & 0x03; s.version != 5 || rh == 0 || (rh == 1 && !existed) {
+			replay = append(replay, sub)
+		}
+		w.WriteByte(sub.qos)
+	}
+	s.write(mqttSuback<<4, w.Bytes())
+	for _, sub := range replay {
+		for _, m := range s.b.retainedFor(sub.filter) {
+			s.deliver(m, min(sub.qos, m.qos), true, []int{sub.subID})
+		}
+	}
+	return true
+}
+
+func (s *mqttSession) unsubscribe(p mqttPacket, r *mqttReader) bool {
+	pid := r.uint16()
+	if s.version == 5 {
+		r.props()
+	}
+	var filters []string
+	for r.err == nil && len(r.b) > 0 {
+		filters = append(filters, r.str())
+	}
+	if r.err != nil || p.flags != 0x02 || len(filters) == 0 {
+		s.disconnect(mqttReasonMalformed, "invalid UNSUBSCRIBE")
+		return false
+	}
+	var w mqttWriter
+	w.uint16(pid)
+	if s.version == 5 {
+		w.props(nil)
+	}
+	for _, f := range filters {
+		s.mu.Lock()
+		_, ok := s.subs[f]
+		delete(s.subs, f)
+		s.mu.Unlock()
+		if s.version == 5 {
+			if ok {
+				w.WriteByte(0)
+			} else {
+				w.WriteByte(mqttReasonNoSubscription)
+			}
+		}
+	}
+	s.write(mqttUnsuback<<4, w.Bytes())
+	return true
+}
+
+// route delivers m if any subscription matches, once, at the highest
+// granted QoS. The retain flag is kept only for MQTT 5 subscriptions with
+// Retain As Published.
+func (s *mqttSession) route(m mqttMessage) {
+	s.mu.Lock()
+	matched, qos, retain := false, byte(0), false
+	var ids []int
+	for _, sub := range s.subs {
+		if !mqttMatch(sub.filter, m.topic) || (sub.noLocal && m.from == s) {
+			continue
+		}
+		matched = true
+		qos = max(qos, sub.qos)
+		retain = retain || (sub.rap && m.retain)
+		ids = append(ids, sub.subID)
+	}
+	s.mu.Unlock()
+	if matched {
+		s.deliver(m, min(qos, m.qos), retain, ids)
+	}
+}
+
+// deliver writes a PUBLISH; QoS 1 messages stay in flight until PUBACK.
+func (s *mqttSession) deliver(m mqttMessage, qos byte, retain bool, subIDs []int) {
+	var pid uint16
+	if qos > 0 {
+		s.mu.Lock()
+		if len(s.inflight) < mqttMaxInflight {
+			for {
+				s.nextPID++
+				if s.nextPID != 0 && !s.inflight[s.nextPID] {
+					break
+				}
+			}
+			pid = s.nextPID
+			s.inflight[pid] = true
+		} else {
+			qos = 0
+		}
+		s.mu.Unlock()
+	}
+	header := byte(mqttPublish<<4) | qos<<1
+	if retain {
+		header |= 0x01
+	}
+	var w mqttWriter
+	w.str(m.topic)
+	if qos > 0 {
+		w.uint16(pid)
+	}
+	if s.version == 5 {
+		props := append([]mqttProp(nil), m.props...)
+		for _, id := range subIDs {
+			if id > 0 {
+				props = append(props, mqttProp{id: mqttPropSubscriptionID, value: mqttVarint(id)})
+			}
+		}
+		w.props(props)
+	}
+	w.Write(m.payload)
+	s.write(header, w.Bytes())
+}
@@ -0,0 +1,327 @@
+package wsserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMQTTTopicMatch(t *testing.T) {
+	cases := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"sensors/+/temp", "sensors/k1/temp", true},
+		{"sensors/+/temp", "sensors/k1/hum", false},
+		{"sensors/#", "sensors", true},
+		{"sensors/#", "sensors/a/b", true},
+		{"#", "a/b", true},
+		{"#", "$SYS/uptime", false},
+		{"+/uptime", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+		{"a/b", "a/b/c", false},
+	}
+	for _, c := range cases {
+		if got := mqttMatch(c.filter, c.topic); got != c.want {
+			t.Errorf("mqttMatch(%q, %q)=%v", c.filter, c.topic, got)
+		}
+	}
+	for _, f := range []string{"a/#/b", "a/b#", "a+/b", ""} {
+		if mqttValidFilter(f) {
+			t.Errorf("filter %q accepted", f)
+		}
+	}
+}
+
+func TestMQTTSplitAcrossMessages(t *testing.T) {
+	a := mqttEncode(mqttPingreq<<4, nil)
+	b := mqttEncode(mqttPublish<<4, append([]byte{0, 1, 't'}, make([]byte, 200)...))
+	stream := append(a, b...)
+	packets, rest, err := mqttSplit(stream[:5])
+	if err != nil || len(packets) != 1 || packets[0].typ != mqttPingreq {
+		t.Fatalf("first part: %+v err=%v", packets, err)
+	}
+	packets, rest, err = mqttSplit(append(rest, stream[5:]...))
+	if err != nil || len(packets) != 1 || len(packets[0].body) != 203 || len(rest) != 0 {
+		t.Fatalf("second part: %d packets, rest=%d err=%v", len(packets), len(rest), err)
+	}
+}
+
+type mqttClient struct {
+	t   *testing.T
+	c   *websocket.Conn
+	v5  bool
+	buf []byte
+}
+
+func dialMQTT(t *testing.T, port int, path string) *mqttClient {
+	t.Helper()
+	d := websocket.Dialer{Subprotocols: []string{"mqtt"}}
+	c, _, err := d.Dial(fmt.Sprintf("ws://127.0.0.1:%d%s", port, path), http.Header{"X-Auth-Token": {staticWsToken}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	if c.Subprotocol() != "mqtt" {
+		t.Fatalf("subprotocol=%q", c.Subprotocol())
+	}
+	_ = c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	return &mqttClient{t: t, c: c}
+}
+
+func (mc *mqttClient) send(header byte, body []byte) {
+	mc.t.Helper()
+	if err := mc.c.WriteMessage(websocket.BinaryMessage, mqttEncode(header, body)); err != nil {
+		mc.t.Fatalf("write: %v", err)
+	}
+}
+
+func (mc *mqttClient) read() mqttPacket {
+	mc.t.Helper()
+	for {
+		packets, rest, err := mqttSplit(mc.buf)
+		if err != nil {
+			mc.t.Fatalf("split: %v", err)
+		}
+		if len(packets) > 0 {
+			mc.buf = append(mqttEncodeAll(packets[1:]), rest...)
+			return packets[0]
+		}
+		_, b, err := mc.c.ReadMessage()
+		if err != nil {
+			mc.t.Fatalf("read: %v", err)
+		}
+		mc.buf = append(mc.buf, b...)
+	}
+}
+
+func mqttEncodeAll(ps []mqttPacket) []byte {
+	var out []byte
+	for _, p := range ps {
+		out = append(out, mqttEncode(p.typ<<4|p.flags, p.body)...)
+	}
+	return out
+}
+
+func (mc *mqttClient) expect(typ byte) mqttPacket {
+	mc.t.Helper()
+	p := mc.read()
+	if p.typ != typ {
+		mc.t.Fatalf("packet type %d, want %d (% x)", p.typ, typ, p.body)
+	}
+	return p
+}
+
+// connect sends CONNECT (level 4 or 5), optionally with a will, and checks
+// the CONNACK.
+func (mc *mqttClient) connect(level byte, clientID string, will *mqttMessage) mqttPacket {
+	mc.t.Helper()
+	mc.v5 = level == 5
+	var w mqttWriter
+	w.str("MQTT")
+	w.WriteByte(level)
+	flags := byte(0x02)
+	if will != nil {
+		flags |= 0x04 | will.qos<<3
+	}
+	w.WriteByte(flags)
+	w.uint16(30)
+	if mc.v5 {
+		w.props(nil)
+	}
+	w.str(clientID)
+	if will != nil {
+		if mc.v5 {
+			w.props(nil)
+		}
+		w.str(will.topic)
+		w.str(string(will.payload))
+	}
+	mc.send(mqttConnect<<4, w.Bytes())
+	p := mc.expect(mqttConnack)
+	if len(p.body) < 2 || p.body[1] != 0 {
+		mc.t.Fatalf("connack=% x", p.body)
+	}
+	return p
+}
+
+func (mc *mqttClient) subscribe(pid uint16, subID int, filter string, opts byte) []byte {
+	mc.t.Helper()
+	var w mqttWriter
+	w.uint16(pid)
+	if mc.v5 {
+		var props []mqttProp
+		if subID > 0 {
+			props = append(props, mqttProp{id: mqttPropSubscriptionID, value: mqttVarint(subID)})
+		}
+		w.props(props)
+	}
+	w.str(filter)
+	w.WriteByte(opts)
+	mc.send(mqttSubscribe<<4|0x02, w.Bytes())
+	r := &mqttReader{b: mc.expect(mqttSuback).body}
+	if got := r.uint16(); got != pid {
+		mc.t.Fatalf("suback pid=%d", got)
+	}
+	if mc.v5 {
+		r.props()
+	}
+	return r.b
+}
+
+func (mc *mqttClient) publish(topic, payload string, qos byte, retain bool, pid uint16) {
+	mc.t.Helper()
+	header := byte(mqttPublish<<4) | qos<<1
+	if retain {
+		header |= 0x01
+	}
+	var w mqttWriter
+	w.str(topic)
+	if qos > 0 {
+		w.uint16(pid)
+	}
+	if mc.v5 {
+		w.props(nil)
+	}
+	w.WriteString(payload)
+	mc.send(header, w.Bytes())
+}
+
+// mqttReceived is a decoded PUBLISH as seen by a test client.
+type mqttReceived struct {
+	topic, payload string
+	qos            byte
+	retain         bool
+	pid            uint16
+	subIDs         []int
+}
+
+// message reads the next PUBLISH.
+func (mc *mqttClient) message() mqttReceived {
+	mc.t.Helper()
+	p := mc.expect(mqttPublish)
+	r := &mqttReader{b: p.body}
+	m := mqttReceived{topic: r.str(), qos: (p.flags >> 1) & 0x03, retain: p.flags&0x01 != 0}
+	if m.qos > 0 {
+		m.pid = r.uint16()
+	}
+	if mc.v5 {
+		for _, pr := range r.props() {
+			if pr.id == mqttPropSubscriptionID {
+				m.subIDs = append(m.subIDs, (&mqttReader{b: pr.value}).varint())
+			}
+		}
+	}
+	m.payload = string(r.b)
+	if r.err != nil {
+		mc.t.Fatalf("publish decode: %v", r.err)
+	}
+	return m
+}
+
+func TestMQTTBroker(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+	port := base + 3
+
+	// MQTT 5 subscriber with a subscription identifier
+	sub := dialMQTT(t, port, "/ws/mqtt")
+	connack := sub.connect(5, "", nil)
+	r := &mqttReader{b: connack.body[2:]}
+	var assigned string
+	for _, pr := range r.props() {
+		if pr.id == mqttPropAssignedClientID {
+			assigned = (&mqttReader{b: pr.value}).str()
+		}
+	}
+	if !strings.HasPrefix(assigned, "auto-") {
+		t.Fatalf("assigned client id=%q", assigned)
+	}
+	if codes := sub.subscribe(1, 7, "sensors/+/temp", 1); len(codes) != 1 || codes[0] != 1 {
+		t.Fatalf("suback=% x", codes)
+	}
+	if codes := sub.subscribe(2, 0, "bad/#/filter", 0); codes[0] != mqttReasonBadFilter {
+		t.Fatalf("bad filter suback=% x", codes)
+	}
+
+	// MQTT 3.1.1 publisher on the /mqtt alias
+	pub := dialMQTT(t, port, "/mqtt")
+	pub.connect(4, "pub", nil)
+	pub.publish("sensors/k1/temp", "21.5", 1, true, 10)
+	if p := pub.expect(mqttPuback); (&mqttReader{b: p.body}).uint16() != 10 {
+		t.Fatalf("puback=% x", p.body)
+	}
+	m := sub.message()
+	if m.topic != "sensors/k1/temp" || m.payload != "21.5" || m.qos != 1 || m.retain || m.pid == 0 || len(m.subIDs) != 1 || m.subIDs[0] != 7 {
+		t.Fatalf("forwarded=%+v", m)
+	}
+	var ack mqttWriter
+	ack.uint16(m.pid)
+	sub.send(mqttPuback<<4, ack.Bytes())
+
+	// retained messages reach late subscribers with the retain flag; a 3.1.1
+	// QoS 0 subscription downgrades delivery
+	late := dialMQTT(t, port, "/ws/mqtt")
+	late.connect(4, "late", nil)
+	if codes := late.subscribe(1, 0, "sensors/#", 0); codes[0] != 0 || len(codes) != 1 {
+		t.Fatalf("late suback=% x", codes)
+	}
+	if m := late.message(); m.topic != "sensors/k1/temp" || !m.retain || m.qos != 0 {
+		t.Fatalf("retained=%+v", m)
+	}
+	// a QoS 1 subscription gets a QoS 0 retained message at QoS 0
+	pub.publish("meters/k2/power", "7", 0, true, 0)
+	pub.send(mqttPingreq<<4, nil)
+	pub.expect(mqttPingresp)
+	meter := dialMQTT(t, port, "/ws/mqtt")
+	meter.connect(4, "meter", nil)
+	meter.subscribe(1, 0, "meters/#", 1)
+	if m := meter.message(); m.topic != "meters/k2/power" || !m.retain || m.qos != 0 || m.pid != 0 {
+		t.Fatalf("retained qos=%+v", m)
+	}
+
+	// will message on an abrupt close; none on DISCONNECT
+	sub.subscribe(3, 0, "status/#", 0)
+	w := dialMQTT(t, port, "/ws/mqtt")
+	w.connect(4, "device-1", &mqttMessage{topic: "status/device-1", payload: []byte("offline")})
+	_ = w.c.NetConn().Close()
+	if m := sub.message(); m.topic != "status/device-1" || m.payload != "offline" {
+		t.Fatalf("will=%+v", m)
+	}
+	clean := dialMQTT(t, port, "/ws/mqtt")
+	clean.connect(4, "device-2", &mqttMessage{topic: "status/device-2", payload: []byte("offline")})
+	clean.send(mqttDisconnect<<4, nil)
+
+	// ping, then the ticker publishes a retained tick every second
+	pub.send(mqttPingreq<<4, nil)
+	pub.expect(mqttPingresp)
+	sub.subscribe(4, 0, mqttTickTopic, 0)
+	m = sub.message()
+	if m.topic != mqttTickTopic || !strings.Contains(m.payload, `"tick":`) {
+		t.Fatalf("tick=%+v", m)
+	}
+
+	// a new connection with the same client id takes the session over
+	again := dialMQTT(t, port, "/ws/mqtt")
+	again.connect(4, "pub", nil)
+	if _, _, err := pub.c.ReadMessage(); err == nil {
+		t.Fatalf("old session survived takeover")
+	}
+}
@@ -0,0 +1,162 @@
+package wsserver
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+// endpoint is the upgrade configuration resolved for one WS path.
+type endpoint struct {
+	path     string
+	opts     topology.WSOptions
+	upgrader *websocket.Upgrader
+}
+
+type endpointKey struct{}
+
+// builtinSubprotocols are offered by protocol endpoints when the topology
+// does not configure subprotocols for them.
+var builtinSubprotocols = map[string][]string{
+	"/ws/stomp":   {"v12.stomp"},
+	"/ws/mqtt":    {"mqtt"},
+	"/mqtt":       {"mqtt"},
+	"/ws/graphql": {"graphql-transport-ws"},
+	"/graphql":    {"graphql-transport-ws"},
+}
+
+// newEndpoint resolves the options for path: the per-endpoint entry when
+// declared, otherwise the service-wide options.
+func newEndpoint(sp WsSpec, path string) *endpoint {
+	var opts topology.WSOptions
+	if o, ok := sp.Endpoints[path]; ok {
+		opts = o
+	} else if sp.Options != nil {
+		opts = *sp.Options
+	}
+	u := &websocket.Upgrader{CheckOrigin: upgrader.CheckOrigin}
+	if len(opts.Subprotocols) > 0 {
+		u.Subprotocols = opts.Subprotocols
+	} else if sub, ok := builtinSubprotocols[path]; ok {
+		u.Subprotocols = sub
+	}
+	if opts.Compression != nil && opts.Compression.Enabled {
+		u.EnableCompression = true
+	}
+	return &endpoint{path: path, opts: opts, upgrader: u}
+}
+
+func endpointFrom(r *http.Request) *endpoint {
+	if ep, ok := r.Context().Value(endpointKey{}).(*endpoint); ok {
+		return ep
+	}
+	return &endpoint{path: r.URL.Path, upgrader: &upgrader}
+}
+
+func withEndpoint(ep *endpoint, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r.WithContext(context.WithValue(r.Context(), endpointKey{}, ep)))
+	}
+}
+
+// upgraderFor returns the upgrader for r. ?fragment=N shrinks the write
+// buffer so streamed (NextWriter) messages leave in frames of N bytes.
+func (ep *endpoint) upgraderFor(r *http.Request) *websocket.Upgrader {
+	n, err := strconv.Atoi(r.URL.Query().Get("fragment"))
+	if err != nil || n <= 0 {
+		return ep.upgrader
+	}
+	u := *ep.upgrader
+	u.WriteBufferSize = n
+	return &u
+}
+
+// checkSubprotocol enforces requireSubprotocol before the upgrade.
+func (ep *endpoint) checkSubprotocol(w http.ResponseWriter, r *http.Request) bool {
+	if !ep.opts.RequireSubprotocol {
+		return true
+	}
+	offered := websocket.Subprotocols(r)
+	for _, want := range ep.opts.Subprotocols {
+		for _, p := range offered {
+			if p == want {
+				return true
+			}
+		}
+	}
+	common.JSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error":     "no acceptable subprotocol",
+		"offered":   offered,
+		"supported": ep.opts.Subprotocols,
+	})
+	return false
+}
+
+// offersDeflate reports whether the client asked for permessage-deflate.
+func offersDeflate(r *http.Request) bool {
+	for _, v := range r.Header.Values("Sec-WebSocket-Extensions") {
+		for _, ext := range strings.Split(v, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(ext), ";")
+			if strings.TrimSpace(name) == "permessage-deflate" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// configureCompression applies the endpoint compression options to c and
+// returns the negotiated extension ("" when not in use).
+func (ep *endpoint) configureCompression(c *websocket.Conn, r *http.Request) string {
+	cfg := ep.opts.Compression
+	if cfg == nil || !cfg.Enabled || !offersDeflate(r) {
+		return ""
+	}
+	if cfg.Level != 0 {
+		_ = c.SetCompressionLevel(cfg.Level)
+	}
+	c.EnableWriteCompression(!cfg.DisableWrite)
+	// gorilla/websocket only negotiates the no-context-takeover variant
+	return "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+}
+
+// wantsHello reports whether the hello frame is enabled for this request
+// (endpoint option or ?hello=1).
+func (ep *endpoint) wantsHello(r *http.Request) bool {
+	switch r.URL.Query().Get("hello") {
+	case "1", "true":
+		return true
+	case "0", "false":
+		return false
+	}
+	return ep.opts.Hello
+}
+
+// sendHello reports the negotiated upgrade parameters in a first JSON frame.
+func sendHello(c *wsConn, sp WsSpec, ep *endpoint, r *http.Request, res authResult, extension string) error {
+	return writeJSONWithLog(c, sp, map[string]interface{}{
+		eventKeyForService(sp): "hello",
+		"service":              sp.Name,
+		"path":                 ep.path,
+		"subprotocol":          c.Subprotocol(),
+		"compression": map[string]interface{}{
+			"enabled":   extension != "",
+			"extension": extension,
+		},
+		"offered": map[string]interface{}{
+			"subprotocols": websocket.Subprotocols(r),
+			"extensions":   r.Header.Values("Sec-WebSocket-Extensions"),
+		},
+		"supported": map[string]interface{}{
+			"subprotocols": ep.opts.Subprotocols,
+			"compression":  ep.upgrader.EnableCompression,
+		},
+		"principal": res.Principal,
+	})
+}
@@ -0,0 +1,81 @@
+package wsserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWsSubprotocolAndCompression(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	off := 0
+	topo := []topology.WSService{{
+		Name:       "ws-options",
+		PortOffset: &off,
+		Bundles:    []string{"echo", "ticker"},
+		Auth:       &topology.WSAuth{Mode: topology.AuthNone},
+		Options: &topology.WSOptions{
+			Subprotocols:       []string{"v2.json", "v1.json"},
+			RequireSubprotocol: true,
+			Compression:        &topology.WSCompression{Enabled: true, Level: 6},
+			Hello:              true,
+		},
+		Endpoints: map[string]topology.WSOptions{"/ws/ticker": {}},
+	}}
+	srvs := StartAll(base, topo)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+	root := fmt.Sprintf("ws://127.0.0.1:%d", base)
+
+	d := websocket.Dialer{Subprotocols: []string{"v1.json", "v2.json"}, EnableCompression: true}
+	c, resp, err := d.Dial(root+"/ws/echo", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = c.Close() }()
+	if c.Subprotocol() != "v2.json" || resp.Header.Get("Sec-WebSocket-Extensions") == "" {
+		t.Fatalf("negotiated protocol=%q extensions=%q", c.Subprotocol(), resp.Header.Get("Sec-WebSocket-Extensions"))
+	}
+	var hello map[string]interface{}
+	if err := c.ReadJSON(&hello); err != nil {
+		t.Fatalf("read hello: %v", err)
+	}
+	comp, _ := hello["compression"].(map[string]interface{})
+	if hello["type"] != "hello" || hello["subprotocol"] != "v2.json" || comp["enabled"] != true {
+		t.Fatalf("hello=%v", hello)
+	}
+	if err := c.WriteMessage(websocket.TextMessage, []byte("zip")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, got, err := c.ReadMessage(); err != nil || string(got) != "zip" {
+		t.Fatalf("echo: %q %v", got, err)
+	}
+
+	if _, resp, err := (&websocket.Dialer{Subprotocols: []string{"v3"}}).Dial(root+"/ws/echo", nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a matching subprotocol, got %v", err)
+	}
+
+	// the ticker endpoint overrides the service options: no hello, no protocol
+	tc, _, err := websocket.DefaultDialer.Dial(root+"/ws/ticker?interval=10", nil)
+	if err != nil {
+		t.Fatalf("dial ticker: %v", err)
+	}
+	defer func() { _ = tc.Close() }()
+	if _, got, err := tc.ReadMessage(); err != nil || string(got) != "tick 1" {
+		t.Fatalf("ticker first frame: %q %v", got, err)
+	}
+}
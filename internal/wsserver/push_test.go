@@ -0,0 +1,151 @@
+package wsserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/admin"
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAdminPushFrames(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+	ctl := httptest.NewServer(admin.Handler())
+	defer ctl.Close()
+
+	hdr := http.Header{"X-Auth-Token": {staticWsToken}}
+	dial := func(path string) *websocket.Conn {
+		t.Helper()
+		c, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d%s", base+4, path), hdr)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		return c
+	}
+	post := func(path, body string) map[string]interface{} {
+		t.Helper()
+		resp, err := http.Post(ctl.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		if resp.StatusCode != 200 {
+			t.Fatalf("POST %s: status=%d %v", path, resp.StatusCode, out)
+		}
+		return out
+	}
+	read := func(c *websocket.Conn) (int, string) {
+		t.Helper()
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		mt, b, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return mt, string(b)
+	}
+
+	a := dial("/ws/echo?tag=a")
+	b := dial("/ws/echo?tag=b")
+
+	resp, err := http.Get(ctl.URL + "/ws/clients?service=ws-ticker&query=tag=a")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	var listed struct {
+		Clients []admin.Client `json:"clients"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&listed)
+	_ = resp.Body.Close()
+	if len(listed.Clients) != 1 || listed.Clients[0].Path != "/ws/echo" {
+		t.Fatalf("clients=%+v", listed.Clients)
+	}
+
+	post(fmt.Sprintf("/ws/clients/%d/send", listed.Clients[0].ID), `{"type":"text","json":{"hello":"a"}}`)
+	if mt, got := read(a); mt != websocket.TextMessage || got != `{"hello":"a"}` {
+		t.Fatalf("a got %d %q", mt, got)
+	}
+
+	out := post("/ws/send?service=ws-ticker&path=/ws/echo", `{"type":"binary","base64":"AAEC"}`)
+	if sent, _ := out["sent"].([]interface{}); len(sent) != 2 {
+		t.Fatalf("broadcast=%v", out)
+	}
+	for _, c := range []*websocket.Conn{a, b} {
+		if mt, got := read(c); mt != websocket.BinaryMessage || got != "\x00\x01\x02" {
+			t.Fatalf("binary got %d %q", mt, got)
+		}
+	}
+
+	post("/ws/send", `{"type":"close","code":4001,"reason":"kick","filter":{"service":"ws-ticker","query":"tag=b"}}`)
+	_ = b.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = b.ReadMessage()
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != 4001 || ce.Text != "kick" {
+		t.Fatalf("close err=%v", err)
+	}
+}
+
+func TestAdminTracksWsClients(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	c, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws/echo?tag=tracked", base+3), http.Header{"X-Auth-Token": {staticWsToken}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	// one echo round trip: the handler has registered the client by then
+	_ = c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if err := c.WriteMessage(websocket.TextMessage, []byte("hi")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, _, err := c.ReadMessage(); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	filter := admin.ClientFilter{Service: "ws-echo", Query: "tag=tracked"}
+	clients := admin.Clients(filter)
+	if len(clients) != 1 || clients[0].Path != "/ws/echo" {
+		t.Fatalf("tracked clients: %+v", clients)
+	}
+
+	// closing the socket removes the client
+	_ = c.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(admin.Clients(filter)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("client still tracked: %+v", admin.Clients(filter))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
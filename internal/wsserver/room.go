@@ -0,0 +1,314 @@
+package wsserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"intercept-wave-upstream/internal/admin"
+	"intercept-wave-upstream/internal/common"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// roomHistoryLimit is the number of messages a room keeps for late joiners.
+	roomHistoryLimit = 50
+	// roomSendBuffer is the default per-member outbound queue length.
+	roomSendBuffer = 64
+	// roomWriteWait bounds a single write to a member.
+	roomWriteWait = 5 * time.Second
+)
+
+// roomHub owns the rooms of one WS service.
+type roomHub struct {
+	mu     sync.Mutex
+	rooms  map[string]*room
+	nextID int
+}
+
+// room fans messages out to its members in one global order (seq).
+type room struct {
+	name    string
+	mu      sync.Mutex
+	seq     int64
+	members map[*roomMember]struct{}
+	history []roomFrame
+}
+
+// roomFrame is one queued outbound frame.
+type roomFrame struct {
+	msgType int
+	payload []byte
+}
+
+// roomMember is a connected client. Only its writer goroutine writes to conn.
+type roomMember struct {
+	id       string
+	nick     string
+	send     chan roomFrame
+	dropSlow bool
+	dropped  int
+	kicked   bool
+}
+
+func newRoomHub() *roomHub {
+	return &roomHub{rooms: map[string]*room{}}
+}
+
+func (h *roomHub) room(name string) *room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rm, ok := h.rooms[name]
+	if !ok {
+		rm = &room{name: name, members: map[*roomMember]struct{}{}}
+		h.rooms[name] = rm
+	}
+	return rm
+}
+
+func (h *roomHub) newMember(nick string, buffer int, dropSlow bool) *roomMember {
+	h.mu.Lock()
+	h.nextID++
+	id := fmt.Sprintf("m-%d", h.nextID)
+	h.mu.Unlock()
+	if nick == "" {
+		nick = id
+	}
+	return &roomMember{id: id, nick: nick, send: make(chan roomFrame, buffer), dropSlow: dropSlow}
+}
+
+// reset drops the history of every room.
+func (h *roomHub) reset() {
+	h.mu.Lock()
+	rooms := make([]*room, 0, len(h.rooms))
+	for _, rm := range h.rooms {
+		rooms = append(rooms, rm)
+	}
+	h.mu.Unlock()
+	for _, rm := range rooms {
+		rm.mu.Lock()
+		rm.history = nil
+		rm.mu.Unlock()
+	}
+}
+
+// memberNamesLocked lists member nicknames; callers hold rm.mu.
+func (rm *room) memberNamesLocked() []string {
+	names := make([]string, 0, len(rm.members))
+	for m := range rm.members {
+		names = append(names, m.nick)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// join announces m to the current members, adds it and queues its welcome
+// frame with up to replay history messages.
+func (rm *room) join(sp WsSpec, m *roomMember, replay int) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	members := append(rm.memberNamesLocked(), m.nick)
+	sort.Strings(members)
+	rm.broadcastLocked(sp, roomEvent(sp, "join", map[string]interface{}{
+		"room":    rm.name,
+		"member":  m.nick,
+		"members": members,
+	}), false)
+	rm.members[m] = struct{}{}
+	history := rm.history
+	if replay < len(history) {
+		history = history[len(history)-replay:]
+	}
+	msgs := make([]json.RawMessage, 0, len(history))
+	for _, f := range history {
+		msgs = append(msgs, f.payload)
+	}
+	welcome := roomEvent(sp, "welcome", map[string]interface{}{
+		"room":    rm.name,
+		"you":     m.nick,
+		"members": members,
+		"history": msgs,
+	})
+	if !rm.enqueueLocked(m, welcome) {
+		rm.kickLocked(sp, m)
+	}
+}
+
+// leave removes m (once) and announces it with reason and the number of
+// frames it lost with slow=drop.
+func (rm *room) leave(sp WsSpec, m *roomMember, reason string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if _, ok := rm.members[m]; !ok {
+		return
+	}
+	delete(rm.members, m)
+	close(m.send)
+	rm.broadcastLocked(sp, roomEvent(sp, "leave", map[string]interface{}{
+		"room":    rm.name,
+		"member":  m.nick,
+		"reason":  reason,
+		"dropped": m.dropped,
+		"members": rm.memberNamesLocked(),
+	}), false)
+}
+
+// publish broadcasts a client message. Text frames are wrapped in a message
+// event (JSON bodies as "data", others as "text"); binary frames are relayed as is.
+func (rm *room) publish(sp WsSpec, from *roomMember, msgType int, payload []byte) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if msgType == websocket.BinaryMessage {
+		rm.broadcastLocked(sp, roomFrame{msgType: msgType, payload: payload}, true)
+		return
+	}
+	fields := map[string]interface{}{"room": rm.name, "from": from.nick}
+	var data interface{}
+	if json.Valid(payload) && json.Unmarshal(payload, &data) == nil {
+		fields["data"] = data
+	} else {
+		fields["text"] = string(payload)
+	}
+	rm.broadcastLocked(sp, roomEvent(sp, "message", fields), true)
+}
+
+// broadcastLocked stamps text events with the next seq, records them in the
+// history when keep is set and queues them for every member.
+func (rm *room) broadcastLocked(sp WsSpec, f roomFrame, keep bool) {
+	if f.msgType == websocket.TextMessage {
+		rm.seq++
+		var m map[string]interface{}
+		_ = json.Unmarshal(f.payload, &m)
+		m["seq"] = rm.seq
+		f.payload, _ = common.JsonMarshalCompat(m)
+		if keep {
+			rm.history = append(rm.history, f)
+			if len(rm.history) > roomHistoryLimit {
+				rm.history = rm.history[len(rm.history)-roomHistoryLimit:]
+			}
+		}
+	}
+	var slow []*roomMember
+	for m := range rm.members {
+		if !rm.enqueueLocked(m, f) {
+			slow = append(slow, m)
+		}
+	}
+	// kick after the fan-out so every member sees frames in seq order
+	for _, m := range slow {
+		rm.kickLocked(sp, m)
+	}
+}
+
+// enqueueLocked queues f without blocking. It reports false when the member
+// queue is full and the member should be disconnected; members with
+// dropSlow lose the frame instead.
+func (rm *room) enqueueLocked(m *roomMember, f roomFrame) bool {
+	select {
+	case m.send <- f:
+		return true
+	default:
+		if m.dropSlow {
+			m.dropped++
+			return true
+		}
+		return false
+	}
+}
+
+// kickLocked disconnects a slow consumer and announces it to the others.
+func (rm *room) kickLocked(sp WsSpec, m *roomMember) {
+	if _, ok := rm.members[m]; !ok {
+		return
+	}
+	common.Logf("WS %s room %s: disconnecting slow member %s", sp.Name, rm.name, m.nick)
+	m.kicked = true
+	delete(rm.members, m)
+	close(m.send)
+	rm.broadcastLocked(sp, roomEvent(sp, "leave", map[string]interface{}{
+		"room":    rm.name,
+		"member":  m.nick,
+		"reason":  "slow",
+		"members": rm.memberNamesLocked(),
+	}), false)
+}
+
+func roomEvent(sp WsSpec, kind string, fields map[string]interface{}) roomFrame {
+	fields[eventKeyForService(sp)] = kind
+	fields["time"] = time.Now().UnixMilli()
+	b, _ := common.JsonMarshalCompat(fields)
+	return roomFrame{msgType: websocket.TextMessage, payload: b}
+}
+
+// writeLoop drains the member queue; a kicked member gets a 1008 close and
+// its connection is closed so the read loop ends.
+func (m *roomMember) writeLoop(c *wsConn, sp WsSpec, rm *room) {
+	for f := range m.send {
+		logWsFrame(sp, "send", f.msgType, f.payload)
+		if err := c.writeMessageWithin(f.msgType, f.payload, roomWriteWait); err != nil {
+			rm.leave(sp, m, "error")
+			break
+		}
+	}
+	rm.mu.Lock()
+	kicked := m.kicked
+	rm.mu.Unlock()
+	if kicked {
+		_ = writeControlLogged(c, sp, websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "slow consumer"), time.Now().Add(time.Second))
+		_ = c.Close()
+	}
+	// drain anything left so a concurrent close never blocks
+	for range m.send {
+	}
+}
+
+// roomRoutes mounts /ws/room/{name}: every member receives every message in
+// the same order, plus welcome/join/leave presence events.
+//
+// Query: nick (display name), history (messages replayed on join, default
+// all kept), buffer (outbound queue length, default 64), slow=drop|close
+// (what happens when the queue overflows; default close with 1008).
+func roomRoutes(mux *http.ServeMux, sp WsSpec) {
+	hub := newRoomHub()
+	admin.RegisterReset(sp.Name, "rooms", hub.reset)
+	handleWS(mux, sp, "/ws/room/{name}", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		buffer, err := strconv.Atoi(q.Get("buffer"))
+		if err != nil || buffer <= 0 {
+			buffer = roomSendBuffer
+		}
+		replay, err := strconv.Atoi(q.Get("history"))
+		if err != nil || replay < 0 {
+			replay = roomHistoryLimit
+		}
+		c, release, ok := accept(w, r, sp)
+		if !ok {
+			return
+		}
+		defer release()
+		rm := hub.room(r.PathValue("name"))
+		m := hub.newMember(q.Get("nick"), buffer, q.Get("slow") == "drop")
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			m.writeLoop(c, sp, rm)
+		}()
+		rm.join(sp, m, replay)
+		for {
+			t, msg, err := c.ReadMessage()
+			if err != nil {
+				common.Logf("WS %s room %s recv loop end: %v", sp.Name, rm.name, err)
+				break
+			}
+			logWsFrame(sp, "recv", t, msg)
+			rm.publish(sp, m, t, msg)
+		}
+		rm.leave(sp, m, "closed")
+		<-done
+	})
+}
@@ -0,0 +1,193 @@
+package wsserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/admin"
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWsRoomBroadcast(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	hdr := http.Header{"X-Auth-Token": {staticWsToken}}
+	join := func(query string) *websocket.Conn {
+		t.Helper()
+		c, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws/room/lobby?%s", base+3, query), hdr)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		return c
+	}
+	next := func(c *websocket.Conn, want string) map[string]interface{} {
+		t.Helper()
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			var ev map[string]interface{}
+			if err := c.ReadJSON(&ev); err != nil {
+				t.Fatalf("read %s: %v", want, err)
+			}
+			if ev["type"] == want {
+				return ev
+			}
+		}
+	}
+
+	alice := join("nick=alice&buffer=2048")
+	next(alice, "welcome")
+	bob := join("nick=bob")
+	if ev := next(alice, "join"); ev["member"] != "bob" {
+		t.Fatalf("join=%v", ev)
+	}
+	next(bob, "welcome")
+
+	for i := 1; i <= 3; i++ {
+		if err := alice.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("msg-%d", i))); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	var lastSeq float64
+	for i := 1; i <= 3; i++ {
+		a, b := next(alice, "message"), next(bob, "message")
+		if a["text"] != fmt.Sprintf("msg-%d", i) || a["seq"] != b["seq"] || a["seq"].(float64) <= lastSeq {
+			t.Fatalf("order mismatch: alice=%v bob=%v", a, b)
+		}
+		lastSeq = a["seq"].(float64)
+	}
+
+	carol := join("nick=carol&history=2&buffer=2048")
+	if hist, _ := next(carol, "welcome")["history"].([]interface{}); len(hist) != 2 {
+		t.Fatalf("history=%v", hist)
+	}
+
+	_ = bob.Close()
+	if ev := next(alice, "leave"); ev["member"] != "bob" {
+		t.Fatalf("leave=%v", ev)
+	}
+
+	// a member that never reads fills its socket and queue and is disconnected
+	join("nick=slow&buffer=1")
+	go func() {
+		for {
+			if _, _, err := alice.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		payload := strings.Repeat("x", 8*1024)
+		for i := 0; i < 1000; i++ {
+			if err := alice.WriteMessage(websocket.TextMessage, []byte(payload)); err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		ev := next(carol, "leave")
+		if ev["member"] == "slow" {
+			if ev["reason"] != "slow" {
+				t.Fatalf("leave=%v", ev)
+			}
+			break
+		}
+	}
+}
+
+// An admin push to a room member while the room fans out must not race with
+// the member's writer; run with -race.
+func TestWsRoomAdminPushDuringFanOut(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	hdr := http.Header{"X-Auth-Token": {staticWsToken}}
+	url := fmt.Sprintf("ws://127.0.0.1:%d/ws/room/pushrace", base+3)
+	sender, _, err := websocket.DefaultDialer.Dial(url+"?nick=sender&slow=drop", hdr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = sender.Close() })
+	member, _, err := websocket.DefaultDialer.Dial(url+"?nick=member&buffer=2048", hdr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = member.Close() })
+	go func() {
+		for {
+			if _, _, err := sender.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	const n = 500
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := sender.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("msg-%d", i))); err != nil {
+				return
+			}
+		}
+	}()
+	// push while the member's writer is busy with the fan-out
+	filter := admin.ClientFilter{Path: "/ws/room/pushrace", Query: "nick=member"}
+	pushErr := make(chan error, 1)
+	go func() {
+		for i := 0; i < n; i++ {
+			res, err := admin.Push(filter, admin.Frame{Type: "text", Data: "pushed"})
+			if err == nil && len(res.Sent) != 1 {
+				err = fmt.Errorf("push %d: %+v", i, res)
+			}
+			if err != nil {
+				pushErr <- err
+				return
+			}
+		}
+		pushErr <- nil
+	}()
+
+	gotPushed, gotMessages := 0, 0
+	_ = member.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for gotPushed < n || gotMessages < n {
+		_, b, err := member.ReadMessage()
+		if err != nil {
+			t.Fatalf("read after %d pushed, %d messages: %v", gotPushed, gotMessages, err)
+		}
+		switch {
+		case string(b) == "pushed":
+			gotPushed++
+		case strings.Contains(string(b), `"message"`):
+			gotMessages++
+		}
+	}
+	if err := <-pushErr; err != nil {
+		t.Fatal(err)
+	}
+}
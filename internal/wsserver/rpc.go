@@ -0,0 +1,402 @@
+package wsserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/food"
+
+	"github.com/gorilla/websocket"
+)
+
+// JSON-RPC 2.0 error codes; -32001 and below are application errors.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcOrderNotFound  = -32001
+)
+
+const rpcMaxSleep = time.Minute
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// notification reports whether no response is expected (the id is absent).
+func (r rpcRequest) notification() bool { return len(r.ID) == 0 }
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+func rpcErr(code int, msg string) *rpcError { return &rpcError{Code: code, Message: msg} }
+
+// rpcSession is the state of one /ws/rpc connection.
+type rpcSession struct {
+	c    *wsConn
+	sp   WsSpec
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu     sync.Mutex
+	nextID int
+	subs   map[string]func()
+}
+
+// rpcRoutes mounts /ws/rpc: JSON-RPC 2.0 with echo, add, sleep, getOrder,
+// subscribe and unsubscribe. Every request runs concurrently, so responses
+// arrive in completion order; a batch is answered with one array once all of
+// its calls finish.
+func rpcRoutes(mux *http.ServeMux, sp WsSpec) {
+	handleWS(mux, sp, "/ws/rpc", func(w http.ResponseWriter, r *http.Request) {
+		c, release, ok := accept(w, r, sp)
+		if !ok {
+			return
+		}
+		defer release()
+		s := &rpcSession{c: c, sp: sp, done: make(chan struct{}), subs: map[string]func(){}}
+		defer s.close()
+		for {
+			t, msg, err := c.ReadMessage()
+			if err != nil {
+				common.Logf("WS %s recv loop end: %v", sp.Name, err)
+				return
+			}
+			logWsFrame(sp, "recv", t, msg)
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.handle(msg)
+			}()
+		}
+	})
+}
+
+// close stops subscriptions and waits for in-flight calls.
+func (s *rpcSession) close() {
+	close(s.done)
+	s.mu.Lock()
+	for id, cancel := range s.subs {
+		cancel()
+		delete(s.subs, id)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *rpcSession) send(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		common.Logf("WS %s rpc marshal: %v", s.sp.Name, err)
+		return
+	}
+	_ = writeMessageLogged(s.c, s.sp, websocket.TextMessage, b)
+}
+
+// handle answers one text message: a single request or a batch.
+func (s *rpcSession) handle(msg []byte) {
+	trimmed := bytes.TrimSpace(msg)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(trimmed, &batch); err != nil {
+			s.send(rpcResponse{JSONRPC: "2.0", Error: rpcErr(rpcParseError, "parse error"), ID: json.RawMessage("null")})
+			return
+		}
+		if len(batch) == 0 {
+			s.send(rpcResponse{JSONRPC: "2.0", Error: rpcErr(rpcInvalidRequest, "empty batch"), ID: json.RawMessage("null")})
+			return
+		}
+		var (
+			mu  sync.Mutex
+			wg  sync.WaitGroup
+			out []rpcResponse
+		)
+		for _, raw := range batch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if resp, ok := s.call(raw); ok {
+					mu.Lock()
+					out = append(out, resp)
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if len(out) > 0 {
+			s.send(out)
+		}
+		return
+	}
+	if resp, ok := s.call(trimmed); ok {
+		s.send(resp)
+	}
+}
+
+// call runs one request; ok is false for notifications.
+func (s *rpcSession) call(raw json.RawMessage) (rpcResponse, bool) {
+	resp := rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		if _, isObj := err.(*json.UnmarshalTypeError); isObj {
+			resp.Error = rpcErr(rpcInvalidRequest, "invalid request")
+		} else {
+			resp.Error = rpcErr(rpcParseError, "parse error")
+		}
+		return resp, true
+	}
+	if len(req.ID) > 0 {
+		resp.ID = req.ID
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = rpcErr(rpcInvalidRequest, "invalid request")
+		return resp, true
+	}
+	result, rerr := s.dispatch(req)
+	if req.notification() {
+		return resp, false
+	}
+	if rerr != nil {
+		resp.Error = rerr
+	} else {
+		resp.Result = result
+		if result == nil {
+			resp.Result = json.RawMessage("null")
+		}
+	}
+	return resp, true
+}
+
+func (s *rpcSession) dispatch(req rpcRequest) (interface{}, *rpcError) {
+	switch req.Method {
+	case "echo":
+		if len(req.Params) == 0 {
+			return nil, nil
+		}
+		return req.Params, nil
+	case "add":
+		nums, rerr := rpcNumbers(req.Params)
+		if rerr != nil {
+			return nil, rerr
+		}
+		sum := 0.0
+		for _, n := range nums {
+			sum += n
+		}
+		return sum, nil
+	case "sleep":
+		var p struct {
+			Ms int `json:"ms"`
+		}
+		if rerr := rpcParams(req.Params, &p, "ms"); rerr != nil {
+			return nil, rerr
+		}
+		d := time.Duration(p.Ms) * time.Millisecond
+		if d < 0 || d > rpcMaxSleep {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params", Data: "ms must be within 0..60000"}
+		}
+		select {
+		case <-time.After(d):
+		case <-s.done:
+		}
+		return map[string]interface{}{"slept": p.Ms}, nil
+	case "getOrder":
+		var p struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if rerr := rpcParams(req.Params, &p, "id"); rerr != nil {
+			return nil, rerr
+		}
+		return getOrder(rpcIDString(p.ID))
+	case "subscribe":
+		return s.subscribe(req.Params)
+	case "unsubscribe":
+		var p struct {
+			Subscription string `json:"subscription"`
+		}
+		if rerr := rpcParams(req.Params, &p, "subscription"); rerr != nil {
+			return nil, rerr
+		}
+		s.mu.Lock()
+		cancel, ok := s.subs[p.Subscription]
+		delete(s.subs, p.Subscription)
+		s.mu.Unlock()
+		if ok {
+			cancel()
+		}
+		return ok, nil
+	}
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: "method not found", Data: req.Method}
+}
+
+// rpcParams decodes by-name params into v; by-position params map their
+// first element to the field named first.
+func rpcParams(raw json.RawMessage, v interface{}, first string) *rpcError {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		var arr []json.RawMessage
+		if err := json.Unmarshal(raw, &arr); err != nil || len(arr) == 0 {
+			return &rpcError{Code: rpcInvalidParams, Message: "invalid params", Data: "expected [" + first + "]"}
+		}
+		raw, _ = json.Marshal(map[string]json.RawMessage{first: arr[0]})
+	}
+	if len(raw) == 0 || json.Unmarshal(raw, v) != nil {
+		return &rpcError{Code: rpcInvalidParams, Message: "invalid params", Data: "expected {\"" + first + "\":...}"}
+	}
+	return nil
+}
+
+// rpcNumbers accepts [1,2,3] or {"a":1,"b":2}.
+func rpcNumbers(raw json.RawMessage) ([]float64, *rpcError) {
+	var arr []float64
+	if err := json.Unmarshal(raw, &arr); err == nil {
+		return arr, nil
+	}
+	var obj map[string]float64
+	if err := json.Unmarshal(raw, &obj); err == nil && len(obj) > 0 {
+		out := make([]float64, 0, len(obj))
+		for _, v := range obj {
+			out = append(out, v)
+		}
+		return out, nil
+	}
+	return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params", Data: "expected numbers"}
+}
+
+// rpcIDString accepts numeric or string ids.
+func rpcIDString(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var n json.Number
+	if json.Unmarshal(raw, &n) == nil {
+		return n.String()
+	}
+	return ""
+}
+
+// getOrder returns a live or fixture order, falling back to
+// assets/order/detail.json for other numeric ids.
+func getOrder(id string) (interface{}, *rpcError) {
+	if id == "" {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params", Data: "id required"}
+	}
+	if ref, ok := food.Default.Lookup(id); ok {
+		if ref.Live != nil {
+			return *ref.Live, nil
+		}
+		return ref.Fixture, nil
+	}
+	if _, err := strconv.Atoi(id); err != nil {
+		return nil, &rpcError{Code: rpcOrderNotFound, Message: "order not found", Data: id}
+	}
+	ctx := &common.TemplateContext{Path: map[string]string{"id": id}}
+	v, err := common.LoadJSONTemplate(common.JoinAssets("order", "detail.json"), ctx)
+	if err != nil {
+		return nil, &rpcError{Code: rpcInternalError, Message: "internal error", Data: "order asset unavailable"}
+	}
+	if m, ok := v.(map[string]interface{}); ok && m["data"] != nil {
+		return m["data"], nil
+	}
+	return v, nil
+}
+
+// subscribe starts a notification stream: topic "ticker" (intervalMs,
+// default 1000) or "orders" (food store events, filtered by orderId, userId
+// or merchantId). Notifications use method "subscription".
+func (s *rpcSession) subscribe(raw json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Topic      string `json:"topic"`
+		IntervalMs int    `json:"intervalMs"`
+		OrderID    string `json:"orderId"`
+		UserID     string `json:"userId"`
+		MerchantID string `json:"merchantId"`
+	}
+	if rerr := rpcParams(raw, &p, "topic"); rerr != nil {
+		return nil, rerr
+	}
+	s.mu.Lock()
+	s.nextID++
+	id := fmt.Sprintf("sub-%d", s.nextID)
+	s.mu.Unlock()
+	notify := func(result interface{}) {
+		s.send(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  "subscription",
+			"params":  map[string]interface{}{"subscription": id, "topic": p.Topic, "result": result},
+		})
+	}
+	stop := make(chan struct{})
+	var once sync.Once
+	cancel := func() { once.Do(func() { close(stop) }) }
+	switch p.Topic {
+	case "ticker":
+		interval := time.Duration(p.IntervalMs) * time.Millisecond
+		if interval <= 0 {
+			interval = time.Second
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			for i := 1; ; i++ {
+				select {
+				case <-t.C:
+					notify(map[string]interface{}{"tick": i, "time": time.Now().UnixMilli()})
+				case <-stop:
+					return
+				case <-s.done:
+					return
+				}
+			}
+		}()
+	case "orders":
+		events, unsubscribe := food.Default.Subscribe(food.Filter{OrderID: p.OrderID, UserID: p.UserID, MerchantID: p.MerchantID})
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer unsubscribe()
+			for {
+				select {
+				case ev := <-events:
+					notify(ev)
+				case <-stop:
+					return
+				case <-s.done:
+					return
+				}
+			}
+		}()
+	default:
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid params", Data: "unknown topic " + strconv.Quote(p.Topic)}
+	}
+	s.mu.Lock()
+	s.subs[id] = cancel
+	s.mu.Unlock()
+	return id, nil
+}
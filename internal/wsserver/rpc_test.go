@@ -0,0 +1,155 @@
+package wsserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/food"
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWsJSONRPC(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+	food.Default.Reset()
+	t.Cleanup(food.Default.Reset)
+
+	c, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws/rpc", base+4), http.Header{"X-Auth-Token": {staticWsToken}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer func() { _ = c.Close() }()
+	_ = c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	send := func(s string) {
+		t.Helper()
+		if err := c.WriteMessage(websocket.TextMessage, []byte(s)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	read := func(v interface{}) {
+		t.Helper()
+		_, b, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatalf("decode %s: %v", b, err)
+		}
+	}
+	type resp struct {
+		ID     interface{}     `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+
+	// out of order: the slow call is answered after the fast one
+	send(`{"jsonrpc":"2.0","id":1,"method":"sleep","params":{"ms":300}}`)
+	send(`{"jsonrpc":"2.0","id":"two","method":"add","params":[1,2,3.5]}`)
+	var r resp
+	read(&r)
+	if r.ID != "two" || string(r.Result) != "6.5" {
+		t.Fatalf("first=%+v", r)
+	}
+	read(&r)
+	if r.ID != float64(1) || string(r.Result) != `{"slept":300}` {
+		t.Fatalf("second=%+v", r)
+	}
+
+	// batch with a notification, an unknown method and a bad request
+	send(`[{"jsonrpc":"2.0","id":1,"method":"echo","params":{"a":1}},{"jsonrpc":"2.0","method":"echo"},{"jsonrpc":"2.0","id":2,"method":"nope"},{"id":3}]`)
+	var batch []resp
+	read(&batch)
+	if len(batch) != 3 {
+		t.Fatalf("batch=%+v", batch)
+	}
+	codes := map[interface{}]int{}
+	for _, b := range batch {
+		if b.Error != nil {
+			codes[b.ID] = b.Error.Code
+		} else if string(b.Result) != `{"a":1}` {
+			t.Fatalf("echo=%s", b.Result)
+		}
+	}
+	if codes[float64(2)] != rpcMethodNotFound || codes[float64(3)] != rpcInvalidRequest {
+		t.Fatalf("batch errors=%v", codes)
+	}
+	send(`{not json`)
+	read(&r)
+	if r.Error == nil || r.Error.Code != rpcParseError || r.ID != nil {
+		t.Fatalf("parse error=%+v", r)
+	}
+
+	// getOrder reads the store first, then the fixture orders, then the
+	// detail template
+	o := food.Default.Create(map[string]interface{}{"amount": 12.5}, "test")
+	send(fmt.Sprintf(`{"jsonrpc":"2.0","id":4,"method":"getOrder","params":[%q]}`, o.ID))
+	read(&r)
+	var got food.Order
+	_ = json.Unmarshal(r.Result, &got)
+	if got.ID != o.ID || got.Status != food.StatusCreated {
+		t.Fatalf("getOrder store=%s", r.Result)
+	}
+	send(`{"jsonrpc":"2.0","id":5,"method":"getOrder","params":{"id":2001}}`)
+	read(&r)
+	var fixture map[string]interface{}
+	_ = json.Unmarshal(r.Result, &fixture)
+	if fixture["id"] != float64(2001) || fixture["status"] != "CREATED" {
+		t.Fatalf("getOrder fixture=%s", r.Result)
+	}
+	send(`{"jsonrpc":"2.0","id":5,"method":"getOrder","params":{"id":3005}}`)
+	read(&r)
+	var detail map[string]interface{}
+	_ = json.Unmarshal(r.Result, &detail)
+	if detail["id"] != "3005" || detail["status"] != "PROCESSING" {
+		t.Fatalf("getOrder detail=%s", r.Result)
+	}
+	send(`{"jsonrpc":"2.0","id":6,"method":"getOrder","params":{"id":"x"}}`)
+	read(&r)
+	if r.Error == nil || r.Error.Code != rpcOrderNotFound {
+		t.Fatalf("getOrder missing=%+v", r)
+	}
+
+	// order subscription notifications
+	send(fmt.Sprintf(`{"jsonrpc":"2.0","id":7,"method":"subscribe","params":{"topic":"orders","orderId":%q}}`, o.ID))
+	read(&r)
+	var sub string
+	_ = json.Unmarshal(r.Result, &sub)
+	if sub == "" {
+		t.Fatalf("subscribe=%+v", r)
+	}
+	if _, err := food.Default.Apply(o.ID, food.ActionSubmit, "test", ""); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	read(&r)
+	var note struct {
+		Subscription string     `json:"subscription"`
+		Result       food.Event `json:"result"`
+	}
+	_ = json.Unmarshal(r.Params, &note)
+	if r.Method != "subscription" || note.Subscription != sub || note.Result.Status != food.StatusSubmitted {
+		t.Fatalf("notification=%s %s", r.Method, r.Params)
+	}
+	send(fmt.Sprintf(`{"jsonrpc":"2.0","id":8,"method":"unsubscribe","params":[%q]}`, sub))
+	read(&r)
+	if string(r.Result) != "true" {
+		t.Fatalf("unsubscribe=%+v", r)
+	}
+}
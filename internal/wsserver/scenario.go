@@ -0,0 +1,484 @@
+package wsserver
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+
+	"github.com/gorilla/websocket"
+)
+
+// Scenario step operations.
+const (
+	opSend       = "send"
+	opSendBinary = "send-binary"
+	opExpect     = "expect"
+	opWait       = "wait"
+	opPing       = "ping"
+	opClose      = "close"
+	opBranch     = "branch"
+)
+
+const (
+	scenarioDefaultTimeout = 10 * time.Second
+	scenarioInboxSize      = 64
+)
+
+// wsScenario is a scripted conversation loaded from
+// assets/ws/scenarios/{name}.json and served at /ws/scenario/{name}.
+type wsScenario struct {
+	Name string `json:"name,omitempty"`
+	// Path additionally mounts the scenario at a fixed endpoint (e.g. "/ws/login").
+	Path  string         `json:"path,omitempty"`
+	Steps []scenarioStep `json:"steps"`
+	// KeepOpen leaves the connection open after the last step instead of
+	// closing it with 1000.
+	KeepOpen bool `json:"keepOpen,omitempty"`
+	// FailCode is the close code sent when an expect step fails (default 1008).
+	FailCode int `json:"failCode,omitempty"`
+}
+
+// scenarioStep is one operation of a scenario. Fields apply per Op:
+//   - send: Data as a text frame (non-strings are serialized as JSON)
+//   - send-binary: Base64 or Hex payload
+//   - expect: the next message must satisfy Match within TimeoutMs; Skip
+//     discards non-matching messages instead of failing
+//   - wait: sleep Ms
+//   - ping: ping frame with Data as payload
+//   - close: end the connection with Code and Reason, then stop; Mode and
+//     AfterClose work like the closeMode and afterClose query options
+//   - branch: run the Steps of the first case whose Match accepts the next
+//     message (or the last received one when Last is set), else Default
+//
+// Strings in Data are templates (see common.TemplateContext): query.*,
+// header.* and path.name refer to the upgrade request, body.* to the JSON of
+// the last received message.
+type scenarioStep struct {
+	Op         string         `json:"op"`
+	Data       interface{}    `json:"data,omitempty"`
+	Base64     string         `json:"base64,omitempty"`
+	Hex        string         `json:"hex,omitempty"`
+	Match      *scenarioMatch `json:"match,omitempty"`
+	TimeoutMs  int            `json:"timeoutMs,omitempty"`
+	Skip       bool           `json:"skip,omitempty"`
+	Ms         int            `json:"ms,omitempty"`
+	Code       int            `json:"code,omitempty"`
+	Reason     string         `json:"reason,omitempty"`
+	Mode       string         `json:"mode,omitempty"`
+	AfterClose int            `json:"afterClose,omitempty"`
+	Last       bool           `json:"last,omitempty"`
+	Cases      []scenarioCase `json:"cases,omitempty"`
+	Default    []scenarioStep `json:"default,omitempty"`
+}
+
+type scenarioCase struct {
+	Match scenarioMatch  `json:"match"`
+	Steps []scenarioStep `json:"steps"`
+}
+
+// scenarioMatch accepts a message when every set criterion holds: Type
+// ("text" or "binary"), Equals (exact text), Regex, and JSON (dotted path to
+// expected value, e.g. {"user.role": "admin"}). An empty match accepts anything.
+type scenarioMatch struct {
+	Type   string                 `json:"type,omitempty"`
+	Equals *string                `json:"equals,omitempty"`
+	Regex  string                 `json:"regex,omitempty"`
+	JSON   map[string]interface{} `json:"json,omitempty"`
+	re     *regexp.Regexp
+}
+
+func (m *scenarioMatch) compile() error {
+	switch m.Type {
+	case "", "text", "binary":
+	default:
+		return fmt.Errorf("unknown match type %q", m.Type)
+	}
+	if m.Regex != "" {
+		re, err := regexp.Compile(m.Regex)
+		if err != nil {
+			return err
+		}
+		m.re = re
+	}
+	return nil
+}
+
+func (m *scenarioMatch) matches(msg scenarioMsg) bool {
+	if m == nil {
+		return true
+	}
+	if m.Type != "" && m.Type != wsTypeName(msg.t) {
+		return false
+	}
+	if m.Equals != nil && *m.Equals != string(msg.data) {
+		return false
+	}
+	if m.re != nil && !m.re.Match(msg.data) {
+		return false
+	}
+	if len(m.JSON) > 0 {
+		var v interface{}
+		if err := json.Unmarshal(msg.data, &v); err != nil {
+			return false
+		}
+		for path, want := range m.JSON {
+			if !reflect.DeepEqual(common.LookupDotted(v, path), want) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateSteps checks ops and compiles matchers; label prefixes errors.
+func validateSteps(steps []scenarioStep, label string) error {
+	for i := range steps {
+		st := &steps[i]
+		at := fmt.Sprintf("%s%d", label, i+1)
+		switch st.Op {
+		case opSend, opWait, opPing:
+		case opClose:
+			switch st.Mode {
+			case "", closeFrame, closeReset, closeDrop, closeHalf:
+			default:
+				return fmt.Errorf("step %s: unknown close mode %q", at, st.Mode)
+			}
+		case opSendBinary:
+			if _, err := st.binary(); err != nil {
+				return fmt.Errorf("step %s: %v", at, err)
+			}
+		case opExpect:
+			if st.Match != nil {
+				if err := st.Match.compile(); err != nil {
+					return fmt.Errorf("step %s: %v", at, err)
+				}
+			}
+		case opBranch:
+			for j := range st.Cases {
+				if err := st.Cases[j].Match.compile(); err != nil {
+					return fmt.Errorf("step %s case %d: %v", at, j+1, err)
+				}
+				if err := validateSteps(st.Cases[j].Steps, fmt.Sprintf("%s.%d.", at, j+1)); err != nil {
+					return err
+				}
+			}
+			if err := validateSteps(st.Default, at+".default."); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("step %s: unknown op %q", at, st.Op)
+		}
+	}
+	return nil
+}
+
+func (st *scenarioStep) binary() ([]byte, error) {
+	if st.Hex != "" {
+		return hex.DecodeString(st.Hex)
+	}
+	return base64.StdEncoding.DecodeString(st.Base64)
+}
+
+func (st *scenarioStep) timeout() time.Duration {
+	if st.TimeoutMs > 0 {
+		return time.Duration(st.TimeoutMs) * time.Millisecond
+	}
+	return scenarioDefaultTimeout
+}
+
+var errScenarioNotFound = errors.New("scenario not found")
+
+// loadScenario reads and validates assets/ws/scenarios/{name}.json. Files are
+// read per connection so edits apply without a restart.
+func loadScenario(name string) (*wsScenario, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return nil, errScenarioNotFound
+	}
+	b, err := os.ReadFile(common.JoinAssets("ws", "scenarios", name+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errScenarioNotFound
+		}
+		return nil, err
+	}
+	var sc wsScenario
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return nil, err
+	}
+	if err := validateSteps(sc.Steps, ""); err != nil {
+		return nil, err
+	}
+	if sc.Name == "" {
+		sc.Name = name
+	}
+	return &sc, nil
+}
+
+// scenarioRoutes mounts /ws/scenario/{name} plus the fixed path of every
+// scenario file that declares one.
+func scenarioRoutes(mux *http.ServeMux, sp WsSpec) {
+	handleWS(mux, sp, "/ws/scenario/{name}", func(w http.ResponseWriter, r *http.Request) {
+		serveScenario(w, r, sp, r.PathValue("name"))
+	})
+	files, _ := filepath.Glob(common.JoinAssets("ws", "scenarios", "*.json"))
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".json")
+		sc, err := loadScenario(name)
+		if err != nil {
+			common.Logf("WS %s: scenario %s: %v", sp.Name, name, err)
+			continue
+		}
+		if sc.Path == "" {
+			continue
+		}
+		if !strings.HasPrefix(sc.Path, "/") {
+			common.Logf("WS %s: scenario %s path %q must start with /", sp.Name, name, sc.Path)
+			continue
+		}
+		if err := safeHandleWS(mux, sp, sc.Path, func(w http.ResponseWriter, r *http.Request) {
+			serveScenario(w, r, sp, name)
+		}); err != nil {
+			common.Logf("WS %s: scenario %s: %v", sp.Name, name, err)
+			continue
+		}
+		common.Logf("WS %s: scenario %s at %s", sp.Name, name, sc.Path)
+	}
+}
+
+// safeHandleWS is handleWS converting ServeMux conflict panics into errors.
+func safeHandleWS(mux *http.ServeMux, sp WsSpec, path string, h http.HandlerFunc) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("%v", rec)
+		}
+	}()
+	handleWS(mux, sp, path, h)
+	return nil
+}
+
+func serveScenario(w http.ResponseWriter, r *http.Request, sp WsSpec, name string) {
+	sc, err := loadScenario(name)
+	if err == errScenarioNotFound {
+		common.JSON(w, http.StatusNotFound, map[string]interface{}{"error": "scenario not found", "scenario": name})
+		return
+	}
+	if err != nil {
+		common.JSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "invalid scenario", "scenario": name, "detail": err.Error()})
+		return
+	}
+	c, release, ok := accept(w, r, sp)
+	if !ok {
+		return
+	}
+	defer release()
+	run := &scenarioRun{c: c, sp: sp, r: r, name: name, inbox: make(chan scenarioMsg, scenarioInboxSize), done: make(chan struct{})}
+	defer close(run.done)
+	go run.readLoop()
+	err = run.steps(sc.Steps, "")
+	var fail *scenarioFailure
+	switch {
+	case err == errScenarioStopped || err == errScenarioClientGone:
+	case errors.As(err, &fail):
+		common.Logf("WS %s scenario %s: %v", sp.Name, name, fail)
+		code := sc.FailCode
+		if code == 0 {
+			code = websocket.ClosePolicyViolation
+		}
+		_ = c.closeWith(code, fail.Error())
+	case err != nil:
+		common.Logf("WS %s scenario %s: %v", sp.Name, name, err)
+	case sc.KeepOpen:
+		<-run.closed()
+	default:
+		_ = c.closeWith(websocket.CloseNormalClosure, "done")
+	}
+}
+
+// truncateReason keeps a close reason within the 123-byte control frame limit.
+func truncateReason(s string) string {
+	if len(s) > 123 {
+		return s[:123]
+	}
+	return s
+}
+
+type scenarioMsg struct {
+	t    int
+	data []byte
+}
+
+var (
+	errScenarioStopped    = errors.New("scenario closed the connection")
+	errScenarioClientGone = errors.New("client went away")
+)
+
+// scenarioFailure reports an expect or branch step that did not get a
+// matching message.
+type scenarioFailure struct {
+	step string
+	msg  string
+}
+
+func (f *scenarioFailure) Error() string { return "step " + f.step + ": " + f.msg }
+
+type scenarioRun struct {
+	c     *wsConn
+	sp    WsSpec
+	r     *http.Request
+	name  string
+	inbox chan scenarioMsg
+	done  chan struct{}
+	last  scenarioMsg
+}
+
+// readLoop feeds inbound data frames to the inbox; it closes the inbox when
+// the client goes away.
+func (s *scenarioRun) readLoop() {
+	defer close(s.inbox)
+	for {
+		t, msg, err := s.c.ReadMessage()
+		if err != nil {
+			common.Logf("WS %s recv loop end: %v", s.sp.Name, err)
+			return
+		}
+		logWsFrame(s.sp, "recv", t, msg)
+		select {
+		case s.inbox <- scenarioMsg{t: t, data: msg}:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// closed drains the inbox and is closed once the client disconnects.
+func (s *scenarioRun) closed() <-chan struct{} {
+	ch := make(chan struct{})
+	go func() {
+		for range s.inbox {
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+func (s *scenarioRun) next(d time.Duration) (scenarioMsg, error) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case msg, ok := <-s.inbox:
+		if !ok {
+			return scenarioMsg{}, errScenarioClientGone
+		}
+		s.last = msg
+		return msg, nil
+	case <-timer.C:
+		return scenarioMsg{}, errors.New("timeout")
+	}
+}
+
+func (s *scenarioRun) templateContext() *common.TemplateContext {
+	return &common.TemplateContext{
+		Path:    map[string]string{"name": s.name},
+		Query:   s.r.URL.Query(),
+		Header:  s.r.Header,
+		RawBody: string(s.last.data),
+	}
+}
+
+func (s *scenarioRun) steps(steps []scenarioStep, label string) error {
+	for i := range steps {
+		if err := s.step(&steps[i], fmt.Sprintf("%s%d", label, i+1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *scenarioRun) step(st *scenarioStep, at string) error {
+	c, sp := s.c, s.sp
+	switch st.Op {
+	case opSend:
+		var payload []byte
+		switch v := common.RenderTemplate(st.Data, s.templateContext()).(type) {
+		case string:
+			payload = []byte(v)
+		default:
+			b, err := common.JsonMarshalCompat(v)
+			if err != nil {
+				return err
+			}
+			payload = b
+		}
+		return writeMessageLogged(c, sp, websocket.TextMessage, payload)
+	case opSendBinary:
+		b, _ := st.binary()
+		return writeMessageLogged(c, sp, websocket.BinaryMessage, b)
+	case opWait:
+		time.Sleep(time.Duration(st.Ms) * time.Millisecond)
+	case opPing:
+		data, _ := st.Data.(string)
+		return writeControlLogged(c, sp, websocket.PingMessage, []byte(data), time.Now().Add(time.Second))
+	case opClose:
+		// query close options still win over the script
+		p := c.plan
+		if p.Code == 0 {
+			p.Code = st.Code
+		}
+		if !p.HasReason {
+			p.Reason = st.Reason
+		}
+		if p.Mode == "" {
+			p.Mode = st.Mode
+		}
+		if p.AfterClose == 0 {
+			p.AfterClose = st.AfterClose
+		}
+		_ = c.terminate(p)
+		return errScenarioStopped
+	case opExpect:
+		deadline := time.Now().Add(st.timeout())
+		for {
+			msg, err := s.next(time.Until(deadline))
+			if err == errScenarioClientGone {
+				return err
+			}
+			if err != nil {
+				return &scenarioFailure{step: at, msg: "expect " + err.Error()}
+			}
+			if st.Match.matches(msg) {
+				return nil
+			}
+			if !st.Skip {
+				return &scenarioFailure{step: at, msg: "unexpected " + summarizePayload(msg.t, msg.data)}
+			}
+		}
+	case opBranch:
+		msg := s.last
+		if !st.Last {
+			var err error
+			if msg, err = s.next(st.timeout()); err == errScenarioClientGone {
+				return err
+			} else if err != nil {
+				return &scenarioFailure{step: at, msg: "branch " + err.Error()}
+			}
+		}
+		for j := range st.Cases {
+			if st.Cases[j].Match.matches(msg) {
+				return s.steps(st.Cases[j].Steps, fmt.Sprintf("%s.%d.", at, j+1))
+			}
+		}
+		return s.steps(st.Default, at+".default.")
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package wsserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestScenarioMatch(t *testing.T) {
+	text := func(s string) scenarioMsg { return scenarioMsg{t: websocket.TextMessage, data: []byte(s)} }
+	bye := "bye"
+	cases := []struct {
+		m    scenarioMatch
+		msg  scenarioMsg
+		want bool
+	}{
+		{scenarioMatch{}, text("anything"), true},
+		{scenarioMatch{Equals: &bye}, text("bye"), true},
+		{scenarioMatch{Equals: &bye}, text("bye!"), false},
+		{scenarioMatch{Regex: `^ord-\d+$`}, text("ord-42"), true},
+		{scenarioMatch{JSON: map[string]interface{}{"user.role": "admin", "items.0.qty": float64(2)}}, text(`{"user":{"role":"admin"},"items":[{"qty":2}]}`), true},
+		{scenarioMatch{JSON: map[string]interface{}{"user.role": "admin"}}, text(`{"user":{"role":"guest"}}`), false},
+		{scenarioMatch{JSON: map[string]interface{}{"type": "x"}}, text("not json"), false},
+		{scenarioMatch{Type: "binary"}, scenarioMsg{t: websocket.BinaryMessage, data: []byte{1}}, true},
+		{scenarioMatch{Type: "binary"}, text("x"), false},
+	}
+	for i, tc := range cases {
+		if err := tc.m.compile(); err != nil {
+			t.Fatalf("case %d: compile: %v", i, err)
+		}
+		if got := tc.m.matches(tc.msg); got != tc.want {
+			t.Fatalf("case %d: matches=%v want %v", i, got, tc.want)
+		}
+	}
+	if err := validateSteps([]scenarioStep{{Op: "teleport"}}, ""); err == nil {
+		t.Fatalf("unknown op accepted")
+	}
+}
+
+func TestScenarioLogin(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+	hdr := http.Header{"X-Auth-Token": {staticWsToken}}
+	dial := func(path string) *websocket.Conn {
+		t.Helper()
+		c, _, err := websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d%s", base+5, path), hdr)
+		if err != nil {
+			t.Fatalf("dial %s: %v", path, err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		_ = c.SetReadDeadline(time.Now().Add(3 * time.Second))
+		return c
+	}
+	readJSON := func(c *websocket.Conn) map[string]interface{} {
+		t.Helper()
+		var m map[string]interface{}
+		if err := c.ReadJSON(&m); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return m
+	}
+
+	c := dial("/ws/scenario/login?client=qa")
+	if hello := readJSON(c); hello["type"] != "hello" || hello["client"] != "qa" {
+		t.Fatalf("hello=%v", hello)
+	}
+	_ = c.WriteJSON(map[string]interface{}{"type": "login", "user": "alice", "token": "secret"})
+	if ok := readJSON(c); ok["type"] != "login_ok" || ok["user"] != "alice" {
+		t.Fatalf("login_ok=%v", ok)
+	}
+	pinged := make(chan string, 1)
+	c.SetPingHandler(func(data string) error { pinged <- data; return nil })
+	if mt, b, err := c.ReadMessage(); err != nil || mt != websocket.BinaryMessage || len(b) != 5 {
+		t.Fatalf("binary: %d %v %v", mt, b, err)
+	}
+	_ = c.WriteMessage(websocket.TextMessage, []byte("bye"))
+	if _, b, err := c.ReadMessage(); err != nil || string(b) != "see you" {
+		t.Fatalf("reply: %q %v", b, err)
+	}
+	if _, _, err := c.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("close: %v", err)
+	}
+	if p := <-pinged; p != "are-you-there" {
+		t.Fatalf("ping=%q", p)
+	}
+
+	// the fixed path serves the same file; a bad token takes the default branch
+	c = dial("/ws/login")
+	readJSON(c)
+	_ = c.WriteJSON(map[string]interface{}{"type": "login", "token": "nope"})
+	if m := readJSON(c); m["type"] != "login_failed" {
+		t.Fatalf("failed=%v", m)
+	}
+	if _, _, err := c.ReadMessage(); !websocket.IsCloseError(err, 4001) {
+		t.Fatalf("close: %v", err)
+	}
+
+	// an unexpected message fails the expect step with 1008
+	c = dial("/ws/login")
+	readJSON(c)
+	_ = c.WriteMessage(websocket.TextMessage, []byte("hi"))
+	if _, _, err := c.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("close: %v", err)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/ws/scenario/missing", base+5))
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("missing scenario status=%d", resp.StatusCode)
+	}
+}
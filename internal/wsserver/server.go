@@ -1,67 +1,105 @@
 package wsserver
 
 import (
-	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"intercept-wave-upstream/internal/admin"
 	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/tlsutil"
+	"intercept-wave-upstream/internal/topology"
 
 	"github.com/gorilla/websocket"
 )
 
 type WsSpec struct {
-	Name string
-	Port int
+	Name            string
+	Port            int
+	InterceptPrefix string
+	EventKey        string
+	Bundles         []string
+	TLS             *topology.ServiceTLS
+	TLSPort         int
+	Options         *topology.WSOptions
+	Endpoints       map[string]topology.WSOptions
+	auth            *wsAuth
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+// routeBundles maps topology bundle names to the WS endpoints they mount.
+var routeBundles = map[string]func(mux *http.ServeMux, sp WsSpec){
+	"echo":     echoRoutes,
+	"ticker":   tickerRoutes,
+	"timeline": timelineRoutes,
+	"food":     foodRoutes,
+	"room":     roomRoutes,
+	"scenario": scenarioRoutes,
+	"binary":   binaryRoutes,
+	"rpc":      rpcRoutes,
+	"socketio": socketioRoutes,
+	"stomp":    stompRoutes,
+	"mqtt":     mqttRoutes,
+	"graphql":  graphqlRoutes,
 }
 
-// staticWsToken is the hardcoded token required to access WS endpoints.
-// Clients must provide it via header "X-Auth-Token" or query param "token".
-const staticWsToken = "zhongmiao-org-token"
-
-func requireToken(w http.ResponseWriter, r *http.Request) bool {
-	tok := r.Header.Get("X-Auth-Token")
-	if tok == "" {
-		tok = r.URL.Query().Get("token")
-	}
-	if tok != staticWsToken {
-		common.JSON(w, http.StatusUnauthorized, map[string]interface{}{
-			"error": "unauthorized",
-			"hint":  "provide X-Auth-Token header or ?token=",
+// SpecsFromTopology resolves topology entries into WS specs for base.
+func SpecsFromTopology(services []topology.WSService, base int) []WsSpec {
+	specs := make([]WsSpec, 0, len(services))
+	for _, s := range services {
+		specs = append(specs, WsSpec{
+			Name:            s.Name,
+			Port:            s.ResolvePort(base),
+			InterceptPrefix: s.InterceptPrefix,
+			EventKey:        s.EventKey,
+			Bundles:         s.Bundles,
+			TLS:             s.TLS,
+			Options:         s.Options,
+			Endpoints:       s.Endpoints,
+			auth:            newWSAuth(s.Auth),
 		})
-		return false
+		if s.TLS != nil {
+			specs[len(specs)-1].TLSPort = s.TLS.ResolvePort(base)
+		}
 	}
-	return true
+	return specs
 }
 
-func StartAll(base int) []*http.Server {
-	specs := []WsSpec{
-		{Name: "ws-echo", Port: base + 3},
-		{Name: "ws-ticker", Port: base + 4},
-		{Name: "ws-timeline", Port: base + 5},
-	}
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func StartAll(base int, topo []topology.WSService) []*http.Server {
+	specs := SpecsFromTopology(topo, base)
 	servers := make([]*http.Server, 0, len(specs))
 	for _, sp := range specs {
+		svc := admin.Register(sp.Name, "ws")
 		mux := http.NewServeMux()
 		attachRoutes(mux, sp)
-		srv := &http.Server{Addr: fmt.Sprintf(":%d", sp.Port), Handler: common.RequestLogger(mux)}
+		handler := common.RequestLogger(admin.Gate(svc, mux))
+		srv := &http.Server{Addr: fmt.Sprintf(":%d", sp.Port), Handler: handler}
 		servers = append(servers, srv)
-		go func(spec WsSpec, s *http.Server) {
-			common.Logf("WS %s listening on :%d", spec.Name, spec.Port)
-			if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-				common.Logf("WS server %s error: %v", spec.Name, err)
-			}
-		}(sp, srv)
+		if err := svc.Serve(srv, sp.Port, false); err != nil {
+			common.Logf("WS server %s error: %v", sp.Name, err)
+		} else {
+			common.Logf("WS %s listening on :%d", sp.Name, sp.Port)
+		}
+		if sp.TLS == nil || !sp.TLS.Enabled {
+			continue
+		}
+		tlsSrv, err := tlsutil.NewServer(sp.Name, sp.TLSPort, handler, *sp.TLS)
+		if err != nil {
+			common.Logf("WSS %s disabled: %v", sp.Name, err)
+			continue
+		}
+		servers = append(servers, tlsSrv)
+		if err := svc.Serve(tlsSrv, sp.TLSPort, true); err != nil {
+			common.Logf("WSS server %s error: %v", sp.Name, err)
+			continue
+		}
+		common.Logf("WSS %s listening on :%d (clientAuth=%s)", sp.Name, sp.TLSPort, sp.TLS.ClientAuth)
 	}
-	// brief wait for bind
-	time.Sleep(100 * time.Millisecond)
 	return servers
 }
 
@@ -69,17 +107,87 @@ func attachRoutes(mux *http.ServeMux, sp WsSpec) {
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		common.JSON(w, 200, map[string]interface{}{"service": sp.Name, "port": sp.Port})
 	})
+	for _, b := range sp.Bundles {
+		routes, ok := routeBundles[b]
+		if !ok {
+			common.Logf("WS %s: unknown route bundle %q (skipped)", sp.Name, b)
+			continue
+		}
+		routes(mux, sp)
+	}
+}
 
-	mux.HandleFunc("/ws/echo", func(w http.ResponseWriter, r *http.Request) {
-		if !requireToken(w, r) {
-			return
+// handleWS registers a WS endpoint at path and, when the service declares an
+// interceptPrefix, also at prefix+path. Both share the endpoint options of path.
+func handleWS(mux *http.ServeMux, sp WsSpec, path string, h http.HandlerFunc) {
+	h = withEndpoint(newEndpoint(sp, path), h)
+	mux.HandleFunc(path, h)
+	if sp.InterceptPrefix != "" {
+		mux.HandleFunc(sp.InterceptPrefix+path, h)
+	}
+}
+
+// accept authenticates the request, upgrades the connection with the
+// endpoint options and tracks it as an admin client that accepts pushes. release closes the
+// connection and drops it from the registry.
+func accept(w http.ResponseWriter, r *http.Request, sp WsSpec) (*wsConn, func(), bool) {
+	ep := endpointFrom(r)
+	res, ok := authorize(w, r, sp)
+	if !ok || !ep.checkSubprotocol(w, r) {
+		return nil, nil, false
+	}
+	var respHeader http.Header
+	if res.Subprotocol != "" {
+		respHeader = http.Header{"Sec-Websocket-Protocol": {res.Subprotocol}}
+	}
+	raw, err := ep.upgraderFor(r).Upgrade(w, r, respHeader)
+	if err != nil {
+		log.Printf("upgrade: %v", err)
+		return nil, nil, false
+	}
+	c := newWSConn(raw, sp, closePlanFromQuery(r))
+	extension := ep.configureCompression(raw, r)
+	untrack := admin.TrackClient(&admin.Client{
+		Service:   sp.Name,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Remote:    r.RemoteAddr,
+		Principal: res.Principal,
+		Send:      c.push,
+	})
+	var closeTimer *time.Timer
+	if c.plan.After > 0 {
+		closeTimer = time.AfterFunc(c.plan.After, func() { _ = c.closeWith(websocket.CloseNormalClosure, "closeAfterMs") })
+	}
+	stopHeartbeat := c.startHeartbeat(ep.heartbeatFor(r), r)
+	release := func() {
+		stopHeartbeat()
+		if closeTimer != nil {
+			closeTimer.Stop()
 		}
-		c, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Printf("upgrade: %v", err)
+		untrack()
+		if c.clientClosed.Load() {
+			// clientClose=ignore: hold the socket without answering
+			time.Sleep(c.plan.Wait)
+		}
+		_ = c.Close()
+	}
+	if ep.wantsHello(r) {
+		if err := sendHello(c, sp, ep, r, res, extension); err != nil {
+			release()
+			return nil, nil, false
+		}
+	}
+	return c, release, true
+}
+
+func echoRoutes(mux *http.ServeMux, sp WsSpec) {
+	handleWS(mux, sp, "/ws/echo", func(w http.ResponseWriter, r *http.Request) {
+		c, release, ok := accept(w, r, sp)
+		if !ok {
 			return
 		}
-		defer func() { _ = c.Close() }()
+		defer release()
 		for {
 			t, msg, err := c.ReadMessage()
 			if err != nil {
@@ -92,17 +200,15 @@ func attachRoutes(mux *http.ServeMux, sp WsSpec) {
 			}
 		}
 	})
+}
 
-	mux.HandleFunc("/ws/ticker", func(w http.ResponseWriter, r *http.Request) {
-		if !requireToken(w, r) {
+func tickerRoutes(mux *http.ServeMux, sp WsSpec) {
+	handleWS(mux, sp, "/ws/ticker", func(w http.ResponseWriter, r *http.Request) {
+		c, release, ok := accept(w, r, sp)
+		if !ok {
 			return
 		}
-		c, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Printf("upgrade: %v", err)
-			return
-		}
-		defer func() { _ = c.Close() }()
+		defer release()
 		// start background reader to log any inbound messages; signals done on error/close
 		done := make(chan struct{})
 		go func() {
@@ -139,32 +245,16 @@ func attachRoutes(mux *http.ServeMux, sp WsSpec) {
 			}
 		}
 	})
+}
 
-	mux.HandleFunc("/ws/timeline", func(w http.ResponseWriter, r *http.Request) {
-		if !requireToken(w, r) {
+func timelineRoutes(mux *http.ServeMux, sp WsSpec) {
+	handleWS(mux, sp, "/ws/timeline", func(w http.ResponseWriter, r *http.Request) {
+		c, release, ok := accept(w, r, sp)
+		if !ok {
 			return
 		}
-		c, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Printf("upgrade: %v", err)
-			return
-		}
-		defer func() { _ = c.Close() }()
-		// Load timeline messages from assets if present
-		msgs := []string{"hello", "processing", "done"}
-		if v, err := common.LoadJSONDynamic(common.JoinAssets("ws", "timeline.json")); err == nil {
-			if arr, ok := v.([]interface{}); ok && len(arr) > 0 {
-				tmp := make([]string, 0, len(arr))
-				for _, it := range arr {
-					if s, ok := it.(string); ok {
-						tmp = append(tmp, s)
-					}
-				}
-				if len(tmp) > 0 {
-					msgs = tmp
-				}
-			}
-		}
+		defer release()
+		msgs := loadTimeline()
 		// background reader: log and stop sequence when client closes
 		done := make(chan struct{})
 		go func() {
@@ -192,24 +282,49 @@ func attachRoutes(mux *http.ServeMux, sp WsSpec) {
 			default:
 			}
 		}
-		if err := writeControlLogged(c, sp, websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"), time.Now().Add(time.Second)); err != nil {
+		if err := c.closeWith(websocket.CloseNormalClosure, "bye"); err != nil {
 			log.Printf("write close ctrl: %v", err)
 		}
 	})
+}
 
-	// Food delivery workflow simulation endpoints
-	// - /ws/food/user: end-user notifications
-	// - /ws/food/merchant: merchant-side notifications
-	mux.HandleFunc("/ws/food/user", func(w http.ResponseWriter, r *http.Request) {
-		if !requireToken(w, r) {
+// loadTimeline returns the messages of assets/ws/timeline.json, falling back
+// to a built-in sequence.
+func loadTimeline() []string {
+	msgs := []string{"hello", "processing", "done"}
+	if v, err := common.LoadJSONDynamic(common.JoinAssets("ws", "timeline.json")); err == nil {
+		if arr, ok := v.([]interface{}); ok && len(arr) > 0 {
+			tmp := make([]string, 0, len(arr))
+			for _, it := range arr {
+				if s, ok := it.(string); ok {
+					tmp = append(tmp, s)
+				}
+			}
+			if len(tmp) > 0 {
+				msgs = tmp
+			}
+		}
+	}
+	return msgs
+}
+
+// Food delivery workflow simulation endpoints
+// - /ws/food/user: end-user notifications
+// - /ws/food/merchant: merchant-side notifications
+//
+// By default both attach to the shared order store (see serveLiveFood);
+// ?mode=replay replays the fixed flows from assets/ws instead.
+func foodRoutes(mux *http.ServeMux, sp WsSpec) {
+	handleWS(mux, sp, "/ws/food/user", func(w http.ResponseWriter, r *http.Request) {
+		c, release, ok := accept(w, r, sp)
+		if !ok {
 			return
 		}
-		c, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Printf("upgrade: %v", err)
+		defer release()
+		if r.URL.Query().Get("mode") != "replay" {
+			serveLiveFood(c, sp, r, foodRoleUser)
 			return
 		}
-		defer func() { _ = c.Close() }()
 		// background reader to log inbound
 		done := make(chan struct{})
 		go func() {
@@ -239,19 +354,19 @@ func attachRoutes(mux *http.ServeMux, sp WsSpec) {
 			default:
 			}
 		}
-		_ = writeControlLogged(c, sp, websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"), time.Now().Add(time.Second))
+		_ = c.closeWith(websocket.CloseNormalClosure, "bye")
 	})
 
-	mux.HandleFunc("/ws/food/merchant", func(w http.ResponseWriter, r *http.Request) {
-		if !requireToken(w, r) {
+	handleWS(mux, sp, "/ws/food/merchant", func(w http.ResponseWriter, r *http.Request) {
+		c, release, ok := accept(w, r, sp)
+		if !ok {
 			return
 		}
-		c, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Printf("upgrade: %v", err)
+		defer release()
+		if r.URL.Query().Get("mode") != "replay" {
+			serveLiveFood(c, sp, r, foodRoleMerchant)
 			return
 		}
-		defer func() { _ = c.Close() }()
 		// background reader to log inbound
 		done := make(chan struct{})
 		go func() {
@@ -281,22 +396,17 @@ func attachRoutes(mux *http.ServeMux, sp WsSpec) {
 			default:
 			}
 		}
-		_ = writeControlLogged(c, sp, websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"), time.Now().Add(time.Second))
+		_ = c.closeWith(websocket.CloseNormalClosure, "bye")
 	})
 }
 
-// pick event key per WS service: ensure diversity across the three services
+// eventKeyForService returns the topology-configured event key (default "type").
+// The default topology keeps diversity across the three services.
 func eventKeyForService(sp WsSpec) string {
-	switch sp.Name {
-	case "ws-echo":
-		return "type"
-	case "ws-ticker":
-		return "action"
-	case "ws-timeline":
-		return "event"
-	default:
-		return "type"
+	if sp.EventKey != "" {
+		return sp.EventKey
 	}
+	return "type"
 }
 
 // parseInterval reads ?interval=ms (default def)
@@ -310,7 +420,7 @@ func parseInterval(r *http.Request, def int) int {
 }
 
 // writeJSON serializes a map to JSON text message
-func writeJSONWithLog(c *websocket.Conn, sp WsSpec, m map[string]interface{}) error {
+func writeJSONWithLog(c *wsConn, sp WsSpec, m map[string]interface{}) error {
 	// use common.jsonMarshal for consistency
 	b, err := common.JsonMarshalCompat(m)
 	if err != nil {
@@ -320,13 +430,13 @@ func writeJSONWithLog(c *websocket.Conn, sp WsSpec, m map[string]interface{}) er
 }
 
 // writeMessageLogged writes a WS frame and logs the payload direction/type.
-func writeMessageLogged(c *websocket.Conn, sp WsSpec, t int, payload []byte) error {
+func writeMessageLogged(c *wsConn, sp WsSpec, t int, payload []byte) error {
 	logWsFrame(sp, "send", t, payload)
 	return c.WriteMessage(t, payload)
 }
 
 // writeControlLogged writes a control frame (e.g., close) and logs it.
-func writeControlLogged(c *websocket.Conn, sp WsSpec, t int, payload []byte, deadline time.Time) error {
+func writeControlLogged(c *wsConn, sp WsSpec, t int, payload []byte, deadline time.Time) error {
 	logWsFrame(sp, "send", t, payload)
 	return c.WriteControl(t, payload, deadline)
 }
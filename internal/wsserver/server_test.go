@@ -3,35 +3,18 @@ package wsserver
 import (
 	"context"
 	"fmt"
-	"net"
 	"net/http"
 	"testing"
 	"time"
 
+	"intercept-wave-upstream/internal/testutil"
+	"intercept-wave-upstream/internal/topology"
+
 	"github.com/gorilla/websocket"
 )
 
-func findFreeBase() (int, error) {
-	for base := 20010; base < 20100; base += 7 {
-		ok := true
-		var conns []net.Listener
-		for p := base; p <= base+5; p++ {
-			ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", p))
-			if err != nil {
-				ok = false
-				break
-			}
-			conns = append(conns, ln)
-		}
-		for _, c := range conns {
-			_ = c.Close()
-		}
-		if ok {
-			return base, nil
-		}
-	}
-	return 0, fmt.Errorf("no free port range")
-}
+// findFreeBase reserves the default topology's 6 ports (WS: +3..+5).
+func findFreeBase() (int, error) { return testutil.FreeBase(6) }
 
 func TestWsEcho(t *testing.T) {
 	base, err := findFreeBase()
@@ -39,7 +22,7 @@ func TestWsEcho(t *testing.T) {
 		t.Fatalf("findFreeBase: %v", err)
 	}
 
-	srvs := StartAll(base)
+	srvs := StartAll(base, topology.Default().WS)
 	t.Cleanup(func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
@@ -0,0 +1,476 @@
+package wsserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+)
+
+// Socket.IO v5 packet types.
+const (
+	sioConnect      = 0
+	sioDisconnect   = 1
+	sioEvent        = 2
+	sioAck          = 3
+	sioConnectError = 4
+	sioBinaryEvent  = 5
+	sioBinaryAck    = 6
+)
+
+// sioPacket is a decoded Socket.IO packet. ID is -1 when absent. Data holds
+// decoded JSON; binary attachments appear as []byte.
+type sioPacket struct {
+	Type        int
+	Nsp         string
+	ID          int
+	Attachments int
+	Data        interface{}
+}
+
+// decodeSIO parses <type>[<attachments>-][<nsp>,][<id>][<json>].
+func decodeSIO(s string) (*sioPacket, error) {
+	if s == "" || s[0] < '0' || s[0] > '6' {
+		return nil, fmt.Errorf("invalid packet type")
+	}
+	p := &sioPacket{Type: int(s[0] - '0'), Nsp: "/", ID: -1}
+	s = s[1:]
+	if p.Type == sioBinaryEvent || p.Type == sioBinaryAck {
+		i := strings.IndexByte(s, '-')
+		if i < 0 {
+			return nil, fmt.Errorf("missing attachment count")
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid attachment count")
+		}
+		p.Attachments, s = n, s[i+1:]
+	}
+	if strings.HasPrefix(s, "/") {
+		if i := strings.IndexByte(s, ','); i >= 0 {
+			p.Nsp, s = s[:i], s[i+1:]
+		} else {
+			p.Nsp, s = s, ""
+		}
+	}
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i > 0 {
+		p.ID, _ = strconv.Atoi(s[:i])
+		s = s[i:]
+	}
+	if s != "" {
+		if err := json.Unmarshal([]byte(s), &p.Data); err != nil {
+			return nil, fmt.Errorf("invalid payload: %v", err)
+		}
+	}
+	return p, nil
+}
+
+// encode renders p as Engine.IO message packets: the text packet followed by
+// one binary packet per []byte found in Data.
+func (p *sioPacket) encode() []eioPacket {
+	data, bufs := sioDeconstruct(p.Data, nil)
+	t := p.Type
+	if len(bufs) > 0 && t == sioEvent {
+		t = sioBinaryEvent
+	} else if len(bufs) > 0 && t == sioAck {
+		t = sioBinaryAck
+	}
+	var sb strings.Builder
+	sb.WriteByte(eioMessage)
+	sb.WriteString(strconv.Itoa(t))
+	if len(bufs) > 0 {
+		sb.WriteString(strconv.Itoa(len(bufs)) + "-")
+	}
+	if p.Nsp != "" && p.Nsp != "/" {
+		sb.WriteString(p.Nsp + ",")
+	}
+	if p.ID >= 0 {
+		sb.WriteString(strconv.Itoa(p.ID))
+	}
+	if data != nil {
+		b, _ := common.JsonMarshalCompat(data)
+		sb.Write(b)
+	}
+	out := []eioPacket{{text: sb.String()}}
+	for _, b := range bufs {
+		out = append(out, eioPacket{binary: b})
+	}
+	return out
+}
+
+// sioDeconstruct replaces []byte values by attachment placeholders.
+func sioDeconstruct(v interface{}, bufs [][]byte) (interface{}, [][]byte) {
+	switch t := v.(type) {
+	case []byte:
+		bufs = append(bufs, t)
+		return map[string]interface{}{"_placeholder": true, "num": len(bufs) - 1}, bufs
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			out[i], bufs = sioDeconstruct(e, bufs)
+		}
+		return out, bufs
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			out[k], bufs = sioDeconstruct(e, bufs)
+		}
+		return out, bufs
+	}
+	return v, bufs
+}
+
+// sioReconstruct replaces attachment placeholders by their buffers.
+func sioReconstruct(v interface{}, bufs [][]byte) interface{} {
+	switch t := v.(type) {
+	case []interface{}:
+		for i, e := range t {
+			t[i] = sioReconstruct(e, bufs)
+		}
+	case map[string]interface{}:
+		if t["_placeholder"] == true {
+			if n, ok := t["num"].(float64); ok && int(n) >= 0 && int(n) < len(bufs) {
+				return bufs[int(n)]
+			}
+		}
+		for k, e := range t {
+			t[k] = sioReconstruct(e, bufs)
+		}
+	}
+	return v
+}
+
+// sioNamespaces lists the served namespaces; onConnect may start server
+// pushes driven by the ws assets.
+var sioNamespaces = map[string]func(k *sioSocket){
+	"/":         nil,
+	"/timeline": sioTimeline,
+	"/food":     sioFood,
+}
+
+// sioSocket is one namespace connection of a session.
+type sioSocket struct {
+	id   string
+	nsp  string
+	sess *eioSession
+	auth map[string]interface{}
+	done chan struct{}
+	once sync.Once
+
+	mu      sync.Mutex
+	rooms   map[string]bool
+	acks    map[int]func(args []interface{})
+	nextAck int
+}
+
+func (sess *eioSession) sendSIO(p *sioPacket) { sess.send(p.encode()...) }
+
+// onMessage handles a Socket.IO packet; binary packets wait for their attachments.
+func (sess *eioSession) onMessage(s string) {
+	p, err := decodeSIO(s)
+	if err != nil {
+		common.Logf("SIO %s session %s: %v", sess.srv.sp.Name, sess.id, err)
+		return
+	}
+	if p.Attachments > 0 {
+		sess.mu.Lock()
+		sess.partial, sess.parts = p, nil
+		sess.mu.Unlock()
+		return
+	}
+	sess.dispatch(p)
+}
+
+func (sess *eioSession) handleBinary(b []byte) {
+	sess.mu.Lock()
+	p := sess.partial
+	if p == nil {
+		sess.mu.Unlock()
+		return
+	}
+	sess.parts = append(sess.parts, b)
+	if len(sess.parts) < p.Attachments {
+		sess.mu.Unlock()
+		return
+	}
+	p.Data = sioReconstruct(p.Data, sess.parts)
+	sess.partial, sess.parts = nil, nil
+	sess.mu.Unlock()
+	sess.dispatch(p)
+}
+
+func (sess *eioSession) dispatch(p *sioPacket) {
+	sess.mu.Lock()
+	k := sess.sockets[p.Nsp]
+	sess.mu.Unlock()
+	switch p.Type {
+	case sioConnect:
+		onConnect, ok := sioNamespaces[p.Nsp]
+		if !ok {
+			sess.sendSIO(&sioPacket{Type: sioConnectError, Nsp: p.Nsp, ID: -1, Data: map[string]interface{}{"message": "Invalid namespace"}})
+			return
+		}
+		if k != nil {
+			return
+		}
+		k = &sioSocket{id: eioID(), nsp: p.Nsp, sess: sess, done: make(chan struct{}), rooms: map[string]bool{}, acks: map[int]func([]interface{}){}}
+		k.auth, _ = p.Data.(map[string]interface{})
+		sess.mu.Lock()
+		sess.sockets[p.Nsp] = k
+		sess.mu.Unlock()
+		sess.sendSIO(&sioPacket{Type: sioConnect, Nsp: p.Nsp, ID: -1, Data: map[string]interface{}{"sid": k.id}})
+		if onConnect != nil {
+			go onConnect(k)
+		}
+	case sioDisconnect:
+		if k != nil {
+			sess.mu.Lock()
+			delete(sess.sockets, p.Nsp)
+			sess.mu.Unlock()
+			k.disconnect()
+		}
+	case sioEvent, sioBinaryEvent:
+		args, _ := p.Data.([]interface{})
+		if k == nil || len(args) == 0 {
+			return
+		}
+		name, _ := args[0].(string)
+		var ack func(args ...interface{})
+		if p.ID >= 0 {
+			id := p.ID
+			ack = func(args ...interface{}) {
+				if args == nil {
+					args = []interface{}{}
+				}
+				sess.sendSIO(&sioPacket{Type: sioAck, Nsp: k.nsp, ID: id, Data: args})
+			}
+		}
+		k.onEvent(name, args[1:], ack)
+	case sioAck, sioBinaryAck:
+		if k == nil {
+			return
+		}
+		k.mu.Lock()
+		cb := k.acks[p.ID]
+		delete(k.acks, p.ID)
+		k.mu.Unlock()
+		if cb != nil {
+			args, _ := p.Data.([]interface{})
+			cb(args)
+		}
+	}
+}
+
+func (k *sioSocket) emit(event string, args ...interface{}) {
+	k.sess.sendSIO(&sioPacket{Type: sioEvent, Nsp: k.nsp, ID: -1, Data: append([]interface{}{event}, args...)})
+}
+
+// emitWithAck asks the client to acknowledge the event; cb gets its reply.
+func (k *sioSocket) emitWithAck(cb func(args []interface{}), event string, args ...interface{}) {
+	k.mu.Lock()
+	id := k.nextAck
+	k.nextAck++
+	k.acks[id] = cb
+	k.mu.Unlock()
+	k.sess.sendSIO(&sioPacket{Type: sioEvent, Nsp: k.nsp, ID: id, Data: append([]interface{}{event}, args...)})
+}
+
+func (k *sioSocket) disconnect() {
+	k.once.Do(func() {
+		close(k.done)
+		k.mu.Lock()
+		rooms := make([]string, 0, len(k.rooms))
+		for r := range k.rooms {
+			rooms = append(rooms, r)
+		}
+		k.mu.Unlock()
+		for _, r := range rooms {
+			k.leave(r)
+		}
+	})
+}
+
+func sioRoomKey(nsp, room string) string { return nsp + "#" + room }
+
+func (k *sioSocket) join(room string) int {
+	s := k.sess.srv
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := sioRoomKey(k.nsp, room)
+	if s.rooms[key] == nil {
+		s.rooms[key] = map[*sioSocket]struct{}{}
+	}
+	s.rooms[key][k] = struct{}{}
+	k.mu.Lock()
+	k.rooms[room] = true
+	k.mu.Unlock()
+	return len(s.rooms[key])
+}
+
+func (k *sioSocket) leave(room string) int {
+	s := k.sess.srv
+	s.mu.Lock()
+	key := sioRoomKey(k.nsp, room)
+	delete(s.rooms[key], k)
+	n := len(s.rooms[key])
+	if n == 0 {
+		delete(s.rooms, key)
+	}
+	s.mu.Unlock()
+	k.mu.Lock()
+	delete(k.rooms, room)
+	k.mu.Unlock()
+	return n
+}
+
+// members returns the sockets of room in k's namespace, or of the whole
+// namespace when room is empty.
+func (k *sioSocket) members(room string) []*sioSocket {
+	s := k.sess.srv
+	var out []*sioSocket
+	if room != "" {
+		s.mu.Lock()
+		for m := range s.rooms[sioRoomKey(k.nsp, room)] {
+			out = append(out, m)
+		}
+		s.mu.Unlock()
+		return out
+	}
+	s.mu.Lock()
+	sessions := make([]*eioSession, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+	for _, sess := range sessions {
+		sess.mu.Lock()
+		if m := sess.sockets[k.nsp]; m != nil {
+			out = append(out, m)
+		}
+		sess.mu.Unlock()
+	}
+	return out
+}
+
+// onEvent implements the events shared by every namespace:
+//   - echo: emits the args back as "echo" and acks them
+//   - join / leave (room): acks {room, members}; other members get
+//     "joined" / "left"
+//   - broadcast ({room, event, data}): emits event to the room or namespace
+//   - binary (fixture): emits "binary" with a protobuf fixture attachment
+//   - request-ack: emits "ack-request" expecting an ack, then reports the
+//     client's reply as "ack-response"
+func (k *sioSocket) onEvent(name string, args []interface{}, ack func(args ...interface{})) {
+	if ack == nil {
+		ack = func(...interface{}) {}
+	}
+	str := func(i int) string {
+		if i < len(args) {
+			s, _ := args[i].(string)
+			return s
+		}
+		return ""
+	}
+	switch name {
+	case "echo":
+		k.emit("echo", args...)
+		ack(args...)
+	case "join", "leave":
+		room := str(0)
+		if room == "" {
+			ack(map[string]interface{}{"error": "room required"})
+			return
+		}
+		n, notice := 0, "joined"
+		if name == "join" {
+			n = k.join(room)
+		} else {
+			n, notice = k.leave(room), "left"
+		}
+		for _, m := range k.members(room) {
+			if m != k {
+				m.emit(notice, map[string]interface{}{"room": room, "sid": k.id})
+			}
+		}
+		ack(map[string]interface{}{"room": room, "members": n})
+	case "broadcast":
+		var opts map[string]interface{}
+		if len(args) > 0 {
+			opts, _ = args[0].(map[string]interface{})
+		}
+		room, _ := opts["room"].(string)
+		event, _ := opts["event"].(string)
+		if event == "" {
+			event = "broadcast"
+		}
+		members := k.members(room)
+		for _, m := range members {
+			m.emit(event, opts["data"])
+		}
+		ack(map[string]interface{}{"delivered": len(members)})
+	case "binary":
+		b, err := loadBinaryFixture(str(0))
+		if err != nil {
+			ack(map[string]interface{}{"error": err.Error()})
+			return
+		}
+		k.emit("binary", map[string]interface{}{"fixture": str(0), "size": len(b)}, b)
+		ack(map[string]interface{}{"size": len(b)})
+	case "request-ack":
+		k.emitWithAck(func(reply []interface{}) {
+			k.emit("ack-response", reply...)
+		}, "ack-request", args...)
+		ack(map[string]interface{}{"requested": true})
+	default:
+		ack(map[string]interface{}{"error": "unknown event", "event": name})
+	}
+}
+
+// sioInterval reads the replay interval from auth.interval or ?interval=.
+func (k *sioSocket) sioInterval(def int) time.Duration {
+	if n, ok := k.auth["interval"].(float64); ok && n > 0 {
+		return time.Duration(n) * time.Millisecond
+	}
+	return time.Duration(parseInterval(k.sess.r, def)) * time.Millisecond
+}
+
+// sioTimeline replays assets/ws/timeline.json as "timeline" events.
+func sioTimeline(k *sioSocket) {
+	interval := k.sioInterval(300)
+	for _, m := range loadTimeline() {
+		select {
+		case <-k.done:
+			return
+		case <-time.After(interval):
+		}
+		k.emit("timeline", m)
+	}
+	k.emit("timeline_end")
+}
+
+// sioFood replays the food flows from assets/ws; each event is emitted
+// under its type (auth {"role":"merchant"} selects the merchant flow).
+func sioFood(k *sioSocket) {
+	file, fallback := "food_user.json", defaultFoodUserFlow()
+	if k.auth["role"] == "merchant" {
+		file, fallback = "food_merchant.json", defaultFoodMerchantFlow()
+	}
+	interval := k.sioInterval(300)
+	for _, m := range loadFoodFlow(file, fallback, "type") {
+		select {
+		case <-k.done:
+			return
+		case <-time.After(interval):
+		}
+		event, _ := m["type"].(string)
+		k.emit(event, m)
+	}
+}
@@ -0,0 +1,196 @@
+package wsserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSocketIOPacketCodec(t *testing.T) {
+	p, err := decodeSIO(`51-/chat,7["upload",{"_placeholder":true,"num":0}]`)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if p.Type != sioBinaryEvent || p.Attachments != 1 || p.Nsp != "/chat" || p.ID != 7 {
+		t.Fatalf("packet=%+v", p)
+	}
+	data := sioReconstruct(p.Data, [][]byte{{1, 2}})
+	if b, _ := data.([]interface{})[1].([]byte); !bytes.Equal(b, []byte{1, 2}) {
+		t.Fatalf("reconstructed=%v", data)
+	}
+	out := (&sioPacket{Type: sioAck, Nsp: "/chat", ID: 7, Data: []interface{}{"ok", []byte{9}}}).encode()
+	if len(out) != 2 || out[0].text != `461-/chat,7["ok",{"_placeholder":true,"num":0}]` || !bytes.Equal(out[1].binary, []byte{9}) {
+		t.Fatalf("encoded=%+v", out)
+	}
+}
+
+func TestSocketIOTransports(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+	root := fmt.Sprintf("127.0.0.1:%d/socket.io/?EIO=4&token=%s", base+3, staticWsToken)
+	poll := func(query string) []string {
+		t.Helper()
+		resp, err := http.Get("http://" + root + query)
+		if err != nil {
+			t.Fatalf("poll: %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != 200 {
+			t.Fatalf("poll status=%d body=%s", resp.StatusCode, b)
+		}
+		return strings.Split(string(b), eioRecordSep)
+	}
+	post := func(query, body string) {
+		t.Helper()
+		resp, err := http.Post("http://"+root+query, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("post status=%d", resp.StatusCode)
+		}
+	}
+
+	// polling handshake, namespace connect and an acknowledged event
+	open := poll("&transport=polling")
+	var hs struct {
+		SID      string   `json:"sid"`
+		Upgrades []string `json:"upgrades"`
+	}
+	if len(open) != 1 || open[0][0] != '0' || json.Unmarshal([]byte(open[0][1:]), &hs) != nil || hs.SID == "" || hs.Upgrades[0] != "websocket" {
+		t.Fatalf("open=%q", open)
+	}
+	sidQ := "&transport=polling&sid=" + hs.SID
+	post(sidQ, "40")
+	if got := poll(sidQ); len(got) != 1 || !strings.HasPrefix(got[0], `40{"sid":`) {
+		t.Fatalf("connect=%q", got)
+	}
+	post(sidQ, `421["echo","hi"]`+eioRecordSep+`40/nope,`)
+	got := strings.Join(poll(sidQ), "|")
+	for _, want := range []string{`42["echo","hi"]`, `431["hi"]`, `44/nope,{"message":"Invalid namespace"}`} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("polling payload %q lacks %q", got, want)
+		}
+	}
+
+	// upgrade the same session to websocket
+	dialWS := func(query string) *websocket.Conn {
+		t.Helper()
+		c, _, err := websocket.DefaultDialer.Dial("ws://"+root+query, nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		_ = c.SetReadDeadline(time.Now().Add(3 * time.Second))
+		return c
+	}
+	readText := func(c *websocket.Conn) string {
+		t.Helper()
+		for {
+			mt, b, err := c.ReadMessage()
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if mt == websocket.TextMessage && string(b) != "2" {
+				return string(b)
+			}
+		}
+	}
+	up := dialWS("&transport=websocket&sid=" + hs.SID)
+	_ = up.WriteMessage(websocket.TextMessage, []byte("2probe"))
+	if s := readText(up); s != "3probe" {
+		t.Fatalf("probe=%q", s)
+	}
+	if got := poll(sidQ); got[0] != "6" {
+		t.Fatalf("noop=%q", got)
+	}
+	_ = up.WriteMessage(websocket.TextMessage, []byte("5"))
+	_ = up.WriteMessage(websocket.TextMessage, []byte(`422["join","r1"]`))
+	if s := readText(up); s != `432[{"members":1,"room":"r1"}]` {
+		t.Fatalf("join ack=%q", s)
+	}
+
+	// a websocket-only session joins the room; the upgraded one is told
+	ws := dialWS("&transport=websocket")
+	if s := readText(ws); !strings.HasPrefix(s, "0{") || !strings.Contains(s, `"upgrades":[]`) {
+		t.Fatalf("ws open=%q", s)
+	}
+	_ = ws.WriteMessage(websocket.TextMessage, []byte("40"))
+	readText(ws)
+	_ = ws.WriteMessage(websocket.TextMessage, []byte(`42["join","r1"]`))
+	if s := readText(up); !strings.HasPrefix(s, `42["joined",{"room":"r1"`) {
+		t.Fatalf("joined=%q", s)
+	}
+
+	// binary events carry attachments
+	_ = up.WriteMessage(websocket.TextMessage, []byte(`42["binary","order"]`))
+	if s := readText(up); !strings.HasPrefix(s, `451-["binary",`) {
+		t.Fatalf("binary event=%q", s)
+	}
+	want, _ := os.ReadFile(common.JoinAssets("ws", "binary", "order.pb"))
+	if mt, b, err := up.ReadMessage(); err != nil || mt != websocket.BinaryMessage || !bytes.Equal(b, want) {
+		t.Fatalf("attachment type=%d err=%v", mt, err)
+	}
+
+	// server-side acks: the client's reply comes back as ack-response
+	_ = ws.WriteMessage(websocket.TextMessage, []byte(`42["request-ack","q"]`))
+	s := readText(ws)
+	if !strings.HasPrefix(s, `420["ack-request","q"]`) {
+		t.Fatalf("ack-request=%q", s)
+	}
+	_ = ws.WriteMessage(websocket.TextMessage, []byte(`430["answer"]`))
+	if s := readText(ws); s != `42["ack-response","answer"]` {
+		t.Fatalf("ack-response=%q", s)
+	}
+
+	// asset-driven namespace
+	_ = ws.WriteMessage(websocket.TextMessage, []byte(`40/timeline,{"interval":10}`))
+	if s := readText(ws); !strings.HasPrefix(s, `40/timeline,{"sid":`) {
+		t.Fatalf("timeline connect=%q", s)
+	}
+	var events []string
+	for {
+		s := readText(ws)
+		events = append(events, s)
+		if s == `42/timeline,["timeline_end"]` {
+			break
+		}
+	}
+	if len(events) != len(loadTimeline())+1 || !strings.HasPrefix(events[0], `42/timeline,["timeline",`) {
+		t.Fatalf("timeline events=%q", events)
+	}
+
+	// unanswered pings close the session
+	hb := dialWS("&transport=websocket&pingInterval=50&pingTimeout=50")
+	readText(hb)
+	if _, b, err := hb.ReadMessage(); err != nil || string(b) != "2" {
+		t.Fatalf("ping=%q err=%v", b, err)
+	}
+	if _, _, err := hb.ReadMessage(); err == nil {
+		t.Fatalf("session survived a missed pong")
+	}
+}
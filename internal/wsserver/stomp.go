@@ -0,0 +1,746 @@
+package wsserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"intercept-wave-upstream/internal/admin"
+	"intercept-wave-upstream/internal/common"
+	"intercept-wave-upstream/internal/food"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// stompVersion is the only protocol version the broker speaks.
+	stompVersion = "1.2"
+	// stompHeartbeat is the default server heart-beat (ms) for both directions.
+	stompHeartbeat = 10000
+	// stompQueueBacklog bounds the messages a queue keeps without consumers.
+	stompQueueBacklog = 100
+)
+
+// Ack modes of a subscription.
+const (
+	stompAckAuto       = "auto"
+	stompAckClient     = "client"
+	stompAckIndividual = "client-individual"
+)
+
+// stompFrame is one STOMP frame. Headers keep their wire order; for
+// repeated headers the first one wins.
+type stompFrame struct {
+	Command string
+	Headers [][2]string
+	Body    []byte
+}
+
+func newStompFrame(command string, headers ...string) *stompFrame {
+	f := &stompFrame{Command: command}
+	for i := 0; i+1 < len(headers); i += 2 {
+		f.set(headers[i], headers[i+1])
+	}
+	return f
+}
+
+func (f *stompFrame) header(k string) string {
+	v, _ := f.lookup(k)
+	return v
+}
+
+func (f *stompFrame) lookup(k string) (string, bool) {
+	for _, h := range f.Headers {
+		if h[0] == k {
+			return h[1], true
+		}
+	}
+	return "", false
+}
+
+func (f *stompFrame) set(k, v string) {
+	f.Headers = append(f.Headers, [2]string{k, v})
+}
+
+// stompEscapes reports whether header values are escaped in this frame;
+// STOMP 1.2 exempts CONNECT and CONNECTED.
+func stompEscapes(command string) bool {
+	return command != "CONNECT" && command != "CONNECTED"
+}
+
+var (
+	stompEscaper   = strings.NewReplacer(`\`, `\\`, "\r", `\r`, "\n", `\n`, ":", `\c`)
+	stompUnescaper = strings.NewReplacer(`\\`, `\`, `\r`, "\r", `\n`, "\n", `\c`, ":")
+)
+
+func (f *stompFrame) encode() []byte {
+	var b bytes.Buffer
+	b.WriteString(f.Command)
+	b.WriteByte('\n')
+	esc := stompEscapes(f.Command)
+	for _, h := range f.Headers {
+		k, v := h[0], h[1]
+		if esc {
+			k, v = stompEscaper.Replace(k), stompEscaper.Replace(v)
+		}
+		b.WriteString(k)
+		b.WriteByte(':')
+		b.WriteString(v)
+		b.WriteByte('\n')
+	}
+	if len(f.Body) > 0 {
+		fmt.Fprintf(&b, "content-length:%d\n", len(f.Body))
+	}
+	b.WriteByte('\n')
+	b.Write(f.Body)
+	b.WriteByte(0)
+	return b.Bytes()
+}
+
+// parseStompFrames decodes the frames of one WS message. Bare EOLs between
+// frames are heart-beats and are skipped.
+func parseStompFrames(data []byte) ([]*stompFrame, error) {
+	var out []*stompFrame
+	for {
+		data = bytes.TrimLeft(data, "\r\n")
+		if len(data) == 0 {
+			return out, nil
+		}
+		f, rest, err := parseStompFrame(data)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, f)
+		data = rest
+	}
+}
+
+func parseStompFrame(data []byte) (*stompFrame, []byte, error) {
+	line := func() (string, bool) {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return "", false
+		}
+		s := strings.TrimSuffix(string(data[:i]), "\r")
+		data = data[i+1:]
+		return s, true
+	}
+	cmd, ok := line()
+	if !ok || cmd == "" {
+		return nil, nil, errors.New("incomplete frame")
+	}
+	f := &stompFrame{Command: cmd}
+	esc := stompEscapes(cmd)
+	for {
+		h, ok := line()
+		if !ok {
+			return nil, nil, errors.New("incomplete headers")
+		}
+		if h == "" {
+			break
+		}
+		k, v, found := strings.Cut(h, ":")
+		if !found {
+			return nil, nil, fmt.Errorf("malformed header %q", h)
+		}
+		if esc {
+			if err := stompCheckEscapes(h); err != nil {
+				return nil, nil, err
+			}
+			k, v = stompUnescaper.Replace(k), stompUnescaper.Replace(v)
+		}
+		f.set(k, v)
+	}
+	if cl, ok := f.lookup("content-length"); ok {
+		n, err := strconv.Atoi(cl)
+		if err != nil || n < 0 {
+			return nil, nil, fmt.Errorf("invalid content-length %q", cl)
+		}
+		if len(data) < n+1 || data[n] != 0 {
+			return nil, nil, errors.New("body does not match content-length")
+		}
+		f.Body = data[:n]
+		return f, data[n+1:], nil
+	}
+	i := bytes.IndexByte(data, 0)
+	if i < 0 {
+		return nil, nil, errors.New("missing NULL terminator")
+	}
+	f.Body = data[:i]
+	return f, data[i+1:], nil
+}
+
+// stompCheckEscapes rejects escape sequences STOMP 1.2 does not define.
+func stompCheckEscapes(s string) error {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			continue
+		}
+		if i+1 >= len(s) || !strings.ContainsRune(`\rnc`, rune(s[i+1])) {
+			return fmt.Errorf("undefined escape in %q", s)
+		}
+		i++
+	}
+	return nil
+}
+
+// stompMessage is a published message before it is addressed to a
+// subscription.
+type stompMessage struct {
+	dest    string
+	headers [][2]string
+	body    []byte
+}
+
+// stompBroker owns the in-memory destinations of one WS service. Topics fan
+// out to every subscriber; /queue/ destinations deliver each message to one
+// subscriber in turn and keep a backlog while nobody listens.
+type stompBroker struct {
+	sp          WsSpec
+	mu          sync.Mutex
+	subs        map[string][]*stompSub
+	cursor      map[string]int
+	queues      map[string][]stompMessage
+	nextID      int64
+	nextSession int
+}
+
+// stompSub is one SUBSCRIBE of a session.
+type stompSub struct {
+	sess *stompSession
+	id   string
+	dest string
+	ack  string
+	stop chan struct{}
+}
+
+func newStompBroker(sp WsSpec) *stompBroker {
+	return &stompBroker{sp: sp, subs: map[string][]*stompSub{}, cursor: map[string]int{}, queues: map[string][]stompMessage{}}
+}
+
+// reset drops queued messages.
+func (b *stompBroker) reset() {
+	b.mu.Lock()
+	b.queues = map[string][]stompMessage{}
+	b.mu.Unlock()
+}
+
+func isStompQueue(dest string) bool { return strings.HasPrefix(dest, "/queue/") }
+
+func (b *stompBroker) add(s *stompSub) {
+	b.mu.Lock()
+	b.subs[s.dest] = append(b.subs[s.dest], s)
+	backlog := b.queues[s.dest]
+	delete(b.queues, s.dest)
+	b.mu.Unlock()
+	for _, m := range backlog {
+		s.sess.deliver(s, m)
+	}
+}
+
+func (b *stompBroker) remove(s *stompSub) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := b.subs[s.dest]
+	for i, x := range list {
+		if x == s {
+			list = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(b.subs, s.dest)
+		delete(b.cursor, s.dest)
+	} else {
+		b.subs[s.dest] = list
+	}
+}
+
+// publish routes m to its subscribers or queue backlog.
+func (b *stompBroker) publish(m stompMessage) {
+	b.mu.Lock()
+	list := b.subs[m.dest]
+	var targets []*stompSub
+	switch {
+	case isStompQueue(m.dest) && len(list) == 0:
+		if q := b.queues[m.dest]; len(q) < stompQueueBacklog {
+			b.queues[m.dest] = append(q, m)
+		}
+	case isStompQueue(m.dest):
+		i := b.cursor[m.dest] % len(list)
+		b.cursor[m.dest] = i + 1
+		targets = []*stompSub{list[i]}
+	default:
+		targets = append(targets, list...)
+	}
+	b.mu.Unlock()
+	for _, s := range targets {
+		s.sess.deliver(s, m)
+	}
+}
+
+func (b *stompBroker) sessionID() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextSession++
+	return fmt.Sprintf("session-%d", b.nextSession)
+}
+
+func (b *stompBroker) messageID() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	return strconv.FormatInt(b.nextID, 10)
+}
+
+// stompPending is a MESSAGE awaiting ACK or NACK.
+type stompPending struct {
+	ackID string
+	sub   *stompSub
+	msg   stompMessage
+}
+
+// stompSession is the state of one /ws/stomp connection.
+type stompSession struct {
+	b    *stompBroker
+	c    *wsConn
+	sp   WsSpec
+	id   string
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu        sync.Mutex
+	connected bool
+	subs      map[string]*stompSub
+	pending   []stompPending
+}
+
+// stompError is a protocol error: it is reported in an ERROR frame and ends
+// the session.
+type stompError struct {
+	message string
+	detail  string
+}
+
+func (e *stompError) Error() string { return e.message }
+
+func stompErr(message, detail string) *stompError {
+	return &stompError{message: message, detail: detail}
+}
+
+// stompRoutes mounts /ws/stomp: a STOMP 1.2 broker with in-memory
+// destinations and built-in feeds:
+//   - /topic/orders and /topic/orders/{id}: food store events
+//   - /topic/ticker: {"tick":N,"time":..} every interval ms (SUBSCRIBE header, default 1000)
+//
+// SEND to /app/orders creates a food order from the JSON body and SEND to
+// /app/orders/{id}/{action} applies an order action. The server heart-beat
+// defaults to 10000,10000 and can be changed with ?heartbeat=sx,sy.
+func stompRoutes(mux *http.ServeMux, sp WsSpec) {
+	b := newStompBroker(sp)
+	admin.RegisterReset(sp.Name, "stomp", b.reset)
+	handleWS(mux, sp, "/ws/stomp", func(w http.ResponseWriter, r *http.Request) {
+		sx, sy := stompHeartbeat, stompHeartbeat
+		if v := r.URL.Query().Get("heartbeat"); v != "" {
+			var ok bool
+			if sx, sy, ok = parseStompHeartbeat(v); !ok {
+				common.JSON(w, http.StatusBadRequest, map[string]interface{}{"error": "heartbeat must be sx,sy in ms"})
+				return
+			}
+		}
+		c, release, ok := accept(w, r, sp)
+		if !ok {
+			return
+		}
+		defer release()
+		s := &stompSession{b: b, c: c, sp: sp, id: b.sessionID(), done: make(chan struct{}), subs: map[string]*stompSub{}}
+		defer s.close()
+		for {
+			t, msg, err := c.ReadMessage()
+			if err != nil {
+				common.Logf("WS %s stomp recv loop end: %v", sp.Name, err)
+				return
+			}
+			logWsFrame(sp, "recv", t, msg)
+			frames, err := parseStompFrames(msg)
+			for _, f := range frames {
+				if !s.handle(f, sx, sy) {
+					return
+				}
+			}
+			if err != nil {
+				s.fail(nil, stompErr("malformed frame", err.Error()))
+				return
+			}
+		}
+	})
+}
+
+func parseStompHeartbeat(v string) (int, int, bool) {
+	a, b, ok := strings.Cut(v, ",")
+	x, err1 := strconv.Atoi(strings.TrimSpace(a))
+	y, err2 := strconv.Atoi(strings.TrimSpace(b))
+	if !ok || err1 != nil || err2 != nil || x < 0 || y < 0 {
+		return 0, 0, false
+	}
+	return x, y, true
+}
+
+// close ends feeds and subscriptions; unacknowledged queue messages go back
+// to their queue.
+func (s *stompSession) close() {
+	close(s.done)
+	s.mu.Lock()
+	subs := s.subs
+	s.subs = map[string]*stompSub{}
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	for _, sub := range subs {
+		s.b.remove(sub)
+		close(sub.stop)
+	}
+	s.wg.Wait()
+	for _, p := range pending {
+		if isStompQueue(p.msg.dest) {
+			s.b.publish(p.msg)
+		}
+	}
+}
+
+func (s *stompSession) send(f *stompFrame) error {
+	return writeMessageLogged(s.c, s.sp, websocket.TextMessage, f.encode())
+}
+
+// fail sends an ERROR frame for in (may be nil) and closes the connection.
+func (s *stompSession) fail(in *stompFrame, e *stompError) {
+	f := newStompFrame("ERROR", "message", e.message)
+	if in != nil {
+		if id, ok := in.lookup("receipt"); ok {
+			f.set("receipt-id", id)
+		}
+		if in.Command == "CONNECT" || in.Command == "STOMP" {
+			f.set("version", stompVersion)
+		}
+	}
+	if e.detail != "" {
+		f.set("content-type", "text/plain")
+		f.Body = []byte(e.detail)
+	}
+	_ = s.send(f)
+	s.c.expire(websocket.CloseProtocolError, "stomp error: "+e.message)
+}
+
+// handle processes one client frame and reports whether the session goes on.
+func (s *stompSession) handle(f *stompFrame, sx, sy int) bool {
+	s.mu.Lock()
+	connected := s.connected
+	s.mu.Unlock()
+	var err *stompError
+	switch {
+	case f.Command == "CONNECT" || f.Command == "STOMP":
+		if connected {
+			err = stompErr("already connected", "")
+		} else {
+			err = s.connect(f, sx, sy)
+		}
+	case !connected:
+		err = stompErr("not connected", "the first frame must be CONNECT")
+	case f.Command == "SEND":
+		err = s.onSend(f)
+	case f.Command == "SUBSCRIBE":
+		err = s.subscribe(f)
+	case f.Command == "UNSUBSCRIBE":
+		err = s.unsubscribe(f)
+	case f.Command == "ACK" || f.Command == "NACK":
+		err = s.ack(f)
+	case f.Command == "DISCONNECT":
+		s.receipt(f)
+		_ = s.c.closeWith(websocket.CloseNormalClosure, "disconnect")
+		return false
+	case f.Command == "BEGIN" || f.Command == "COMMIT" || f.Command == "ABORT":
+		err = stompErr("transactions are not supported", "")
+	default:
+		err = stompErr("unknown command", f.Command)
+	}
+	if err != nil {
+		s.fail(f, err)
+		return false
+	}
+	if f.Command != "CONNECT" && f.Command != "STOMP" {
+		s.receipt(f)
+	}
+	return true
+}
+
+func (s *stompSession) receipt(f *stompFrame) {
+	if id, ok := f.lookup("receipt"); ok {
+		_ = s.send(newStompFrame("RECEIPT", "receipt-id", id))
+	}
+}
+
+// connect negotiates the version and heart-beats. Each side sends at the
+// slower of what it offers and what the other side wants; the session ends
+// when nothing arrives for twice the incoming period.
+func (s *stompSession) connect(f *stompFrame, sx, sy int) *stompError {
+	if v, ok := f.lookup("accept-version"); ok {
+		found := false
+		for _, x := range strings.Split(v, ",") {
+			found = found || strings.TrimSpace(x) == stompVersion
+		}
+		if !found {
+			return stompErr("unsupported protocol version", "supported versions are "+stompVersion)
+		}
+	}
+	cx, cy := 0, 0
+	if v, ok := f.lookup("heart-beat"); ok {
+		var valid bool
+		if cx, cy, valid = parseStompHeartbeat(v); !valid {
+			return stompErr("invalid heart-beat", v)
+		}
+	}
+	s.mu.Lock()
+	s.connected = true
+	s.mu.Unlock()
+	if err := s.send(newStompFrame("CONNECTED",
+		"version", stompVersion,
+		"heart-beat", fmt.Sprintf("%d,%d", sx, sy),
+		"session", s.id,
+		"server", "intercept-wave-upstream",
+	)); err != nil {
+		return nil
+	}
+	if sx > 0 && cy > 0 {
+		s.every(time.Duration(max(sx, cy))*time.Millisecond, func() {
+			_ = writeMessageLogged(s.c, s.sp, websocket.TextMessage, []byte("\n"))
+		})
+	}
+	if cx > 0 && sy > 0 {
+		in := time.Duration(max(cx, sy)) * time.Millisecond
+		s.every(in, func() {
+			if time.Since(time.Unix(0, s.c.lastRecv.Load())) > 2*in {
+				s.c.expire(websocket.CloseGoingAway, "stomp heart-beat timeout")
+			}
+		})
+	}
+	return nil
+}
+
+// every runs fn every d until the session ends.
+func (s *stompSession) every(d time.Duration, fn func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		t := time.NewTicker(d)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				fn()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+// stompReserved lists SEND headers that are not copied onto MESSAGE frames.
+var stompReserved = map[string]bool{"destination": true, "receipt": true, "content-length": true, "transaction": true}
+
+func (s *stompSession) onSend(f *stompFrame) *stompError {
+	dest := f.header("destination")
+	if dest == "" {
+		return stompErr("missing destination", "SEND requires a destination header")
+	}
+	if strings.HasPrefix(dest, "/app/") {
+		return s.app(dest, f.Body)
+	}
+	m := stompMessage{dest: dest, body: f.Body}
+	seen := map[string]bool{}
+	for _, h := range f.Headers {
+		if !stompReserved[h[0]] && !seen[h[0]] {
+			seen[h[0]] = true
+			m.headers = append(m.headers, h)
+		}
+	}
+	s.b.publish(m)
+	return nil
+}
+
+// app handles application destinations that drive the food store.
+func (s *stompSession) app(dest string, body []byte) *stompError {
+	parts := strings.Split(strings.TrimPrefix(dest, "/app/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "orders":
+		fields := map[string]interface{}{}
+		if len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, &fields); err != nil {
+				return stompErr("invalid order body", err.Error())
+			}
+		}
+		food.Default.Create(fields, "stomp")
+		return nil
+	case len(parts) == 3 && parts[0] == "orders":
+		var p struct {
+			Reason string `json:"reason"`
+		}
+		_ = json.Unmarshal(body, &p)
+		if _, err := food.Default.Apply(parts[1], parts[2], "stomp", p.Reason); err != nil {
+			return stompErr("order action failed", err.Error())
+		}
+		return nil
+	}
+	return stompErr("unknown application destination", dest)
+}
+
+func (s *stompSession) subscribe(f *stompFrame) *stompError {
+	id, dest := f.header("id"), f.header("destination")
+	if id == "" || dest == "" {
+		return stompErr("invalid subscription", "SUBSCRIBE requires id and destination headers")
+	}
+	mode := f.header("ack")
+	switch mode {
+	case "":
+		mode = stompAckAuto
+	case stompAckAuto, stompAckClient, stompAckIndividual:
+	default:
+		return stompErr("invalid ack mode", mode)
+	}
+	sub := &stompSub{sess: s, id: id, dest: dest, ack: mode, stop: make(chan struct{})}
+	s.mu.Lock()
+	if _, dup := s.subs[id]; dup {
+		s.mu.Unlock()
+		return stompErr("duplicate subscription id", id)
+	}
+	s.subs[id] = sub
+	s.mu.Unlock()
+	s.b.add(sub)
+	s.feed(sub, f)
+	return nil
+}
+
+// feed starts the built-in publisher behind sub's destination, if any.
+// Feeds deliver to their own subscription only.
+func (s *stompSession) feed(sub *stompSub, f *stompFrame) {
+	run := func(loop func()) {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			loop()
+		}()
+	}
+	switch {
+	case sub.dest == "/topic/ticker":
+		interval := time.Second
+		if ms, err := strconv.Atoi(f.header("interval")); err == nil && ms > 0 {
+			interval = time.Duration(ms) * time.Millisecond
+		}
+		run(func() {
+			t := time.NewTicker(interval)
+			defer t.Stop()
+			for i := 1; ; i++ {
+				select {
+				case <-t.C:
+					body, _ := json.Marshal(map[string]interface{}{"tick": i, "time": time.Now().UnixMilli()})
+					s.deliver(sub, stompMessage{dest: sub.dest, headers: [][2]string{{"content-type", "application/json"}}, body: body})
+				case <-sub.stop:
+					return
+				}
+			}
+		})
+	case sub.dest == "/topic/orders" || strings.HasPrefix(sub.dest, "/topic/orders/"):
+		id := strings.TrimPrefix(strings.TrimPrefix(sub.dest, "/topic/orders"), "/")
+		events, unsubscribe := food.Default.Subscribe(food.Filter{OrderID: id})
+		run(func() {
+			defer unsubscribe()
+			for {
+				select {
+				case ev := <-events:
+					body, _ := json.Marshal(ev)
+					s.deliver(sub, stompMessage{dest: sub.dest, headers: [][2]string{{"content-type", "application/json"}, {"event", ev.Type}}, body: body})
+				case <-sub.stop:
+					return
+				}
+			}
+		})
+	}
+}
+
+func (s *stompSession) unsubscribe(f *stompFrame) *stompError {
+	id := f.header("id")
+	if id == "" {
+		return stompErr("invalid unsubscribe", "UNSUBSCRIBE requires an id header")
+	}
+	s.mu.Lock()
+	sub, ok := s.subs[id]
+	delete(s.subs, id)
+	s.mu.Unlock()
+	if ok {
+		s.b.remove(sub)
+		close(sub.stop)
+	}
+	return nil
+}
+
+// deliver sends m to sub as a MESSAGE frame, tracking it for client acks.
+func (s *stompSession) deliver(sub *stompSub, m stompMessage) {
+	msgID := s.b.messageID()
+	f := newStompFrame("MESSAGE", "subscription", sub.id, "message-id", msgID, "destination", m.dest)
+	if sub.ack != stompAckAuto {
+		f.set("ack", msgID)
+		s.mu.Lock()
+		s.pending = append(s.pending, stompPending{ackID: msgID, sub: sub, msg: m})
+		s.mu.Unlock()
+	}
+	f.Headers = append(f.Headers, m.headers...)
+	f.Body = m.body
+	_ = s.send(f)
+}
+
+// ack settles pending messages: the named one, plus every earlier message of
+// the same subscription in client mode. NACKed queue messages are delivered
+// again (to the next consumer).
+func (s *stompSession) ack(f *stompFrame) *stompError {
+	id := f.header("id")
+	if id == "" {
+		return stompErr("missing id", f.Command+" requires an id header")
+	}
+	s.mu.Lock()
+	idx := -1
+	for i, p := range s.pending {
+		if p.ackID == id {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		s.mu.Unlock()
+		return stompErr("unknown ack id", id)
+	}
+	target := s.pending[idx]
+	var settled []stompPending
+	kept := s.pending[:0:0]
+	for i, p := range s.pending {
+		if i == idx || (target.sub.ack == stompAckClient && p.sub == target.sub && i < idx) {
+			settled = append(settled, p)
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	s.pending = kept
+	s.mu.Unlock()
+	if f.Command == "NACK" {
+		for _, p := range settled {
+			if isStompQueue(p.msg.dest) {
+				s.b.publish(p.msg)
+			}
+		}
+	}
+	return nil
+}
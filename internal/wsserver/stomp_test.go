@@ -0,0 +1,228 @@
+package wsserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/food"
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStompFrameCodec(t *testing.T) {
+	in := newStompFrame("MESSAGE", "destination", "/topic/a:b", "note", "line\nbreak")
+	in.Body = []byte("x\x00y")
+	frames, err := parseStompFrames(append([]byte("\n\r\n"), append(in.encode(), "\nSEND\ndestination:/q\n\nhi\x00"...)...))
+	if err != nil || len(frames) != 2 {
+		t.Fatalf("frames=%d err=%v", len(frames), err)
+	}
+	got := frames[0]
+	if got.header("destination") != "/topic/a:b" || got.header("note") != "line\nbreak" || string(got.Body) != "x\x00y" {
+		t.Fatalf("round trip=%+v", got)
+	}
+	if frames[1].Command != "SEND" || string(frames[1].Body) != "hi" {
+		t.Fatalf("second=%+v", frames[1])
+	}
+	if _, err := parseStompFrames([]byte("SEND\nbad:\\t\n\n\x00")); err == nil {
+		t.Fatalf("undefined escape accepted")
+	}
+	// CONNECT headers are not unescaped
+	f, _ := parseStompFrames([]byte("CONNECT\nlogin:a\\cb\n\n\x00"))
+	if f[0].header("login") != `a\cb` {
+		t.Fatalf("connect header=%q", f[0].header("login"))
+	}
+}
+
+type stompClient struct {
+	t *testing.T
+	c *websocket.Conn
+}
+
+func dialStomp(t *testing.T, port int, query string) *stompClient {
+	t.Helper()
+	d := websocket.Dialer{Subprotocols: []string{"v10.stomp", "v11.stomp", "v12.stomp"}}
+	c, _, err := d.Dial(fmt.Sprintf("ws://127.0.0.1:%d/ws/stomp%s", port, query), http.Header{"X-Auth-Token": {staticWsToken}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	if c.Subprotocol() != "v12.stomp" {
+		t.Fatalf("subprotocol=%q", c.Subprotocol())
+	}
+	_ = c.SetReadDeadline(time.Now().Add(3 * time.Second))
+	return &stompClient{t: t, c: c}
+}
+
+func (sc *stompClient) send(command string, body string, headers ...string) {
+	sc.t.Helper()
+	f := newStompFrame(command, headers...)
+	f.Body = []byte(body)
+	if err := sc.c.WriteMessage(websocket.TextMessage, f.encode()); err != nil {
+		sc.t.Fatalf("write: %v", err)
+	}
+}
+
+// read returns the next frame, skipping heart-beats.
+func (sc *stompClient) read() *stompFrame {
+	sc.t.Helper()
+	for {
+		_, b, err := sc.c.ReadMessage()
+		if err != nil {
+			sc.t.Fatalf("read: %v", err)
+		}
+		frames, err := parseStompFrames(b)
+		if err != nil {
+			sc.t.Fatalf("parse %q: %v", b, err)
+		}
+		if len(frames) > 0 {
+			return frames[0]
+		}
+	}
+}
+
+func (sc *stompClient) expect(command string, headers ...string) *stompFrame {
+	sc.t.Helper()
+	f := sc.read()
+	if f.Command != command {
+		sc.t.Fatalf("got %s %v %q, want %s", f.Command, f.Headers, f.Body, command)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		if v := f.header(headers[i]); v != headers[i+1] {
+			sc.t.Fatalf("%s %s=%q, want %q", command, headers[i], v, headers[i+1])
+		}
+	}
+	return f
+}
+
+func TestStompBroker(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+	food.Default.Reset()
+	t.Cleanup(food.Default.Reset)
+	port := base + 3
+
+	a := dialStomp(t, port, "")
+	a.send("SEND", "early", "destination", "/topic/x")
+	a.expect("ERROR", "message", "not connected")
+
+	a = dialStomp(t, port, "")
+	a.send("CONNECT", "", "accept-version", "1.1,1.2", "host", "/", "heart-beat", "0,0")
+	a.expect("CONNECTED", "version", "1.2", "heart-beat", "10000,10000")
+
+	// pub/sub on a topic with user headers and receipts
+	b := dialStomp(t, port, "")
+	b.send("CONNECT", "", "accept-version", "1.2", "heart-beat", "0,0")
+	b.expect("CONNECTED")
+	a.send("SUBSCRIBE", "", "id", "s1", "destination", "/topic/chat", "receipt", "r1")
+	a.expect("RECEIPT", "receipt-id", "r1")
+	b.send("SEND", "hello", "destination", "/topic/chat", "content-type", "text/plain", "x-from", "b")
+	m := a.expect("MESSAGE", "subscription", "s1", "destination", "/topic/chat", "x-from", "b", "content-type", "text/plain")
+	if string(m.Body) != "hello" || m.header("message-id") == "" {
+		t.Fatalf("message=%+v", m)
+	}
+
+	// food events on /topic/orders/{id}, driven through /app/orders
+	o := food.Default.Create(nil, "test")
+	a.send("SUBSCRIBE", "", "id", "o1", "destination", "/topic/orders/"+o.ID, "receipt", "r2")
+	a.expect("RECEIPT", "receipt-id", "r2")
+	b.send("SEND", "", "destination", "/app/orders/"+o.ID+"/submit")
+	m = a.expect("MESSAGE", "subscription", "o1", "event", "order_submitted", "content-type", "application/json")
+	var ev food.Event
+	if err := json.Unmarshal(m.Body, &ev); err != nil || ev.OrderID != o.ID || ev.Status != food.StatusSubmitted {
+		t.Fatalf("event=%s err=%v", m.Body, err)
+	}
+
+	// ticker feed
+	a.send("SUBSCRIBE", "", "id", "t1", "destination", "/topic/ticker", "interval", "20")
+	m = a.expect("MESSAGE", "subscription", "t1")
+	if !strings.Contains(string(m.Body), `"tick":1`) {
+		t.Fatalf("tick=%s", m.Body)
+	}
+	a.send("UNSUBSCRIBE", "", "id", "t1", "receipt", "r3")
+	for f := a.read(); f.Command != "RECEIPT"; f = a.read() {
+	}
+
+	// queue backlog, client acks are cumulative
+	b.send("SEND", "job-1", "destination", "/queue/jobs")
+	b.send("SEND", "job-2", "destination", "/queue/jobs", "receipt", "q")
+	b.expect("RECEIPT", "receipt-id", "q")
+	a.send("SUBSCRIBE", "", "id", "q1", "destination", "/queue/jobs", "ack", "client")
+	first := a.expect("MESSAGE", "subscription", "q1")
+	second := a.expect("MESSAGE", "subscription", "q1")
+	if string(first.Body) != "job-1" || string(second.Body) != "job-2" || first.header("ack") == "" {
+		t.Fatalf("queue=%q %q", first.Body, second.Body)
+	}
+	a.send("ACK", "", "id", second.header("ack"), "receipt", "r4")
+	a.expect("RECEIPT", "receipt-id", "r4")
+	a.send("ACK", "", "id", first.header("ack"))
+	a.expect("ERROR", "message", "unknown ack id")
+
+	// NACKed queue messages go to the next consumer
+	c := dialStomp(t, port, "")
+	c.send("CONNECT", "", "accept-version", "1.2")
+	c.expect("CONNECTED")
+	c.send("SUBSCRIBE", "", "id", "w", "destination", "/queue/work", "ack", "client-individual")
+	c.send("SEND", "job", "destination", "/queue/work")
+	m = c.expect("MESSAGE", "subscription", "w")
+	c.send("NACK", "", "id", m.header("ack"))
+	if again := c.expect("MESSAGE", "subscription", "w"); string(again.Body) != "job" || again.header("ack") == m.header("ack") {
+		t.Fatalf("redelivery=%+v", again)
+	}
+
+	// version negotiation
+	v := dialStomp(t, port, "")
+	v.send("CONNECT", "", "accept-version", "1.0")
+	v.expect("ERROR", "message", "unsupported protocol version", "version", "1.2")
+}
+
+func TestStompHeartbeat(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	srvs := StartAll(base, topology.Default().WS)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+	sc := dialStomp(t, base+3, "?heartbeat=50,50")
+	sc.send("CONNECT", "", "accept-version", "1.2", "heart-beat", "50,50")
+	sc.expect("CONNECTED", "heart-beat", "50,50")
+	if _, b, err := sc.c.ReadMessage(); err != nil || string(b) != "\n" {
+		t.Fatalf("heart-beat=%q err=%v", b, err)
+	}
+	// the client never beats, so the server gives up after twice the period
+	start := time.Now()
+	for {
+		if _, _, err := sc.c.ReadMessage(); err != nil {
+			var ce *websocket.CloseError
+			if !errors.As(err, &ce) || ce.Code != websocket.CloseGoingAway {
+				t.Fatalf("close=%v", err)
+			}
+			break
+		}
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("heart-beat timeout took %v", time.Since(start))
+	}
+}
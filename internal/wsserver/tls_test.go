@@ -0,0 +1,91 @@
+package wsserver
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"intercept-wave-upstream/internal/tlsutil"
+	"intercept-wave-upstream/internal/topology"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWssListeners(t *testing.T) {
+	base, err := findFreeBase()
+	if err != nil {
+		t.Fatalf("findFreeBase: %v", err)
+	}
+	// The WS services sit on +3..+5, so the free +0 and +1 take the TLS
+	// listeners: ws-echo without client auth, ws-ticker requiring a client
+	// certificate.
+	services := topology.Default().WS
+	services[0].TLS = &topology.ServiceTLS{Enabled: true, Port: base}
+	services[1].TLS = &topology.ServiceTLS{Enabled: true, Port: base + 1, ClientAuth: "require"}
+	srvs := StartAll(base, services)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		for _, s := range srvs {
+			_ = s.Shutdown(ctx)
+		}
+	})
+
+	a, err := tlsutil.Default()
+	if err != nil {
+		t.Fatalf("tlsutil: %v", err)
+	}
+	certPEM, keyPEM, err := a.IssueClient("wss-test-client")
+	if err != nil {
+		t.Fatalf("issue client: %v", err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("client pair: %v", err)
+	}
+	noCert := &websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: a.Pool()}, HandshakeTimeout: 5 * time.Second}
+	withCert := &websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: a.Pool(), Certificates: []tls.Certificate{pair}}, HandshakeTimeout: 5 * time.Second}
+	hdr := http.Header{"X-Auth-Token": {staticWsToken}}
+	roundTrip := func(d *websocket.Dialer, url, msg string) string {
+		t.Helper()
+		c, _, err := d.Dial(url, hdr)
+		if err != nil {
+			t.Fatalf("dial %s: %v", url, err)
+		}
+		defer func() { _ = c.Close() }()
+		_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if err := c.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_, got, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return string(got)
+	}
+
+	// wait a bit for bind
+	time.Sleep(150 * time.Millisecond)
+
+	t.Run("same routes over wss", func(t *testing.T) {
+		want := roundTrip(websocket.DefaultDialer, fmt.Sprintf("ws://127.0.0.1:%d/ws/echo", base+3), "hello")
+		if got := roundTrip(noCert, fmt.Sprintf("wss://127.0.0.1:%d/ws/echo", base), "hello"); got != want {
+			t.Fatalf("echo over wss: %q, want %q", got, want)
+		}
+	})
+
+	t.Run("client auth require", func(t *testing.T) {
+		url := fmt.Sprintf("wss://127.0.0.1:%d/ws/echo", base+1)
+		if c, _, err := noCert.Dial(url, hdr); err == nil {
+			_ = c.Close()
+			t.Fatalf("expected handshake failure without client certificate")
+		}
+		want := roundTrip(websocket.DefaultDialer, fmt.Sprintf("ws://127.0.0.1:%d/ws/echo", base+4), "ping")
+		if got := roundTrip(withCert, url, "ping"); got != want {
+			t.Fatalf("echo over mTLS: %q, want %q", got, want)
+		}
+	})
+}
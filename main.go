@@ -2,22 +2,47 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"intercept-wave-upstream/internal/admin"
+	"intercept-wave-upstream/internal/grpcserver"
 	"intercept-wave-upstream/internal/httpserver"
+	"intercept-wave-upstream/internal/tlsutil"
+	"intercept-wave-upstream/internal/topology"
 	"intercept-wave-upstream/internal/wsserver"
 )
 
 func main() {
+	topoPath := flag.String("topology", topology.PathFromEnv(), "service topology file (JSON or YAML); defaults to env TOPOLOGY_FILE or the built-in layout")
+	flag.Parse()
+
+	topo, err := topology.Load(*topoPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load topology: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err := tlsutil.Setup(topo.TLS); err != nil {
+		fmt.Fprintf(os.Stderr, "tls setup: %v\n", err)
+		os.Exit(1)
+	}
+
 	base := httpserver.BasePortFromEnv()
-	httpServers := httpserver.StartAll(base)
-	wsServers := wsserver.StartAll(base)
+	httpServers := httpserver.StartAll(base, topo.HTTP)
+	wsServers := wsserver.StartAll(base, topo.WS)
+	grpcServers := grpcserver.StartAll(base, topo.GRPC)
+	var adminServer *http.Server
+	if !topo.Admin.Disabled {
+		adminServer = admin.Start(admin.PortFromEnv(topo.Admin.ResolvePort(base)))
+	}
 
-	fmt.Printf("Upstream servers started: HTTP:%d-%d WS:%d-%d\n", base, base+2, base+3, base+5)
+	fmt.Printf("Upstream servers started: %d HTTP listeners, %d WS listeners, %d gRPC listeners (BASE_PORT=%d)\n", len(httpServers), len(wsServers), len(grpcServers), base)
 	// graceful shutdown on SIGINT/SIGTERM
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
@@ -30,4 +55,10 @@ func main() {
 	for _, s := range wsServers {
 		_ = s.Shutdown(ctx)
 	}
+	for _, s := range grpcServers {
+		_ = s.Shutdown(ctx)
+	}
+	if adminServer != nil {
+		_ = adminServer.Shutdown(ctx)
+	}
 }